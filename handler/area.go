@@ -32,7 +32,6 @@ func GetCountryProvinceDistricts(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
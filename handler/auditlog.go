@@ -35,7 +35,6 @@ func GetAuditlog(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -163,7 +162,6 @@ func GetAuditlogByUsername(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	orgId := GetVariableFromToken(c, "orgId")
 	username := c.Param("username")
 	id := c.Param("id")
@@ -2,8 +2,8 @@ package handler
 
 import (
 	"context"
-	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"mainPackage/model"
@@ -30,7 +30,10 @@ func GetVariableFromToken(c *gin.Context, varname string) interface{} {
 	return var_return
 }
 
-func CreateToken(username string, orgId string) (string, string, error) {
+// CreateToken signs an access and a refresh token for a session. Both carry
+// the session id as jti; the refresh token also carries the refresh id (rid)
+// that RefreshToken checks against the session.
+func CreateToken(username string, orgId string, sessionId string, refreshId string) (string, string, error) {
 
 	var secretKey = []byte(os.Getenv("TOKEN_SECRET_KEY"))
 	var refreshKey = []byte(os.Getenv("REFRESH_TOKEN_KEY"))
@@ -41,6 +44,7 @@ func CreateToken(username string, orgId string) (string, string, error) {
 		jwt.MapClaims{
 			"username": username,
 			"orgId":    orgId,
+			"jti":      sessionId,
 			"exp":      time.Now().Add(TIMEOUT).Unix(),
 		})
 
@@ -48,13 +52,13 @@ func CreateToken(username string, orgId string) (string, string, error) {
 	if err != nil {
 		return "", "", err
 	}
-	TimeoutStr = os.Getenv("REFRESH_TOKEN_TIMEOUT")
-	timeoutInt, _ = strconv.Atoi(TimeoutStr)
-	TIMEOUT = time.Minute * time.Duration(timeoutInt)
+	TIMEOUT = refreshTokenTimeout()
 	refreshtoken := jwt.NewWithClaims(jwt.SigningMethodHS256,
 		jwt.MapClaims{
 			"username": username,
 			"orgId":    orgId,
+			"jti":      sessionId,
+			"rid":      refreshId,
 			"exp":      time.Now().Add(TIMEOUT).Unix(),
 		})
 
@@ -66,6 +70,58 @@ func CreateToken(username string, orgId string) (string, string, error) {
 	return tokenString, refreshtokenString, nil
 }
 
+func refreshTokenTimeout() time.Duration {
+	return time.Minute * time.Duration(utils.EnvInt("REFRESH_TOKEN_TIMEOUT", 0))
+}
+
+// startSession registers a new session for username and returns its tokens.
+func startSession(c *gin.Context, username string, orgId string) (string, string, error) {
+	now := time.Now()
+	session := model.AuthSession{
+		ID:        uuid.New().String(),
+		OrgID:     orgId,
+		Username:  username,
+		RefreshID: uuid.New().String(),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(refreshTokenTimeout()),
+	}
+	if err := utils.SessionSet(session); err != nil {
+		return "", "", err
+	}
+	return CreateToken(username, orgId, session.ID, session.RefreshID)
+}
+
+// RevokeUserSessions ends every session of a user except keep, which may be
+// empty. It is called whenever the credentials or the status of the user
+// change.
+func RevokeUserSessions(orgId string, username string, keep string, reason string) {
+	logger := utils.GetLog()
+	n, err := utils.SessionDelUser(orgId, username, keep)
+	if err != nil {
+		logger.Warn("Revoke sessions failed",
+			zap.String("orgId", orgId), zap.String("username", username), zap.Error(err))
+		return
+	}
+	logger.Info("Sessions revoked",
+		zap.String("orgId", orgId), zap.String("username", username),
+		zap.Int64("count", n), zap.String("reason", reason))
+}
+
+// userActive tells whether the user still exists and is active.
+func userActive(ctx context.Context, conn *pgx.Conn, orgId string, username string) (bool, error) {
+	var active bool
+	err := conn.QueryRow(ctx,
+		`SELECT active FROM public.um_users WHERE "orgId"=$1 AND username=$2`,
+		orgId, username).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return active, err
+}
+
 func verifyToken(tokenString string) (*jwt.Token, error) {
 	var secretKey = []byte(os.Getenv("TOKEN_SECRET_KEY"))
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -151,19 +207,48 @@ func ProtectedHandler(c *gin.Context) {
 		username, uOK := claims["username"].(string)
 		orgId, orgOK := claims["orgId"].(string)
 
-		if uOK && orgOK {
+		sessionId, _ := claims["jti"].(string)
+
+		if uOK && orgOK && sessionId != "" {
+			active, err := utils.SessionActive(sessionId)
+			if err != nil {
+				logger.Warn("Session lookup failed", zap.Error(err))
+				c.JSON(http.StatusServiceUnavailable, model.Response{
+					Status: "-1",
+					Msg:    "Failed",
+					Desc:   "Cannot verify session",
+				})
+				c.Abort()
+				return
+			}
+			if !active {
+				c.JSON(http.StatusUnauthorized, model.Response{
+					Status: "-1",
+					Msg:    "Failed",
+					Desc:   "Session has expired or been revoked",
+				})
+				c.Abort()
+				return
+			}
 			logger.Debug("Verified user",
 				zap.String("username", username),
 				zap.String("orgId", orgId),
 			)
 			c.Set("username", username)
 			c.Set("orgId", orgId)
+			c.Set("sessionId", sessionId)
 			c.Set("tokenString", tokenString)
 			c.Next()
 			return
 		}
 	}
 
+	c.JSON(http.StatusUnauthorized, model.Response{
+		Status: "-1",
+		Msg:    "Failed",
+		Desc:   "Invalid token claims",
+	})
+	c.Abort()
 }
 
 // @summary Login
@@ -194,12 +279,11 @@ func UserLoginGet(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// Call Shared Logic
 	resp, err := LoginUser(ctx, c, conn, req)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, resp)
+		c.JSON(loginFailedStatus(c, err), resp)
 		return
 	}
 
@@ -228,11 +312,10 @@ func UserLoginPost(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	resp, err := LoginUser(ctx, c, conn, req)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, resp)
+		c.JSON(loginFailedStatus(c, err), resp)
 		return
 	}
 
@@ -255,7 +338,6 @@ func UserAddAuth(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
@@ -282,16 +364,33 @@ func UserAddAuth(c *gin.Context) {
 
 	// now req is ready to use
 
+	if err := passwordPolicy().Check(req.Password, req.Username); err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "UserAddAuth", "",
+			"create", -1, start_time, GetQueryParams(c), response, "Password policy : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
 	var enc string
 	var err error
 	var id int
-	enc, err = encrypt(req.Password)
+	enc, err = hashPassword(req.Password)
 	if err != nil {
 		//=======AUDIT_START=====//
 		_ = utils.InsertAuditLogs(
 			c, conn, orgId.(string), username.(string),
 			txtId, "", "Authentication", "UserAddAuth", "",
-			"login", -1, start_time, GetQueryParams(c), "", "encrypt fail : "+err.Error(),
+			"login", -1, start_time, GetQueryParams(c), "", "hash fail : "+err.Error(),
 		)
 		//=======AUDIT_END=====//
 		return
@@ -385,10 +484,57 @@ func RefreshToken(c *gin.Context) {
 	}
 
 	claims := token.Claims.(jwt.MapClaims)
-	username := claims["username"].(string)
-	orgId := claims["orgId"].(string)
+	username, _ := claims["username"].(string)
+	orgId, _ := claims["orgId"].(string)
+	sessionId, _ := claims["jti"].(string)
+	refreshId, _ := claims["rid"].(string)
+	if username == "" || orgId == "" || sessionId == "" || refreshId == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	// A user deleted or deactivated since the session began gets no new
+	// tokens, even if revoking its sessions failed at the time.
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "cannot verify user"})
+		return
+	}
+	defer cancel()
+	active, err := userActive(ctx, conn, orgId, username)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "cannot verify user"})
+		return
+	}
+	if !active {
+		RevokeUserSessions(orgId, username, "", "inactive on refresh")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user is inactive or has been removed"})
+		return
+	}
 
-	accessToken, refreshToken, err := CreateToken(username, orgId)
+	// Every refresh replaces the refresh id of the session, so an older
+	// refresh token showing up again means it was copied. The session is
+	// ended for both parties since we cannot tell which one is the owner.
+	newRefreshId := uuid.New().String()
+	result, err := utils.SessionRotate(orgId, username, sessionId, refreshId, newRefreshId, time.Now().Add(refreshTokenTimeout()))
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "cannot verify session"})
+		return
+	}
+	switch result {
+	case utils.SessionNotFound:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session has expired or been revoked"})
+		return
+	case utils.SessionReused:
+		utils.GetLog().Warn("Refresh token reused, session revoked",
+			zap.String("orgId", orgId), zap.String("username", username),
+			zap.String("sessionId", sessionId), zap.String("ip", c.ClientIP()))
+		_ = utils.SessionDel(sessionId)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reuse detected, session revoked"})
+		return
+	}
+
+	accessToken, refreshToken, err := CreateToken(username, orgId, sessionId, newRefreshId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
@@ -424,6 +570,16 @@ func UserLogout(c *gin.Context) {
 	orgId := GetVariableFromToken(c, "orgId")
 	username := GetVariableFromToken(c, "username")
 
+	if sessionId := c.GetString("sessionId"); sessionId != "" {
+		if err := utils.SessionDel(sessionId); err != nil {
+			logger.Warn("Session revoke failed", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, model.Response{
+				Status: "-1", Msg: "Failure", Desc: "Logout failed: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	conn, ctx, cancel := utils.ConnectDB()
 	if conn == nil {
 		c.JSON(http.StatusInternalServerError, model.Response{
@@ -432,7 +588,6 @@ func UserLogout(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	query := `
 		UPDATE public.um_users
@@ -473,6 +628,7 @@ func UserLogout(c *gin.Context) {
 }
 
 // @summary Verify Token
+// @description Signs in the user of an SSO access token. Users with two-factor authentication get an mfaToken instead of a session, as from login.
 // @tags Authentication
 // @security ApiKeyAuth
 // @id VerifyToken
@@ -513,7 +669,6 @@ func VerifyTokenHandler(c *gin.Context) {
 			return
 		}
 		defer cancel()
-		defer conn.Close(ctx)
 
 		orgId := os.Getenv("INTEGRATION_ORG_ID")
 
@@ -525,14 +680,12 @@ func VerifyTokenHandler(c *gin.Context) {
 		if user == nil {
 			log.Printf("User not found")
 		} else {
-			pass_, _ := decrypt(user.Password)
 			loginReq := model.Login{
 				Username:       user.Username,
-				Password:       pass_, // ⚠️ user.password should be encrypted
 				OrganizationId: &orgId,
 			}
 
-			resp, err := LoginUser(ctx, c, conn, loginReq)
+			resp, err := loginUser(ctx, c, conn, loginReq, false)
 			if err != nil {
 				c.JSON(http.StatusUnauthorized, resp)
 				return
@@ -576,12 +729,24 @@ func LoginUser(
 	conn *pgx.Conn,
 	req model.Login,
 ) (model.Response, error) {
+	return loginUser(ctx, c, conn, req, true)
+}
+
+// loginUser signs req.Username in. checkPassword is false only when the user
+// has already been authenticated elsewhere, as by the token introspection of
+// VerifyTokenHandler. The second factor is asked for either way.
+func loginUser(
+	ctx context.Context,
+	c *gin.Context,
+	conn *pgx.Conn,
+	req model.Login,
+	checkPassword bool,
+) (model.Response, error) {
 
 	//logger := utils.GetLog()
 	start_time := time.Now()
 	txtId := uuid.New().String()
 	log.Print("===LoginUser==")
-	log.Print(req)
 	// ========= Find Organization =========
 	var orgId string
 	if req.OrganizationId != nil {
@@ -603,7 +768,102 @@ func LoginUser(
 		}
 	}
 
+	// ========= Throttle =========
+	ip := c.ClientIP()
+	if checkPassword {
+		if err := checkLoginThrottle(orgId, req.Username, ip); err != nil {
+			resp := model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()}
+			_ = utils.InsertAuditLogs(
+				c, conn, orgId, req.Username,
+				txtId, "", "Authentication", "UserLoginPost", "",
+				"login", -1, start_time, GetQueryParams(c), resp, "throttled",
+			)
+			return resp, err
+		}
+	}
+
 	// ========= Find User =========
+	// An unknown username gets the same answer, after the same work, as a
+	// wrong password, so neither tells whether the username exists.
+	user, err := findLoginUser(ctx, conn, orgId, req.Username)
+	if err != nil {
+		resp := model.Response{Status: "-1", Desc: "Invalid credentials"}
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId, req.Username,
+			txtId, "", "Authentication", "UserLoginPost", "",
+			"login", -1, start_time, GetQueryParams(c), resp, "user not found : "+err.Error(),
+		)
+		if checkPassword {
+			burnPasswordCheck(req.Password)
+		}
+		if checkPassword && errors.Is(err, pgx.ErrNoRows) {
+			recordLoginFailure(ctx, c, conn, orgId, req.Username, ip)
+		}
+		return resp, err
+	}
+
+	// ========= Verify Password =========
+	if checkPassword {
+		matched, rehash, err := verifyPassword(user.Password, req.Password)
+		if err == nil && !matched {
+			err = fmt.Errorf("invalid credentials")
+		}
+		if err != nil {
+			resp := model.Response{Status: "-1", Desc: "Invalid credentials"}
+			_ = utils.InsertAuditLogs(
+				c, conn, orgId, req.Username,
+				txtId, "", "Authentication", "UserLoginPost", "",
+				"login", -1, start_time, GetQueryParams(c), resp, "invalid pass",
+			)
+			recordLoginFailure(ctx, c, conn, orgId, req.Username, ip)
+			return resp, err
+		}
+		// Passwords still encrypted with AES, or hashed with an older cost,
+		// are replaced now that the plain password is known. Login goes on
+		// if this fails; it is tried again next time.
+		if rehash {
+			if hash, err := hashPassword(req.Password); err == nil {
+				_, err = conn.Exec(ctx,
+					`UPDATE public.um_users SET password=$1 WHERE id=$2 AND password=$3`,
+					hash, user.ID, user.Password)
+				if err != nil {
+					log.Printf("Password rehash failed for %s: %v", user.Username, err)
+				}
+			}
+		}
+	}
+
+	// ========= Second Factor =========
+	// Logins vouched for by the auth server need it too, or a role with
+	// mfaRequired could be entered without one. The failure counter is only
+	// cleared once the login is complete, so repeating the password does
+	// not buy more tries at the code.
+	resp, pending, err := startMfaLogin(ctx, c, conn, orgId, user)
+	if err != nil {
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId, req.Username,
+			txtId, "", "Authentication", "UserLoginPost", "",
+			"login", -1, start_time, GetQueryParams(c), resp, "mfa check failed",
+		)
+		return resp, err
+	}
+	if pending {
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId, req.Username,
+			txtId, "", "Authentication", "UserLoginPost", "",
+			"login", 0, start_time, GetQueryParams(c), model.Response{Status: "0", Msg: "Success"}, "mfa pending",
+		)
+		return resp, nil
+	}
+	if checkPassword {
+		recordLoginSuccess(orgId, req.Username)
+	}
+
+	return completeLogin(ctx, c, conn, orgId, user, txtId, start_time)
+}
+
+// findLoginUser loads an active user with the fields returned on login.
+func findLoginUser(ctx context.Context, conn *pgx.Conn, orgId string, username string) (model.Um_User_Login, error) {
 	var user model.Um_User_Login
 	err := conn.QueryRow(ctx, `
 SELECT u.id, u."orgId", u."displayName", u.title,
@@ -622,7 +882,7 @@ LEFT JOIN public.um_user_with_area_response a
     ON a.username = u.username
    AND a."orgId" = u."orgId"
 WHERE u.username=$1 AND u."orgId"=$2 AND u.active=true;
-`, req.Username, orgId).Scan(
+`, username, orgId).Scan(
 		&user.ID, &user.OrgID, &user.DisplayName, &user.Title,
 		&user.FirstName, &user.MiddleName, &user.LastName,
 		&user.CitizenID, &user.Bod, &user.Blood, &user.Gender,
@@ -635,31 +895,24 @@ WHERE u.username=$1 AND u."orgId"=$2 AND u.active=true;
 		&user.UpdatedAt, &user.CreatedBy, &user.UpdatedBy,
 		&user.DistIdLists,
 	)
+	return user, err
+}
 
-	if err != nil {
-		resp := model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()}
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId, req.Username,
-			txtId, "", "Authentication", "UserLoginPost", "",
-			"login", -1, start_time, GetQueryParams(c), resp, "user not found",
-		)
-		return resp, err
-	}
-
-	// ========= Decrypt Password =========
-	dec, err := decrypt(user.Password)
-	if err != nil || subtle.ConstantTimeCompare([]byte(dec), []byte(req.Password)) != 1 {
-		resp := model.Response{Status: "-1", Desc: "Invalid credentials"}
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId, req.Username,
-			txtId, "", "Authentication", "UserLoginPost", "",
-			"login", -1, start_time, GetQueryParams(c), resp, "invalid pass",
-		)
-		return resp, err
-	}
+// completeLogin opens a session for a user whose credentials have been
+// checked and returns the login response.
+func completeLogin(
+	ctx context.Context,
+	c *gin.Context,
+	conn *pgx.Conn,
+	orgId string,
+	user model.Um_User_Login,
+	txtId string,
+	start_time time.Time,
+) (model.Response, error) {
+	user.Password = ""
 
 	// ========= Create Token =========
-	accessToken, refreshToken, err := CreateToken(user.Username, orgId)
+	accessToken, refreshToken, err := startSession(c, user.Username, orgId)
 	if err != nil {
 		resp := model.Response{Status: "-1", Desc: "Token creation failed"}
 		return resp, err
@@ -698,7 +951,7 @@ WHERE u.username=$1 AND u."orgId"=$2 AND u.active=true;
 	}
 
 	_ = utils.InsertAuditLogs(
-		c, conn, orgId, req.Username,
+		c, conn, orgId, user.Username,
 		txtId, "", "Authentication", "UserLoginPost", "",
 		"login", 0, start_time, GetQueryParams(c), resp, "success",
 	)
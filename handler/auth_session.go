@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// listSessions returns the live sessions of a user, newest first, marking the
+// one making the request.
+func listSessions(c *gin.Context, orgId string, username string) ([]model.AuthSession, error) {
+	sessions, err := utils.SessionList(orgId, username)
+	if err != nil {
+		return nil, err
+	}
+	current := c.GetString("sessionId")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// @summary Get My Sessions
+// @description Lists the active sessions of the signed in user
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Get My Sessions
+// @accept json
+// @produce json
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/sessions [get]
+func GetMySessions(c *gin.Context) {
+	conn, _, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	sessions, err := listSessions(c, orgId.(string), username.(string))
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "GetMySessions", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   sessions,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "GetMySessions", "",
+		"search", 0, start_time, GetQueryParams(c), response, "GetMySessions Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Get User Sessions
+// @description Lists the active sessions of a user
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Get User Sessions
+// @accept json
+// @produce json
+// @Param username path string true "username"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/users/username/{username}/sessions [get]
+func GetUserSessions(c *gin.Context) {
+	conn, _, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	target := c.Param("username")
+
+	sessions, err := listSessions(c, orgId.(string), target)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, target, "Authentication", "GetUserSessions", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   sessions,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, target, "Authentication", "GetUserSessions", "",
+		"search", 0, start_time, GetQueryParams(c), response, "GetUserSessions Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Kick User Session
+// @description Revokes one session of a user; its tokens stop working at once
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Kick User Session
+// @accept json
+// @produce json
+// @Param username path string true "username"
+// @Param sessionId path string true "sessionId"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/users/username/{username}/sessions/{sessionId} [delete]
+func KickUserSession(c *gin.Context) {
+	conn, _, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	target := c.Param("username")
+	sessionId := c.Param("sessionId")
+
+	session, err := utils.SessionGet(sessionId)
+	status := http.StatusInternalServerError
+	if err == nil && (session == nil || session.OrgID != orgId.(string) || session.Username != target) {
+		err = fmt.Errorf("session not found")
+		status = http.StatusNotFound
+	}
+	if err == nil {
+		err = utils.SessionDel(sessionId)
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, sessionId, "Authentication", "KickUserSession", "",
+			"delete", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Session revoked",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, sessionId, "Authentication", "KickUserSession", "",
+		"delete", 0, start_time, GetQueryParams(c), response, "KickUserSession Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Kick User Sessions
+// @description Revokes every session of a user
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Kick User Sessions
+// @accept json
+// @produce json
+// @Param username path string true "username"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/users/username/{username}/sessions [delete]
+func KickUserSessions(c *gin.Context) {
+	conn, _, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	target := c.Param("username")
+
+	n, err := utils.SessionDelUser(orgId.(string), target, "")
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, target, "Authentication", "KickUserSessions", "",
+			"delete", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   gin.H{"revoked": n},
+		Desc:   "Sessions revoked",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, target, "Authentication", "KickUserSessions", "",
+		"delete", 0, start_time, GetQueryParams(c), response, "KickUserSessions Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
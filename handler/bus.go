@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// BusMessage is a message delivered by a Bus, independent of the broker.
+// Offsets only identify a message within its Source: "kafka" for the Kafka
+// cluster, or one value per MemoryBus, whose offsets start over with it.
+type BusMessage struct {
+	Source    string
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+}
+
+// BusHandler processes one message. The message is acknowledged when the
+// handler returns nil. See each Bus for what happens to a failed message.
+type BusHandler func(msg *BusMessage) error
+
+// Bus is the publish/subscribe transport used by the ESB integration.
+// Subscribe blocks until ctx is cancelled or the bus is closed; subscribers
+// of the same topic share one position, so each message is handled once.
+type Bus interface {
+	Publish(ctx context.Context, topic string, key []byte, value []byte) error
+	Subscribe(ctx context.Context, name string, topic string, handle BusHandler) error
+	Close() error
+}
+
+// EsbBus is the bus used by ESB consumers and producers. It is set by
+// InitEsbBus at start up; tests may replace it with a MemoryBus.
+var EsbBus Bus
+
+// InitEsbBus selects the bus from ESB_BUS ("kafka" or "memory", default kafka).
+func InitEsbBus() {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("ESB_BUS"))) {
+	case "memory":
+		log.Println("ESB bus: in-memory")
+		EsbBus = NewMemoryBus()
+	default:
+		EsbBus = NewKafkaBus(strings.Split(os.Getenv("ESB_SERVER"), ","))
+	}
+}
+
+func esbBus() Bus {
+	if EsbBus == nil {
+		InitEsbBus()
+	}
+	return EsbBus
+}
+
+// publishWorkOrder sends an outbound work order. When the topic named by
+// topicEnv is configured it is published on EsbBus keyed by work order
+// number; otherwise it is posted to the Mettriq API at path.
+func publishWorkOrder(ctx context.Context, topicEnv string, path string, data map[string]interface{}) (string, error) {
+	topic := strings.TrimSpace(os.Getenv(topicEnv))
+	if topic == "" {
+		return callAPI(os.Getenv("METTTER_SERVER")+path, "POST", data)
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal data: %w", err)
+	}
+	key := ""
+	switch v := data["work_order_number"].(type) {
+	case string:
+		key = v
+	case *string:
+		if v != nil {
+			key = *v
+		}
+	}
+	if err := esbBus().Publish(ctx, topic, []byte(key), value); err != nil {
+		return "", err
+	}
+	return "published to " + topic, nil
+}
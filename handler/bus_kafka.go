@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// KafkaBus is the Bus backed by Kafka consumer groups and a sync producer.
+// A failed message is logged and still acknowledged, since a partition
+// cannot move past a message without committing it; callers that must keep
+// failures wrap their handler (ConsumeEsbTopic stores them as dead letters).
+type KafkaBus struct {
+	brokers []string
+
+	mu       sync.Mutex
+	producer sarama.SyncProducer
+}
+
+func NewKafkaBus(brokers []string) *KafkaBus {
+	return &KafkaBus{brokers: brokers}
+}
+
+// kafkaGroupHandler implements sarama.ConsumerGroupHandler. Messages of a
+// claim are handled one by one so the committed offset never runs ahead of
+// the work.
+type kafkaGroupHandler struct {
+	name   string
+	handle BusHandler
+}
+
+func (h *kafkaGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka %s joined group, claims: %v", h.name, session.Claims())
+	return nil
+}
+
+func (h *kafkaGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka %s leaving generation %d", h.name, session.GenerationID())
+	return nil
+}
+
+func (h *kafkaGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.handle(&BusMessage{
+				Source:    "kafka",
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+				Timestamp: msg.Timestamp,
+			}); err != nil {
+				log.Printf("❌ %s partition %d offset %d: %v", h.name, msg.Partition, msg.Offset, err)
+			}
+			session.MarkMessage(msg, "")
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// EsbGroupId returns the consumer group used for a topic. Every replica shares
+// the same group so each message is handled once across the cluster.
+func EsbGroupId(topic string) string {
+	prefix := strings.TrimSpace(os.Getenv("ESB_GROUP_ID"))
+	if prefix == "" {
+		prefix = "tix-backend"
+	}
+	return prefix + "." + topic
+}
+
+// NewEsbConsumerConfig returns the sarama config shared by all ESB consumers.
+// A group without a committed offset starts from ESB_OFFSET_INITIAL
+// ("oldest" or "newest", default oldest).
+func NewEsbConsumerConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	config.Consumer.Offsets.AutoCommit.Interval = time.Second
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("ESB_OFFSET_INITIAL")), "newest") {
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
+	return config
+}
+
+func kafkaRetry() (int, time.Duration) {
+	maxRetryInt, err_ := strconv.Atoi(os.Getenv("KAFKA_RETRY"))
+	if err_ != nil {
+		fmt.Println("Invalid KAFKA_RETRY, using default of 10")
+		maxRetryInt = 10 // default fallback
+	}
+	intervalInt, err_ := strconv.Atoi(os.Getenv("KAFKA_INTERVAL"))
+	if err_ != nil {
+		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
+		intervalInt = 10 // default fallback
+	}
+	return maxRetryInt, time.Duration(intervalInt) * time.Second
+}
+
+// Subscribe joins the consumer group of the topic and handles messages from
+// every assigned partition until ctx is cancelled. Processing resumes from
+// the last committed offset after a restart or a rebalance.
+func (b *KafkaBus) Subscribe(ctx context.Context, name string, topic string, handle BusHandler) error {
+	maxRetry, retryInterval := kafkaRetry()
+	groupId := EsbGroupId(topic)
+
+	var group sarama.ConsumerGroup
+	var err error
+
+	for attempt := 1; attempt <= maxRetry; attempt++ {
+		log.Printf("Attempt %d to connect to "+topic+" brokers: %v", attempt, b.brokers)
+		group, err = sarama.NewConsumerGroup(b.brokers, groupId, NewEsbConsumerConfig())
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to connect to "+topic+": %v", err)
+		if !sleepCtx(ctx, retryInterval) {
+			return nil
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not connect to "+topic+" after %d attempts: %w", maxRetry, err)
+	}
+
+	defer func() {
+		if err := group.Close(); err != nil {
+			log.Printf("Error closing %s consumer group: %v", name, err)
+		}
+	}()
+
+	go func() {
+		for err := range group.Errors() {
+			log.Printf("Kafka %s error: %v", name, err)
+		}
+	}()
+
+	log.Printf("Kafka %s started. Listening for messages... topic=%s group=%s", name, topic, groupId)
+
+	handler := &kafkaGroupHandler{name: name, handle: handle}
+	for {
+		// Consume returns on every rebalance and must be called again to
+		// rejoin the group.
+		if err := group.Consume(ctx, []string{topic}, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			log.Printf("Kafka %s consume error: %v", name, err)
+			if !sleepCtx(ctx, retryInterval) {
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (b *KafkaBus) syncProducer() (sarama.SyncProducer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.producer != nil {
+		return b.producer, nil
+	}
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer(b.brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	b.producer = producer
+	return producer, nil
+}
+
+// Publish sends the message and returns once every in-sync replica has it.
+func (b *KafkaBus) Publish(ctx context.Context, topic string, key []byte, value []byte) error {
+	producer, err := b.syncProducer()
+	if err != nil {
+		return fmt.Errorf("kafka producer: %w", err)
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(value)}
+	if len(key) > 0 {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	partition, offset, err := producer.SendMessage(msg)
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", topic, err)
+	}
+	log.Printf("Kafka published topic=%s partition=%d offset=%d", topic, partition, offset)
+	return nil
+}
+
+func (b *KafkaBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.producer == nil {
+		return nil
+	}
+	err := b.producer.Close()
+	b.producer = nil
+	return err
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryBus is an in-process Bus. Each topic is a single-partition log with
+// one shared position; messages published before a subscriber joins are
+// delivered when it does. A message whose handler fails is acknowledged like
+// on Kafka and kept in Failed for inspection.
+type MemoryBus struct {
+	source string
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	closed chan struct{}
+	once   sync.Once
+}
+
+type memoryTopic struct {
+	log      []*BusMessage
+	position int
+	failed   []*BusMessage
+	notify   chan struct{}
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		source: "memory:" + uuid.New().String(),
+		topics: map[string]*memoryTopic{},
+		closed: make(chan struct{}),
+	}
+}
+
+// topic must be called with b.mu held.
+func (b *MemoryBus) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{notify: make(chan struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, topic string, key []byte, value []byte) error {
+	select {
+	case <-b.closed:
+		return fmt.Errorf("bus closed")
+	default:
+	}
+	b.mu.Lock()
+	t := b.topic(topic)
+	t.log = append(t.log, &BusMessage{
+		Source:    b.source,
+		Topic:     topic,
+		Offset:    int64(len(t.log)),
+		Key:       append([]byte(nil), key...),
+		Value:     append([]byte(nil), value...),
+		Timestamp: time.Now(),
+	})
+	close(t.notify)
+	t.notify = make(chan struct{})
+	b.mu.Unlock()
+	return nil
+}
+
+// next claims the next unhandled message of the topic, or returns the channel
+// closed on the next publish.
+func (b *MemoryBus) next(topic string) (*BusMessage, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	if t.position < len(t.log) {
+		msg := t.log[t.position]
+		t.position++
+		return msg, nil
+	}
+	return nil, t.notify
+}
+
+func (b *MemoryBus) Subscribe(ctx context.Context, name string, topic string, handle BusHandler) error {
+	log.Printf("Memory bus %s subscribed to %s", name, topic)
+	for {
+		msg, wait := b.next(topic)
+		if msg == nil {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil
+			case <-b.closed:
+				return nil
+			}
+		}
+		if err := handle(msg); err != nil {
+			log.Printf("❌ %s offset %d: %v", name, msg.Offset, err)
+			b.mu.Lock()
+			t := b.topic(topic)
+			t.failed = append(t.failed, msg)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Messages returns every message published to the topic.
+func (b *MemoryBus) Messages(topic string) []*BusMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*BusMessage(nil), b.topic(topic).log...)
+}
+
+// Pending returns how many messages of the topic are not delivered yet.
+func (b *MemoryBus) Pending(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	return len(t.log) - t.position
+}
+
+// Failed returns the messages of the topic whose handler returned an error.
+func (b *MemoryBus) Failed(topic string) []*BusMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*BusMessage(nil), b.topic(topic).failed...)
+}
+
+func (b *MemoryBus) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
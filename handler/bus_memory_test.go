@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"mainPackage/model"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryWorkOrderStore keeps cases in memory for the ESB round trip test. It
+// is both the store the consumers write to and the reader outbound updates
+// are built from.
+type memoryWorkOrderStore struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+	cases map[string]*model.Case
+}
+
+func newMemoryWorkOrderStore() *memoryWorkOrderStore {
+	return &memoryWorkOrderStore{locks: map[string]*sync.Mutex{}, cases: map[string]*model.Case{}}
+}
+
+func (s *memoryWorkOrderStore) OpenSink(c *gin.Context, orgId string, username string) (WorkOrderSink, func(), error) {
+	return memoryWorkOrderSink{s}, func() {}, nil
+}
+
+func (s *memoryWorkOrderStore) caseStatus(caseId string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.cases[caseId]; ok {
+		return c.StatusID
+	}
+	return ""
+}
+
+type memoryWorkOrderSink struct {
+	s *memoryWorkOrderStore
+}
+
+func (k memoryWorkOrderSink) Lock(key string) (func(), error) {
+	k.s.mu.Lock()
+	l, ok := k.s.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		k.s.locks[key] = l
+	}
+	k.s.mu.Unlock()
+	l.Lock()
+	return l.Unlock, nil
+}
+
+func (k memoryWorkOrderSink) FindCase(wo model.WorkOrder) (string, bool, error) {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+	if _, ok := k.s.cases[wo.WorkOrderNumber]; ok {
+		return wo.WorkOrderNumber, true, nil
+	}
+	for id, c := range k.s.cases {
+		if wo.WorkOrderRefNumber != "" && c.IntegrationRefNumber != nil && *c.IntegrationRefNumber == wo.WorkOrderRefNumber {
+			return id, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (k memoryWorkOrderSink) Create(wo model.WorkOrder) error {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+	ref := wo.WorkOrderRefNumber
+	detail := wo.WorkOrderMetadata.Description
+	scheduled := false
+	k.s.cases[wo.WorkOrderNumber] = &model.Case{
+		CaseID:               wo.WorkOrderNumber,
+		StatusID:             GetCaseStatusMap()[wo.Status],
+		DistID:               "D01",
+		CaseSTypeID:          "ST01",
+		Priority:             1,
+		CaseDetail:           &detail,
+		ScheduleFlag:         &scheduled,
+		IntegrationRefNumber: &ref,
+	}
+	return nil
+}
+
+func (k memoryWorkOrderSink) Update(wo model.WorkOrder) error {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+	k.s.cases[wo.WorkOrderNumber].StatusID = GetCaseStatusMap()[wo.Status]
+	return nil
+}
+
+func (s *memoryWorkOrderStore) GetCase(ctx context.Context, orgId string, caseId string) (*model.Case, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *s.cases[caseId]
+	return &c, nil
+}
+
+func (s *memoryWorkOrderStore) GetArea(ctx context.Context, orgId string, distId string) (*model.AreaDistrict, error) {
+	th, ns := "District 1", "bangkok"
+	return &model.AreaDistrict{DistID: &distId, Th: &th, NameSpace: &ns}, nil
+}
+
+func (s *memoryWorkOrderStore) GetUser(ctx context.Context, orgId string, username string) (*model.User, error) {
+	return &model.User{Username: username, EmpID: "EMP-" + username, FirstName: "Unit", LastName: username}, nil
+}
+
+func (s *memoryWorkOrderStore) GetCaseSubType(ctx context.Context, orgId string, sTypeId string) (*model.CaseSubType, error) {
+	woType, deviceType := "REPAIR", "PUMP"
+	return &model.CaseSubType{STypeID: sTypeId, TH: "Pump failure", MWorkOrderType: &woType, MDeviceType: &deviceType}, nil
+}
+
+func (s *memoryWorkOrderStore) GetAttachments(ctx context.Context, orgId string, caseId string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (s *memoryWorkOrderStore) GetAssignedUnits(ctx context.Context, orgId string, caseId string) ([]model.UnitDispatch, error) {
+	return nil, nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestEsbWorkOrderRoundTrip runs work order create -> case -> status update ->
+// outbound publish through the real consumers on a MemoryBus.
+func TestEsbWorkOrderRoundTrip(t *testing.T) {
+	t.Setenv("ESB_WO_CREATE", "wo.create")
+	t.Setenv("ESB_WO_UPDATE", "wo.update")
+	t.Setenv("ESB_WO_OUT_UPDATE", "wo.out.update")
+	t.Setenv("INTEGRATION_SOURCE", "cms")
+	t.Setenv("INTEGRATION_ORG_ID", "org-1")
+	t.Setenv("INTEGRATION_USR", "integration")
+	t.Setenv("NEW", "S001")
+	t.Setenv("ASSIGNED", "S003")
+	t.Setenv("CONV_NEW", "S001")
+	t.Setenv("CONV_ASSIGNED", "S003")
+
+	bus := NewMemoryBus()
+	store := newMemoryWorkOrderStore()
+	prevBus, prevStore, prevParking := EsbBus, EsbWorkOrderStore, EsbWorkOrderParking
+	EsbBus, EsbWorkOrderStore, EsbWorkOrderParking = bus, store, &MemoryWorkOrderParking{}
+	t.Cleanup(func() {
+		bus.Close()
+		EsbBus, EsbWorkOrderStore, EsbWorkOrderParking = prevBus, prevStore, prevParking
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ESB_WORK_ORDER_CREATE(ctx)
+	go ESB_WORK_ORDER_UPDATE(ctx)
+
+	publish := func(topic string, wo model.WorkOrder) {
+		b, err := json.Marshal(wo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Publish(ctx, topic, []byte(wo.WorkOrderNumber), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wo := model.WorkOrder{
+		WorkOrderNumber:    "WO-100",
+		WorkOrderRefNumber: "REF-100",
+		Status:             "NEW",
+		Source:             "mettriq",
+		WorkOrderMetadata:  model.WorkOrderMeta{Description: "Pump 3 is down"},
+	}
+	publish("wo.create", wo)
+	waitFor(t, "case to be created", func() bool { return store.caseStatus("WO-100") == "S001" })
+
+	wo.Status = "ASSIGNED"
+	publish("wo.update", wo)
+	waitFor(t, "case status update", func() bool { return store.caseStatus("WO-100") == "S003" })
+
+	// Our own echo of the update is skipped by the consumer.
+	echo := wo
+	echo.Status = "NEW"
+	echo.Source = "cms"
+	publish("wo.update", echo)
+	waitFor(t, "echo to be consumed", func() bool { return bus.Pending("wo.update") == 0 })
+
+	err := publishWorkOrderUpdate(ctx, store, "org-1", model.UpdateStageRequest{
+		CaseId:   "WO-100",
+		Status:   "S003",
+		UnitUser: "unit-7",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bus.Messages("wo.out.update")
+	if len(out) != 1 {
+		t.Fatalf("outbound messages = %d, want 1", len(out))
+	}
+	if string(out[0].Key) != "WO-100" {
+		t.Errorf("outbound key = %q, want WO-100", out[0].Key)
+	}
+	var got struct {
+		WorkOrderNumber    string `json:"work_order_number"`
+		WorkOrderRefNumber string `json:"work_order_ref_number"`
+		WorkOrderType      string `json:"work_order_type"`
+		Status             string `json:"status"`
+		State              string `json:"state"`
+		Namespace          string `json:"namespace"`
+		Source             string `json:"source"`
+		UserMetadata       struct {
+			Assigned struct {
+				EmployeeCode string `json:"user_employee_code"`
+			} `json:"assigned_employee_code"`
+		} `json:"user_metadata"`
+	}
+	if err := json.Unmarshal(out[0].Value, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]string{
+		"work_order_number":      {got.WorkOrderNumber, "WO-100"},
+		"work_order_ref_number":  {got.WorkOrderRefNumber, "REF-100"},
+		"work_order_type":        {got.WorkOrderType, "REPAIR"},
+		"status":                 {got.Status, "ASSIGNED"},
+		"state":                  {got.State, "OPEN"},
+		"namespace":              {got.Namespace, "bangkok"},
+		"source":                 {got.Source, "cms"},
+		"assigned employee code": {got.UserMetadata.Assigned.EmployeeCode, "EMP-unit-7"},
+	}
+	for field, v := range want {
+		if v[0] != v[1] {
+			t.Errorf("%s = %q, want %q", field, v[0], v[1])
+		}
+	}
+	for _, topic := range []string{"wo.create", "wo.update"} {
+		if failed := bus.Failed(topic); len(failed) > 0 {
+			t.Errorf("%s: %d messages failed", topic, len(failed))
+		}
+	}
+}
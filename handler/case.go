@@ -78,6 +78,7 @@ func genCaseID() string {
 // @Param distId query string false "distId (can be comma-separated)"
 // @Param category query string false "category (alias for statusId)"
 // @Param createBy query string false "createBy"
+// @Param answer query []string false "form answer filter field:op:value, e.g. injured:gt:3 (repeatable)" collectionFormat(multi)
 // @Param orderBy query string false "orderBy (can be comma-separated)"
 // @Param direction query string false "direction (can be comma-separated)"
 // @response 200 {object} model.Response "OK - Request successful"
@@ -89,7 +90,6 @@ func ListCase(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	orgId := GetVariableFromToken(c, "orgId")
 	username := GetVariableFromToken(c, "username")
@@ -107,6 +107,13 @@ func ListCase(c *gin.Context) {
 	provId := c.Query("provId")
 	distId := c.Query("distId")
 	createBy := c.Query("createBy")
+	answerFilters, err := parseAnswerPredicates(c.QueryArray("answer"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1", Msg: "Failure", Desc: err.Error(),
+		})
+		return
+	}
 
 	// Load user info including dist list
 	userInfo, err := utils.GetAreaByUsernameOrLoad(c, conn, orgId.(string), username.(string))
@@ -241,6 +248,20 @@ func ListCase(c *gin.Context) {
 	}
 	baseQuery += fmt.Sprintf(` AND "distId" IN (%s)`, strings.Join(distPlaceholders, ","))
 
+	// Form answer filters
+	answerSQL, err := answerPredicateSQL(answerFilters, "tix_cases", func(v interface{}) string {
+		params = append(params, v)
+		paramIndex++
+		return fmt.Sprintf("$%d", paramIndex-1)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1", Msg: "Failure", Desc: err.Error(),
+		})
+		return
+	}
+	baseQuery += answerSQL
+
 	// More filters
 	if detail != "" {
 		baseQuery += fmt.Sprintf(` OR "caseDetail" ILIKE $%d`, paramIndex)
@@ -341,7 +362,6 @@ func ListCase_bk(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	orgId := GetVariableFromToken(c, "orgId")
 	//username := GetVariableFromToken(c, "username")
@@ -570,7 +590,6 @@ func CaseResult(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -707,7 +726,6 @@ func CaseById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	orgId := GetVariableFromToken(c, "orgId")
 	id := c.Param("id")
 	start_time := time.Now()
@@ -822,7 +840,6 @@ func CaseByCaseId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("caseId")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -903,7 +920,6 @@ func InsertCase(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	username := GetVariableFromToken(c, "username")
 	uuid := uuid.New()
@@ -1090,6 +1106,23 @@ func InsertCase(c *gin.Context) {
 		}
 	}
 
+	// Check the form answer before anything is written, so a rejected
+	// answer leaves no case behind.
+	if req.FormData != nil && req.FormData.FormId != "" {
+		if err := validateFormAnswer(ctx, conn, orgId.(string), *req.FormData); err != nil {
+			status, response := formAnswerFailure(err)
+			//=======AUDIT_START=====//
+			_ = utils.InsertAuditLogs(
+				c, conn, orgId.(string), username.(string),
+				uuid.String(), "", "Cases", "InsertCase", "",
+				"create", -1, start_time, GetQueryParams(c), response, "Failure : "+err.Error(),
+			)
+			//=======AUDIT_END=====//
+			c.JSON(status, response)
+			return
+		}
+	}
+
 	err = conn.QueryRow(ctx, query, args...).Scan(&id)
 	log.Print(err)
 	if err != nil {
@@ -1162,14 +1195,7 @@ func InsertCase(c *gin.Context) {
 	} else {
 		err = InsertFormAnswer(conn, ctx, orgId.(string), caseId, *req.FormData, username.(string))
 		if err != nil {
-			log.Fatal("Insert error:", err)
-		}
-		if err != nil {
-			response := model.Response{
-				Status: "-1",
-				Msg:    "Failure",
-				Desc:   err.Error(),
-			}
+			status, response := formAnswerFailure(err)
 			//=======AUDIT_START=====//
 			_ = utils.InsertAuditLogs(
 				c, conn, orgId.(string), username.(string),
@@ -1177,7 +1203,7 @@ func InsertCase(c *gin.Context) {
 				"create", -1, start_time, GetQueryParams(c), response, "Failure : "+err.Error(),
 			)
 			//=======AUDIT_END=====//
-			c.JSON(http.StatusInternalServerError, response)
+			c.JSON(status, response)
 			return
 		}
 	}
@@ -1288,7 +1314,6 @@ func UpdateCase(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -1307,6 +1332,24 @@ func UpdateCase(c *gin.Context) {
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
+
+	// Check the form answer before the case is updated, so a rejected answer
+	// leaves the case as it was.
+	if req.FormData != nil && req.FormData.FormId != "" {
+		if err := validateFormAnswer(ctx, conn, orgId.(string), *req.FormData); err != nil {
+			status, response := formAnswerFailure(err)
+			//=======AUDIT_START=====//
+			_ = utils.InsertAuditLogs(
+				c, conn, orgId.(string), username.(string),
+				txtId, id, "Cases", "UpdateCase", "",
+				"update", -1, now, GetQueryParams(c), response, "Failure : "+err.Error(),
+			)
+			//=======AUDIT_END=====//
+			c.JSON(status, response)
+			return
+		}
+	}
+
 	query := `UPDATE public."tix_cases" SET "caseVersion"=$3,"referCaseId"=$4,"caseTypeId"=$5,
 	"caseSTypeId"=$6,priority=$7,source=$8,"deviceId"=$9,"phoneNo"=$10,
 	"phoneNoHide"=$11,"caseDetail"=$12,"extReceive"=$13,"statusId"=$14,"caseLat"=$15,
@@ -1356,14 +1399,7 @@ func UpdateCase(c *gin.Context) {
 	} else {
 		err = UpdateFormAnswer(conn, ctx, orgId.(string), *req.CaseId, *req.FormData, username.(string))
 		if err != nil {
-			log.Fatal("Update Form error:", err)
-		}
-		if err != nil {
-			response := model.Response{
-				Status: "-1",
-				Msg:    "Failure",
-				Desc:   err.Error(),
-			}
+			status, response := formAnswerFailure(err)
 			//=======AUDIT_START=====//
 			_ = utils.InsertAuditLogs(
 				c, conn, orgId.(string), username.(string),
@@ -1371,7 +1407,7 @@ func UpdateCase(c *gin.Context) {
 				"update", -1, now, GetQueryParams(c), response, "Failure : "+err.Error(),
 			)
 			//=======AUDIT_END=====//
-			c.JSON(http.StatusInternalServerError, response)
+			c.JSON(status, response)
 			return
 		}
 	}
@@ -1429,7 +1465,6 @@ func DeleteCase(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	start_time := time.Now()
@@ -33,7 +33,6 @@ func GetCaseHistory(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -159,7 +158,6 @@ func GetCaseHistoryByCaseId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	caseId := c.Param("caseId")
 	orgId := GetVariableFromToken(c, "orgId")
@@ -277,7 +275,6 @@ func InsertCaseHistory(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	txtId := uuid.New().String()
 	start_time := time.Now()
 	var req model.CaseHistoryInsert
@@ -354,7 +351,7 @@ func InsertCaseHistory(c *gin.Context) {
 	additionalJsonMap := msg
 	additionalJSON, err := json.Marshal(additionalJsonMap)
 	if err != nil {
-		log.Printf("covent additionalData Error :", err)
+		log.Printf("covent additionalData Error : %v", err)
 	}
 	additionalData := json.RawMessage(additionalJSON)
 	event := "CASE-HISTORY"
@@ -393,7 +390,6 @@ func UpdateCaseHistory(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -482,7 +478,6 @@ func DeleteCaseHistory(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	orgId := GetVariableFromToken(c, "orgId")
 	id := c.Param("id")
 	start_time := time.Now()
@@ -0,0 +1,780 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Recurring case schedules. A schedule holds a case template and a cron rule;
+// ScheduleMonitor creates a case from the template at every occurrence and
+// activates it at once. Occurrences are claimed in case_schedule_runs, so one
+// occurrence never creates two cases. Occurrences missed while no monitor ran
+// are not made up: only the latest due one creates a case.
+
+const caseScheduleColumns = `id, "orgId", "scheduleId", title, rule, "timeZone", template, "autoDispatch", active,
+	"startAt", "endAt", "nextRunAt", "lastRunAt", "lastCaseId", "createdAt", "updatedAt", "createdBy", "updatedBy"`
+
+func scanCaseSchedule(row pgx.Row) (model.CaseSchedule, error) {
+	var s model.CaseSchedule
+	var template []byte
+	err := row.Scan(&s.ID, &s.OrgID, &s.ScheduleID, &s.Title, &s.Rule, &s.TimeZone, &template, &s.AutoDispatch, &s.Active,
+		&s.StartAt, &s.EndAt, &s.NextRunAt, &s.LastRunAt, &s.LastCaseID, &s.CreatedAt, &s.UpdatedAt, &s.CreatedBy, &s.UpdatedBy)
+	if err != nil {
+		return s, err
+	}
+	if len(template) > 0 {
+		if err := json.Unmarshal(template, &s.Template); err != nil {
+			return s, fmt.Errorf("schedule %s template: %w", s.ScheduleID, err)
+		}
+	}
+	return s, nil
+}
+
+// scheduleLocation loads the time zone of a schedule, TIME_ZONE when empty.
+func scheduleLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		tz = os.Getenv("TIME_ZONE")
+	}
+	if tz == "" {
+		tz = "Asia/Bangkok"
+	}
+	return time.LoadLocation(tz)
+}
+
+// nextScheduleRun returns the first occurrence of rule after after, read in
+// loc, or nil when there is none before endAt.
+func nextScheduleRun(rule *utils.CronRule, loc *time.Location, after time.Time, endAt *time.Time) *time.Time {
+	next := rule.Next(after.In(loc))
+	if next.IsZero() || (endAt != nil && next.After(*endAt)) {
+		return nil
+	}
+	return &next
+}
+
+// firstScheduleRun returns the first occurrence of a new or changed schedule:
+// the next one from now, or from startAt when that is later.
+func firstScheduleRun(rule *utils.CronRule, loc *time.Location, startAt *time.Time, endAt *time.Time) *time.Time {
+	after := time.Now()
+	if startAt != nil && startAt.After(after) {
+		after = startAt.Add(-time.Nanosecond)
+	}
+	return nextScheduleRun(rule, loc, after, endAt)
+}
+
+// checkCaseSchedule parses the rule and time zone of a schedule.
+func checkCaseSchedule(rule string, tz string, startAt *time.Time, endAt *time.Time) (*utils.CronRule, *time.Location, error) {
+	cron, err := utils.ParseCron(rule)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc, err := scheduleLocation(tz)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid time zone %q: %w", tz, err)
+	}
+	if startAt != nil && endAt != nil && endAt.Before(*startAt) {
+		return nil, nil, fmt.Errorf("endAt is before startAt")
+	}
+	return cron, loc, nil
+}
+
+// runCaseSchedules runs the occurrences of the schedules that are due. Every
+// schedule gets its own pooled connection, like checkSlaStages.
+func runCaseSchedules(leaseCtx context.Context, c *gin.Context, orgId string, username string) error {
+	var due []model.CaseSchedule
+	err := func() error {
+		conn, ctx, cancel := utils.ConnectDB()
+		if conn == nil {
+			return fmt.Errorf("DB connection is nil")
+		}
+		defer cancel()
+		rows, err := conn.Query(ctx, `SELECT `+caseScheduleColumns+`
+			FROM public.case_schedules
+			WHERE "orgId" = $1 AND active = TRUE AND "nextRunAt" <= NOW()
+			ORDER BY "nextRunAt"`, orgId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			s, err := scanCaseSchedule(rows)
+			if err != nil {
+				return err
+			}
+			due = append(due, s)
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range due {
+		if err := leaseCtx.Err(); err != nil {
+			return fmt.Errorf("case schedules stopped: %w", err)
+		}
+		func() {
+			conn, ctx, cancel := utils.ConnectDB()
+			if conn == nil {
+				log.Printf("DB connection is nil")
+				return
+			}
+			defer cancel()
+			if err := runCaseSchedule(ctx, c, conn, s, username); err != nil {
+				log.Printf("Case schedule %s: %v", s.ScheduleID, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// runCaseSchedule creates the case of the due occurrence of s and moves the
+// schedule on to its next occurrence after now. When creating the case fails
+// nothing of it is kept and the occurrence is retried on the next run.
+func runCaseSchedule(ctx context.Context, c *gin.Context, conn *pgx.Conn, s model.CaseSchedule, username string) error {
+	runAt := *s.NextRunAt
+	rule, loc, err := checkCaseSchedule(s.Rule, s.TimeZone, nil, nil)
+	if err != nil {
+		return err
+	}
+	after := time.Now()
+	if runAt.After(after) {
+		after = runAt
+	}
+	next := nextScheduleRun(rule, loc, after, s.EndAt)
+
+	caseId, err := createScheduledCase(ctx, c, conn, s, runAt, username)
+	if err != nil {
+		return err
+	}
+	if caseId != "" {
+		log.Printf("Case schedule %s created case %s for %s", s.ScheduleID, caseId, runAt.Format(time.RFC3339))
+	}
+
+	_, err = conn.Exec(ctx, `
+		UPDATE public.case_schedules SET "nextRunAt" = $4
+		WHERE "orgId" = $1 AND "scheduleId" = $2 AND "nextRunAt" = $3`, s.OrgID, s.ScheduleID, runAt, next)
+	return err
+}
+
+// createScheduledCase creates the case of one occurrence of s from its
+// template, scheduled at runAt, and activates it. It returns "" when the
+// occurrence has been claimed already. The claim, the case, its stage, form
+// answer and attachments and the schedule's last run are written in one
+// transaction, so an occurrence gets one whole case or none; notifications
+// and the activation follow once it is committed.
+func createScheduledCase(ctx context.Context, c *gin.Context, conn *pgx.Conn, s model.CaseSchedule, runAt time.Time, username string) (string, error) {
+	logger := utils.GetLog()
+	orgId := s.OrgID
+	req := s.Template
+
+	caseId, err := GenerateCaseID(ctx, conn, "D")
+	if err != nil {
+		caseId = genCaseID()
+	}
+	sType, err := utils.GetCaseSubTypeByCode(ctx, conn, orgId, req.CaseSTypeID)
+	if err != nil {
+		log.Printf("sType Error: %v", err)
+	}
+	caseSLA := "0"
+	if sType != nil {
+		caseSLA = sType.CaseSLA
+		if req.WfID == nil || *req.WfID == "" {
+			req.WfID = &sType.WFID
+		}
+	}
+
+	statusId := os.Getenv("NEW")
+	if sch := scheduledStatuses(); len(sch) > 1 {
+		statusId = sch[0]
+	}
+	scheduleFlag := true
+	now := time.Now()
+	ref := uuid.New()
+	req.CaseId = &caseId
+	req.StatusID = statusId
+	req.ScheduleFlag = &scheduleFlag
+	req.ScheduleDate = &runAt
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO public.case_schedule_runs ("orgId", "scheduleId", "runAt", "caseId", "createdAt")
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT DO NOTHING`, orgId, s.ScheduleID, runAt, caseId)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO public."tix_cases" (
+			"orgId", "caseId", "caseVersion", "referCaseId", "caseTypeId", "caseSTypeId",
+			priority, "wfId", "versions", source,
+			"deviceId", "phoneNo", "phoneNoHide", "caseDetail", "extReceive",
+			"statusId", "caseLat", "caseLon", "caselocAddr", "caselocAddrDecs",
+			"countryId", "provId", "distId", "caseDuration",
+			"createdDate", "startedDate", usercreate, "scheduleFlag", "scheduleDate",
+			"createdAt", "updatedAt", "createdBy", "updatedBy", "caseSla", "integration_ref_number"
+		) VALUES (
+			$1,$2,$3,$4,$5,$6,
+			$7,$8,$9,$10,
+			$11,$12,$13,$14,$15,
+			$16,$17,$18,$19,$20,
+			$21,$22,$23,$24,
+			$25,$25,$26,$27,$25,
+			$28,$28,$26,$26,$29,$30
+		)`,
+		orgId, caseId, req.CaseVersion, req.ReferCaseID, req.CaseTypeID, req.CaseSTypeID,
+		req.Priority, req.WfID, req.WfVersions, req.Source,
+		req.DeviceID, req.PhoneNo, req.PhoneNoHide, req.CaseDetail, req.ExtReceive,
+		statusId, req.CaseLat, req.CaseLon, req.CaseLocAddr, req.CaseLocAddrDecs,
+		req.CountryID, req.ProvID, req.DistID, req.CaseDuration,
+		runAt, username, scheduleFlag,
+		now, caseSLA, ref,
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert case failed: %w", err)
+	}
+
+	// The helpers below write through conn, inside tx.
+	if req.NodeID != "" {
+		err = CaseResponseAndCurrentStageInsert(conn, ctx, c, model.CustomCaseCurrentStage{
+			CaseID:   caseId,
+			WfID:     req.WfID,
+			NodeID:   req.NodeID,
+			StatusID: statusId,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if req.FormData != nil && req.FormData.FormId != "" {
+		if err := InsertFormAnswer(conn, ctx, orgId, caseId, *req.FormData, username); err != nil {
+			return "", err
+		}
+	}
+	if err := InsertCaseAttachments(ctx, conn, orgId, caseId, username, req.Attachments, logger); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE public.case_schedules SET "lastRunAt" = $3, "lastCaseId" = $4
+		WHERE "orgId" = $1 AND "scheduleId" = $2`, orgId, s.ScheduleID, runAt, caseId)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(model.OwnerCaseSyncReq{CaseId: caseId, Type: "create", Count: 0})
+	if err == nil {
+		utils.CaseSyncSet(caseId, string(b))
+	}
+	if sType != nil {
+		CreateBusKafka_WO(c, conn, req, sType, ref.String(), os.Getenv("INTEGRATION_SOURCE"), username)
+	}
+
+	evt := model.CaseHistoryEvent{
+		OrgID:     orgId,
+		CaseID:    caseId,
+		Username:  username,
+		Type:      "event",
+		FullMsg:   "สร้างตามกำหนดการ : " + s.Title,
+		JsonData:  map[string]interface{}{"type": "SCHEDULE-CREATE", "scheduleId": s.ScheduleID, "runAt": runAt},
+		CreatedBy: username,
+	}
+	if err := InsertCaseHistoryEvent(ctx, conn, evt); err != nil {
+		log.Printf("Schedule history of %s: %v", caseId, err)
+	}
+	recipients := []model.Recipient{
+		{Type: "provId", Value: req.ProvID},
+	}
+	if err := CalDashboardCaseSummary(ctx, conn, orgId, recipients, username, req.CaseTypeID, req.CountryID, req.ProvID, req.DistID); err != nil {
+		log.Printf("AddOrUpdateCaseSummary failed: %v", err)
+	}
+
+	// A failed activation is retried by activateDueCases; the case exists.
+	if _, err := ActivateScheduledCase(ctx, c, conn, orgId, caseId, runAt, username, s.AutoDispatch); err != nil {
+		log.Printf("Activate scheduled case %s: %v", caseId, err)
+	}
+	return caseId, nil
+}
+
+// @summary Get Case Schedules
+// @description Lists the recurring case schedules
+// @tags Case Schedules
+// @security ApiKeyAuth
+// @id Get Case Schedules
+// @accept json
+// @produce json
+// @Param start query int false "start" default(0)
+// @Param length query int false "length" default(1000)
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case_schedules [get]
+func ListCaseSchedules(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	start, err := strconv.Atoi(c.DefaultQuery("start", "0"))
+	if err != nil {
+		start = 0
+	}
+	length, err := strconv.Atoi(c.DefaultQuery("length", "1000"))
+	if err != nil {
+		length = 1000
+	}
+
+	schedules := []model.CaseSchedule{}
+	rows, err := conn.Query(ctx, `SELECT `+caseScheduleColumns+`
+		FROM public.case_schedules WHERE "orgId" = $1
+		ORDER BY id LIMIT $2 OFFSET $3`, orgId, length, start)
+	if err == nil {
+		for rows.Next() {
+			var s model.CaseSchedule
+			if s, err = scanCaseSchedule(rows); err != nil {
+				break
+			}
+			schedules = append(schedules, s)
+		}
+		rows.Close()
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "CaseSchedule", "ListCaseSchedules", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   schedules,
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "CaseSchedule", "ListCaseSchedules", "",
+		"search", 0, start_time, GetQueryParams(c), response, "ListCaseSchedules Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Get Case Schedule by ID
+// @description Returns a recurring case schedule with its next five occurrences
+// @tags Case Schedules
+// @security ApiKeyAuth
+// @id Get Case Schedule by ID
+// @accept json
+// @produce json
+// @Param id path string true "scheduleId"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case_schedules/{id} [get]
+func GetCaseSchedule(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	id := c.Param("id")
+
+	s, err := scanCaseSchedule(conn.QueryRow(ctx, `SELECT `+caseScheduleColumns+`
+		FROM public.case_schedules WHERE "orgId" = $1 AND "scheduleId" = $2`, orgId, id))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, pgx.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "CaseSchedule", "GetCaseSchedule", "",
+			"view", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+	if rule, loc, err := checkCaseSchedule(s.Rule, s.TimeZone, nil, nil); err == nil && s.NextRunAt != nil {
+		at := s.NextRunAt
+		for i := 0; i < 5 && at != nil; i++ {
+			s.NextRuns = append(s.NextRuns, *at)
+			at = nextScheduleRun(rule, loc, *at, s.EndAt)
+		}
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   s,
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "CaseSchedule", "GetCaseSchedule", "",
+		"view", 0, start_time, GetQueryParams(c), response, "GetCaseSchedule Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Get Case Schedule Runs
+// @description Lists the occurrences of a recurring case schedule and the cases created for them, latest first
+// @tags Case Schedules
+// @security ApiKeyAuth
+// @id Get Case Schedule Runs
+// @accept json
+// @produce json
+// @Param id path string true "scheduleId"
+// @Param length query int false "length" default(100)
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case_schedules/{id}/runs [get]
+func GetCaseScheduleRuns(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	id := c.Param("id")
+	length, err := strconv.Atoi(c.DefaultQuery("length", "100"))
+	if err != nil {
+		length = 100
+	}
+
+	runs := []model.CaseScheduleRun{}
+	rows, err := conn.Query(ctx, `
+		SELECT "scheduleId", "runAt", "caseId", "createdAt"
+		FROM public.case_schedule_runs
+		WHERE "orgId" = $1 AND "scheduleId" = $2
+		ORDER BY "runAt" DESC LIMIT $3`, orgId, id, length)
+	if err == nil {
+		for rows.Next() {
+			var r model.CaseScheduleRun
+			if err = rows.Scan(&r.ScheduleID, &r.RunAt, &r.CaseID, &r.CreatedAt); err != nil {
+				break
+			}
+			runs = append(runs, r)
+		}
+		rows.Close()
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "CaseSchedule", "GetCaseScheduleRuns", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   runs,
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "CaseSchedule", "GetCaseScheduleRuns", "",
+		"search", 0, start_time, GetQueryParams(c), response, "GetCaseScheduleRuns Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Create Case Schedule
+// @description Creates a recurring case schedule. rule is a five field cron rule (minute hour day month weekday) read in timeZone, TIME_ZONE by default; a case is created from template at every occurrence between startAt and endAt.
+// @tags Case Schedules
+// @security ApiKeyAuth
+// @id Create Case Schedule
+// @accept json
+// @produce json
+// @param Body body model.CaseScheduleInsert true "Schedule"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case_schedules/add [post]
+func InsertCaseSchedule(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	fail := func(status int, msg string) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   msg,
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "CaseSchedule", "InsertCaseSchedule", "",
+			"create", -1, start_time, GetQueryParams(c), response, "Failed : "+msg,
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.CaseScheduleInsert
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	rule, loc, err := checkCaseSchedule(req.Rule, req.TimeZone, req.StartAt, req.EndAt)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	template, err := json.Marshal(req.Template)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	scheduleId := uuid.New().String()
+	next := firstScheduleRun(rule, loc, req.StartAt, req.EndAt)
+
+	_, err = conn.Exec(ctx, `
+		INSERT INTO public.case_schedules (
+			"orgId", "scheduleId", title, rule, "timeZone", template, "autoDispatch", active,
+			"startAt", "endAt", "nextRunAt", "createdAt", "updatedAt", "createdBy", "updatedBy")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12, $13, $13)`,
+		orgId, scheduleId, req.Title, req.Rule, loc.String(), template, req.AutoDispatch, active,
+		req.StartAt, req.EndAt, next, start_time, username)
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Create successfully",
+		Data:   gin.H{"scheduleId": scheduleId, "nextRunAt": next},
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, scheduleId, "CaseSchedule", "InsertCaseSchedule", "",
+		"create", 0, start_time, GetQueryParams(c), response, "InsertCaseSchedule Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Update Case Schedule
+// @description Updates a recurring case schedule; its next occurrence is worked out again from now
+// @tags Case Schedules
+// @security ApiKeyAuth
+// @id Update Case Schedule
+// @accept json
+// @produce json
+// @Param id path string true "scheduleId"
+// @param Body body model.CaseScheduleUpdate true "Schedule"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case_schedules/{id} [patch]
+func UpdateCaseSchedule(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	id := c.Param("id")
+
+	fail := func(status int, msg string) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   msg,
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "CaseSchedule", "UpdateCaseSchedule", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+msg,
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.CaseScheduleUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	rule, loc, err := checkCaseSchedule(req.Rule, req.TimeZone, req.StartAt, req.EndAt)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	template, err := json.Marshal(req.Template)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	next := firstScheduleRun(rule, loc, req.StartAt, req.EndAt)
+
+	tag, err := conn.Exec(ctx, `
+		UPDATE public.case_schedules
+		SET title = $3, rule = $4, "timeZone" = $5, template = $6, "autoDispatch" = $7, active = $8,
+		    "startAt" = $9, "endAt" = $10, "nextRunAt" = $11, "updatedAt" = $12, "updatedBy" = $13
+		WHERE "orgId" = $1 AND "scheduleId" = $2`,
+		orgId, id, req.Title, req.Rule, loc.String(), template, req.AutoDispatch, req.Active,
+		req.StartAt, req.EndAt, next, start_time, username)
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		fail(http.StatusNotFound, "schedule not found")
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Update successfully",
+		Data:   gin.H{"scheduleId": id, "nextRunAt": next},
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "CaseSchedule", "UpdateCaseSchedule", "",
+		"update", 0, start_time, GetQueryParams(c), response, "UpdateCaseSchedule Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Delete Case Schedule
+// @description Deletes a recurring case schedule; cases it created are kept
+// @tags Case Schedules
+// @security ApiKeyAuth
+// @id Delete Case Schedule
+// @accept json
+// @produce json
+// @Param id path string true "scheduleId"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case_schedules/{id} [delete]
+func DeleteCaseSchedule(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	id := c.Param("id")
+
+	tag, err := conn.Exec(ctx, `
+		DELETE FROM public.case_schedules WHERE "orgId" = $1 AND "scheduleId" = $2`, orgId, id)
+	status := http.StatusInternalServerError
+	if err == nil && tag.RowsAffected() == 0 {
+		err = fmt.Errorf("schedule not found")
+		status = http.StatusNotFound
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "CaseSchedule", "DeleteCaseSchedule", "",
+			"delete", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Delete successfully",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "CaseSchedule", "DeleteCaseSchedule", "",
+		"delete", 0, start_time, GetQueryParams(c), response, "DeleteCaseSchedule Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
@@ -32,7 +32,6 @@ func GetCaseStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	id := c.Param("id")
@@ -155,7 +154,6 @@ func GetCaseStatusById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	// orgId := GetVariableFromToken(c, "orgId")
 	query := `SELECT id, "statusId", th, en, color, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.case_status WHERE "id"=$1 `
@@ -237,7 +235,6 @@ func InsertCaseStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.CaseStatusInsert
@@ -332,7 +329,6 @@ func UpdateCaseStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -427,7 +423,6 @@ func DeleteCaseStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	orgId := GetVariableFromToken(c, "orgId")
 	id := c.Param("id")
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"context"
 	"mainPackage/model"
+	"mainPackage/repository"
 	"mainPackage/utils"
 	"net/http"
 	"strconv"
@@ -27,22 +29,13 @@ func ListCaseTypeWithSubtype(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
 
-	query := `SELECT t1."typeId",t1."orgId",t1."en",t1."th",t1."active",t2."sTypeId",t2."sTypeCode",
-	t2."en",t2."th",t2."wfId", t2."caseSla", t2.priority, t2."userSkillList", t2."unitPropLists", t2.active
-	FROM public.case_types t1
-	FULL JOIN public.case_sub_types t2
-	ON t1."typeId" = t2."typeId"
-	WHERE t1."orgId"=$1`
-	logger.Debug(`Query`, zap.String("query", query))
-
-	rows, err := conn.Query(ctx, query, orgId)
+	caseLists, errorMsg, err := listCaseTypesWithSubtype(ctx, conn, orgId.(string))
 	if err != nil {
 		logger.Warn("Query failed", zap.Error(err))
 		response := model.Response{
@@ -60,26 +53,6 @@ func ListCaseTypeWithSubtype(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, response)
 		return
 	}
-	defer rows.Close()
-
-	var caseLists []model.CaseTypeWithSubType
-	var errorMsg string
-	for rows.Next() {
-		var cusCase model.CaseTypeWithSubType
-		err := rows.Scan(
-			&cusCase.TypeID, &cusCase.OrgID, &cusCase.TypeEN, &cusCase.TypeTH, &cusCase.TypeActive,
-			&cusCase.SubTypeID, &cusCase.SubTypeCode, &cusCase.SubTypeEN, &cusCase.SubTypeTH,
-			&cusCase.WfID, &cusCase.CaseSla, &cusCase.Priority,
-			&cusCase.UserSkillList, &cusCase.UnitPropLists, &cusCase.SubTypeActive,
-		)
-		if err != nil {
-			logger.Warn("Query failed", zap.Error(err))
-			errorMsg = err.Error()
-			continue
-		}
-
-		caseLists = append(caseLists, cusCase)
-	}
 
 	// Final JSON
 	response := model.Response{
@@ -102,6 +75,46 @@ func ListCaseTypeWithSubtype(c *gin.Context) {
 	logger.Info(logStr)
 }
 
+// listCaseTypesWithSubtype returns every case type of the organization with
+// its subtypes, one row per subtype. Rows that cannot be read are skipped and
+// the last scan error is returned as a message.
+func listCaseTypesWithSubtype(ctx context.Context, db repository.DBTX, orgId string) ([]model.CaseTypeWithSubType, string, error) {
+	logger := utils.GetLog()
+	query := `SELECT t1."typeId",t1."orgId",t1."en",t1."th",t1."active",t2."sTypeId",t2."sTypeCode",
+	t2."en",t2."th",t2."wfId", t2."caseSla", t2.priority, t2."userSkillList", t2."unitPropLists", t2.active
+	FROM public.case_types t1
+	FULL JOIN public.case_sub_types t2
+	ON t1."typeId" = t2."typeId"
+	WHERE t1."orgId"=$1`
+	logger.Debug(`Query`, zap.String("query", query))
+
+	rows, err := db.Query(ctx, query, orgId)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var caseLists []model.CaseTypeWithSubType
+	var errorMsg string
+	for rows.Next() {
+		var cusCase model.CaseTypeWithSubType
+		err := rows.Scan(
+			&cusCase.TypeID, &cusCase.OrgID, &cusCase.TypeEN, &cusCase.TypeTH, &cusCase.TypeActive,
+			&cusCase.SubTypeID, &cusCase.SubTypeCode, &cusCase.SubTypeEN, &cusCase.SubTypeTH,
+			&cusCase.WfID, &cusCase.CaseSla, &cusCase.Priority,
+			&cusCase.UserSkillList, &cusCase.UnitPropLists, &cusCase.SubTypeActive,
+		)
+		if err != nil {
+			logger.Warn("Query failed", zap.Error(err))
+			errorMsg = err.Error()
+			continue
+		}
+
+		caseLists = append(caseLists, cusCase)
+	}
+	return caseLists, errorMsg, rows.Err()
+}
+
 // @summary List Cases Type
 // @tags Case-Types and Case-SubTypes
 // @security ApiKeyAuth
@@ -119,7 +132,6 @@ func ListCaseType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -211,7 +223,6 @@ func InsertCaseType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -301,7 +312,6 @@ func UpdateCaseType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -394,7 +404,6 @@ func DeleteCaseType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	start_time := time.Now()
@@ -455,7 +464,6 @@ func ListCaseSubType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -549,7 +557,6 @@ func InsertCaseSubType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -639,7 +646,6 @@ func UpdateCaseSubType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -737,7 +743,6 @@ func DeleteCaseSubType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	start_time := time.Now()
@@ -32,7 +32,6 @@ func GetCommand(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -149,7 +148,6 @@ func GetCommandById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	orgId := GetVariableFromToken(c, "orgId")
 	query := `SELECT id, "deptId", "orgId", "commId", en, th, active, "createdAt", "updatedAt", "createdBy", "updatedBy" 
 	FROM public.sec_commands WHERE "commId"=$1 AND "orgId"=$2`
@@ -235,7 +233,6 @@ func InsertCommand(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	start_time := time.Now()
 	txtId := uuid.New().String()
@@ -328,7 +325,6 @@ func UpdateCommand(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -422,7 +418,6 @@ func DeleteCommand(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	orgId := GetVariableFromToken(c, "orgId")
@@ -4,14 +4,13 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"mainPackage/model"
+	"mainPackage/repository"
 	"mainPackage/utils"
 	"os"
 	"reflect"
@@ -71,30 +70,8 @@ func deriveKey(passphrase string) []byte {
 	return hash[:] // 32 bytes
 }
 
-func encrypt(plaintext string) (string, error) {
-	key := deriveKey(os.Getenv("SECRET_KEY"))
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
-	}
-
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
-	}
-
-	ciphertext := aesGCM.Seal(nonce, nonce, []byte(plaintext), nil)
-
-	// base64-encode so it's printable
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
-}
-
+// decrypt reads a password stored with the AES encryption used before
+// passwords were hashed. See verifyPassword.
 func decrypt(ciphertextBase64 string) (string, error) {
 	// logger := config.GetLog()
 	key := deriveKey(os.Getenv("SECRET_KEY"))
@@ -204,23 +181,31 @@ func CaseResponseAndCurrentStageInsert(conn *pgx.Conn, ctx context.Context, c *g
 		return err
 	}
 	log.Print("===CaseResponseAndCurrentStageInsert_2===")
-	// Step 2: Load workflow node from DB
+	// Step 2: Load workflow node of the version the case is pinned to
+	wfId := ""
+	if req.WfID != nil {
+		wfId = *req.WfID
+	}
+	version, err := pinCaseWorkflow(ctx, conn, orgId.(string), req.CaseID, wfId, username.(string))
+	if err != nil {
+		return fmt.Errorf("pin workflow version: %w", err)
+	}
 	query := `
 	SELECT t1.id, t1."orgId", t1."wfId", t1."nodeId", t1.versions, t1.type, t1.section, t1.data,
 	       t1.pic, t1."group", t1."formId", t1."createdAt", t1."updatedAt", t1."createdBy", t1."updatedBy"
 	FROM public.wf_nodes t1
 	JOIN public.wf_definitions t2
-	  ON t1."versions" = t2."versions" AND t1."wfId" = t2."wfId"
-	WHERE t2."wfId" = $1 AND t1."nodeId" = $2 AND t2."orgId" = $3
+	  ON t1."wfId" = t2."wfId"
+	WHERE t2."wfId" = $1 AND t1."nodeId" = $2 AND t2."orgId" = $3 AND t1."versions" = $4
 	`
 
 	logger.Debug("Loading workflow node",
 		zap.String("query", query),
-		zap.Any("params", []any{req.WfID, req.NodeID, orgId}),
+		zap.Any("params", []any{req.WfID, req.NodeID, orgId, version}),
 	)
 
 	var workflow model.WfNode
-	err = conn.QueryRow(ctx, query, req.WfID, req.NodeID, orgId).Scan(
+	err = conn.QueryRow(ctx, query, req.WfID, req.NodeID, orgId, version).Scan(
 		&workflow.ID, &workflow.OrgID, &workflow.WfID, &workflow.NodeID,
 		&workflow.Versions, &workflow.Type, &workflow.Section,
 		&workflow.Data, &workflow.Pic, &workflow.Group, &workflow.FormID,
@@ -279,6 +264,24 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 	orgId := GetVariableFromToken(ctx, "orgId")
 	log.Print("====9===")
 
+	// Updates of one case run one at a time from reading its stages until the
+	// new stages are written, so branches of a parallel flow finishing
+	// together each see the others' stages and the join fires once. Called
+	// inside a transaction of the caller, like SnapshotWorkflowVersion, the
+	// update joins it and the lock is held until that transaction ends.
+	commit := func(context.Context) error { return nil }
+	if conn.PgConn().TxStatus() == 'I' {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return model.Response{Status: "-1", Msg: "Failure.UpdateCurrentStageCore.lock-" + req.CaseId, Desc: err.Error()}, err
+		}
+		defer tx.Rollback(context.Background())
+		commit = tx.Commit
+	}
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "case:"+orgId.(string)+":"+req.CaseId); err != nil {
+		return model.Response{Status: "-1", Msg: "Failure.UpdateCurrentStageCore.lock-" + req.CaseId, Desc: err.Error()}, err
+	}
+
 	caseData, err := GetCaseByID(ctx, conn, orgId.(string), req.CaseId)
 	if err != nil {
 		log.Print(err)
@@ -325,6 +328,7 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 
 	var caseStages model.CurrentStage
 	var unitStages model.CurrentStage
+	var caseRows []model.CurrentStage
 	for rows.Next() {
 		var stage model.CurrentStage
 		if err := rows.Scan(
@@ -349,7 +353,10 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 		//stages = append(stages, stage)
 		if stage.StageType == "case" {
 			log.Println("---CASE---")
-			caseStages = stage
+			caseRows = append(caseRows, stage)
+			if stage.UnitID == "" {
+				caseStages = stage
+			}
 		}
 		if stage.StageType == "unit" {
 			log.Println("---UNIT--->>")
@@ -390,56 +397,18 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 
 	log.Print("------CHECK---")
 	// 🔹 Step 3: Check next node
-	CaseNextNode, UnitNextNode, caseCount, unitCount := GetNextNode(allNodesId, nodeConn, caseStages, unitStages, logger)
-
-	fmt.Println("Case Next Node:", CaseNextNode)
-	fmt.Println("Unit Next Node:", UnitNextNode)
-	fmt.Println("caseCount:", caseCount)
-	fmt.Println("unitCount:", unitCount)
-
-	//Check Stage
-	dataMaps := UnitNextNode.Data.(map[string]interface{})
-	data2 := dataMaps["data"].(map[string]interface{})
-	config := data2["config"].(map[string]interface{})
-	log.Print("======dataMaps==")
-	log.Print(config["action"])
-	log.Print(req)
-
-	//Check Close Case
-	if req.ResID != "" {
-		if config["action"] == req.Status {
-			log.Print("--> 1. Case Close")
-			Result, err := UpdateCaseCurrentStage(ctx, conn, req, CaseNextNode, "case", username.(string))
-			if err != nil {
-				return Result, err
-			}
-
-			//--Update tix_cases on time (Group status)
-			Result_, err := DispatchReponseAndUpdateCaseStatus(ctx, conn, req, username.(string))
-			if err != nil {
-
-				log.Printf("Update status failed: %v", err)
-			} else {
-				log.Print(Result_)
-				log.Println("Case status updated successfully-1")
-			}
-			GenerateNotiAndComment(ctx, conn, req, orgId.(string), "0", &req.ResDetail)
-			//-->New Function for close
-			log.Print("--> 1.1 CalDashboardSLA")
-			if esb {
-				UpdateBusKafka_WO(ctx, conn, req)
-			}
-			log.Print("--> 1.2 CalDashboardSLA")
-			CalDashboardSLA(ctx, conn, orgId.(string), username.(string), req.CaseId)
-			return Result, err
-		} else {
-			log.Println("Status worng number-1 :", err)
-			return model.Response{Status: "-1", Msg: "Failure.3", Desc: "Status worng number!"}, err
-		}
-
+	decisions := newDecisionEvaluator(ctx, conn, orgId.(string), caseData)
+	decide := func(node model.WorkflowNode) model.WorkflowNode {
+		return decisions.Decide(node, nodeConn, allNodesId)
+	}
+	store := newDBStageStore(ctx, conn, orgId.(string), req.CaseId, username.(string))
+	par := newParallelFlow(orgId.(string), req.CaseId, allNodesId, nodeConn, decide, caseRows, store)
+	plan, res, err := planStageUpdate(&req, par, unitStages, allNodesId, nodeConn, dispatchNode, decisions, logger)
+	if res.Status != "" || err != nil {
+		return res, err
 	}
 
-	if unitCount == 0 || (config["action"] == req.Status) {
+	if plan.Step != stageStepClose {
 		// 1. Insert responder
 
 		createdAt := time.Now().UTC()
@@ -468,28 +437,42 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 		if err != nil {
 			return result, err
 		}
-
-	} else {
-		log.Printf("Status worng number-2 : %s , %s ", config["action"], req.Status)
-		return model.Response{Status: "-2", Msg: "Failure.3", Desc: "Status worng number!"}, err
 	}
 
-	//return model.Response{}, err
+	// The stage advances from here on; keep the decisions that picked it.
+	decisions.WriteHistory(username.(string))
+
 	// 🔹 Step 4:  Update data
-	if unitCount == 0 && CaseNextNode.Type == "dispatch" { //--First Unit for case
-		//--Update current stage :  case
-		log.Print("--> 1--Update current stage :  case")
-		Result, err := UpdateCaseCurrentStage(ctx, conn, req, CaseNextNode, "case", username.(string))
-		if err != nil {
-			return Result, err
-		}
+	Result, err := plan.Apply(par, store, req)
+	if err != nil {
+		return Result, err
+	}
+	if err := commit(ctx); err != nil {
+		return model.Response{Status: "-1", Msg: "Failure.UpdateCurrentStageCore.commit-" + req.CaseId, Desc: err.Error()}, err
+	}
 
-		//--insert current stage : unit
-		Result, err = InsertUnitCurrentStage(ctx, conn, req, UnitNextNode, "unit", username.(string))
+	switch plan.Step {
+	case stageStepClose:
+		//--Update tix_cases on time (Group status)
+		Result_, err := DispatchReponseAndUpdateCaseStatus(ctx, conn, req, username.(string))
 		if err != nil {
-			return Result, err
+
+			log.Printf("Update status failed: %v", err)
+		} else {
+			log.Print(Result_)
+			log.Println("Case status updated successfully-1")
 		}
+		GenerateNotiAndComment(ctx, conn, req, orgId.(string), "0", &req.ResDetail)
+		//-->New Function for close
+		log.Print("--> 1.1 CalDashboardSLA")
+		if esb {
+			UpdateBusKafka_WO(ctx, conn, req)
+		}
+		log.Print("--> 1.2 CalDashboardSLA")
+		CalDashboardSLA(ctx, conn, orgId.(string), username.(string), req.CaseId)
+		return Result, err
 
+	case stageStepFirst: //--First Unit for case
 		//--Update tix_cases on time (Group status)
 		Result, err = DispatchReponseAndUpdateCaseStatus(ctx, conn, req, username.(string))
 		if err != nil {
@@ -505,20 +488,7 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 		CalDashboardStatus(ctx, conn, orgId.(string), username.(string), req.CaseId, "inprogress")
 		return Result, err
 
-	} else if unitCount == caseCount { //-- Unit relate Case
-		//--Update current stage :  case
-		log.Print("--> 2--Update current stage :  case")
-		Result, err := UpdateCaseCurrentStage(ctx, conn, req, CaseNextNode, "case", username.(string))
-		if err != nil {
-			return Result, err
-		}
-
-		//--Update current stage :  unit
-		Result, err = UpdateCaseCurrentStage(ctx, conn, req, UnitNextNode, "unit", username.(string))
-		if err != nil {
-			return Result, err
-		}
-
+	case stageStepCase: //-- Unit relate Case
 		//--Update tix_cases on time (Group status)
 		Result, err = DispatchReponseAndUpdateCaseStatus(ctx, conn, req, username.(string))
 		if err != nil {
@@ -538,30 +508,7 @@ func UpdateCurrentStageCore(ctx *gin.Context, conn *pgx.Conn, req model.UpdateSt
 		}
 		return Result, err
 
-	} else if unitCount > 0 && unitCount < caseCount { //--Second Unit follow SOP
-
-		//--Update current stage :  unit
-		log.Print("--> --Second Unit follow SOP ")
-		Result, err := UpdateCaseCurrentStage(ctx, conn, req, UnitNextNode, "unit", username.(string))
-		if err != nil {
-			return Result, err
-		}
-
-		GenerateNotiAndComment(ctx, conn, req, orgId.(string), "0")
-		if esb {
-			UpdateBusKafka_WO(ctx, conn, req)
-		}
-		return Result, err
-
-	} else if unitCount == 0 { //--Second Unit - First dispatch
-		//--insert current stage : unit
-		log.Print("--> --Second Unit - First dispatch ")
-		UnitNextNode = dispatchNode
-		Result, err := InsertUnitCurrentStage(ctx, conn, req, UnitNextNode, "unit", username.(string))
-		if err != nil {
-			return Result, err
-		}
-
+	case stageStepUnit, stageStepDispatch: //--Second Unit
 		GenerateNotiAndComment(ctx, conn, req, orgId.(string), "0")
 		if esb {
 			UpdateBusKafka_WO(ctx, conn, req)
@@ -654,6 +601,7 @@ func GetNextNode(
 	caseStages model.CurrentStage,
 	unitStages model.CurrentStage,
 	logger *zap.Logger,
+	decide func(node model.WorkflowNode) model.WorkflowNode,
 ) (model.WorkflowNode, model.WorkflowNode, int, int) {
 
 	var CaseNextNode model.WorkflowNode
@@ -666,24 +614,7 @@ func GetNextNode(
 		//----- For Unit Stage
 		logger.Info("---Unit Stage---", zap.Any("node", wfConn))
 		if wfConn.Source == unitStages.NodeId {
-			candidateCase := allNodesId[wfConn.Target]
-
-			for candidateCase.Type == "sla" {
-				found := false
-				for _, c := range nodeConn {
-					if c.Source == candidateCase.NodeId && c.Label == "yes" {
-						candidateCase = allNodesId[c.Target]
-						//logger.Info("---candidate---", zap.Any("node", candidateCase))
-						if candidateCase.Type == "process" {
-							found = true
-							break
-						}
-					}
-				}
-				if !found {
-					break
-				}
-			}
+			candidateCase := resolveStageNode(allNodesId[wfConn.Target], allNodesId, nodeConn, decide)
 
 			UnitNextNode = candidateCase
 
@@ -700,25 +631,9 @@ func GetNextNode(
 		//----- For Case Stage
 		logger.Info("---Case Stage---", zap.Any("node", wfConn))
 		if wfConn.Source == caseStages.NodeId {
-			candidateCase := allNodesId[wfConn.Target]
-
-			// ถ้า node type เป็น SLA → ข้ามไปหา target ต่อไป
-			for candidateCase.Type == "sla" {
-				found := false
-				for _, c := range nodeConn {
-					if c.Source == candidateCase.NodeId && c.Label == "yes" {
-						candidateCase = allNodesId[c.Target]
-						logger.Info("---candidate--CASE-", zap.Any("node", candidateCase))
-						if candidateCase.Type == "process" {
-							found = true
-							break
-						}
-					}
-				}
-				if !found {
-					break
-				}
-			}
+			// ถ้า node type เป็น SLA หรือ decision → ข้ามไปหา target ต่อไป
+			candidateCase := resolveStageNode(allNodesId[wfConn.Target], allNodesId, nodeConn, decide)
+			logger.Info("---candidate--CASE-", zap.Any("node", candidateCase))
 
 			CaseNextNode = candidateCase
 
@@ -739,6 +654,46 @@ func GetNextNode(
 	return CaseNextNode, UnitNextNode, caseCount, unitCount
 }
 
+// resolveStageNode moves past the nodes a stage never rests on. An sla node
+// follows its "yes" branch; a decision node follows the branch chosen by
+// decide. A nil decide, or a decision without a result, leaves the decision
+// node in place. Forks and joins are left in place for parallelFlow.
+func resolveStageNode(
+	candidate model.WorkflowNode,
+	allNodesId map[string]model.WorkflowNode,
+	nodeConn []model.WorkFlowConnection,
+	decide func(node model.WorkflowNode) model.WorkflowNode,
+) model.WorkflowNode {
+	for hops := 0; hops <= len(allNodesId); hops++ {
+		switch candidate.Type {
+		case "sla":
+			for _, c := range nodeConn {
+				if c.Source == candidate.NodeId && c.Label == "yes" {
+					candidate = allNodesId[c.Target]
+					if candidate.Type == "process" {
+						break
+					}
+				}
+			}
+			if candidate.Type != WorkflowDecisionNode {
+				return candidate
+			}
+		case WorkflowDecisionNode:
+			if decide == nil {
+				return candidate
+			}
+			next := decide(candidate)
+			if next.NodeId == "" {
+				return candidate
+			}
+			candidate = next
+		default:
+			return candidate
+		}
+	}
+	return candidate
+}
+
 func InsertUnitCurrentStage(
 	ctx context.Context,
 	conn *pgx.Conn,
@@ -748,22 +703,14 @@ func InsertUnitCurrentStage(
 	username string,
 ) (model.Response, error) {
 
-	var node model.WfNode
-	nodeQuery := `
-		SELECT n."orgId", n."wfId", n."nodeId", d."versions", n."type", n."section", 
-       n."formId", n."pic", n."group"
-		FROM public."wf_nodes" n
-		JOIN public."wf_definitions" d 
-		ON n."wfId" = d."wfId"
-		AND n."versions" = d."versions"
-		WHERE n."nodeId" = $1  
-	`
+	stages := repository.NewStageRepository(conn)
 	log.Print("-----SELECT-NODE--")
 	log.Print(nextStage.NodeId)
-	err := conn.QueryRow(ctx, nodeQuery, nextStage.NodeId).Scan(
-		&node.OrgID, &node.WfID, &node.NodeID, &node.Versions, &node.Type,
-		&node.Section, &node.FormID, &node.Pic, &node.Group,
-	)
+	wfId, version, err := stages.CaseWorkflow(ctx, req.CaseId)
+	if err != nil {
+		return model.Response{Status: "-1", Msg: "Failure.InsertUnitCurrentStage.1", Desc: err.Error()}, err
+	}
+	node, err := pinnedNode(ctx, stages, nextStage.NodeId, wfId, version)
 	if err != nil {
 		return model.Response{Status: "-1", Msg: "Failure.InsertUnitCurrentStage.1", Desc: err.Error()}, err
 	}
@@ -791,20 +738,15 @@ func InsertUnitCurrentStage(
 	// 	formId = *node.FormID
 	// }
 
-	now := time.Now()
-
-	insertQuery := `
-	INSERT INTO public."tix_case_current_stage"
-	("orgId", "caseId", "wfId", "nodeId", "versions", "type", "section", "data",
-	  "stageType", "unitId",
-	 "username", "updatedAt", "createdAt", "createdBy", "updatedBy")
-	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14, $15)
-	`
-	_, err = conn.Exec(ctx, insertQuery,
-		node.OrgID, req.CaseId, node.WfID, req.NodeId, node.Versions, node.Type, node.Section, dataBytes,
-		stageType, req.UnitId,
-		req.UnitUser, now, now, username, username,
-	)
+	err = stages.Insert(ctx, *node, repository.StageWrite{
+		CaseID:    req.CaseId,
+		NodeID:    req.NodeId,
+		StageType: stageType,
+		UnitID:    req.UnitId,
+		Username:  req.UnitUser,
+		Data:      dataBytes,
+		By:        username,
+	})
 	if err != nil {
 		return model.Response{Status: "-1", Msg: "Failure.InsertUnitCurrentStage.3", Desc: err.Error()}, err
 	}
@@ -831,27 +773,26 @@ func UpdateCaseCurrentStage(
 		log.Print(err)
 		return model.Response{Status: "-1", Msg: "Failure.UpdateCaseCurrentStage.0-" + req.CaseId, Desc: err.Error()}, err
 	}
+	if caseData == nil {
+		err = fmt.Errorf("case not found: %s", req.CaseId)
+		return model.Response{Status: "-1", Msg: "Failure.UpdateCaseCurrentStage.0-" + req.CaseId, Desc: err.Error()}, err
+	}
 	log.Print(caseData)
-	var node model.WfNode
-	nodeQuery := `
-		SELECT n."orgId", n."wfId", n."nodeId", d."versions", n."type", n."section", 
-       n."formId", n."pic", n."group"
-		FROM public."wf_nodes" n
-		JOIN public."wf_definitions" d 
-		ON n."wfId" = d."wfId"
-		AND n."versions" = d."versions"
-		WHERE n."nodeId" = $1
-		AND d."wfId" = $2
-	`
+	wfId, version := "", ""
+	if caseData.WfID != nil {
+		wfId = *caseData.WfID
+	}
+	if caseData.WfVersions != nil {
+		version = *caseData.WfVersions
+	}
+	stages := repository.NewStageRepository(conn)
 	log.Print("-----SELECT-NODE--")
 	log.Print(nextStage.NodeId)
-	err = conn.QueryRow(ctx, nodeQuery, nextStage.NodeId, caseData.WfID).Scan(
-		&node.OrgID, &node.WfID, &nextStage.NodeId, &node.Versions, &node.Type,
-		&node.Section, &node.FormID, &node.Pic, &node.Group,
-	)
+	node, err := pinnedNode(ctx, stages, nextStage.NodeId, wfId, version)
 	if err != nil {
 		return model.Response{Status: "-1", Msg: "Failure.UpdateCaseCurrentStage.1-" + stageType, Desc: err.Error()}, err
 	}
+	nextStage.NodeId = node.NodeID
 
 	// Marshal nextStage.Data to JSON for jsonb column
 	dataBytes, err := json.Marshal(nextStage.Data)
@@ -859,38 +800,21 @@ func UpdateCaseCurrentStage(
 		return model.Response{Status: "-1", Msg: "Failure.UpdateCaseCurrentStage.2-" + stageType, Desc: err.Error()}, err
 	}
 
-	now := time.Now()
-
 	log.Print("---Update---")
 
 	if stageType == "case" {
-		req.UnitId = ""
+		req.UnitId = caseBranchKey(req.Branch)
 		req.UnitUser = ""
 	}
-	updateQuery := `
-	UPDATE public."tix_case_current_stage"
-	SET "wfId" = $1,
-	    "type" = $2,
-	    "section" = $3,
-	    "data" = $4,
-	     
-	    
-	    "username" = $6,
-	    "updatedAt" = $7,
-	    "updatedBy" = $8,
-		"nodeId" = $12,
-		"versions" = $11 
-	WHERE "caseId" = $9
-	  AND "stageType" = $10 
-	  AND "unitId" = $5
-	`
-
-	_, err = conn.Exec(ctx, updateQuery,
-		node.WfID, node.Type, node.Section, dataBytes,
-
-		req.UnitId, req.UnitUser, now, username,
-		req.CaseId, stageType, node.Versions, nextStage.NodeId,
-	)
+	err = stages.Update(ctx, *node, repository.StageWrite{
+		CaseID:    req.CaseId,
+		NodeID:    nextStage.NodeId,
+		StageType: stageType,
+		UnitID:    req.UnitId,
+		Username:  req.UnitUser,
+		Data:      dataBytes,
+		By:        username,
+	})
 
 	if err != nil {
 		return model.Response{Status: "-1", Msg: "Failure.UpdateCaseCurrentStage.3-" + stageType, Desc: err.Error()}, err
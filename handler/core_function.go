@@ -420,7 +420,6 @@ func CoreNotifications(ctx context.Context, inputs []model.NotificationCreateReq
 
 	conn, ctx, cancel := utils.ConnectDB()
 	defer cancel()
-	defer conn.Close(ctx)
 
 	tx, err := conn.Begin(ctx)
 	if err != nil {
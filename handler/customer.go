@@ -31,7 +31,6 @@ func CustomerList(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -181,7 +180,6 @@ func CustomerById(c *gin.Context) {
 	username := GetVariableFromToken(c, "username")
 	txtId := uuid.New().String()
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, "orgId", "displayName", title, "firstName", "middleName", "lastName", "citizenId", dob, blood, gender, "mobileNo", address, photo, email, usertype, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 		FROM public.cust_customers WHERE id=$1 AND "orgId"=$2`
 
@@ -266,7 +264,6 @@ func CustomerByPhoneNo(c *gin.Context) {
 	username := GetVariableFromToken(c, "username")
 	txtId := uuid.New().String()
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, "orgId", "displayName", title, "firstName", "middleName", "lastName", "citizenId", dob, blood, gender, "mobileNo", address, photo, email, usertype, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 		FROM public.cust_customers WHERE "mobileNo"=$1 AND "orgId"=$2`
 
@@ -346,7 +343,6 @@ func CustomerAdd(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -445,7 +441,6 @@ func CustomerUpdate(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -538,7 +533,6 @@ func CustomerDelete(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -605,7 +599,6 @@ func CustomerSocialList(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -741,7 +734,6 @@ func CustomerWithSocialById(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, "orgId", "custId", "socialType", "socialId", "socialName", "imgUrl", "createdAt", "updatedAt", "createdBy", "updatedBy"
 		FROM public.cust_customer_with_socials  
 		WHERE id=$1 AND "orgId"=$2`
@@ -813,7 +805,6 @@ func CustomerSocialAdd(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -906,7 +897,6 @@ func CustomerSocialUpdate(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -996,7 +986,6 @@ func CustomerSocialDelete(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1062,7 +1051,6 @@ func CustomerContactList(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -1188,7 +1176,6 @@ func CustomerContactById(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, "orgId", "custId", "contactName", "contactPhone", "contactAddr", "createdAt", "updatedAt", "createdBy", "updatedBy"
 		FROM public.cust_contacts 
 		WHERE id=$1 AND "orgId"=$2`
@@ -1259,7 +1246,6 @@ func CustomerContactAdd(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1349,7 +1335,6 @@ func CustomerContactUpdate(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1440,7 +1425,6 @@ func CustomerContactDelete(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -37,7 +37,6 @@ func GetDepartment(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -148,7 +147,6 @@ func GetDepartmentbyId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -253,7 +251,6 @@ func InsertDepartment(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.DepartmentInsert
 	start_time := time.Now()
@@ -345,7 +342,6 @@ func UpdateDepartment(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -440,7 +436,6 @@ func DeleteDepartment(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	start_time := time.Now()
@@ -38,7 +38,6 @@ func GetDeviceIoT(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	orgId := GetVariableFromToken(c, "orgId")
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -171,7 +170,6 @@ func GetDeviceIoTById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	orgId := GetVariableFromToken(c, "orgId")
 	query := `SELECT
 				"orgId",
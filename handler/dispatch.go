@@ -3,11 +3,14 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"mainPackage/model"
+	"mainPackage/repository"
 	"mainPackage/utils"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"log"
@@ -34,7 +37,6 @@ func GetSOP(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 
 	if conn == nil {
 		return
@@ -125,6 +127,14 @@ func GetSOP(c *gin.Context) {
 	cusCase.CurrentStage = currentNode
 	cusCase.NextStage = nextStage
 	cusCase.DispatchStage = dispatchNode
+
+	//Get parallel branches
+	branches, err := GetCaseBranches(ctx, conn, orgId.(string), caseId)
+	if err != nil {
+		logger.Warn("GetCaseBranches failed", zap.Error(err))
+	} else if len(branches) > 0 {
+		cusCase.Branches = branches
+	}
 	log.Println(cusCase)
 	log.Println("=GetSOP==allNodes=x=x=x=x=x")
 	log.Println(allNodes)
@@ -191,7 +201,6 @@ func GetWorkflowAndCurrentNode(c *gin.Context, orgId, caseId string, unitId stri
 		return nil, nil, nil, nil, nil
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// 🔹 Step 1: Get current node and wfId
 	currentQuery := `
@@ -277,6 +286,14 @@ func GetWorkflowAndCurrentNode(c *gin.Context, orgId, caseId string, unitId stri
 		}
 	}
 
+	if err := rows.Err(); err != nil {
+		logger.Error("Failed to read workflow nodes", zap.Error(err))
+		return nil, nil, nil, nil, err
+	}
+	if len(allNodesId) == 0 {
+		return nil, nil, nil, nil, fmt.Errorf("workflow %s version %s not found for caseId=%s", wfId, current.Versions, caseId)
+	}
+
 	log.Println("===== all workflow nodes =====")
 	//log.Println(allNodes)
 
@@ -327,6 +344,9 @@ func GetWorkflowAndCurrentNode(c *gin.Context, orgId, caseId string, unitId stri
 // @accept json
 // @produce json
 // @Param caseId path string true "caseId"
+// @Param nodeId query string false "dispatch node to rank for (default: the next dispatch node of the case)"
+// @Param speed query number false "average speed (km/h) used for ETA"
+// @Param all query bool false "include units that were left out"
 // @response 200 {object} model.Response "OK - Request successful"
 // @Router /api/v1/dispatch/{caseId}/units [get]
 func GetUnit(c *gin.Context) {
@@ -337,248 +357,60 @@ func GetUnit(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
-
-	if conn == nil {
-		return
-	}
-
-	fmt.Println("=xcxxxx==xx=x=x=x=x=x")
-	log.Println("===")
 
 	orgId := GetVariableFromToken(c, "orgId")
 	caseId := c.Param("caseId")
 
 	//--Get Skill All
-	Skills, err_ := utils.GetUserSkills(ctx, conn, orgId.(string))
-	log.Print("---Skills---")
-	if err_ != nil {
-		panic(err_)
+	Skills, err := utils.GetUserSkills(ctx, conn, orgId.(string))
+	if err != nil {
+		logger.Warn("Get skills failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
 	}
 
-	log.Print(Skills)
-
 	//--Get Property All
-	Props, err_ := utils.GetUnitProp(ctx, conn, orgId.(string))
-	log.Print("---Props---")
-	if err_ != nil {
-		panic(err_)
-	}
-
-	log.Print(Props)
-
-	// 	query := `
-	//   WITH case_info AS (
-	//   SELECT
-	//     c."caseSTypeId",
-	//     c."countryId",
-	//     c."provId",
-	//     c."distId",
-	//     s."unitPropLists",
-	//     s."userSkillList"
-	//   FROM "tix_cases" c
-	//   JOIN "case_sub_types" s ON c."caseSTypeId" = s."sTypeId"
-	//   WHERE c."caseId" = $1
-	//     AND s."active" = TRUE
-	// ),
-	// unit_with_props AS (
-	//   SELECT
-	//     "unitId",
-	//     array_agg("propId") AS props
-	//   FROM "mdm_unit_with_properties"
-	//   WHERE "active" = TRUE
-	//   GROUP BY "unitId"
-	// ),
-	// units_matched AS (
-	//   SELECT u."unitId", u."unitName", p.props
-	//   FROM "mdm_units" u
-	//   JOIN unit_with_props p ON u."unitId" = p."unitId"
-	//   CROSS JOIN case_info c
-	//   WHERE u."active" = TRUE
-	//     AND (
-	//       SELECT COUNT(DISTINCT prop_uuid)
-	//       FROM (
-	//         SELECT (jsonb_array_elements_text(c."unitPropLists"::jsonb))::uuid AS prop_uuid
-	//       ) AS required_props
-	//       WHERE prop_uuid = ANY(p.props)
-	//     ) = (SELECT jsonb_array_length(c."unitPropLists"::jsonb))
-	// ),
-	// users_on_units AS (
-	//   SELECT u."unitId", mdm."username"
-	//   FROM units_matched u
-	//   JOIN "mdm_units" mdm ON mdm."unitId" = u."unitId" AND mdm."active" = TRUE
-	//   JOIN "um_users" um ON um."username" = mdm."username" AND um."active" = TRUE
-	// ),
-	// users_with_skill AS (
-	//   SELECT DISTINCT "userName"
-	//   FROM "um_user_with_skills"
-	//   WHERE "skillId" IN (
-	//     SELECT (jsonb_array_elements_text(ci."userSkillList"::jsonb))::uuid
-	//     FROM case_info ci
-	//   )
-	//   AND "active" = TRUE
-	// ),
-	// users_in_area AS (
-	//   SELECT "username"
-	//   FROM "um_user_with_area_response" ua
-	//   CROSS JOIN case_info c
-	//   WHERE ua."orgId" = $2
-	//     AND EXISTS (
-	//       SELECT 1
-	//       FROM jsonb_array_elements_text(ua."distIdLists") AS distId
-	//       WHERE distId.value = c."distId"
-	//     )
-	// )
-	// SELECT mu."orgId",
-	//        mu."unitId",
-	//        mu."unitName",
-	//        mu."unitSourceId",
-	//        mu."unitTypeId",
-	//        mu."priority",
-	//        mu."compId",
-	//        mu."deptId",
-	//        mu."commId",
-	//        mu."stnId",
-	//        mu."plateNo",
-	//        mu."provinceCode",
-	//        mu."active",
-	//        mu."username",
-	//        mu."isLogin",
-	//        mu."isFreeze",
-	//        mu."isOutArea",
-	//        mu."locLat",
-	//        mu."locLon",
-	//        mu."locAlt",
-	//        mu."locBearing",
-	//        mu."locSpeed",
-	//        mu."locProvider",
-	//        mu."locGpsTime",
-	//        mu."locSatellites",
-	//        mu."locAccuracy",
-	//        mu."locLastUpdateTime",
-	//        mu."breakDuration",
-	//        mu."healthChk",
-	//        mu."healthChkTime",
-	//        mu."sttId",
-	//        mu."createdBy",
-	//        mu."updatedBy",
-	//        ci."unitPropLists",
-	//        ci."userSkillList"
-	// FROM users_on_units u
-	// JOIN users_with_skill us ON u."username" = us."userName"
-	// JOIN users_in_area ua ON u."username" = ua."username"
-	// JOIN "mdm_units" mu ON mu."unitId" = u."unitId"
-	// CROSS JOIN case_info ci;
-	// `
+	Props, err := utils.GetUnitProp(ctx, conn, orgId.(string))
+	if err != nil {
+		logger.Warn("Get unit properties failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
 
-	query := `
-  WITH case_info AS (
-  SELECT 
-    c."caseSTypeId", 
-    c."countryId",
-    c."provId",
-    c."distId",
-    s."unitPropLists", 
-    s."userSkillList"
-  FROM "tix_cases" c
-  JOIN "case_sub_types" s ON c."caseSTypeId" = s."sTypeId"
-  WHERE c."caseId" = $1
-    AND s."active" = TRUE
-  LIMIT 1
-),
-unit_with_props AS (
-  SELECT 
-    "unitId", 
-    array_agg("propId") AS props
-  FROM "mdm_unit_with_properties"
-  WHERE "active" = TRUE
-  GROUP BY "unitId"
-),
-units_matched AS (
-  SELECT u."unitId", u."unitName", p.props
-  FROM "mdm_units" u
-  JOIN unit_with_props p ON u."unitId" = p."unitId"
-  CROSS JOIN case_info c
-  WHERE u."active" = TRUE
-    AND (
-      SELECT COUNT(DISTINCT prop_uuid)
-      FROM (
-        SELECT (jsonb_array_elements_text(c."unitPropLists"::jsonb))::uuid AS prop_uuid
-      ) AS required_props
-      WHERE prop_uuid = ANY(p.props)
-    ) = (SELECT jsonb_array_length(c."unitPropLists"::jsonb))
-),
-users_on_units AS (
-  SELECT u."unitId", mdm."username"
-  FROM units_matched u
-  JOIN "mdm_units" mdm ON mdm."unitId" = u."unitId" AND mdm."active" = TRUE
-  JOIN "um_users" um ON um."username" = mdm."username" AND um."active" = TRUE
-),
-users_with_skill AS (
-  SELECT DISTINCT "userName"
-  FROM "um_user_with_skills"
-  WHERE "skillId" IN (
-    SELECT (jsonb_array_elements_text(ci."userSkillList"::jsonb))::uuid
-    FROM case_info ci
-  )
-  AND "active" = TRUE
-),
-users_in_area AS (
-  SELECT DISTINCT "username"
-  FROM "um_user_with_area_response" ua
-  CROSS JOIN case_info c
-  WHERE ua."orgId" = $2
-    AND EXISTS (
-      SELECT 1
-      FROM jsonb_array_elements_text(ua."distIdLists") AS distId
-      WHERE distId.value = c."distId"
-    )
-)
-SELECT DISTINCT ON (mu."unitId")
-       mu."orgId",
-       mu."unitId",
-       mu."unitName",
-       mu."unitSourceId",
-       mu."unitTypeId",
-       mu."priority",
-       mu."compId",
-       mu."deptId",
-       mu."commId",
-       mu."stnId",
-       mu."plateNo",
-       mu."provinceCode",
-       mu."active",
-       u."username",
-       mu."isLogin",
-       mu."isFreeze",
-       mu."isOutArea",
-       mu."locLat",
-       mu."locLon",
-       mu."locAlt",
-       mu."locBearing",
-       mu."locSpeed",
-       mu."locProvider",
-       mu."locGpsTime",
-       mu."locSatellites",
-       mu."locAccuracy",
-       mu."locLastUpdateTime",
-       mu."breakDuration",
-       mu."healthChk",
-       mu."healthChkTime",
-       mu."sttId",
-       mu."createdBy",
-       mu."updatedBy",
-       ci."unitPropLists",
-       ci."userSkillList"
-FROM users_on_units u
-JOIN users_with_skill us ON u."username" = us."userName"
-JOIN users_in_area ua ON u."username" = ua."username"
-JOIN "mdm_units" mu ON mu."unitId" = u."unitId"
-CROSS JOIN case_info ci;
-`
+	// Rank for the dispatch node asked for, else the one the case goes to
+	// next.
+	nodeId := c.Query("nodeId")
+	if nodeId == "" {
+		node, _, err := nextAutoDispatchNode(c, conn, orgId.(string), caseId, true)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			logger.Warn("Find dispatch node failed", zap.Error(err))
+		} else if node != nil {
+			nodeId = node.NodeId
+		}
+	}
+	criteria, err := LoadDispatchCriteria(ctx, conn, orgId.(string), caseId, nodeId)
+	if err != nil {
+		logger.Warn("Load dispatch criteria failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+	if speed, err := strconv.ParseFloat(c.Query("speed"), 64); err == nil && speed > 0 {
+		criteria.AvgSpeedKmh = speed
+	}
 
-	logger.Debug(`Query`, zap.String("query", query))
-	rows, err := conn.Query(context.Background(), query, caseId, orgId)
+	units, err := LoadUnitCandidates(ctx, conn, orgId.(string), *criteria)
 	if err != nil {
 		logger.Warn("Query failed", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, model.Response{
@@ -588,33 +420,21 @@ CROSS JOIN case_info ci;
 		})
 		return
 	}
-	defer rows.Close()
 
-	var results []model.UnitUser
+	ranked := RankUnitCandidates(units, *criteria, time.Now())
+	includeAll := c.Query("all") == "true"
 
-	for rows.Next() {
-		var u model.UnitUser
-		if err := rows.Scan(
-			&u.OrgID, &u.UnitID, &u.UnitName, &u.UnitSourceID, &u.UnitTypeID, &u.Priority,
-			&u.CompID, &u.DeptID, &u.CommID, &u.StnID, &u.PlateNo, &u.ProvinceCode,
-			&u.Active, &u.Username, &u.IsLogin, &u.IsFreeze, &u.IsOutArea,
-			&u.LocLat, &u.LocLon, &u.LocAlt, &u.LocBearing, &u.LocSpeed, &u.LocProvider,
-			&u.LocGpsTime, &u.LocSatellites, &u.LocAccuracy, &u.LocLastUpdateTime,
-			&u.BreakDuration, &u.HealthChk, &u.HealthChkTime, &u.SttID,
-			&u.CreatedBy, &u.UpdatedBy, &u.UnitPropLists, &u.UserSkillList,
-		); err != nil {
-			logger.Warn("Row scan failed", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, model.Response{
-				Status: "-1",
-				Msg:    "Failure",
-				Desc:   err.Error(),
-			})
-			return
+	results := []model.UnitCandidate{}
+	for _, u := range ranked {
+		if !u.Eligible && !includeAll {
+			continue
 		}
-
-		u.SkillLists = ConvertSkills(Skills, *u.UserSkillList)
-		u.ProplLists = ConvertProps(Props, *u.UnitPropLists)
-
+		userSkillList := criteria.RequiredSkills
+		unitPropLists := criteria.RequiredProps
+		u.UserSkillList = &userSkillList
+		u.UnitPropLists = &unitPropLists
+		u.SkillLists = ConvertSkills(Skills, userSkillList)
+		u.ProplLists = ConvertProps(Props, unitPropLists)
 		results = append(results, u)
 	}
 
@@ -637,12 +457,11 @@ CROSS JOIN case_info ci;
 func UpdateCurrentStage(c *gin.Context) {
 	logger := utils.GetLog()
 
-	conn, ctx, cancel := utils.ConnectDB()
+	conn, _, cancel := utils.ConnectDB()
 	if conn == nil {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.UpdateStageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -686,7 +505,6 @@ func GetUnitSOP(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 
 	fmt.Println("=xcxxxx==xx=x=x=x=x=x")
 	log.Println("===")
@@ -836,134 +654,12 @@ func GetReferCaseList(ctx context.Context, conn *pgx.Conn, orgID string, caseID
 
 // GetUnits returns a list of units (unitId, username)
 func GetUnits(ctx context.Context, conn *pgx.Conn, orgID string, caseID string, statusId string, unitID string) ([]model.UnitDispatch, int, error) {
-	var unitLists []model.UnitDispatch
-	//st := []string{"S007", "S016", "S017", "S018"}
-
-	// If statusId is in the skip list, return empty slice
-	// if contains(st, statusId) {
-	// 	//return unitLists, nil
-	// }
-
-	query := `
-		SELECT 
-    cs."unitId",
-    cs."username",
-    u."firstName",
-    u."lastName"
-FROM public.tix_case_current_stage AS cs
-JOIN public.um_users AS u
-    ON cs."username" = u."username"
-    AND cs."orgId" = u."orgId"
-WHERE 
-    cs."orgId" = $1
-    AND cs."caseId" = $2
-    AND cs."stageType" = 'unit'
-	`
-
-	args := []interface{}{orgID, caseID}
-	argIndex := 3
-
-	// ถ้ามี statusId ให้เพิ่ม filter จาก JSONB
-	if statusId != "" {
-		query += fmt.Sprintf(` AND data->'data'->'config'->>'action' = $%d`, argIndex)
-		args = append(args, statusId)
-		argIndex++
-	}
-
-	// ถ้ามี unitID ให้เพิ่ม filter ด้วย
-	if unitID != "" {
-		query += fmt.Sprintf(` AND "unitId" = $%d`, argIndex)
-		args = append(args, unitID)
-		argIndex++
-	}
-	log.Print(query)
-	log.Print(args)
-	rows, err := conn.Query(ctx, query, args...)
-
-	//rows, err := conn.Query(ctx, query, orgID, caseID)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var u model.UnitDispatch
-		if err := rows.Scan(&u.UnitID, &u.Username, &u.FirstName, &u.LastName); err != nil {
-			return nil, 0, err
-		}
-		unitLists = append(unitLists, u)
-	}
-
-	count := len(unitLists)
-
-	return unitLists, count, nil
+	return repository.NewUnitRepository(conn).ListCaseStages(ctx, orgID, caseID, statusId, unitID)
 }
 
 // GetUnits returns a list of units (unitId, username)
 func GetUnitsWithDispatch(ctx context.Context, conn *pgx.Conn, orgID string, caseID string, statusId string, unitID string) ([]model.UnitDispatch, int, error) {
-	var unitLists []model.UnitDispatch
-	//st := []string{"S007", "S016", "S017", "S018"}
-
-	// If statusId is in the skip list, return empty slice
-	// if contains(st, statusId) {
-	// 	//return unitLists, nil
-	// }
-
-	query := `
-		SELECT 
-			r."unitId",
-			u."username",
-			u."firstName",
-			u."lastName",
-			r."createdBy",
-			r."statusId"
-		FROM public.tix_case_responders AS r
-		JOIN public.um_users AS u
-			ON r."userOwner" = u."username"
-			AND r."orgId" = u."orgId"
-		WHERE 
-			r."unitId" != 'case' 
-			AND r."orgId" = $1
-			AND r."caseId" =  $2
-	`
-
-	args := []interface{}{orgID, caseID}
-	argIndex := 3
-
-	// ถ้ามี statusId ให้เพิ่ม filter จาก JSONB
-	if statusId != "" {
-		query += fmt.Sprintf(` AND r."statusId" = $%d`, argIndex)
-		args = append(args, statusId)
-		argIndex++
-	}
-
-	// ถ้ามี unitID ให้เพิ่ม filter ด้วย
-	if unitID != "" {
-		query += fmt.Sprintf(` AND r."unitId" = $%d`, argIndex)
-		args = append(args, unitID)
-		argIndex++
-	}
-	log.Print(query)
-	log.Print(args)
-	rows, err := conn.Query(ctx, query, args...)
-
-	//rows, err := conn.Query(ctx, query, orgID, caseID)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var u model.UnitDispatch
-		if err := rows.Scan(&u.UnitID, &u.Username, &u.FirstName, &u.LastName, &u.CreatedBy, &u.StatusId); err != nil {
-			return nil, 0, err
-		}
-		unitLists = append(unitLists, u)
-	}
-
-	count := len(unitLists)
-
-	return unitLists, count, nil
+	return repository.NewUnitRepository(conn).ListResponders(ctx, orgID, caseID, statusId, unitID)
 }
 
 func GetFormAnswers(conn *pgx.Conn, ctx context.Context, orgId, caseId, formId string, returnUid bool) (*model.FormAnswerRequest, error) {
@@ -1144,7 +840,7 @@ func GetSLA(ctx *gin.Context, conn *pgx.Conn, orgID string, caseID string, unitI
 // @response 200 {object} model.Response "OK - Request successful"
 // @Router /api/v1/dispatch/cancel/unit [post]
 func DispatchCancelUnit(c *gin.Context) {
-	conn, ctx, cancel := utils.ConnectDB()
+	conn, _, cancel := utils.ConnectDB()
 	if conn == nil {
 		c.JSON(http.StatusInternalServerError, model.Response{
 			Status: "-1",
@@ -1153,7 +849,6 @@ func DispatchCancelUnit(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.CancelUnitRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1185,33 +880,7 @@ func DispatchCancelUnit(c *gin.Context) {
 }
 
 func DeleteCurrentUnit(ctx context.Context, conn *pgx.Conn, orgID, caseID, statusID, unitID string) (int64, error) {
-
-	query := `
-		DELETE FROM public.tix_case_current_stage
-		WHERE "orgId" = $1 
-		  AND "caseId" = $2 
-		  AND "stageType" = 'unit'
-	`
-	args := []interface{}{orgID, caseID}
-	argIndex := 3
-
-	// ถ้ามี unitID ให้เพิ่ม filter
-	if unitID != "" {
-		query += fmt.Sprintf(` AND "unitId" = $%d`, argIndex)
-		args = append(args, unitID)
-	}
-
-	// Execute delete query
-	cmdTag, err := conn.Exec(ctx, query, args...)
-	if err != nil {
-		return 0, fmt.Errorf("DeleteCurrentUnit failed: %w", err)
-	}
-
-	// cmdTag.RowsAffected() คืนค่าจำนวนแถวที่ถูกลบ
-	deletedCount := cmdTag.RowsAffected()
-	log.Printf("Deleted %d unit(s) from case %s", deletedCount, caseID)
-
-	return deletedCount, nil
+	return repository.NewUnitRepository(conn).DeleteCaseStages(ctx, orgID, caseID, unitID)
 }
 
 func DeleteReponseUnit(ctx context.Context, conn *pgx.Conn, orgID, caseID, statusID, unitID string) (int64, error) {
@@ -1419,7 +1088,7 @@ func UpdateStage(ctx context.Context, conn *pgx.Conn, orgId, caseId, nodeId stri
 			"formId" = $6,
 			"updatedAt" = $7,
 			"updatedBy" = $8
-		WHERE "orgId" = $1 AND "caseId" = $2 AND "stageType" = 'case'
+		WHERE "orgId" = $1 AND "caseId" = $2 AND "stageType" = 'case' AND "unitId" = ''
 	`
 
 	_, err = conn.Exec(ctx, query, orgId, caseId, nodeId, "process", jsonData, formId, time.Now(), username)
@@ -1449,7 +1118,6 @@ func DispatchCancelCase(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.CancelCaseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
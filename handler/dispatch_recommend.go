@@ -0,0 +1,341 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const earthRadiusKm = 6371.0
+
+// LoadDispatchCriteria builds the ranking criteria for a case from its sub type
+// and the dispatch node nodeId of the workflow version the case is pinned to.
+// With no nodeId only the sub type counts. The node config "maxLoad" overrides
+// DISPATCH_MAX_LOAD.
+func LoadDispatchCriteria(ctx context.Context, conn *pgx.Conn, orgId string, caseId string, nodeId string) (*model.DispatchCriteria, error) {
+	query := `
+	SELECT c."caseId", COALESCE(c."wfId", ''), COALESCE(c."versions", ''), c."distId",
+	       c."caseLat", c."caseLon",
+	       COALESCE(s."userSkillList", '[]'::jsonb), COALESCE(s."unitPropLists", '[]'::jsonb)
+	FROM public.tix_cases c
+	LEFT JOIN public.case_sub_types s
+	       ON s."sTypeId" = c."caseSTypeId" AND s."orgId" = c."orgId" AND s.active = TRUE
+	WHERE c."orgId" = $1 AND c."caseId" = $2
+	LIMIT 1`
+
+	var criteria model.DispatchCriteria
+	var lat, lon *string
+	var skills, props []string
+	err := conn.QueryRow(ctx, query, orgId, caseId).Scan(
+		&criteria.CaseID, &criteria.WfID, &criteria.Versions, &criteria.DistID,
+		&lat, &lon, &skills, &props,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("case not found: %s", caseId)
+		}
+		return nil, err
+	}
+	criteria.CaseLat = parseCoordinate(lat)
+	criteria.CaseLon = parseCoordinate(lon)
+	criteria.RequiredSkills = skills
+	criteria.RequiredProps = props
+
+	config, err := GetDispatchNodeConfig(ctx, conn, orgId, criteria.WfID, criteria.Versions, nodeId)
+	if err != nil {
+		return nil, err
+	}
+	criteria.RequiredSkills = mergeIds(criteria.RequiredSkills, configStrings(config, "userSkillList"))
+	criteria.RequiredProps = mergeIds(criteria.RequiredProps, configStrings(config, "unitPropLists"))
+
+	criteria.StaleAfter = time.Duration(utils.EnvInt("DISPATCH_GPS_STALE_MIN", 30)) * time.Minute
+	criteria.AvgSpeedKmh = float64(utils.EnvInt("DISPATCH_AVG_SPEED_KMH", 40))
+	criteria.UnitStatuses = []string{}
+	for _, s := range getEnvList("DISPATCH_UNIT_STATUS") {
+		if s = strings.TrimSpace(s); s != "" {
+			criteria.UnitStatuses = append(criteria.UnitStatuses, s)
+		}
+	}
+	criteria.MaxLoad = configInt(config, "maxLoad", utils.EnvInt("DISPATCH_MAX_LOAD", 1))
+
+	return &criteria, nil
+}
+
+// GetDispatchNodeConfig returns the config block of dispatch node nodeId in the
+// given workflow version, or nil when there is no such dispatch node.
+func GetDispatchNodeConfig(ctx context.Context, conn *pgx.Conn, orgId string, wfId string, versions string, nodeId string) (map[string]interface{}, error) {
+	if wfId == "" || nodeId == "" {
+		return nil, nil
+	}
+	query := `
+	SELECT COALESCE("data"->'data'->'config', '{}'::jsonb)
+	FROM public.wf_nodes
+	WHERE "orgId" = $1 AND "wfId" = $2 AND "versions" = $3 AND "nodeId" = $4
+	  AND "section" = 'nodes' AND LOWER("type") = 'dispatch'`
+
+	var raw []byte
+	err := conn.QueryRow(ctx, query, orgId, wfId, versions, nodeId).Scan(&raw)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadUnitCandidates returns the active units of the organization that can take
+// a case: logged in, not frozen, in one of criteria.UnitStatuses and holding
+// fewer than criteria.MaxLoad unit stages of open cases. Each comes with its
+// properties, its user's skills, its load and whether the user covers the case
+// district.
+func LoadUnitCandidates(ctx context.Context, conn *pgx.Conn, orgId string, criteria model.DispatchCriteria) ([]model.UnitCandidate, error) {
+	query := `
+WITH unit_loads AS (
+  SELECT cs."unitId", COUNT(*) AS load
+  FROM "tix_case_current_stage" cs
+  JOIN "tix_cases" c ON c."caseId" = cs."caseId" AND c."orgId" = cs."orgId"
+  WHERE cs."orgId" = $1 AND cs."stageType" = 'unit'
+    AND NOT (c."statusId" = ANY($4))
+  GROUP BY cs."unitId"
+),
+unit_with_props AS (
+  SELECT "unitId", array_agg("propId"::text) AS props
+  FROM "mdm_unit_with_properties"
+  WHERE "active" = TRUE
+  GROUP BY "unitId"
+),
+user_with_skills AS (
+  SELECT "userName", array_agg("skillId"::text) AS skills
+  FROM "um_user_with_skills"
+  WHERE "active" = TRUE
+  GROUP BY "userName"
+)
+SELECT DISTINCT ON (mu."unitId")
+       mu."orgId", mu."unitId", mu."unitName", mu."unitSourceId", mu."unitTypeId", mu."priority",
+       mu."compId", mu."deptId", mu."commId", mu."stnId", mu."plateNo", mu."provinceCode",
+       mu."active", mu."username", mu."isLogin", mu."isFreeze", mu."isOutArea",
+       mu."locLat", mu."locLon", mu."locAlt", mu."locBearing", mu."locSpeed", mu."locProvider",
+       mu."locGpsTime", mu."locSatellites", mu."locAccuracy", mu."locLastUpdateTime",
+       mu."breakDuration", mu."healthChk", mu."healthChkTime", mu."sttId",
+       mu."createdBy", mu."updatedBy",
+       COALESCE(p.props, '{}'), COALESCE(s.skills, '{}'), COALESCE(l.load, 0),
+       EXISTS (
+         SELECT 1
+         FROM "um_user_with_area_response" ua,
+              jsonb_array_elements_text(ua."distIdLists") AS distId
+         WHERE ua."orgId" = mu."orgId"
+           AND ua."username" = mu."username"
+           AND distId.value = $2
+       )
+FROM "mdm_units" mu
+JOIN "um_users" um ON um."username" = mu."username" AND um."orgId" = mu."orgId" AND um."active" = TRUE
+LEFT JOIN unit_with_props p ON p."unitId" = mu."unitId"
+LEFT JOIN user_with_skills s ON s."userName" = mu."username"
+LEFT JOIN unit_loads l ON l."unitId" = mu."unitId"
+WHERE mu."orgId" = $1 AND mu."active" = TRUE
+  AND mu."isLogin" = TRUE AND mu."isFreeze" IS NOT TRUE
+  AND (cardinality($3::text[]) = 0 OR mu."sttId" = ANY($3))
+  AND COALESCE(l.load, 0) < $5`
+
+	rows, err := conn.Query(ctx, query, orgId, criteria.DistID, criteria.UnitStatuses, closedCaseStatuses(), criteria.MaxLoad)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var units []model.UnitCandidate
+	for rows.Next() {
+		var u model.UnitCandidate
+		if err := rows.Scan(
+			&u.OrgID, &u.UnitID, &u.UnitName, &u.UnitSourceID, &u.UnitTypeID, &u.Priority,
+			&u.CompID, &u.DeptID, &u.CommID, &u.StnID, &u.PlateNo, &u.ProvinceCode,
+			&u.Active, &u.Username, &u.IsLogin, &u.IsFreeze, &u.IsOutArea,
+			&u.LocLat, &u.LocLon, &u.LocAlt, &u.LocBearing, &u.LocSpeed, &u.LocProvider,
+			&u.LocGpsTime, &u.LocSatellites, &u.LocAccuracy, &u.LocLastUpdateTime,
+			&u.BreakDuration, &u.HealthChk, &u.HealthChkTime, &u.SttID,
+			&u.CreatedBy, &u.UpdatedBy,
+			&u.UnitProps, &u.UserSkills, &u.Load, &u.InArea,
+		); err != nil {
+			return nil, err
+		}
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
+
+// RankUnitCandidates marks each unit eligible or not against the criteria and
+// orders them: eligible first, then by distance (unknown last), then by unit
+// priority. A unit must be available (logged in, not frozen, in one of
+// UnitStatuses and holding fewer than MaxLoad unit stages), hold every required
+// property and at least one required skill, cover the case district and, when
+// StaleAfter is set, report a GPS fix no older than StaleAfter.
+func RankUnitCandidates(units []model.UnitCandidate, criteria model.DispatchCriteria, now time.Time) []model.UnitCandidate {
+	for i := range units {
+		u := &units[i]
+		u.Eligible = true
+		u.Reasons = []string{}
+
+		if reason := unitUnavailable(*u, criteria); reason != "" {
+			u.Eligible = false
+			u.Reasons = append(u.Reasons, reason)
+		}
+
+		if missing := missingIds(criteria.RequiredProps, u.UnitProps); len(missing) > 0 {
+			u.Eligible = false
+			u.Reasons = append(u.Reasons, "missing properties: "+strings.Join(missing, ","))
+		} else if len(criteria.RequiredProps) > 0 {
+			u.Reasons = append(u.Reasons, "has all required properties")
+		}
+
+		if len(criteria.RequiredSkills) > 0 {
+			if len(missingIds(criteria.RequiredSkills, u.UserSkills)) == len(criteria.RequiredSkills) {
+				u.Eligible = false
+				u.Reasons = append(u.Reasons, "no required skill")
+			} else {
+				u.Reasons = append(u.Reasons, "has required skill")
+			}
+		}
+
+		if !u.InArea {
+			u.Eligible = false
+			u.Reasons = append(u.Reasons, "outside case district "+criteria.DistID)
+		} else {
+			u.Reasons = append(u.Reasons, "covers case district")
+		}
+
+		if u.LocLastUpdateTime != nil {
+			age := now.Sub(*u.LocLastUpdateTime).Minutes()
+			age = math.Round(age*10) / 10
+			u.GpsAgeMin = &age
+		}
+		if criteria.StaleAfter > 0 {
+			switch {
+			case u.LocLat == nil || u.LocLon == nil || u.GpsAgeMin == nil:
+				u.Eligible = false
+				u.Reasons = append(u.Reasons, "no GPS fix")
+			case now.Sub(*u.LocLastUpdateTime) > criteria.StaleAfter:
+				u.Eligible = false
+				u.Reasons = append(u.Reasons, fmt.Sprintf("GPS fix stale (%.1f min)", *u.GpsAgeMin))
+			}
+		}
+
+		if criteria.CaseLat != nil && criteria.CaseLon != nil && u.LocLat != nil && u.LocLon != nil {
+			d := haversineKm(*criteria.CaseLat, *criteria.CaseLon, *u.LocLat, *u.LocLon)
+			d = math.Round(d*100) / 100
+			u.DistanceKm = &d
+			u.Reasons = append(u.Reasons, fmt.Sprintf("%.2f km from case", d))
+			if criteria.AvgSpeedKmh > 0 {
+				eta := math.Round(d/criteria.AvgSpeedKmh*60*10) / 10
+				u.EtaMinutes = &eta
+			}
+		} else {
+			u.Reasons = append(u.Reasons, "distance unknown")
+		}
+	}
+
+	sort.SliceStable(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a.Eligible != b.Eligible {
+			return a.Eligible
+		}
+		if (a.DistanceKm == nil) != (b.DistanceKm == nil) {
+			return a.DistanceKm != nil
+		}
+		if a.DistanceKm != nil && *a.DistanceKm != *b.DistanceKm {
+			return *a.DistanceKm < *b.DistanceKm
+		}
+		return a.Priority < b.Priority
+	})
+	return units
+}
+
+// unitUnavailable tells why a unit cannot take a case right now, or returns ""
+// when it can. LoadUnitCandidates already leaves these units out.
+func unitUnavailable(u model.UnitCandidate, criteria model.DispatchCriteria) string {
+	switch {
+	case !u.IsLogin:
+		return "not logged in"
+	case u.IsFreeze:
+		return "frozen"
+	case len(criteria.UnitStatuses) > 0 && (u.SttID == nil || !contains(criteria.UnitStatuses, *u.SttID)):
+		return "unit status not available"
+	case u.Load >= criteria.MaxLoad:
+		return fmt.Sprintf("busy with %d open case(s)", u.Load)
+	}
+	return ""
+}
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func parseCoordinate(v *string) *float64 {
+	if v == nil || strings.TrimSpace(*v) == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(*v), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+func missingIds(required []string, have []string) []string {
+	haveMap := make(map[string]bool, len(have))
+	for _, h := range have {
+		haveMap[h] = true
+	}
+	missing := []string{}
+	for _, r := range required {
+		if !haveMap[r] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+func mergeIds(a []string, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	result := []string{}
+	for _, id := range append(append([]string{}, a...), b...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func configStrings(config map[string]interface{}, key string) []string {
+	raw, ok := config[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"mainPackage/model"
+	"testing"
+	"time"
+)
+
+func TestRankUnitCandidatesAvailability(t *testing.T) {
+	criteria := model.DispatchCriteria{
+		DistID:       "d1",
+		UnitStatuses: []string{"001"},
+		MaxLoad:      1,
+	}
+	status := func(s string) *string { return &s }
+	unit := func(id string, login, freeze bool, stt *string, load int) model.UnitCandidate {
+		u := model.UnitCandidate{InArea: true, Load: load}
+		u.UnitID = id
+		u.IsLogin = login
+		u.IsFreeze = freeze
+		u.SttID = stt
+		return u
+	}
+
+	tests := []struct {
+		name       string
+		unit       model.UnitCandidate
+		wantOK     bool
+		wantReason string
+	}{
+		{"available", unit("u1", true, false, status("001"), 0), true, ""},
+		{"logged out", unit("u2", false, false, status("001"), 0), false, "not logged in"},
+		{"frozen", unit("u3", true, true, status("001"), 0), false, "frozen"},
+		{"off duty status", unit("u4", true, false, status("000"), 0), false, "unit status not available"},
+		{"no status", unit("u5", true, false, nil, 0), false, "unit status not available"},
+		{"busy on an open case", unit("u6", true, false, status("001"), 1), false, "busy with 1 open case(s)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RankUnitCandidates([]model.UnitCandidate{tt.unit}, criteria, time.Now())[0]
+			if got.Eligible != tt.wantOK {
+				t.Fatalf("eligible = %v, want %v (reasons %v)", got.Eligible, tt.wantOK, got.Reasons)
+			}
+			if tt.wantReason != "" && (len(got.Reasons) == 0 || got.Reasons[0] != tt.wantReason) {
+				t.Errorf("reasons = %v, want %q first", got.Reasons, tt.wantReason)
+			}
+		})
+	}
+
+	// Any status goes when none is configured, and a higher load limit lets a
+	// busy unit take one more case.
+	loose := criteria
+	loose.UnitStatuses = nil
+	loose.MaxLoad = 2
+	got := RankUnitCandidates([]model.UnitCandidate{unit("u7", true, false, status("000"), 1)}, loose, time.Now())[0]
+	if !got.Eligible {
+		t.Errorf("unit left out with reasons %v", got.Reasons)
+	}
+
+	// Unavailable units rank after available ones.
+	ranked := RankUnitCandidates([]model.UnitCandidate{
+		unit("busy", true, false, status("001"), 1),
+		unit("free", true, false, status("001"), 0),
+	}, criteria, time.Now())
+	if ranked[0].UnitID != "free" {
+		t.Errorf("first unit = %s, want free", ranked[0].UnitID)
+	}
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"context"
+	"log"
+)
+
+// EsbMessageHandler processes one ESB message. A message whose handler fails
+// is kept as a dead letter so it can be replayed later.
+type EsbMessageHandler = BusHandler
+
+// ConsumeEsbTopic subscribes the handler to the topic on EsbBus until ctx is
+// cancelled. The handler is also registered for dead-letter replay.
+func ConsumeEsbTopic(ctx context.Context, name string, topic string, handle EsbMessageHandler) error {
+	registerEsbHandler(topic, handle)
+	return esbBus().Subscribe(ctx, name, topic, deadLetterOnError(name, handle))
+}
+
+// deadLetterOnError stores failed messages as dead letters. The error is still
+// returned so the bus can log it.
+func deadLetterOnError(name string, handle EsbMessageHandler) EsbMessageHandler {
+	return func(msg *BusMessage) error {
+		err := handle(msg)
+		if err != nil {
+			if dlqErr := StoreEsbDeadLetter(msg, err); dlqErr != nil {
+				log.Printf("❌ %s store dead letter: %v", name, dlqErr)
+			}
+		}
+		return err
+	}
+}
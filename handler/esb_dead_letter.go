@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	DeadLetterFailed    = "FAILED"
+	DeadLetterReplayed  = "REPLAYED"
+	DeadLetterDiscarded = "DISCARDED"
+)
+
+var (
+	esbHandlersMu sync.RWMutex
+	esbHandlers   = map[string]EsbMessageHandler{}
+)
+
+// registerEsbHandler remembers the handler of a topic so dead letters of that
+// topic can be replayed through the same code path as live messages.
+func registerEsbHandler(topic string, handle EsbMessageHandler) {
+	esbHandlersMu.Lock()
+	defer esbHandlersMu.Unlock()
+	esbHandlers[topic] = handle
+}
+
+func getEsbHandler(topic string) (EsbMessageHandler, bool) {
+	esbHandlersMu.RLock()
+	defer esbHandlersMu.RUnlock()
+	handle, ok := esbHandlers[topic]
+	return handle, ok
+}
+
+// StoreEsbDeadLetter keeps a failed message with its error and original
+// payload. A message already stored for the same source/topic/partition/offset
+// only gets its error and attempt count updated.
+func StoreEsbDeadLetter(msg *BusMessage, handleErr error) error {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return fmt.Errorf("DB connection is nil")
+	}
+	defer cancel()
+
+	var key *string
+	if len(msg.Key) > 0 {
+		k := string(msg.Key)
+		key = &k
+	}
+
+	_, err := conn.Exec(ctx, `
+	INSERT INTO public.esb_dead_letters
+	  (id, "orgId", source, topic, "partition", "offset", "msgKey", payload, error, attempts, status, "createdAt", "updatedAt")
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 1, $10, NOW(), NOW())
+	ON CONFLICT (source, topic, "partition", "offset") DO UPDATE
+	SET error = EXCLUDED.error, attempts = esb_dead_letters.attempts + 1,
+	    status = EXCLUDED.status, "updatedAt" = NOW()`,
+		uuid.New().String(), os.Getenv("INTEGRATION_ORG_ID"), msg.Source, msg.Topic, msg.Partition, msg.Offset,
+		key, string(msg.Value), handleErr.Error(), DeadLetterFailed,
+	)
+	return err
+}
+
+func getEsbDeadLetter(ctx context.Context, conn *pgx.Conn, orgId string, id string) (*model.EsbDeadLetter, error) {
+	query := `
+	SELECT id, "orgId", source, topic, "partition", "offset", "msgKey", payload, error, attempts, status,
+	       "createdAt", "updatedAt", "updatedBy", "replayAt"
+	FROM public.esb_dead_letters
+	WHERE "orgId" = $1 AND id = $2`
+	var d model.EsbDeadLetter
+	err := conn.QueryRow(ctx, query, orgId, id).Scan(
+		&d.ID, &d.OrgID, &d.Source, &d.Topic, &d.Partition, &d.Offset, &d.MsgKey, &d.Payload, &d.Error,
+		&d.Attempts, &d.Status, &d.CreatedAt, &d.UpdatedAt, &d.UpdatedBy, &d.ReplayAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// @summary Get ESB dead letters
+// @tags Integration
+// @security ApiKeyAuth
+// @id Get ESB dead letters
+// @accept json
+// @produce json
+// @Param status query string false "FAILED | REPLAYED | DISCARDED"
+// @Param topic query string false "topic"
+// @Param start query int false "start" default(0)
+// @Param length query int false "length" default(10)
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/esb/dead_letters [get]
+func GetEsbDeadLetters(c *gin.Context) {
+	logger := utils.GetLog()
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	start, err := strconv.Atoi(c.DefaultQuery("start", "0"))
+	if err != nil {
+		start = 0
+	}
+	length, err := strconv.Atoi(c.DefaultQuery("length", "10"))
+	if err != nil {
+		length = 10
+	}
+
+	query := `
+	SELECT id, "orgId", source, topic, "partition", "offset", "msgKey", payload, error, attempts, status,
+	       "createdAt", "updatedAt", "updatedBy", "replayAt"
+	FROM public.esb_dead_letters
+	WHERE "orgId" = $1
+	  AND ($2 = '' OR status = $2)
+	  AND ($3 = '' OR topic = $3)
+	ORDER BY "createdAt" DESC
+	LIMIT $4 OFFSET $5`
+	logger.Debug(`Query`, zap.String("query", query))
+	rows, err := conn.Query(ctx, query, orgId, c.Query("status"), c.Query("topic"), length, start)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Integration", "GetEsbDeadLetters", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Query failed = "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		logger.Warn("Query failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	defer rows.Close()
+
+	letters := []model.EsbDeadLetter{}
+	for rows.Next() {
+		var d model.EsbDeadLetter
+		if err := rows.Scan(
+			&d.ID, &d.OrgID, &d.Source, &d.Topic, &d.Partition, &d.Offset, &d.MsgKey, &d.Payload, &d.Error,
+			&d.Attempts, &d.Status, &d.CreatedAt, &d.UpdatedAt, &d.UpdatedBy, &d.ReplayAt,
+		); err != nil {
+			logger.Warn("Scan failed", zap.Error(err))
+			response := model.Response{
+				Status: "-1",
+				Msg:    "Failure",
+				Desc:   err.Error(),
+			}
+			c.JSON(http.StatusInternalServerError, response)
+			return
+		}
+		letters = append(letters, d)
+	}
+
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   letters,
+		Desc:   "",
+	})
+}
+
+// @summary Get ESB dead letter by id
+// @tags Integration
+// @security ApiKeyAuth
+// @id Get ESB dead letter by id
+// @accept json
+// @produce json
+// @Param id path string true "id"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/esb/dead_letters/{id} [get]
+func GetEsbDeadLetterById(c *gin.Context) {
+	logger := utils.GetLog()
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	id := c.Param("id")
+	orgId := GetVariableFromToken(c, "orgId")
+	letter, err := getEsbDeadLetter(ctx, conn, orgId.(string), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == pgx.ErrNoRows {
+			status = http.StatusNotFound
+		}
+		logger.Warn("Query failed", zap.Error(err))
+		c.JSON(status, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   letter,
+		Desc:   "",
+	})
+}
+
+// @summary Update ESB dead letter
+// @description Edit the stored payload before a replay, or set status DISCARDED.
+// @tags Integration
+// @security ApiKeyAuth
+// @id Update ESB dead letter
+// @accept json
+// @produce json
+// @Param id path string true "id"
+// @param Body body model.EsbDeadLetterUpdate true "Dead letter changes"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/esb/dead_letters/{id} [patch]
+func UpdateEsbDeadLetter(c *gin.Context) {
+	logger := utils.GetLog()
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	id := c.Param("id")
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.EsbDeadLetterUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("Update failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+	if req.Status != nil && *req.Status != DeadLetterFailed && *req.Status != DeadLetterDiscarded {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   "status must be " + DeadLetterFailed + " or " + DeadLetterDiscarded,
+		})
+		return
+	}
+
+	query := `UPDATE public.esb_dead_letters
+	SET payload = COALESCE($3, payload), status = COALESCE($4, status),
+	    "updatedAt" = NOW(), "updatedBy" = $5
+	WHERE "orgId" = $1 AND id = $2 AND status <> $6`
+	tag, err := conn.Exec(ctx, query, orgId, id, req.Payload, req.Status, username, DeadLetterReplayed)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = fmt.Errorf("dead letter not found or already replayed: %s", id)
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "Integration", "UpdateEsbDeadLetter", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failure = "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		logger.Warn("Update failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Update successfully",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "Integration", "UpdateEsbDeadLetter", "",
+		"update", 0, start_time, GetQueryParams(c), response, "UpdateEsbDeadLetter Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Replay ESB dead letter
+// @description Run the stored payload through the handler of its topic again.
+// @tags Integration
+// @security ApiKeyAuth
+// @id Replay ESB dead letter
+// @accept json
+// @produce json
+// @Param id path string true "id"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/esb/dead_letters/{id}/replay [post]
+func ReplayEsbDeadLetter(c *gin.Context) {
+	logger := utils.GetLog()
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	id := c.Param("id")
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	fail := func(status int, err error) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "Integration", "ReplayEsbDeadLetter", "",
+			"replay", -1, start_time, GetQueryParams(c), response, "Failure = "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		logger.Warn("Replay failed", zap.Error(err))
+		c.JSON(status, response)
+	}
+
+	letter, err := getEsbDeadLetter(ctx, conn, orgId.(string), id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			fail(http.StatusNotFound, fmt.Errorf("dead letter not found: %s", id))
+			return
+		}
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if letter.Status != DeadLetterFailed {
+		fail(http.StatusConflict, fmt.Errorf("dead letter is %s", letter.Status))
+		return
+	}
+	handle, ok := getEsbHandler(letter.Topic)
+	if !ok {
+		fail(http.StatusBadRequest, fmt.Errorf("no handler registered for topic %s", letter.Topic))
+		return
+	}
+
+	msg := &BusMessage{
+		Source:    letter.Source,
+		Topic:     letter.Topic,
+		Partition: letter.Partition,
+		Offset:    letter.Offset,
+		Value:     []byte(letter.Payload),
+		Timestamp: time.Now(),
+	}
+	if letter.MsgKey != nil {
+		msg.Key = []byte(*letter.MsgKey)
+	}
+	log.Printf("Replay dead letter %s topic=%s offset=%d by %v", id, letter.Topic, letter.Offset, username)
+	handleErr := handle(msg)
+
+	if handleErr != nil {
+		_, err = conn.Exec(ctx, `UPDATE public.esb_dead_letters
+		SET error = $3, attempts = attempts + 1, "updatedAt" = NOW(), "updatedBy" = $4
+		WHERE "orgId" = $1 AND id = $2`, orgId, id, handleErr.Error(), username)
+		if err != nil {
+			logger.Warn("Update dead letter failed", zap.Error(err))
+		}
+		fail(http.StatusUnprocessableEntity, handleErr)
+		return
+	}
+
+	_, err = conn.Exec(ctx, `UPDATE public.esb_dead_letters
+	SET status = $3, attempts = attempts + 1, "replayAt" = NOW(), "updatedAt" = NOW(), "updatedBy" = $4
+	WHERE "orgId" = $1 AND id = $2`, orgId, id, DeadLetterReplayed, username)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Replay successfully",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "Integration", "ReplayEsbDeadLetter", "",
+		"replay", 0, start_time, GetQueryParams(c), response, "ReplayEsbDeadLetter Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
@@ -9,266 +9,74 @@ import (
 	"mainPackage/model"
 	"mainPackage/utils"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
-func ESB_USER_CREATE(type_ string) error {
-	maxRetryStr := os.Getenv("KAFKA_RETRY")
-	intervalStr := os.Getenv("KAFKA_INTERVAL")
-	maxRetryInt, err_ := strconv.Atoi(maxRetryStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		maxRetryInt = 10 // default fallback
-	}
-	intervalInt, err_ := strconv.Atoi(intervalStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		intervalInt = 10 // default fallback
-	}
+func ESB_USER_CREATE(ctx context.Context, type_ string) error {
 	TOPIC := os.Getenv("ESB_USER_STAFF_CREATE")
 	if type_ == "ADMIN" {
 		TOPIC = os.Getenv("ESB_USER_ADMIN_CREATE")
 	}
-	var (
-		brokers       = []string{os.Getenv("ESB_SERVER")}
-		topic         = TOPIC
-		maxRetry      = maxRetryInt
-		retryInterval = time.Duration(intervalInt) * time.Second
-	)
-
-	var consumer sarama.Consumer
-	var err error
-
-	for attempt := 1; attempt <= maxRetry; attempt++ {
-		log.Printf("Attempt %d to connect to "+topic+" brokers: %v", attempt, brokers)
-		consumer, err = sarama.NewConsumer(brokers, nil)
-		if err == nil {
-			break
-		}
-		log.Printf("Failed to connect to "+topic+": %v", err)
-		time.Sleep(retryInterval)
-	}
-
-	if err != nil {
-		return fmt.Errorf("could not connect to "+topic+" after %d attempts: %w", maxRetry, err)
-	}
-
-	defer func() {
-		if err := consumer.Close(); err != nil {
-			log.Printf("Error closing "+topic+" consumer: %v", err)
-		}
-	}()
-
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Printf("Error starting partition consumer: %v", err)
-		return err
-	}
-	defer func() {
-		if err := partitionConsumer.Close(); err != nil {
-			log.Printf("Error closing %s() consumer: %v", type_, err)
-		}
-	}()
-
-	// decryptedPassword, err := encrypt("chiranan")
-	// log.Print("======>" + decryptedPassword)
-
-	log.Printf("Kafka CREATE %s() started. Listening for messages...%v", type_, TOPIC)
-	// username_ := os.Getenv("INTEGRATION_USR")
-	// orgId_ := os.Getenv("INTEGRATION_ORG_ID")
-	for msg := range partitionConsumer.Messages() {
+	return ConsumeEsbTopic(ctx, "CREATE "+type_+"()", TOPIC, func(msg *BusMessage) error {
 		//---> Call funtion for insert or update by user_code
 		log.Print(string(msg.Value))
 		var payload model.ESBUserStaffPayload
 		if err := json.Unmarshal(msg.Value, &payload); err != nil {
-			log.Println("❌ JSON Unmarshal error:", err)
-			continue
+			return fmt.Errorf("JSON unmarshal error: %w", err)
 		}
 
 		if err := UpsertUserFromESB(type_, payload); err != nil {
-			log.Println("❌ Upsert error:", err)
-		} else {
-			log.Println("✅ Create User processed:", payload.Username)
+			return fmt.Errorf("upsert error: %w", err)
 		}
-	}
-
-	return nil
+		log.Println("✅ Create User processed:", payload.Username)
+		return nil
+	})
 }
 
-func ESB_USER_UPDATE(type_ string) error {
-	maxRetryStr := os.Getenv("KAFKA_RETRY")
-	intervalStr := os.Getenv("KAFKA_INTERVAL")
-	maxRetryInt, err_ := strconv.Atoi(maxRetryStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		maxRetryInt = 10 // default fallback
-	}
-	intervalInt, err_ := strconv.Atoi(intervalStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		intervalInt = 10 // default fallback
-	}
-
+func ESB_USER_UPDATE(ctx context.Context, type_ string) error {
 	TOPIC := os.Getenv("ESB_USER_STAFF_UPDATE")
 	if type_ == "ADMIN" {
 		TOPIC = os.Getenv("ESB_USER_ADMIN_UPDATE")
 	}
-
-	var (
-		brokers       = []string{os.Getenv("ESB_SERVER")}
-		topic         = TOPIC
-		maxRetry      = maxRetryInt
-		retryInterval = time.Duration(intervalInt) * time.Second
-	)
-
-	var consumer sarama.Consumer
-	var err error
-
-	for attempt := 1; attempt <= maxRetry; attempt++ {
-		log.Printf("Attempt %d to connect to "+topic+" brokers: %v", attempt, brokers)
-		consumer, err = sarama.NewConsumer(brokers, nil)
-		if err == nil {
-			break
-		}
-		log.Printf("Failed to connect to "+topic+": %v", err)
-		time.Sleep(retryInterval)
-	}
-
-	if err != nil {
-		return fmt.Errorf("could not connect to "+topic+" after %d attempts: %w", maxRetry, err)
-	}
-
-	defer func() {
-		if err := consumer.Close(); err != nil {
-			log.Printf("Error closing "+topic+" consumer: %v", err)
-		}
-	}()
-
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Printf("Error starting partition consumer: %v", err)
-		return err
-	}
-	defer func() {
-		if err := partitionConsumer.Close(); err != nil {
-			log.Printf("Error closing %s() consumer: %v", type_, err)
-		}
-	}()
-
-	log.Printf("Kafka UPDATE %s() started. Listening for messages...%v", type_, TOPIC)
-	// username_ := os.Getenv("INTEGRATION_USR")
-	// orgId_ := os.Getenv("INTEGRATION_ORG_ID")
-	for msg := range partitionConsumer.Messages() {
+	return ConsumeEsbTopic(ctx, "UPDATE "+type_+"()", TOPIC, func(msg *BusMessage) error {
 		//---> Call funtion for insert or update by user_code
 		log.Print(string(msg.Value))
 		var payload model.ESBUserStaffPayload
 		if err := json.Unmarshal(msg.Value, &payload); err != nil {
-			log.Println("❌ JSON Unmarshal error:", err)
-			continue
+			return fmt.Errorf("JSON unmarshal error: %w", err)
 		}
 
 		if err := UpsertUserFromESB(type_, payload); err != nil {
-			log.Println("❌ Upsert error:", err)
-		} else {
-			log.Println("✅ Update User processed:", payload.Username)
+			return fmt.Errorf("upsert error: %w", err)
 		}
-	}
-
-	return nil
+		log.Println("✅ Update User processed:", payload.Username)
+		return nil
+	})
 }
 
-func ESB_USER_DELETE(type_ string) error {
-	maxRetryStr := os.Getenv("KAFKA_RETRY")
-	intervalStr := os.Getenv("KAFKA_INTERVAL")
-	maxRetryInt, err_ := strconv.Atoi(maxRetryStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		maxRetryInt = 10 // default fallback
-	}
-	intervalInt, err_ := strconv.Atoi(intervalStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		intervalInt = 10 // default fallback
-	}
-
+func ESB_USER_DELETE(ctx context.Context, type_ string) error {
 	TOPIC := os.Getenv("ESB_USER_STAFF_DELETE")
 	if type_ == "ADMIN" {
 		TOPIC = os.Getenv("ESB_USER_ADMIN_DELETE")
 	}
-
-	var (
-		brokers       = []string{os.Getenv("ESB_SERVER")}
-		topic         = TOPIC
-		maxRetry      = maxRetryInt
-		retryInterval = time.Duration(intervalInt) * time.Second
-	)
-
-	var consumer sarama.Consumer
-	var err error
-
-	for attempt := 1; attempt <= maxRetry; attempt++ {
-		log.Printf("Attempt %d to connect to "+topic+" brokers: %v", attempt, brokers)
-		consumer, err = sarama.NewConsumer(brokers, nil)
-		if err == nil {
-			break
-		}
-		log.Printf("Failed to connect to "+topic+": %v", err)
-		time.Sleep(retryInterval)
-	}
-
-	if err != nil {
-		return fmt.Errorf("could not connect to "+topic+" after %d attempts: %w", maxRetry, err)
-	}
-
-	defer func() {
-		if err := consumer.Close(); err != nil {
-			log.Printf("Error closing "+topic+" consumer: %v", err)
-		}
-	}()
-
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Printf("Error starting partition consumer: %v", err)
-		return err
-	}
-	defer func() {
-		if err := partitionConsumer.Close(); err != nil {
-			log.Printf("Error closing %s() consumer: %v", type_, err)
-		}
-	}()
-
-	log.Printf("Kafka DELETE %s() started. Listening for messages...%v", type_, os.Getenv("ESB_USER_STAFF_DELETE"))
-	// username_ := os.Getenv("INTEGRATION_USR")
-	// orgId_ := os.Getenv("INTEGRATION_ORG_ID")
-	for msg := range partitionConsumer.Messages() {
+	return ConsumeEsbTopic(ctx, "DELETE "+type_+"()", TOPIC, func(msg *BusMessage) error {
 		//---> Call funtion for insert or update by user_code
 		log.Print(string(msg.Value))
 		var payload model.ESBUserStaffPayload
 		if err := json.Unmarshal(msg.Value, &payload); err != nil {
-			log.Println("❌ JSON Unmarshal error:", err)
-			continue
+			return fmt.Errorf("JSON unmarshal error: %w", err)
 		}
 
-		// if err := DeleteUserFromESB(payload); err != nil {
-		// 	log.Println("❌ Upsert error:", err)
-		// } else {
-		// 	log.Println("✅ User processed:", payload.Username)
-		// }
-
 		if err := DeActivateUserFromESB(payload); err != nil {
-			log.Println("❌ Upsert error:", err)
-		} else {
-			log.Println("✅ Disable User processed:", payload.Username)
+			return fmt.Errorf("disable user error: %w", err)
 		}
-	}
-
-	return nil
+		log.Println("✅ Disable User processed:", payload.Username)
+		return nil
+	})
 }
 
 func UpsertUserFromESB(type_ string, p model.ESBUserStaffPayload) error {
@@ -277,7 +85,6 @@ func UpsertUserFromESB(type_ string, p model.ESBUserStaffPayload) error {
 		return fmt.Errorf("database connection is nil")
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// Get environment variables
 	username := strings.TrimSpace(os.Getenv("INTEGRATION_USR"))
@@ -296,7 +103,10 @@ func UpsertUserFromESB(type_ string, p model.ESBUserStaffPayload) error {
 	displayName := strings.TrimSpace(p.FirstName + " " + p.LastName)
 	userCode := strings.TrimSpace(p.UserCode)
 	userUsername := strings.TrimSpace(p.Username)
-	encPassword, _ := encrypt(p.Password)
+	encPassword, err := hashPassword(p.Password)
+	if err != nil {
+		return fmt.Errorf("hash password error: %w", err)
+	}
 
 	fmt.Println("DEBUG PARAM TYPES:")
 	fmt.Printf("orgId      (%T): %v\n", orgId, orgId)
@@ -400,7 +210,6 @@ func DeleteUserFromESB(p model.ESBUserStaffPayload) error {
 		return fmt.Errorf("database connection is nil")
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	orgId := os.Getenv("INTEGRATION_ORG_ID")
 	username := os.Getenv("INTEGRATION_USR")
@@ -428,7 +237,6 @@ func DeActivateUserFromESB(p model.ESBUserStaffPayload) error {
 		return errors.New("cannot connect database")
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	orgId := os.Getenv("INTEGRATION_ORG_ID")
 	username := os.Getenv("INTEGRATION_USR")
@@ -440,17 +248,35 @@ func DeActivateUserFromESB(p model.ESBUserStaffPayload) error {
             "updatedAt" = NOW(),
 			"updatedBy" = $3
         WHERE "orgId" = $1 AND "empId" = $2
+        RETURNING username
     `
 
-	cmd, err := conn.Exec(ctx, query, orgId, empId, username)
+	rows, err := conn.Query(ctx, query, orgId, empId, username)
 	if err != nil {
 		return fmt.Errorf("update error: %v", err)
 	}
+	var deactivated []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return fmt.Errorf("update error: %v", err)
+		}
+		deactivated = append(deactivated, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("update error: %v", err)
+	}
 
-	if cmd.RowsAffected() == 0 {
+	if len(deactivated) == 0 {
 		return fmt.Errorf("no user found with orgId=%s empId=%s", orgId, empId)
 	}
 
+	for _, u := range deactivated {
+		RevokeUserSessions(orgId, u, "", "deactivated from ESB")
+	}
+
 	DeleteUnit(ctx, conn, orgId, empId)
 	DeleteUnitProperty(ctx, conn, orgId, empId)
 
@@ -1,133 +1,38 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"mainPackage/model"
 	"mainPackage/utils"
 	"os"
-	"strconv"
-	"time"
 
-	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 )
 
-func ESB_WORK_ORDER_CREATE() error {
-	maxRetryStr := os.Getenv("KAFKA_RETRY")
-	intervalStr := os.Getenv("KAFKA_INTERVAL")
-	maxRetryInt, err_ := strconv.Atoi(maxRetryStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		maxRetryInt = 10 // default fallback
-	}
-	intervalInt, err_ := strconv.Atoi(intervalStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		intervalInt = 10 // default fallback
-	}
-	var (
-		brokers       = []string{os.Getenv("ESB_SERVER")}
-		topic         = os.Getenv("ESB_WO_CREATE")
-		maxRetry      = maxRetryInt
-		retryInterval = time.Duration(intervalInt) * time.Second
-	)
-
-	var consumer sarama.Consumer
-	var err error
-
-	for attempt := 1; attempt <= maxRetry; attempt++ {
-		log.Printf("Attempt %d to connect to "+topic+" brokers: %v", attempt, brokers)
-		consumer, err = sarama.NewConsumer(brokers, nil)
-		if err == nil {
-			break
-		}
-		log.Printf("Failed to connect to "+topic+": %v", err)
-		time.Sleep(retryInterval)
-	}
-
-	if err != nil {
-		return fmt.Errorf("could not connect to "+topic+" after %d attempts: %w", maxRetry, err)
-	}
-
-	defer func() {
-		if err := consumer.Close(); err != nil {
-			log.Printf("Error closing "+topic+" consumer: %v", err)
-		}
-	}()
-
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Printf("Error starting partition consumer: %v", err)
-		return err
-	}
-	defer func() {
-		if err := partitionConsumer.Close(); err != nil {
-			log.Printf("Error closing ESB_WORK_ORDER_CREATE() consumer: %v", err)
-		}
-	}()
-
-	log.Println("Kafka ESB_WORK_ORDER_CREATE() started. Listening for messages...")
-
-	for msg := range partitionConsumer.Messages() {
+func ESB_WORK_ORDER_CREATE(ctx context.Context) error {
+	return ConsumeEsbTopic(ctx, "ESB_WORK_ORDER_CREATE()", os.Getenv("ESB_WO_CREATE"), func(msg *BusMessage) error {
 		log.Print("ESB_WORK_ORDER_CREATE --> ", string(msg.Value))
-		go handleMessage_WO_Create(&gin.Context{}, msg.Value)
-	}
-
-	return nil
+		return handleMessage_WO_Create(&gin.Context{}, msg.Value)
+	})
 }
 
-func handleMessage_WO_Create(c *gin.Context, message []byte) {
+func handleMessage_WO_Create(c *gin.Context, message []byte) error {
 	source := os.Getenv("INTEGRATION_SOURCE")
 	log.Printf("Create :: Raw message: %s", string(message))
 	var wo model.WorkOrder
 	if err := json.Unmarshal(message, &wo); err != nil {
-		log.Printf("Error unmarshalling message: %v", err)
-		return
+		return fmt.Errorf("unmarshal work order: %w", err)
 	}
 
-	// Check owner -- Start
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Println("Hostname error:", err)
-		hostname = "unknown"
-	}
-
-	log.Printf("Recheck ESB Create WO on host: %s\n", hostname)
-
-	val, err := utils.EsbCreateGet(wo.IncidentNumber)
-	if err != nil {
-		log.Println("Redis GET error:", err)
-		return
-	}
-
-	if val == "" || val == hostname {
-		err = utils.EsbCreateSet(wo.WorkOrderNumber, hostname)
-		if err != nil {
-			log.Println("Redis SET error:", err)
-			return
-		}
-	} else {
-		log.Printf("Skip Message Not allow Owner : %s\n", hostname)
-		return
-	}
-	// Check owner -- End
-
 	if wo.Source == source {
 		log.Printf("Skip Message From Original Source : %s\n", source)
-		return
-	}
-
-	conn, ctx, cancel := utils.ConnectDB()
-	if conn == nil {
-		log.Printf("DB connection is nil")
-		return
+		return nil
 	}
-	defer cancel()
-	defer conn.Close(ctx)
 
 	username := os.Getenv("INTEGRATION_USR")
 	orgId := os.Getenv("INTEGRATION_ORG_ID")
@@ -135,117 +40,58 @@ func handleMessage_WO_Create(c *gin.Context, message []byte) {
 	c.Set("username", username)
 	c.Set("orgId", orgId)
 
-	if err := IntegrateCreateCaseFromWorkOrder(c, conn, wo, username, orgId); err != nil {
-		log.Printf("Error creating case from WorkOrder: %v", err)
-	}
-}
-
-func ESB_WORK_ORDER_UPDATE() error {
-
-	// Check owner -- Start
-	// hostname, err := os.Hostname()
-	// if err != nil {
-	// 	log.Println("Hostname error:", err)
-	// 	hostname = "unknown"
-	// }
-	// log.Printf("Recheck ESB Update WO on host: %s\n", hostname)
-	// if val == "" || val == hostname {
-	// 	err = utils.EsbCreateSet(wo.WorkOrderNumber, hostname)
-	// 	if err != nil {
-	// 		log.Println("Redis SET error:", err)
-	// 		return
-	// 	}
-	// } else {
-	// 	log.Printf("Skip Connection Not allow Owner : %s\n", hostname)
-	// 	return
-	// }
-	// Check owner -- End
-
-	maxRetryStr := os.Getenv("KAFKA_RETRY")
-	intervalStr := os.Getenv("KAFKA_INTERVAL")
-	maxRetryInt, err_ := strconv.Atoi(maxRetryStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		maxRetryInt = 10 // default fallback
-	}
-	intervalInt, err_ := strconv.Atoi(intervalStr)
-	if err_ != nil {
-		fmt.Println("Invalid KAFKA_INTERVAL, using default of 10 seconds")
-		intervalInt = 10 // default fallback
-	}
-	var (
-		brokers       = []string{os.Getenv("ESB_SERVER")}
-		topic         = os.Getenv("ESB_WO_UPDATE")
-		maxRetry      = maxRetryInt
-		retryInterval = time.Duration(intervalInt) * time.Second
-	)
-
-	var consumer sarama.Consumer
-	var err error
-
-	for attempt := 1; attempt <= maxRetry; attempt++ {
-		log.Printf("Attempt %d to connect to "+topic+" brokers: %v", attempt, brokers)
-		consumer, err = sarama.NewConsumer(brokers, nil)
-		if err == nil {
-			break
-		}
-		log.Printf("Failed to connect to "+topic+": %v", err)
-		time.Sleep(retryInterval)
-	}
-
+	sink, release, err := EsbWorkOrderStore.OpenSink(c, orgId, username)
 	if err != nil {
-		return fmt.Errorf("could not connect to "+topic+" after %d attempts: %w", maxRetry, err)
-	}
-
-	defer func() {
-		if err := consumer.Close(); err != nil {
-			log.Printf("Error closing "+topic+" consumer: %v", err)
-		}
-	}()
-
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Printf("Error starting partition consumer: %v", err)
 		return err
 	}
-	defer func() {
-		if err := partitionConsumer.Close(); err != nil {
-			log.Printf("Error closing ESB_WORK_ORDER_UPDATE() consumer: %v", err)
-		}
-	}()
-
-	log.Println("Kafka ESB_WORK_ORDER_UPDATE() started. Listening for messages...")
+	defer release()
 
-	for msg := range partitionConsumer.Messages() {
-		log.Print("ESB_WORK_ORDER_UPDATE --> ", string(msg.Value))
-		go handleMessage_WO_Update(&gin.Context{}, msg.Value)
+	if err := newWorkOrderIngestor(sink).HandleCreate(wo); err != nil {
+		return fmt.Errorf("create case from WorkOrder: %w", err)
 	}
-
 	return nil
 }
 
-func handleMessage_WO_Update(c *gin.Context, message []byte) {
+func ESB_WORK_ORDER_UPDATE(ctx context.Context) error {
+	return ConsumeEsbTopic(ctx, "ESB_WORK_ORDER_UPDATE()", os.Getenv("ESB_WO_UPDATE"), func(msg *BusMessage) error {
+		log.Print("ESB_WORK_ORDER_UPDATE --> ", string(msg.Value))
+		return handleMessage_WO_Update(&gin.Context{}, msg.Value)
+	})
+}
+
+func handleMessage_WO_Update(c *gin.Context, message []byte) error {
 	source := os.Getenv("INTEGRATION_SOURCE")
 	log.Printf("Update :: Raw message: %s", string(message))
 
 	var wo model.WorkOrder
 	if err := json.Unmarshal(message, &wo); err != nil {
-		log.Printf("Error unmarshalling message: %v", err)
-		return
+		return fmt.Errorf("unmarshal work order: %w", err)
 	}
 
 	if wo.Source == source {
 		log.Printf("Skip Message From Original Source : %s", source)
-		return
+		return nil
 	}
-	conn, ctx, cancel := utils.ConnectDB()
-	if conn == nil {
-		log.Printf("DB connection is nil")
-		return
+	username := os.Getenv("INTEGRATION_USR")
+	orgId := os.Getenv("INTEGRATION_ORG_ID")
+
+	c.Set("username", username)
+	c.Set("orgId", orgId)
+
+	sink, release, err := EsbWorkOrderStore.OpenSink(c, orgId, username)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := newWorkOrderIngestor(sink).HandleUpdate(wo); err != nil {
+		return fmt.Errorf("update case from WorkOrder: %w", err)
 	}
-	defer cancel()
-	defer conn.Close(ctx)
+	return nil
+}
 
+// applyWorkOrderUpdate applies a work order update to an existing case.
+func applyWorkOrderUpdate(c *gin.Context, ctx context.Context, conn *pgx.Conn, wo model.WorkOrder, username, orgId string) error {
 	log.Print("==handleMessage_WO_Update==")
 	log.Print(wo)
 
@@ -258,19 +104,13 @@ func handleMessage_WO_Update(c *gin.Context, message []byte) {
 	// •	ONHOLD
 	// •	CANCEL
 
-	username := os.Getenv("INTEGRATION_USR")
-	orgId := os.Getenv("INTEGRATION_ORG_ID")
-
-	c.Set("username", username)
-	c.Set("orgId", orgId)
-
 	createBy := wo.CreatedBy
 	if wo.CreatedBy == "" {
 		createBy = username
 	}
 
 	if wo.Status == "NEW" {
-		return
+		return nil
 	}
 	if wo.Status == "CANCEL" {
 		log.Printf("WorkOrder %s cancelled, processing...", wo.WorkOrderNumber)
@@ -279,9 +119,9 @@ func handleMessage_WO_Update(c *gin.Context, message []byte) {
 
 		err := CancelCaseCore(c, conn, orgId, createBy, wo.WorkOrderNumber, resId, "cancel from workorder integration")
 		if err != nil {
-			log.Printf("cancel failed: %v", err)
+			return fmt.Errorf("cancel failed: %w", err)
 		}
-		return
+		return nil
 	}
 
 	if wo.Status == "ASSIGNED" {
@@ -291,7 +131,7 @@ func handleMessage_WO_Update(c *gin.Context, message []byte) {
 
 			unitLists, count, err := GetUnitsWithDispatch(ctx, conn, orgId, wo.WorkOrderNumber, "S003", "")
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("get dispatched units: %w", err)
 			}
 
 			log.Printf(" %s - Total Units: %d", wo.WorkOrderNumber, count)
@@ -358,9 +198,7 @@ func handleMessage_WO_Update(c *gin.Context, message []byte) {
 
 	log.Print("====1===")
 
-	if err := IntegrateUpdateCaseFromWorkOrder(c, conn, wo, username, orgId); err != nil {
-		log.Printf("Error creating case from WorkOrder: %v", err)
-	}
+	return IntegrateUpdateCaseFromWorkOrder(c, conn, wo, username, orgId)
 }
 
 func CancelCaseCore(ctx *gin.Context, conn *pgx.Conn, orgId, username, caseId, resId, resDetail string) error {
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// WorkOrderSink applies work orders to cases. FindCase matches on the work
+// order number first and falls back to the integration ref number.
+type WorkOrderSink interface {
+	Lock(key string) (unlock func(), err error)
+	FindCase(wo model.WorkOrder) (caseId string, found bool, err error)
+	Create(wo model.WorkOrder) error
+	Update(wo model.WorkOrder) error
+}
+
+// WorkOrderParking holds updates whose case does not exist yet.
+type WorkOrderParking interface {
+	Park(key string, wo model.WorkOrder) error
+	Drain(key string) ([]model.WorkOrder, error)
+}
+
+// WorkOrderIngestor applies ESB work orders idempotently:
+//   - a create for a work order that already has a case is a no-op;
+//   - an update for a work order without a case is parked;
+//   - parked updates are applied, in arrival order, right after the create.
+//
+// All steps for one work order number run under the sink lock so replicas
+// and redeliveries cannot interleave.
+type WorkOrderIngestor struct {
+	Sink    WorkOrderSink
+	Parking WorkOrderParking
+}
+
+func workOrderKey(wo model.WorkOrder) string {
+	return strings.TrimSpace(wo.WorkOrderNumber)
+}
+
+func (in *WorkOrderIngestor) HandleCreate(wo model.WorkOrder) error {
+	key := workOrderKey(wo)
+	if key == "" {
+		return fmt.Errorf("work order number is empty")
+	}
+	unlock, err := in.Sink.Lock(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	caseId, found, err := in.Sink.FindCase(wo)
+	if err != nil {
+		return err
+	}
+	if found {
+		log.Printf("Case Duplicate : work order %s already ingested as %s", key, caseId)
+	} else if err := in.Sink.Create(wo); err != nil {
+		return err
+	}
+	return in.applyParked(key)
+}
+
+func (in *WorkOrderIngestor) HandleUpdate(wo model.WorkOrder) error {
+	key := workOrderKey(wo)
+	if key == "" {
+		return fmt.Errorf("work order number is empty")
+	}
+	unlock, err := in.Sink.Lock(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	caseId, found, err := in.Sink.FindCase(wo)
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("Park update of work order %s until it is created", key)
+		return in.Parking.Park(key, wo)
+	}
+	wo.WorkOrderNumber = caseId
+	return in.Sink.Update(wo)
+}
+
+func (in *WorkOrderIngestor) applyParked(key string) error {
+	parked, err := in.Parking.Drain(key)
+	if err != nil {
+		return err
+	}
+	for i, wo := range parked {
+		caseId, found, err := in.Sink.FindCase(wo)
+		if err == nil && !found {
+			err = fmt.Errorf("case not found for parked update: %s", key)
+		}
+		if err == nil {
+			wo.WorkOrderNumber = caseId
+			err = in.Sink.Update(wo)
+		}
+		if err != nil {
+			// Put back what has not been applied so the next create or a
+			// replay can pick it up.
+			for _, rest := range parked[i:] {
+				if perr := in.Parking.Park(key, rest); perr != nil {
+					log.Printf("re-park work order %s: %v", key, perr)
+				}
+			}
+			return fmt.Errorf("apply parked update: %w", err)
+		}
+	}
+	return nil
+}
+
+// dbWorkOrderSink is the production sink backed by tix_cases.
+type dbWorkOrderSink struct {
+	c        *gin.Context
+	conn     *pgx.Conn
+	ctx      context.Context
+	orgId    string
+	username string
+}
+
+// Lock takes a transaction level advisory lock on a connection of its own.
+// The lock ends with that transaction, and the pool drops a connection that
+// comes back inside a transaction, so a failed unlock cannot leave the work
+// order locked.
+func (s *dbWorkOrderSink) Lock(key string) (func(), error) {
+	lockKey := "wo:" + s.orgId + ":" + key
+	lockConn, ctx, release := utils.ConnectDB()
+	if lockConn == nil {
+		return nil, fmt.Errorf("lock work order %s: DB connection is nil", key)
+	}
+	tx, err := lockConn.Begin(ctx)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("lock work order %s: %w", key, err)
+	}
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, lockKey); err != nil {
+		_ = tx.Rollback(context.Background())
+		release()
+		return nil, fmt.Errorf("lock work order %s: %w", key, err)
+	}
+	return func() {
+		if err := tx.Rollback(context.Background()); err != nil {
+			log.Printf("unlock work order %s: %v", key, err)
+		}
+		release()
+	}, nil
+}
+
+func (s *dbWorkOrderSink) FindCase(wo model.WorkOrder) (string, bool, error) {
+	query := `
+	SELECT "caseId"
+	FROM public."tix_cases"
+	WHERE "orgId" = $1
+	  AND ("caseId" = $2 OR ($3 <> '' AND "integration_ref_number" = $3))
+	ORDER BY ("caseId" = $2) DESC
+	LIMIT 1`
+	var caseId string
+	err := s.conn.QueryRow(s.ctx, query, s.orgId, wo.WorkOrderNumber, strings.TrimSpace(wo.WorkOrderRefNumber)).Scan(&caseId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return caseId, true, nil
+}
+
+func (s *dbWorkOrderSink) Create(wo model.WorkOrder) error {
+	return IntegrateCreateCaseFromWorkOrder(s.c, s.conn, wo, s.username, s.orgId)
+}
+
+func (s *dbWorkOrderSink) Update(wo model.WorkOrder) error {
+	return applyWorkOrderUpdate(s.c, s.ctx, s.conn, wo, s.username, s.orgId)
+}
+
+// redisWorkOrderParking parks updates in Redis so they survive a restart and
+// are visible to every replica.
+type redisWorkOrderParking struct{}
+
+func (redisWorkOrderParking) Park(key string, wo model.WorkOrder) error {
+	b, err := json.Marshal(wo)
+	if err != nil {
+		return err
+	}
+	return utils.EsbPendingPush(key, string(b))
+}
+
+func (redisWorkOrderParking) Drain(key string) ([]model.WorkOrder, error) {
+	values, err := utils.EsbPendingDrain(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]model.WorkOrder, 0, len(values))
+	for _, v := range values {
+		var wo model.WorkOrder
+		if err := json.Unmarshal([]byte(v), &wo); err != nil {
+			log.Printf("drop unreadable parked work order %s: %v", key, err)
+			continue
+		}
+		result = append(result, wo)
+	}
+	return result, nil
+}
+
+// MemoryWorkOrderParking keeps parked updates in memory. It backs the
+// ingestor when it is driven by a fake message source.
+type MemoryWorkOrderParking struct {
+	mu     sync.Mutex
+	parked map[string][]model.WorkOrder
+}
+
+func (p *MemoryWorkOrderParking) Park(key string, wo model.WorkOrder) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.parked == nil {
+		p.parked = map[string][]model.WorkOrder{}
+	}
+	p.parked[key] = append(p.parked[key], wo)
+	return nil
+}
+
+func (p *MemoryWorkOrderParking) Drain(key string) ([]model.WorkOrder, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := p.parked[key]
+	delete(p.parked, key)
+	return result, nil
+}
+
+func newWorkOrderIngestor(sink WorkOrderSink) *WorkOrderIngestor {
+	return &WorkOrderIngestor{Sink: sink, Parking: EsbWorkOrderParking}
+}
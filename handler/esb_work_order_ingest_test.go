@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mainPackage/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWorkOrderSink keeps cases in memory, keyed by work order number.
+type fakeWorkOrderSink struct {
+	mu        sync.Mutex
+	locks     map[string]*sync.Mutex
+	cases     map[string][]string // work order number -> statuses applied
+	creates   int
+	failAfter int // Update fails once this many updates succeeded, when > 0
+	updates   int
+}
+
+func newFakeWorkOrderSink() *fakeWorkOrderSink {
+	return &fakeWorkOrderSink{locks: map[string]*sync.Mutex{}, cases: map[string][]string{}}
+}
+
+func (s *fakeWorkOrderSink) Lock(key string) (func(), error) {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[key] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock, nil
+}
+
+func (s *fakeWorkOrderSink) FindCase(wo model.WorkOrder) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.cases[wo.WorkOrderNumber]
+	return wo.WorkOrderNumber, ok, nil
+}
+
+func (s *fakeWorkOrderSink) Create(wo model.WorkOrder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Give concurrent creates a chance to interleave if the lock did not
+	// keep them apart.
+	time.Sleep(time.Millisecond)
+	s.creates++
+	s.cases[wo.WorkOrderNumber] = []string{}
+	return nil
+}
+
+func (s *fakeWorkOrderSink) Update(wo model.WorkOrder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failAfter > 0 && s.updates >= s.failAfter {
+		return errors.New("update failed")
+	}
+	s.updates++
+	s.cases[wo.WorkOrderNumber] = append(s.cases[wo.WorkOrderNumber], wo.Status)
+	return nil
+}
+
+func (s *fakeWorkOrderSink) statuses(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cases[key]...)
+}
+
+func workOrder(number string, status string) model.WorkOrder {
+	return model.WorkOrder{WorkOrderNumber: number, Status: status}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWorkOrderIngestorOrdering(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []func(in *WorkOrderIngestor) error
+		want    []string
+		creates int
+	}{
+		{
+			name: "create then updates",
+			steps: []func(in *WorkOrderIngestor) error{
+				func(in *WorkOrderIngestor) error { return in.HandleCreate(workOrder("WO-1", "NEW")) },
+				func(in *WorkOrderIngestor) error { return in.HandleUpdate(workOrder("WO-1", "ASSIGNED")) },
+				func(in *WorkOrderIngestor) error { return in.HandleUpdate(workOrder("WO-1", "DONE")) },
+			},
+			want:    []string{"ASSIGNED", "DONE"},
+			creates: 1,
+		},
+		{
+			name: "updates before create are applied in arrival order",
+			steps: []func(in *WorkOrderIngestor) error{
+				func(in *WorkOrderIngestor) error { return in.HandleUpdate(workOrder("WO-1", "ASSIGNED")) },
+				func(in *WorkOrderIngestor) error { return in.HandleUpdate(workOrder("WO-1", "DONE")) },
+				func(in *WorkOrderIngestor) error { return in.HandleCreate(workOrder("WO-1", "NEW")) },
+			},
+			want:    []string{"ASSIGNED", "DONE"},
+			creates: 1,
+		},
+		{
+			name: "redelivered create is a no-op",
+			steps: []func(in *WorkOrderIngestor) error{
+				func(in *WorkOrderIngestor) error { return in.HandleCreate(workOrder("WO-1", "NEW")) },
+				func(in *WorkOrderIngestor) error { return in.HandleUpdate(workOrder("WO-1", "ASSIGNED")) },
+				func(in *WorkOrderIngestor) error { return in.HandleCreate(workOrder("WO-1", "NEW")) },
+			},
+			want:    []string{"ASSIGNED"},
+			creates: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newFakeWorkOrderSink()
+			in := &WorkOrderIngestor{Sink: sink, Parking: &MemoryWorkOrderParking{}}
+			for i, step := range tt.steps {
+				if err := step(in); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+			}
+			if got := sink.statuses("WO-1"); !equalStrings(got, tt.want) {
+				t.Errorf("statuses = %v, want %v", got, tt.want)
+			}
+			if sink.creates != tt.creates {
+				t.Errorf("creates = %d, want %d", sink.creates, tt.creates)
+			}
+		})
+	}
+}
+
+func TestWorkOrderIngestorEmptyNumber(t *testing.T) {
+	in := &WorkOrderIngestor{Sink: newFakeWorkOrderSink(), Parking: &MemoryWorkOrderParking{}}
+	if err := in.HandleCreate(workOrder(" ", "NEW")); err == nil {
+		t.Error("create without work order number accepted")
+	}
+	if err := in.HandleUpdate(workOrder("", "DONE")); err == nil {
+		t.Error("update without work order number accepted")
+	}
+}
+
+func TestWorkOrderIngestorReparksUnappliedUpdates(t *testing.T) {
+	sink := newFakeWorkOrderSink()
+	parking := &MemoryWorkOrderParking{}
+	in := &WorkOrderIngestor{Sink: sink, Parking: parking}
+
+	for _, status := range []string{"ASSIGNED", "ONSITE", "DONE"} {
+		if err := in.HandleUpdate(workOrder("WO-1", status)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.failAfter = 1
+	if err := in.HandleCreate(workOrder("WO-1", "NEW")); err == nil {
+		t.Fatal("create succeeded although a parked update failed")
+	}
+	if got := sink.statuses("WO-1"); !equalStrings(got, []string{"ASSIGNED"}) {
+		t.Fatalf("statuses = %v, want [ASSIGNED]", got)
+	}
+
+	// The redelivered create applies what was put back, in order.
+	sink.failAfter = 0
+	if err := in.HandleCreate(workOrder("WO-1", "NEW")); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.statuses("WO-1"); !equalStrings(got, []string{"ASSIGNED", "ONSITE", "DONE"}) {
+		t.Errorf("statuses = %v, want [ASSIGNED ONSITE DONE]", got)
+	}
+	if sink.creates != 1 {
+		t.Errorf("creates = %d, want 1", sink.creates)
+	}
+}
+
+func TestWorkOrderIngestorConcurrentCreates(t *testing.T) {
+	sink := newFakeWorkOrderSink()
+	in := &WorkOrderIngestor{Sink: sink, Parking: &MemoryWorkOrderParking{}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := in.HandleCreate(workOrder("WO-1", "NEW")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sink.creates != 1 {
+		t.Errorf("creates = %d, want 1", sink.creates)
+	}
+}
+
+// TestWorkOrderIngestorFromBus feeds the ingestor from a MemoryBus the way
+// the ESB consumers do, with the update published before the create.
+func TestWorkOrderIngestorFromBus(t *testing.T) {
+	bus := NewMemoryBus()
+	defer bus.Close()
+	sink := newFakeWorkOrderSink()
+	in := &WorkOrderIngestor{Sink: sink, Parking: &MemoryWorkOrderParking{}}
+
+	publish := func(topic string, wo model.WorkOrder) {
+		b, _ := json.Marshal(wo)
+		if err := bus.Publish(context.Background(), topic, []byte(wo.WorkOrderNumber), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("wo.update", workOrder("WO-1", "ASSIGNED"))
+	publish("wo.update", workOrder("WO-1", "DONE"))
+	publish("wo.create", workOrder("WO-1", "NEW"))
+	publish("wo.create", workOrder("WO-1", "NEW"))
+
+	// Drain the update topic before the create topic so the updates are
+	// certainly early.
+	consume := func(topic string, handle func(model.WorkOrder) error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handled := make(chan struct{}, 16)
+		go func() {
+			_ = bus.Subscribe(ctx, topic, topic, func(msg *BusMessage) error {
+				defer func() { handled <- struct{}{} }()
+				var wo model.WorkOrder
+				if err := json.Unmarshal(msg.Value, &wo); err != nil {
+					return err
+				}
+				return handle(wo)
+			})
+		}()
+		for range bus.Messages(topic) {
+			select {
+			case <-handled:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: timed out waiting for messages", topic)
+			}
+		}
+	}
+	consume("wo.update", in.HandleUpdate)
+	consume("wo.create", in.HandleCreate)
+
+	for _, topic := range []string{"wo.update", "wo.create"} {
+		if failed := bus.Failed(topic); len(failed) > 0 {
+			t.Errorf("%s: %d messages failed", topic, len(failed))
+		}
+	}
+	if got := sink.statuses("WO-1"); !equalStrings(got, []string{"ASSIGNED", "DONE"}) {
+		t.Errorf("statuses = %v, want [ASSIGNED DONE]", got)
+	}
+	if sink.creates != 1 {
+		t.Errorf("creates = %d, want 1", sink.creates)
+	}
+	if msgs := bus.Messages("wo.create"); len(msgs) != 2 || msgs[0].Source == "" || msgs[0].Source != msgs[1].Source {
+		t.Errorf("messages of one bus should share a source: %+v", msgs)
+	}
+}
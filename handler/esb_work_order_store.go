@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// WorkOrderStore opens the sink the ESB work order consumers apply inbound
+// work orders to. EsbWorkOrderStore is backed by Postgres; tests replace it,
+// with EsbWorkOrderParking and EsbBus, to run the flow without a database or
+// broker.
+type WorkOrderStore interface {
+	OpenSink(c *gin.Context, orgId string, username string) (sink WorkOrderSink, release func(), err error)
+}
+
+// WorkOrderReader reads what an outbound work order update is built from.
+type WorkOrderReader interface {
+	GetCase(ctx context.Context, orgId string, caseId string) (*model.Case, error)
+	GetArea(ctx context.Context, orgId string, distId string) (*model.AreaDistrict, error)
+	GetUser(ctx context.Context, orgId string, username string) (*model.User, error)
+	GetCaseSubType(ctx context.Context, orgId string, sTypeId string) (*model.CaseSubType, error)
+	GetAttachments(ctx context.Context, orgId string, caseId string) ([]string, error)
+	GetAssignedUnits(ctx context.Context, orgId string, caseId string) ([]model.UnitDispatch, error)
+}
+
+var (
+	EsbWorkOrderStore   WorkOrderStore   = pgWorkOrderStore{}
+	EsbWorkOrderParking WorkOrderParking = redisWorkOrderParking{}
+)
+
+type pgWorkOrderStore struct{}
+
+func (pgWorkOrderStore) OpenSink(c *gin.Context, orgId string, username string) (WorkOrderSink, func(), error) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return nil, nil, fmt.Errorf("DB connection is nil")
+	}
+	return &dbWorkOrderSink{c: c, conn: conn, ctx: ctx, orgId: orgId, username: username}, cancel, nil
+}
+
+// pgWorkOrderReader reads through a connection the caller holds.
+type pgWorkOrderReader struct {
+	conn *pgx.Conn
+}
+
+func (r pgWorkOrderReader) GetCase(ctx context.Context, orgId string, caseId string) (*model.Case, error) {
+	return GetCaseByID(ctx, r.conn, orgId, caseId)
+}
+
+func (r pgWorkOrderReader) GetArea(ctx context.Context, orgId string, distId string) (*model.AreaDistrict, error) {
+	return utils.GetAreaById(ctx, r.conn, orgId, distId)
+}
+
+func (r pgWorkOrderReader) GetUser(ctx context.Context, orgId string, username string) (*model.User, error) {
+	return utils.GetUserByUsername(ctx, r.conn, orgId, username)
+}
+
+func (r pgWorkOrderReader) GetCaseSubType(ctx context.Context, orgId string, sTypeId string) (*model.CaseSubType, error) {
+	return utils.GetCaseSubTypeByCode(ctx, r.conn, orgId, sTypeId)
+}
+
+func (r pgWorkOrderReader) GetAttachments(ctx context.Context, orgId string, caseId string) ([]string, error) {
+	return GetCaseAttachments_(ctx, r.conn, orgId, caseId)
+}
+
+func (r pgWorkOrderReader) GetAssignedUnits(ctx context.Context, orgId string, caseId string) ([]model.UnitDispatch, error) {
+	units, _, err := GetUnits(ctx, r.conn, orgId, caseId, os.Getenv("ASSIGNED"), "")
+	return units, err
+}
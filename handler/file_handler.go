@@ -218,7 +218,6 @@ func UploadFile(c *gin.Context) {
 			return
 		}
 		defer cancel()
-		defer conn.Close(ctx)
 
 		query := `
 			INSERT INTO tix_case_attachments 
@@ -375,7 +374,6 @@ func DeleteFile(c *gin.Context) {
 			return
 		}
 		defer cancel()
-		defer conn.Close(ctx)
 
 		query := `
 			DELETE FROM tix_case_attachments
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"mainPackage/model"
+	"mainPackage/repository"
 	"mainPackage/utils"
 	"math"
 	"net/http"
@@ -43,7 +44,6 @@ func GetFormById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	if orgId == "" {
 		response := model.Response{
@@ -79,25 +79,7 @@ func GetFormById(c *gin.Context) {
 		return
 	}
 
-	query := `SELECT 
-		fb."formId", 
-		fb."formName", 
-		fb."active", 
-		fb."publish", 
-		fb."locks",
-		fe."eleData", 
-		fe."createdBy", 
-		fe."createdAt", 
-		fe."updatedAt", 
-		fe."updatedBy"
-	FROM public.form_builder AS fb 
-	INNER JOIN public.form_elements AS fe ON fb."formId" = fe."formId" 
-	WHERE fb."formId" = $1 AND fb."orgId" = $2 AND fe."versions" = $3`
-
-	logger.Debug("Query", zap.String("query", query))
-	logger.Debug("Parameters", zap.String("formId", formId), zap.String("orgId", orgId.(string)), zap.String("version", version))
-
-	rows, err := conn.Query(ctx, query, formId, orgId, version)
+	form, err := loadForm(ctx, conn, orgId.(string), formId, version)
 	if err != nil {
 		logger.Warn("Query failed", zap.Error(err))
 		response := model.Response{
@@ -115,69 +97,7 @@ func GetFormById(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, response)
 		return
 	}
-	defer rows.Close()
-
-	var form model.FormsManager
-	var rawJSON []byte
-	found := false
-
-	if rows.Next() {
-		err = rows.Scan(
-			&form.FormId,
-			&form.FormName,
-			&form.Active,
-			&form.Publish,
-			&form.Locks,
-			&rawJSON,
-			&form.CreatedBy,
-			&form.CreatedAt,
-			&form.UpdatedAt,
-			&form.UpdatedBy,
-		)
-		if err != nil {
-			logger.Warn("Scan failed", zap.Error(err))
-			response := model.Response{
-				Status: "-1",
-				Msg:    "Failed to scan data",
-				Desc:   err.Error(),
-			}
-			//=======AUDIT_START=====//
-			_ = utils.InsertAuditLogs(
-				c, conn, orgId.(string), username.(string),
-				txtId, formId, "Form", "GetFormById", "",
-				"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
-			)
-			//=======AUDIT_END=====//
-			c.JSON(http.StatusInternalServerError, response)
-			return
-		}
-
-		var eleData model.Form
-		if err := json.Unmarshal(rawJSON, &eleData); err != nil {
-			logger.Warn("Unmarshal eleData failed", zap.Error(err))
-			response := model.Response{
-				Status: "-1",
-				Msg:    "Failed to parse form data",
-				Desc:   err.Error(),
-			}
-			//=======AUDIT_START=====//
-			_ = utils.InsertAuditLogs(
-				c, conn, orgId.(string), username.(string),
-				txtId, formId, "Form", "GetFormById", "",
-				"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
-			)
-			//=======AUDIT_END=====//
-			c.JSON(http.StatusInternalServerError, response)
-			return
-		}
-
-		form.FormFieldJson = eleData.FormFieldJson
-		form.FormColSpan = eleData.FormColSpan
-		form.Versions = version
-		found = true
-	}
-
-	if !found {
+	if form == nil {
 		response := model.Response{
 			Status: "-1",
 			Msg:    "No data found",
@@ -211,6 +131,59 @@ func GetFormById(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// loadForm returns one version of a form, or nil when the form has no such
+// version.
+func loadForm(ctx context.Context, db repository.DBTX, orgId string, formId string, version string) (*model.FormsManager, error) {
+	logger := utils.GetLog()
+	query := `SELECT 
+		fb."formId", 
+		fb."formName", 
+		fb."active", 
+		fb."publish", 
+		fb."locks",
+		fe."eleData", 
+		fe."createdBy", 
+		fe."createdAt", 
+		fe."updatedAt", 
+		fe."updatedBy"
+	FROM public.form_builder AS fb 
+	INNER JOIN public.form_elements AS fe ON fb."formId" = fe."formId" 
+	WHERE fb."formId" = $1 AND fb."orgId" = $2 AND fe."versions" = $3`
+
+	logger.Debug("Query", zap.String("query", query))
+	logger.Debug("Parameters", zap.String("formId", formId), zap.String("orgId", orgId), zap.String("version", version))
+
+	var form model.FormsManager
+	var rawJSON []byte
+	err := db.QueryRow(ctx, query, formId, orgId, version).Scan(
+		&form.FormId,
+		&form.FormName,
+		&form.Active,
+		&form.Publish,
+		&form.Locks,
+		&rawJSON,
+		&form.CreatedBy,
+		&form.CreatedAt,
+		&form.UpdatedAt,
+		&form.UpdatedBy,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var eleData model.Form
+	if err := json.Unmarshal(rawJSON, &eleData); err != nil {
+		return nil, fmt.Errorf("parse form data: %w", err)
+	}
+	form.FormFieldJson = eleData.FormFieldJson
+	form.FormColSpan = eleData.FormColSpan
+	form.Versions = version
+	return &form, nil
+}
+
 // @summary Get Form
 // @tags Form and Workflow
 // @security ApiKeyAuth
@@ -238,7 +211,6 @@ func GetForm(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	if orgId == "" {
 		response := model.Response{
@@ -400,7 +372,6 @@ func GetFormlinkWf(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -507,7 +478,6 @@ func GetAllForm(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -721,7 +691,6 @@ func GetAllFormlinkWf(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -1130,7 +1099,6 @@ func FormInsert(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1453,7 +1421,6 @@ func FormUpdate(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	uuid := c.Param("uuid")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1538,6 +1505,7 @@ func FormUpdate(c *gin.Context) {
 	if string(oldFormJson) != string(newFormJson) || req.FormColSpan != oldForm.FormColSpan {
 		isUpdate = true
 	}
+	assignFieldUIDs(oldForm.FormFieldJson, req.FormFieldJson)
 
 	// Update main form
 	query := `
@@ -1778,7 +1746,6 @@ func FormPublish(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	var req model.FormPublish
 	id := c.Param("id")
 	start_time := time.Now()
@@ -1943,7 +1910,6 @@ func FormChangeVersion(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.FormChangeVersion
 	id := c.Param("id")
@@ -2054,7 +2020,6 @@ func FormLock(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	var req model.FormLock
 	id := c.Param("id")
 	start_time := time.Now()
@@ -2142,7 +2107,6 @@ func FormActive(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	var req model.FormActive
 	id := c.Param("id")
 	start_time := time.Now()
@@ -2233,7 +2197,6 @@ func DeleteForm(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	formId := c.Param("formId")
 	startTime := time.Now()
@@ -2353,19 +2316,8 @@ func GetWorkFlow(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
-	// query := `SELECT "section","data",title,"desc",wf_definitions."versions",wf_definitions."createdAt",wf_definitions."updatedAt",wf_definitions."totalSla"
-	// FROM public.wf_definitions Inner join public.wf_nodes
-	// ON wf_definitions."wfId" = wf_nodes."wfId" WHERE wf_definitions."wfId" = $1 AND wf_nodes."orgId"=$2`
-	query := `SELECT "section","data",title,"desc",wf_definitions."versions",wf_definitions."createdAt",wf_definitions."updatedAt"
-	FROM public.wf_definitions Inner join public.wf_nodes
-	ON wf_definitions."wfId" = wf_nodes."wfId" WHERE wf_definitions."wfId" = $1 AND wf_nodes."orgId"=$2`
-
-	var rows pgx.Rows
-	logger.Debug(`Query`, zap.String("query", query))
-	logger.Debug(`id :` + id)
-	rows, err := conn.Query(ctx, query, id, orgId)
+	workflow, err := loadWorkflow(ctx, conn, orgId.(string), id)
 	if err != nil {
 		logger.Warn("Query failed", zap.Error(err))
 		response := model.Response{
@@ -2383,15 +2335,45 @@ func GetWorkFlow(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, response)
 		return
 	}
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   workflow,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "WorkFlow", "GetWorkFlow", "",
+		"search", 0, start_time, GetQueryParams(c), response, "GetWorkFlow Success",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// loadWorkflow returns the nodes and connections of the current version of a
+// workflow. Rows that cannot be read are logged and left out.
+func loadWorkflow(ctx context.Context, db repository.DBTX, orgId string, wfId string) (model.WorkFlow, error) {
+	logger := utils.GetLog()
+	// query := `SELECT "section","data",title,"desc",wf_definitions."versions",wf_definitions."createdAt",wf_definitions."updatedAt",wf_definitions."totalSla"
+	// FROM public.wf_definitions Inner join public.wf_nodes
+	// ON wf_definitions."wfId" = wf_nodes."wfId" WHERE wf_definitions."wfId" = $1 AND wf_nodes."orgId"=$2`
+	query := `SELECT "section","data",title,"desc",wf_definitions."versions",wf_definitions."createdAt",wf_definitions."updatedAt"
+	FROM public.wf_definitions Inner join public.wf_nodes
+	ON wf_definitions."wfId" = wf_nodes."wfId" AND wf_definitions."versions" = wf_nodes."versions" WHERE wf_definitions."wfId" = $1 AND wf_nodes."orgId"=$2`
+
+	logger.Debug(`Query`, zap.String("query", query))
+	logger.Debug(`id :` + wfId)
+	var workflow model.WorkFlow
+	rows, err := db.Query(ctx, query, wfId, orgId)
+	if err != nil {
+		return workflow, err
+	}
 	defer rows.Close()
-	var errorMsg string
 	var NodesArray []map[string]interface{}
 	var ConnectionArray []map[string]interface{}
-	var workflow model.WorkFlow
 	var workflowMetaData model.WorkFlowMetadata
-	rowIndex := 0
 	for rows.Next() {
-		rowIndex++
 		var rawJSON []byte
 		var rowsType string
 		// err := rows.Scan(&rowsType, &rawJSON, &workflowMetaData.Title, &workflowMetaData.Desc,
@@ -2399,21 +2381,7 @@ func GetWorkFlow(c *gin.Context) {
 		err := rows.Scan(&rowsType, &rawJSON, &workflowMetaData.Title, &workflowMetaData.Desc,
 			&workflowMetaData.Status, &workflowMetaData.CreatedAt, &workflowMetaData.UpdatedAt)
 		if err != nil {
-			logger.Warn("Scan failed", zap.Error(err))
-			response := model.Response{
-				Status: "-1",
-				Msg:    "Failed",
-				Data:   workflow,
-				Desc:   errorMsg,
-			}
-			//=======AUDIT_START=====//
-			_ = utils.InsertAuditLogs(
-				c, conn, orgId.(string), username.(string),
-				txtId, id, "WorkFlow", "GetWorkFlow", "",
-				"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
-			)
-			//=======AUDIT_END=====//
-			c.JSON(http.StatusInternalServerError, response)
+			return workflow, err
 		}
 
 		switch rowsType {
@@ -2439,37 +2407,7 @@ func GetWorkFlow(c *gin.Context) {
 		workflow.Connections = ConnectionArray
 		workflow.MetaData = workflowMetaData
 	}
-	if errorMsg != "" {
-		response := model.Response{
-			Status: "-1",
-			Msg:    "Failed",
-			Data:   workflow,
-			Desc:   errorMsg,
-		}
-		//=======AUDIT_START=====//
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId.(string), username.(string),
-			txtId, id, "WorkFlow", "GetWorkFlow", "",
-			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+errorMsg,
-		)
-		//=======AUDIT_END=====//
-		c.JSON(http.StatusInternalServerError, response)
-	} else {
-		response := model.Response{
-			Status: "0",
-			Msg:    "Success",
-			Data:   workflow,
-			Desc:   "",
-		}
-		//=======AUDIT_START=====//
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId.(string), username.(string),
-			txtId, id, "WorkFlow", "GetWorkFlow", "",
-			"search", 0, start_time, GetQueryParams(c), response, "GetWorkFlow Success",
-		)
-		//=======AUDIT_END=====//
-		c.JSON(http.StatusOK, response)
-	}
+	return workflow, rows.Err()
 }
 
 // @summary Get Workflow List
@@ -2490,7 +2428,6 @@ func GetWorkFlowList(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -2606,7 +2543,6 @@ func GetWorkFlowListOld(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -2625,7 +2561,7 @@ func GetWorkFlowListOld(c *gin.Context) {
 	query := `SELECT t1."id",t1."wfId","section","data",title,"desc",t1."versions",t1."createdAt",t1."updatedAt" 
 	FROM public.wf_definitions t1
 	Inner join public.wf_nodes t2
-	ON t1."wfId" = t2."wfId" WHERE t2."orgId"=$1 LIMIT $2 OFFSET $3`
+	ON t1."wfId" = t2."wfId" AND t1."versions" = t2."versions" WHERE t2."orgId"=$1 LIMIT $2 OFFSET $3`
 
 	var rows pgx.Rows
 	logger.Debug(`Query`, zap.String("query", query),
@@ -2779,7 +2715,6 @@ func WorkFlowInsert(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	var req model.WorkFlowInsert
 	id := c.Param("id")
 	start_time := time.Now()
@@ -2803,6 +2738,9 @@ func WorkFlowInsert(c *gin.Context) {
 		logger.Warn("Insert failed", zap.Error(err))
 		return
 	}
+	if rejectInvalidWorkflow(c, ctx, conn, req, orgId.(string), username.(string), txtId, id, "WorkFlowInsert", "create", start_time) {
+		return
+	}
 	uuid := uuid.New()
 	now := time.Now()
 	// query := `INSERT INTO public.wf_definitions(
@@ -2919,9 +2857,15 @@ func WorkFlowInsert(c *gin.Context) {
 		return
 	}
 
+	version, err := SnapshotWorkflowVersion(ctx, conn, orgId.(string), uuid.String(), username.(string))
+	if err != nil {
+		logger.Warn("Workflow snapshot failed", zap.Error(err))
+	}
+
 	response := model.Response{
 		Status: "0",
 		Msg:    "Success",
+		Data:   model.WorkflowVersion{WfID: uuid.String(), Versions: version},
 		Desc:   "Update successfully",
 	}
 
@@ -2955,7 +2899,6 @@ func WorkFlowUpdate(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	var req model.WorkFlowInsert
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, model.Response{
@@ -2972,6 +2915,9 @@ func WorkFlowUpdate(c *gin.Context) {
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
 	now := time.Now()
+	if rejectInvalidWorkflow(c, ctx, conn, req, orgId.(string), username.(string), uuid, id, "WorkFlowUpdate", "update", start_time) {
+		return
+	}
 
 	// query := `UPDATE public.wf_definitions
 	// SET title=$3, "desc"=$4, active=$5, publish=$6, locks=$7, versions=$8, "updatedAt"=$9,"updatedBy"=$10,"totalSla"=$11
@@ -3007,7 +2953,7 @@ func WorkFlowUpdate(c *gin.Context) {
 
 	query = `
 	DELETE FROM public.wf_nodes
-	WHERE "wfId"=$1;
+	WHERE "wfId"=$1 AND "versions"='draft';
 	`
 	_, err = conn.Exec(ctx, query, uuid)
 
@@ -3148,9 +3094,15 @@ func WorkFlowUpdate(c *gin.Context) {
 		return
 	}
 
+	version, err := SnapshotWorkflowVersion(ctx, conn, orgId.(string), uuid, username.(string))
+	if err != nil {
+		logger.Warn("Workflow snapshot failed", zap.Error(err))
+	}
+
 	response := model.Response{
 		Status: "0",
 		Msg:    "Success",
+		Data:   model.WorkflowVersion{WfID: uuid, Versions: version},
 		Desc:   "Update successfully",
 	}
 	//=======AUDIT_START=====//
@@ -3180,7 +3132,6 @@ func WorkflowDelete(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("uuid")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -3261,7 +3212,6 @@ func GetFormByCaseSubType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -3745,6 +3695,9 @@ WHERE t1."wfId" = $1
 // }
 
 func InsertFormAnswer(conn *pgx.Conn, ctx context.Context, orgId string, caseId string, fa model.FormAnswerRequest, user string) error {
+	if err := validateFormAnswer(ctx, conn, orgId, fa); err != nil {
+		return err
+	}
 
 	form := model.Form{
 		FormName:      &fa.FormName,
@@ -3778,6 +3731,7 @@ func InsertFormAnswer(conn *pgx.Conn, ctx context.Context, orgId string, caseId
 		return err
 	}
 
+	syncFormAnswerProjection(ctx, conn, orgId, caseId, fa)
 	return nil
 }
 
@@ -3827,6 +3781,9 @@ func UpdateFormAnswer(conn *pgx.Conn, ctx context.Context, orgId string, caseId
 	if len(oldform.FormFieldJson) != len(fa.FormFieldJson) {
 		return errors.New("form not match")
 	}
+	if err := validateFormAnswer(ctx, conn, orgId, fa); err != nil {
+		return err
+	}
 
 	query := `
 		UPDATE form_answers
@@ -3848,6 +3805,7 @@ func UpdateFormAnswer(conn *pgx.Conn, ctx context.Context, orgId string, caseId
 		return err
 	}
 
+	syncFormAnswerProjection(ctx, conn, orgId, caseId, fa)
 	return nil
 }
 
@@ -3917,6 +3875,7 @@ func InsertFormElement(conn *pgx.Conn, ctx context.Context, req model.Form, orgI
 		FormFieldJson: req.FormFieldJson,
 		FormId:        &formId,
 	}
+	assignFieldUIDs(nil, form.FormFieldJson)
 
 	eleData, err := json.Marshal(form)
 	if err != nil {
@@ -0,0 +1,480 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"mainPackage/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Types of the values kept in form_answer_values.
+const (
+	answerValueNumber = "number"
+	answerValueDate   = "date"
+	answerValueText   = "text"
+	answerValueOption = "option"
+)
+
+// formAnswerValues flattens answered fields into typed values. Fields shown
+// by a selected option are included with the id of their parent.
+func formAnswerValues(fields []model.IndividualFormField) []model.FormAnswerValue {
+	values := []model.FormAnswerValue{}
+	var walk func(f formField, fieldType string, parent string)
+	walk = func(f formField, fieldType string, parent string) {
+		values = append(values, typedAnswerValues(f, fieldType, parent)...)
+		for _, opt := range formFieldOptions(f.options) {
+			if !optionSelected(f.value, opt.Value) {
+				continue
+			}
+			for _, child := range opt.Form {
+				walk(formChildOf(child), child.Type, f.id)
+			}
+		}
+	}
+	for _, f := range fields {
+		walk(formFieldOf(f), f.Type, "")
+	}
+	return values
+}
+
+// typedAnswerValues types the value of one field. Values of fields with
+// options are options; other values are typed by the field type and by
+// their JSON type. Files and other objects are left out.
+func typedAnswerValues(f formField, fieldType string, parent string) []model.FormAnswerValue {
+	if f.id == "" {
+		return nil
+	}
+	items, ok := f.value.([]interface{})
+	if !ok {
+		items = []interface{}{f.value}
+	}
+	kind := strings.ToLower(fieldType)
+	values := []model.FormAnswerValue{}
+	for _, item := range items {
+		v := model.FormAnswerValue{FieldId: f.id, FieldUid: f.uid, ParentId: parent, Label: f.label, FieldType: fieldType}
+		switch x := item.(type) {
+		case float64:
+			v.TextValue = formatFormNumber(x)
+			v.ValueType = answerValueNumber
+			v.NumValue = &x
+		case bool:
+			v.TextValue = strconv.FormatBool(x)
+			v.ValueType = answerValueText
+		case string:
+			x = strings.TrimSpace(x)
+			if x == "" {
+				continue
+			}
+			v.TextValue = x
+			v.ValueType = answerValueText
+			if strings.Contains(kind, "date") || strings.Contains(kind, "time") {
+				if t, _, err := parseFormDate(x); err == nil {
+					v.ValueType = answerValueDate
+					v.DateValue = &t
+				}
+			} else if strings.Contains(kind, "number") || strings.Contains(kind, "numeric") {
+				if n, err := strconv.ParseFloat(x, 64); err == nil {
+					v.ValueType = answerValueNumber
+					v.NumValue = &n
+				}
+			}
+		default:
+			continue
+		}
+		if len(f.options) > 0 {
+			v.ValueType = answerValueOption
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// syncFormAnswerValues replaces the typed values of a form answer of a case
+// in a single statement, so the values of an answer are swapped at once or
+// not at all. Fields answered without a uid take the uid of the field in the
+// answered version of the form, so values line up across versions.
+func syncFormAnswerValues(ctx context.Context, db repository.DBTX, orgId string, caseId string, formId string, versions string, fields []model.IndividualFormField) error {
+	var uids map[string]string
+	if versions != "" {
+		form, err := loadForm(ctx, db, orgId, formId, versions)
+		if err != nil {
+			return err
+		}
+		if form != nil {
+			uids = map[string]string{}
+			for _, f := range form.FormFieldJson {
+				if f.UID != nil {
+					uids[f.ID] = *f.UID
+				}
+			}
+		}
+	}
+	params := []interface{}{orgId, caseId, formId}
+	var rows []string
+	now := time.Now()
+	for _, v := range formAnswerValues(fields) {
+		if v.FieldUid == "" && v.ParentId == "" {
+			v.FieldUid = uids[v.FieldId]
+		}
+		n := len(params)
+		params = append(params, versions, v.FieldId, v.FieldUid, v.ParentId, v.Label, v.FieldType,
+			v.ValueType, v.NumValue, v.DateValue, v.TextValue, now)
+		rows = append(rows, fmt.Sprintf("($1, $2, $3, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11, n+11))
+	}
+	query := `
+		DELETE FROM public.form_answer_values
+		WHERE "orgId"::text = $1 AND "caseId" = $2 AND "formId"::text = $3`
+	if len(rows) > 0 {
+		query = `
+		WITH old AS (` + query + `)
+		INSERT INTO public.form_answer_values(
+		"orgId", "caseId", "formId", versions, "fieldId", "fieldUid", "parentId", label, "fieldType",
+		"valueType", "numValue", "dateValue", "textValue", "createdAt", "updatedAt")
+		VALUES ` + strings.Join(rows, ", ")
+	}
+	_, err := db.Exec(ctx, query, params...)
+	return err
+}
+
+// syncFormAnswerProjection refreshes the typed values of an answer that has
+// been saved. The values are only a projection of form_answers used by
+// answer filters, so a failure is logged rather than failing the save; the
+// reindex endpoint rebuilds them.
+func syncFormAnswerProjection(ctx context.Context, db repository.DBTX, orgId string, caseId string, fa model.FormAnswerRequest) {
+	if err := syncFormAnswerValues(ctx, db, orgId, caseId, fa.FormId, fa.Versions, fa.FormFieldJson); err != nil {
+		log.Printf("Form answer values of %s, form %s: %v", caseId, fa.FormId, err)
+	}
+}
+
+// parseAnswerPredicates reads ListCase answer filters written as
+// field:op:value, for example injured:gt:3. Values of "in" are separated
+// by |.
+func parseAnswerPredicates(params []string) ([]model.FormAnswerPredicate, error) {
+	var preds []model.FormAnswerPredicate
+	for _, p := range params {
+		parts := strings.SplitN(p, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("answer filter %q: expected field:op:value", p)
+		}
+		pred := model.FormAnswerPredicate{Field: parts[0], Op: parts[1]}
+		if len(parts) == 3 {
+			pred.Value = parts[2]
+			if pred.Op == "in" {
+				pred.Values = strings.Split(parts[2], "|")
+			}
+		}
+		preds = append(preds, pred)
+	}
+	return preds, nil
+}
+
+// answerPredicateSQL returns one EXISTS condition per predicate on the case
+// of the row named by caseRef. arg adds a query argument and returns its
+// placeholder.
+func answerPredicateSQL(preds []model.FormAnswerPredicate, caseRef string, arg func(v interface{}) string) (string, error) {
+	var sql strings.Builder
+	for _, p := range preds {
+		if p.Field == "" {
+			return "", fmt.Errorf("answer filter: field is required")
+		}
+		cond, err := answerCondition(p, arg)
+		if err != nil {
+			return "", err
+		}
+		field := arg(p.Field)
+		sql.WriteString(fmt.Sprintf(` AND EXISTS (SELECT 1 FROM public.form_answer_values AS fav
+			WHERE fav."orgId"::text = %[1]s."orgId"::text AND fav."caseId" = %[1]s."caseId"
+			AND (fav."fieldId" = %[2]s OR fav."fieldUid" = %[2]s OR fav.label = %[2]s)`, caseRef, field))
+		if p.FormId != "" {
+			sql.WriteString(` AND fav."formId"::text = ` + arg(p.FormId))
+		}
+		if cond != "" {
+			sql.WriteString(" AND " + cond)
+		}
+		sql.WriteString(")")
+	}
+	return sql.String(), nil
+}
+
+// answerCondition compares the typed value of a predicate. A number compares
+// numbers and a date compares dates; a date without a time covers the whole
+// day.
+func answerCondition(p model.FormAnswerPredicate, arg func(v interface{}) string) (string, error) {
+	ops := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+	switch p.Op {
+	case "exists":
+		return "", nil
+	case "contains":
+		return `fav."textValue" ILIKE ` + arg("%"+p.Value+"%"), nil
+	case "in":
+		values := p.Values
+		if len(values) == 0 && p.Value != "" {
+			values = []string{p.Value}
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("answer filter %s: in needs values", p.Field)
+		}
+		return `fav."textValue" = ANY(` + arg(values) + `)`, nil
+	}
+	op, ok := ops[p.Op]
+	if !ok {
+		return "", fmt.Errorf("answer filter %s: unknown op %q", p.Field, p.Op)
+	}
+	if n, err := strconv.ParseFloat(strings.TrimSpace(p.Value), 64); err == nil {
+		if p.Op == "eq" {
+			return fmt.Sprintf(`(fav."numValue" = %s OR fav."textValue" = %s)`, arg(n), arg(p.Value)), nil
+		}
+		return fmt.Sprintf(`fav."numValue" %s %s`, op, arg(n)), nil
+	}
+	if t, dateOnly, err := parseFormDate(p.Value); err == nil {
+		if !dateOnly {
+			return fmt.Sprintf(`fav."dateValue" %s %s`, op, arg(t)), nil
+		}
+		day, next := arg(t), arg(t.AddDate(0, 0, 1))
+		switch p.Op {
+		case "eq":
+			return fmt.Sprintf(`(fav."dateValue" >= %s AND fav."dateValue" < %s)`, day, next), nil
+		case "ne":
+			return fmt.Sprintf(`(fav."dateValue" < %s OR fav."dateValue" >= %s)`, day, next), nil
+		case "gt":
+			return `fav."dateValue" >= ` + next, nil
+		case "lte":
+			return `fav."dateValue" < ` + next, nil
+		}
+		return fmt.Sprintf(`fav."dateValue" %s %s`, op, day), nil
+	}
+	if p.Op != "eq" && p.Op != "ne" {
+		return "", fmt.Errorf("answer filter %s: %s needs a number or a date", p.Field, p.Op)
+	}
+	return fmt.Sprintf(`fav."textValue" %s %s`, op, arg(p.Value)), nil
+}
+
+// QueryFormAnswers returns the values of the cases that match every
+// predicate.
+func QueryFormAnswers(ctx context.Context, db repository.DBTX, orgId string, req model.FormAnswerQueryRequest) (model.FormAnswerQueryResult, error) {
+	result := model.FormAnswerQueryResult{Values: []model.FormAnswerValue{}}
+	params := []interface{}{orgId}
+	arg := func(v interface{}) string {
+		params = append(params, v)
+		return fmt.Sprintf("$%d", len(params))
+	}
+	filter, err := answerPredicateSQL(req.Predicates, "a", arg)
+	if err != nil {
+		return result, err
+	}
+	fields := req.Fields
+	if len(fields) == 0 {
+		for _, p := range req.Predicates {
+			fields = append(fields, p.Field)
+		}
+	}
+	base := `
+		FROM public.form_answer_values AS a
+		WHERE a."orgId"::text = $1` + filter
+	if err := db.QueryRow(ctx, `SELECT COUNT(DISTINCT a."caseId")`+base, params...).Scan(&result.TotalCases); err != nil {
+		return result, err
+	}
+	f := arg(fields)
+	base += fmt.Sprintf(` AND (a."fieldId" = ANY(%[1]s) OR a."fieldUid" = ANY(%[1]s) OR a.label = ANY(%[1]s))`, f)
+	length := req.Length
+	if length <= 0 {
+		length = 100
+	}
+	query := `SELECT a."caseId", a."formId", a.versions, a."fieldId", COALESCE(a."fieldUid", ''), a."parentId", a.label, a."fieldType",
+		a."valueType", a."numValue", a."dateValue", a."textValue"` + base +
+		fmt.Sprintf(` ORDER BY a."caseId", a."formId", a."fieldId" LIMIT %s OFFSET %s`, arg(length), arg(req.Start))
+	rows, err := db.Query(ctx, query, params...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v model.FormAnswerValue
+		if err := rows.Scan(&v.CaseId, &v.FormId, &v.Versions, &v.FieldId, &v.FieldUid, &v.ParentId, &v.Label, &v.FieldType,
+			&v.ValueType, &v.NumValue, &v.DateValue, &v.TextValue); err != nil {
+			return result, err
+		}
+		result.Values = append(result.Values, v)
+	}
+	return result, rows.Err()
+}
+
+// reindexBatchSize is how many stored answers reindexFormAnswers reads at a
+// time.
+const reindexBatchSize = 500
+
+// reindexFormAnswers rebuilds the typed values of the stored answers of an
+// organization, or of one case when caseId is set. Answers are read in
+// batches by id; an answer that cannot be indexed is logged and skipped. It
+// returns the number of answers reindexed.
+func reindexFormAnswers(ctx context.Context, db repository.DBTX, orgId string, caseId string) (int, error) {
+	query := `SELECT id, "caseId", "formId"::text, COALESCE(versions, ''), "eleData" FROM form_answers WHERE "orgId"::text = $1 AND id > $2`
+	params := []interface{}{orgId, int64(0)}
+	if caseId != "" {
+		query += ` AND "caseId" = $3`
+		params = append(params, caseId)
+	}
+	query += fmt.Sprintf(` ORDER BY id LIMIT %d`, reindexBatchSize)
+	type answer struct {
+		caseId, formId, versions string
+		form                     model.Form
+	}
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		rows, err := db.Query(ctx, query, params...)
+		if err != nil {
+			return count, err
+		}
+		var answers []answer
+		read := 0
+		for rows.Next() {
+			var a answer
+			var raw []byte
+			var id int64
+			if err := rows.Scan(&id, &a.caseId, &a.formId, &a.versions, &raw); err != nil {
+				rows.Close()
+				return count, err
+			}
+			read++
+			params[1] = id
+			if err := json.Unmarshal(raw, &a.form); err != nil {
+				log.Printf("Reindex: unreadable form answer of %s: %v", a.caseId, err)
+				continue
+			}
+			answers = append(answers, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+		for _, a := range answers {
+			if err := syncFormAnswerValues(ctx, db, orgId, a.caseId, a.formId, a.versions, a.form.FormFieldJson); err != nil {
+				if ctx.Err() != nil {
+					return count, ctx.Err()
+				}
+				log.Printf("Reindex: form answer %s of %s: %v", a.formId, a.caseId, err)
+				continue
+			}
+			count++
+		}
+		if read < reindexBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// @summary Query Form Answers
+// @description Returns the typed answer values of the cases matching every predicate, with the number of matching cases.
+// @tags Cases
+// @security ApiKeyAuth
+// @id Query Form Answers
+// @accept json
+// @produce json
+// @param Body body model.FormAnswerQueryRequest true "Predicates"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/case/answers/query [post]
+func FormAnswerQuery(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.FormAnswerQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+	result, err := QueryFormAnswers(ctx, conn, orgId.(string), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   result,
+	})
+}
+
+// @summary Reindex Form Answers
+// @description Rebuilds the typed answer values used by answer filters from the stored form answers.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Reindex Form Answers
+// @accept json
+// @produce json
+// @Param caseId query string false "only this case"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/forms/answers/reindex [post]
+func FormAnswerReindex(c *gin.Context) {
+	conn, _, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	// A whole organization takes longer than the usual query timeout.
+	ctx, stop := context.WithTimeout(c.Request.Context(), time.Duration(utils.EnvInt("FORM_REINDEX_TIMEOUT", 600))*time.Second)
+	defer stop()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	caseId := c.Query("caseId")
+
+	count, err := reindexFormAnswers(ctx, conn, orgId.(string), caseId)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, caseId, "Form", "FormAnswerReindex", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   fmt.Sprintf("%d answers reindexed", count),
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, caseId, "Form", "FormAnswerReindex", "",
+		"update", 0, start_time, GetQueryParams(c), response, "FormAnswerReindex Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,555 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"mainPackage/utils"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// assignFieldUIDs gives every field of next a uid. A field keeps the uid it
+// was sent with, else takes the uid of the field with the same id in prev,
+// else gets a new one. Copied fields sharing a uid get a new one. Uids are
+// what ties a field to itself across versions, whatever its id or label.
+func assignFieldUIDs(prev []model.IndividualFormField, next []model.IndividualFormField) {
+	byId := map[string]string{}
+	for _, f := range prev {
+		if f.UID != nil && *f.UID != "" {
+			byId[f.ID] = *f.UID
+		}
+	}
+	used := map[string]bool{}
+	for i := range next {
+		f := &next[i]
+		uid := ""
+		if f.UID != nil {
+			uid = *f.UID
+		}
+		if uid == "" {
+			uid = byId[f.ID]
+		}
+		if uid == "" || used[uid] {
+			uid = uuid.New().String()
+		}
+		used[uid] = true
+		f.UID = &uid
+	}
+}
+
+// diffField is a field of a form version flattened for comparison. Fields
+// shown by an option carry the id of their parent.
+type diffField struct {
+	uid     string
+	id      string
+	parent  string
+	label   string
+	props   map[string]interface{}
+	options []string
+	matched bool
+}
+
+func flattenDiffFields(fields []model.IndividualFormField) []*diffField {
+	var raw []interface{}
+	b, _ := json.Marshal(fields)
+	_ = json.Unmarshal(b, &raw)
+	var out []*diffField
+	var walk func(items []interface{}, parent string)
+	walk = func(items []interface{}, parent string) {
+		for _, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			f := &diffField{parent: parent, props: map[string]interface{}{}}
+			f.uid, _ = m["uid"].(string)
+			f.id, _ = m["id"].(string)
+			f.label, _ = m["label"].(string)
+			for k, v := range m {
+				switch k {
+				case "uid", "id", "value", "options":
+				default:
+					f.props[k] = v
+				}
+			}
+			out = append(out, f)
+			opts, _ := m["options"].([]interface{})
+			for _, o := range opts {
+				switch opt := o.(type) {
+				case string:
+					f.options = append(f.options, opt)
+				case map[string]interface{}:
+					v, _ := opt["value"].(string)
+					f.options = append(f.options, v)
+					if children, ok := opt["form"].([]interface{}); ok {
+						walk(children, f.id)
+					}
+				}
+			}
+		}
+	}
+	walk(raw, "")
+	return out
+}
+
+// diffProps compares the properties of two versions of a field or node.
+// Maps listed in nested are compared key by key.
+func diffProps(old, new map[string]interface{}, prefix string, nested map[string]bool) []model.DiffChange {
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	changes := []model.DiffChange{}
+	for _, k := range sorted {
+		o, n := old[k], new[k]
+		om, oIsMap := o.(map[string]interface{})
+		nm, nIsMap := n.(map[string]interface{})
+		if nested[k] && (oIsMap || o == nil) && (nIsMap || n == nil) {
+			changes = append(changes, diffProps(om, nm, prefix+k+".", nil)...)
+			continue
+		}
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, model.DiffChange{Path: prefix + k, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+func diffOptions(old, new []string) ([]string, []string) {
+	inOld, inNew := map[string]bool{}, map[string]bool{}
+	for _, o := range old {
+		inOld[o] = true
+	}
+	for _, n := range new {
+		inNew[n] = true
+	}
+	var added, removed []string
+	for _, n := range new {
+		if !inOld[n] {
+			added = append(added, n)
+		}
+	}
+	for _, o := range old {
+		if !inNew[o] {
+			removed = append(removed, o)
+		}
+	}
+	return added, removed
+}
+
+// DiffFormVersions lists the fields added, removed and changed from one
+// version of a form to another. Fields are matched by uid, then by id.
+func DiffFormVersions(from, to *model.FormsManager) model.FormVersionDiff {
+	diff := model.FormVersionDiff{
+		FormId:  derefString(to.FormId),
+		From:    from.Versions,
+		To:      to.Versions,
+		Changes: []model.DiffChange{},
+		Fields:  []model.FormFieldDiff{},
+	}
+	if derefString(from.FormName) != derefString(to.FormName) {
+		diff.Changes = append(diff.Changes, model.DiffChange{Path: "formName", Old: derefString(from.FormName), New: derefString(to.FormName)})
+	}
+	if from.FormColSpan != to.FormColSpan {
+		diff.Changes = append(diff.Changes, model.DiffChange{Path: "formColSpan", Old: from.FormColSpan, New: to.FormColSpan})
+	}
+
+	oldFields, newFields := flattenDiffFields(from.FormFieldJson), flattenDiffFields(to.FormFieldJson)
+	byUid, byId := map[string]*diffField{}, map[string]*diffField{}
+	for _, f := range newFields {
+		if f.uid != "" {
+			byUid[f.uid] = f
+		}
+		byId[f.parent+"/"+f.id] = f
+	}
+	type pair struct{ old, new *diffField }
+	var pairs []pair
+	for _, o := range oldFields {
+		n := byUid[o.uid]
+		if o.uid == "" || n == nil {
+			n = byId[o.parent+"/"+o.id]
+			if n != nil && o.uid != "" && n.uid != "" && n.uid != o.uid {
+				n = nil
+			}
+		}
+		if n == nil || n.matched {
+			diff.Fields = append(diff.Fields, model.FormFieldDiff{
+				Uid: o.uid, FieldId: o.id, ParentId: o.parent, Label: o.label, Change: diffRemoved,
+			})
+			continue
+		}
+		n.matched = true
+		pairs = append(pairs, pair{o, n})
+	}
+	for _, p := range pairs {
+		fd := model.FormFieldDiff{
+			Uid: p.new.uid, FieldId: p.new.id, ParentId: p.new.parent, Label: p.new.label, Change: diffChanged,
+			Changes: diffProps(p.old.props, p.new.props, "", map[string]bool{"formRule": true}),
+		}
+		if p.old.id != p.new.id {
+			fd.OldFieldId = p.old.id
+			fd.Changes = append(fd.Changes, model.DiffChange{Path: "id", Old: p.old.id, New: p.new.id})
+		}
+		fd.OptionsAdded, fd.OptionsRemoved = diffOptions(p.old.options, p.new.options)
+		if len(fd.Changes) > 0 || len(fd.OptionsAdded) > 0 || len(fd.OptionsRemoved) > 0 {
+			diff.Fields = append(diff.Fields, fd)
+		}
+	}
+	for _, n := range newFields {
+		if !n.matched {
+			diff.Fields = append(diff.Fields, model.FormFieldDiff{
+				Uid: n.uid, FieldId: n.id, ParentId: n.parent, Label: n.label, Change: diffAdded, OptionsAdded: n.options,
+			})
+		}
+	}
+	return diff
+}
+
+// loadWorkflowVersion returns the nodes and connections of one version of a
+// workflow. found is false when the version has no rows.
+func loadWorkflowVersion(ctx context.Context, db repository.DBTX, orgId string, wfId string, version string) ([]model.WorkFlowNode, []model.WorkFlowConnection, bool, error) {
+	rows, err := db.Query(ctx, `
+		SELECT "section", "data"
+		FROM public.wf_nodes
+		WHERE "orgId"::text = $1 AND "wfId"::text = $2 AND "versions" = $3
+		ORDER BY id`, orgId, wfId, version)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer rows.Close()
+	var nodes []model.WorkFlowNode
+	var conns []model.WorkFlowConnection
+	found := false
+	for rows.Next() {
+		var section string
+		var data []byte
+		if err := rows.Scan(&section, &data); err != nil {
+			return nil, nil, false, err
+		}
+		found = true
+		switch section {
+		case "nodes":
+			var n model.WorkFlowNode
+			if err := json.Unmarshal(data, &n); err != nil {
+				return nil, nil, false, fmt.Errorf("read node of version %s: %w", version, err)
+			}
+			nodes = append(nodes, n)
+		case "connections":
+			if err := json.Unmarshal(data, &conns); err != nil {
+				return nil, nil, false, fmt.Errorf("read connections of version %s: %w", version, err)
+			}
+		}
+	}
+	return nodes, conns, found, rows.Err()
+}
+
+func nodeDiffProps(n model.WorkFlowNode) map[string]interface{} {
+	props := map[string]interface{}{"type": n.Type}
+	if n.Data != nil {
+		props["label"] = n.Data.Label
+		if n.Data.Config != nil {
+			props["config"] = *n.Data.Config
+		}
+	}
+	return props
+}
+
+// DiffWorkflowVersions lists the nodes added, removed and changed and the
+// connections added and removed between two versions. Node positions are
+// layout only and are not compared.
+func DiffWorkflowVersions(wfId string, from string, to string, oldNodes []model.WorkFlowNode, oldConns []model.WorkFlowConnection,
+	newNodes []model.WorkFlowNode, newConns []model.WorkFlowConnection) model.WorkflowVersionDiff {
+	diff := model.WorkflowVersionDiff{
+		WfID:               wfId,
+		From:               from,
+		To:                 to,
+		Nodes:              []model.WorkflowNodeDiff{},
+		ConnectionsAdded:   []model.WorkFlowConnection{},
+		ConnectionsRemoved: []model.WorkFlowConnection{},
+	}
+	label := func(n model.WorkFlowNode) string {
+		if n.Data != nil {
+			return n.Data.Label
+		}
+		return ""
+	}
+	newById := map[string]model.WorkFlowNode{}
+	for _, n := range newNodes {
+		newById[n.Id] = n
+	}
+	oldById := map[string]bool{}
+	for _, o := range oldNodes {
+		oldById[o.Id] = true
+		n, ok := newById[o.Id]
+		if !ok {
+			diff.Nodes = append(diff.Nodes, model.WorkflowNodeDiff{NodeId: o.Id, Label: label(o), Type: o.Type, Change: diffRemoved})
+			continue
+		}
+		// round trip so both sides hold plain JSON values
+		var op, np map[string]interface{}
+		b, _ := json.Marshal(nodeDiffProps(o))
+		_ = json.Unmarshal(b, &op)
+		b, _ = json.Marshal(nodeDiffProps(n))
+		_ = json.Unmarshal(b, &np)
+		if changes := diffProps(op, np, "", map[string]bool{"config": true}); len(changes) > 0 {
+			diff.Nodes = append(diff.Nodes, model.WorkflowNodeDiff{NodeId: n.Id, Label: label(n), Type: n.Type, Change: diffChanged, Changes: changes})
+		}
+	}
+	for _, n := range newNodes {
+		if !oldById[n.Id] {
+			diff.Nodes = append(diff.Nodes, model.WorkflowNodeDiff{NodeId: n.Id, Label: label(n), Type: n.Type, Change: diffAdded})
+		}
+	}
+
+	connKey := func(c model.WorkFlowConnection) string {
+		return c.Source + "|" + c.Target + "|" + c.Label + "|" + c.Condition
+	}
+	oldKeys, newKeys := map[string]bool{}, map[string]bool{}
+	for _, c := range oldConns {
+		oldKeys[connKey(c)] = true
+	}
+	for _, c := range newConns {
+		newKeys[connKey(c)] = true
+		if !oldKeys[connKey(c)] {
+			diff.ConnectionsAdded = append(diff.ConnectionsAdded, c)
+		}
+	}
+	for _, c := range oldConns {
+		if !newKeys[connKey(c)] {
+			diff.ConnectionsRemoved = append(diff.ConnectionsRemoved, c)
+		}
+	}
+	return diff
+}
+
+// formVersionList returns every stored version of a form, oldest first.
+func formVersionList(ctx context.Context, db repository.DBTX, orgId string, formId string) ([]model.Form, []string, error) {
+	rows, err := db.Query(ctx, `
+		SELECT versions, "eleData"
+		FROM public.form_elements
+		WHERE "formId"::text = $1 AND "orgId"::text = $2
+		ORDER BY NULLIF(regexp_replace(versions, '\D', '', 'g'), '')::INT`, formId, orgId)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var forms []model.Form
+	var versions []string
+	for rows.Next() {
+		var version string
+		var raw []byte
+		if err := rows.Scan(&version, &raw); err != nil {
+			return nil, nil, err
+		}
+		var form model.Form
+		if err := json.Unmarshal(raw, &form); err != nil {
+			return nil, nil, fmt.Errorf("read version %s: %w", version, err)
+		}
+		forms = append(forms, form)
+		versions = append(versions, version)
+	}
+	return forms, versions, rows.Err()
+}
+
+// FormLineage follows every top-level field through the versions of a form.
+// Fields are followed by uid; fields saved before uids existed are followed
+// by id.
+func FormLineage(forms []model.Form, versions []string) []model.FormFieldLineage {
+	var lineage []*model.FormFieldLineage
+	byUid, byId := map[string]*model.FormFieldLineage{}, map[string]*model.FormFieldLineage{}
+	for i, form := range forms {
+		nextById := map[string]*model.FormFieldLineage{}
+		for _, f := range form.FormFieldJson {
+			uid := ""
+			if f.UID != nil {
+				uid = *f.UID
+			}
+			l := byUid[uid]
+			if uid == "" || l == nil {
+				l = byId[f.ID]
+				if l != nil && l.Uid != "" && uid != "" && l.Uid != uid {
+					l = nil
+				}
+			}
+			if l == nil {
+				l = &model.FormFieldLineage{Versions: []model.FormFieldVersion{}}
+				lineage = append(lineage, l)
+			}
+			if l.Uid == "" && uid != "" {
+				l.Uid = uid
+				byUid[uid] = l
+			}
+			l.Versions = append(l.Versions, model.FormFieldVersion{Version: versions[i], FieldId: f.ID, Label: f.Label, Type: f.Type})
+			nextById[f.ID] = l
+		}
+		byId = nextById
+	}
+	out := make([]model.FormFieldLineage, 0, len(lineage))
+	for _, l := range lineage {
+		out = append(out, *l)
+	}
+	return out
+}
+
+// @summary Diff Form Versions
+// @description Lists the fields added, removed and changed between two versions of a form, with rule and option changes. to defaults to the current version and from to the version before it.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Diff Form Versions
+// @accept json
+// @produce json
+// @Param formId path string true "formId"
+// @Param from query string false "from version"
+// @Param to query string false "to version"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/forms/{formId}/diff [get]
+func GetFormVersionDiff(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	orgId := GetVariableFromToken(c, "orgId").(string)
+	formId := c.Param("formId")
+	from, to := c.Query("from"), c.Query("to")
+
+	if to == "" {
+		if err := conn.QueryRow(ctx, `
+			SELECT versions FROM public.form_builder
+			WHERE "formId"::text = $1 AND "orgId"::text = $2`, formId, orgId).Scan(&to); err != nil {
+			c.JSON(http.StatusNotFound, model.Response{Status: "-1", Msg: "Failure", Desc: "form not found"})
+			return
+		}
+	}
+	if from == "" {
+		n, err := strconv.Atoi(to)
+		if err != nil || n < 2 {
+			c.JSON(http.StatusBadRequest, model.Response{Status: "-1", Msg: "Failure", Desc: "from version is required"})
+			return
+		}
+		from = strconv.Itoa(n - 1)
+	}
+	forms := make([]*model.FormsManager, 2)
+	for i, version := range []string{from, to} {
+		form, err := loadForm(ctx, conn, orgId, formId, version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+			return
+		}
+		if form == nil {
+			c.JSON(http.StatusNotFound, model.Response{Status: "-1", Msg: "Failure", Desc: "version " + version + " not found"})
+			return
+		}
+		forms[i] = form
+	}
+	c.JSON(http.StatusOK, model.Response{Status: "0", Msg: "Success", Data: DiffFormVersions(forms[0], forms[1])})
+}
+
+// @summary Form Field Lineage
+// @description Follows every field of a form through its versions by uid, so answers given under one version can be lined up with another.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Form Field Lineage
+// @accept json
+// @produce json
+// @Param formId path string true "formId"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/forms/{formId}/lineage [get]
+func GetFormLineage(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	orgId := GetVariableFromToken(c, "orgId").(string)
+	formId := c.Param("formId")
+
+	forms, versions, err := formVersionList(ctx, conn, orgId, formId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+		return
+	}
+	if len(forms) == 0 {
+		c.JSON(http.StatusNotFound, model.Response{Status: "-1", Msg: "Failure", Desc: "form not found"})
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{Status: "0", Msg: "Success", Data: FormLineage(forms, versions)})
+}
+
+// @summary Diff Workflow Versions
+// @description Lists the nodes added, removed and changed and the connections added and removed between two versions of a workflow. to defaults to the draft and from to the latest numbered version.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Diff Workflow Versions
+// @accept json
+// @produce json
+// @Param id path string true "wfId"
+// @Param from query string false "from version"
+// @Param to query string false "to version"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/{id}/diff [get]
+func GetWorkflowVersionDiff(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	orgId := GetVariableFromToken(c, "orgId").(string)
+	wfId := c.Param("id")
+	from, to := c.Query("from"), c.DefaultQuery("to", workflowDraftVersion)
+
+	if from == "" {
+		latest, err := LatestWorkflowVersion(ctx, conn, orgId, wfId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+			return
+		}
+		from = latest
+	}
+	oldNodes, oldConns, found, err := loadWorkflowVersion(ctx, conn, orgId, wfId, from)
+	if err == nil && !found {
+		err = fmt.Errorf("version %q not found", from)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+		return
+	}
+	newNodes, newConns, found, err := loadWorkflowVersion(ctx, conn, orgId, wfId, to)
+	if err == nil && !found {
+		err = fmt.Errorf("version %q not found", to)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   DiffWorkflowVersions(wfId, from, to, oldNodes, oldConns, newNodes, newConns),
+	})
+}
@@ -0,0 +1,654 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"mainPackage/utils"
+	"mime"
+	"net/http"
+	"net/mail"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Form answer validation error codes.
+const (
+	FormErrRequired       = "REQUIRED"
+	FormErrMinLength      = "MIN_LENGTH"
+	FormErrMaxLength      = "MAX_LENGTH"
+	FormErrContain        = "CONTAIN"
+	FormErrNotNumber      = "NOT_A_NUMBER"
+	FormErrMinNumber      = "MIN_NUMBER"
+	FormErrMaxNumber      = "MAX_NUMBER"
+	FormErrEmail          = "INVALID_EMAIL"
+	FormErrMinSelections  = "MIN_SELECTIONS"
+	FormErrMaxSelections  = "MAX_SELECTIONS"
+	FormErrUppercase      = "UPPERCASE_MISSING"
+	FormErrLowercase      = "LOWERCASE_MISSING"
+	FormErrDigit          = "NUMBER_MISSING"
+	FormErrSpecialChar    = "SPECIAL_CHAR_MISSING"
+	FormErrWhitespace     = "WHITESPACE_NOT_ALLOWED"
+	FormErrDate           = "INVALID_DATE"
+	FormErrMinDate        = "DATE_BEFORE_MIN"
+	FormErrMaxDate        = "DATE_AFTER_MAX"
+	FormErrFutureDate     = "DATE_NOT_FUTURE"
+	FormErrPastDate       = "DATE_NOT_PAST"
+	FormErrMinFiles       = "MIN_FILES"
+	FormErrMaxFiles       = "MAX_FILES"
+	FormErrFileSize       = "FILE_TOO_LARGE"
+	FormErrFileType       = "FILE_TYPE_NOT_ALLOWED"
+	FormErrCountry        = "COUNTRY_NOT_ALLOWED"
+	formAnswerInvalidDesc = "Form answer validation failed"
+)
+
+// formErrorText holds the English and Thai message of each code. The
+// arguments of the code fill the %v verbs.
+var formErrorText = map[string][2]string{
+	FormErrRequired:      {"This field is required", "กรุณากรอกข้อมูลช่องนี้"},
+	FormErrMinLength:     {"Must be at least %v characters", "ต้องมีอย่างน้อย %v ตัวอักษร"},
+	FormErrMaxLength:     {"Must be at most %v characters", "ต้องมีไม่เกิน %v ตัวอักษร"},
+	FormErrContain:       {"Must contain \"%v\"", "ต้องมีคำว่า \"%v\""},
+	FormErrNotNumber:     {"Must be a number", "ต้องเป็นตัวเลข"},
+	FormErrMinNumber:     {"Must be at least %v", "ต้องมีค่าไม่น้อยกว่า %v"},
+	FormErrMaxNumber:     {"Must be at most %v", "ต้องมีค่าไม่เกิน %v"},
+	FormErrEmail:         {"Invalid email address", "รูปแบบอีเมลไม่ถูกต้อง"},
+	FormErrMinSelections: {"Select at least %v options", "ต้องเลือกอย่างน้อย %v รายการ"},
+	FormErrMaxSelections: {"Select at most %v options", "เลือกได้ไม่เกิน %v รายการ"},
+	FormErrUppercase:     {"Must contain an uppercase letter", "ต้องมีตัวอักษรพิมพ์ใหญ่"},
+	FormErrLowercase:     {"Must contain a lowercase letter", "ต้องมีตัวอักษรพิมพ์เล็ก"},
+	FormErrDigit:         {"Must contain a number", "ต้องมีตัวเลข"},
+	FormErrSpecialChar:   {"Must contain a special character", "ต้องมีอักขระพิเศษ"},
+	FormErrWhitespace:    {"Must not contain spaces", "ห้ามมีช่องว่าง"},
+	FormErrDate:          {"Invalid date", "รูปแบบวันที่ไม่ถูกต้อง"},
+	FormErrMinDate:       {"Must not be before %v", "ต้องไม่ก่อน %v"},
+	FormErrMaxDate:       {"Must not be after %v", "ต้องไม่หลัง %v"},
+	FormErrFutureDate:    {"Must be a future date", "ต้องเป็นวันที่ในอนาคต"},
+	FormErrPastDate:      {"Must be a past date", "ต้องเป็นวันที่ในอดีต"},
+	FormErrMinFiles:      {"Attach at least %v files", "ต้องแนบไฟล์อย่างน้อย %v ไฟล์"},
+	FormErrMaxFiles:      {"Attach at most %v files", "แนบไฟล์ได้ไม่เกิน %v ไฟล์"},
+	FormErrFileSize:      {"%v is larger than %v MB", "ไฟล์ %v มีขนาดเกิน %v MB"},
+	FormErrFileType:      {"%v is not an allowed file type", "ไฟล์ %v เป็นประเภทที่ไม่อนุญาต"},
+	FormErrCountry:       {"Country %v is not allowed", "ไม่อนุญาตประเทศ %v"},
+}
+
+// countryDialCodes maps calling codes to countries for the allowedCountries
+// rule of phone fields.
+var countryDialCodes = map[string]string{
+	"66": "TH", "856": "LA", "855": "KH", "95": "MM", "60": "MY", "65": "SG",
+	"84": "VN", "62": "ID", "63": "PH", "673": "BN", "1": "US", "44": "GB",
+	"61": "AU", "64": "NZ", "81": "JP", "82": "KR", "86": "CN", "852": "HK",
+	"886": "TW", "91": "IN", "33": "FR", "49": "DE", "39": "IT", "7": "RU",
+	"971": "AE",
+}
+
+var formDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// FormAnswerError is returned when a form answer breaks the rules of its form.
+type FormAnswerError struct {
+	Errors []model.FormFieldError
+}
+
+func (e *FormAnswerError) Error() string {
+	return fmt.Sprintf("%s (%d errors)", formAnswerInvalidDesc, len(e.Errors))
+}
+
+// formField is a field of a form or a field shown by one of its options.
+type formField struct {
+	id       string
+	uid      string
+	label    string
+	value    interface{}
+	options  []interface{}
+	required bool
+	rule     *model.FormRule
+}
+
+func formFieldOf(f model.IndividualFormField) formField {
+	uid := ""
+	if f.UID != nil {
+		uid = *f.UID
+	}
+	return formField{f.ID, uid, f.Label, f.Value, f.Options, f.Required, f.FormRule}
+}
+
+func formChildOf(f model.IndividualFormFieldWithChildren) formField {
+	return formField{f.ID, "", f.Label, f.Value, f.Options, f.Required, f.FormRule}
+}
+
+// formFieldOptions returns the options of a field that show more fields.
+func formFieldOptions(options []interface{}) []model.FormFieldOption {
+	var opts []model.FormFieldOption
+	for _, raw := range options {
+		b, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+		var opt model.FormFieldOption
+		if json.Unmarshal(b, &opt) != nil || len(opt.Form) == 0 {
+			continue
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+type formValidator struct {
+	now   time.Time
+	rules map[string]formField
+	seen  map[string]bool
+	errs  []model.FormFieldError
+}
+
+// ValidateFormAnswers checks answered fields against Required and FormRule.
+// When def, the fields of the stored form, is given its rules win over the
+// ones sent with the answer, and required fields missing from the answer are
+// reported. Fields shown by an option are checked only when the option is
+// selected.
+func ValidateFormAnswers(def []model.IndividualFormField, answers []model.IndividualFormField, now time.Time) []model.FormFieldError {
+	v := &formValidator{
+		now:   now,
+		rules: map[string]formField{},
+		seen:  map[string]bool{},
+		errs:  []model.FormFieldError{},
+	}
+	for _, f := range def {
+		v.collectRules(formFieldOf(f))
+	}
+	for _, f := range answers {
+		v.check(formFieldOf(f), "")
+	}
+	for _, f := range def {
+		if f.Required && !v.seen[f.ID] {
+			v.add(formFieldOf(f), "", FormErrRequired)
+		}
+	}
+	return v.errs
+}
+
+func (v *formValidator) collectRules(f formField) {
+	if f.id != "" {
+		v.rules[f.id] = f
+	}
+	for _, opt := range formFieldOptions(f.options) {
+		for _, child := range opt.Form {
+			v.collectRules(formChildOf(child))
+		}
+	}
+}
+
+func (v *formValidator) add(f formField, parent string, code string, args ...interface{}) {
+	text := formErrorText[code]
+	v.errs = append(v.errs, model.FormFieldError{
+		FieldId:  f.id,
+		ParentId: parent,
+		Label:    f.label,
+		Code:     code,
+		En:       fmt.Sprintf(text[0], args...),
+		Th:       fmt.Sprintf(text[1], args...),
+	})
+}
+
+func (v *formValidator) check(f formField, parent string) {
+	if d, ok := v.rules[f.id]; ok {
+		f.required, f.rule = d.required, d.rule
+	}
+	v.seen[f.id] = true
+	if formValueEmpty(f.value) {
+		if f.required {
+			v.add(f, parent, FormErrRequired)
+		}
+	} else if f.rule != nil {
+		v.checkRule(f, parent)
+	}
+	for _, opt := range formFieldOptions(f.options) {
+		if !optionSelected(f.value, opt.Value) {
+			continue
+		}
+		for _, child := range opt.Form {
+			v.check(formChildOf(child), f.id)
+		}
+	}
+}
+
+func formValueEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func (v *formValidator) checkRule(f formField, parent string) {
+	r := f.rule
+	add := func(code string, args ...interface{}) { v.add(f, parent, code, args...) }
+
+	if s, ok := f.value.(string); ok {
+		n := utf8.RuneCountInString(s)
+		if r.MinLength != nil && n < *r.MinLength {
+			add(FormErrMinLength, *r.MinLength)
+		}
+		if r.MaxLength != nil && n > *r.MaxLength {
+			add(FormErrMaxLength, *r.MaxLength)
+		}
+		if r.Contain != nil && *r.Contain != "" && !strings.Contains(s, *r.Contain) {
+			add(FormErrContain, *r.Contain)
+		}
+		if r.ValidEmailFormat != nil && *r.ValidEmailFormat {
+			if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+				add(FormErrEmail)
+			}
+		}
+		if r.HasUppercase != nil && *r.HasUppercase && strings.IndexFunc(s, unicode.IsUpper) < 0 {
+			add(FormErrUppercase)
+		}
+		if r.HasLowercase != nil && *r.HasLowercase && strings.IndexFunc(s, unicode.IsLower) < 0 {
+			add(FormErrLowercase)
+		}
+		if r.HasNumber != nil && *r.HasNumber && strings.IndexFunc(s, unicode.IsDigit) < 0 {
+			add(FormErrDigit)
+		}
+		if r.HasSpecialChar != nil && *r.HasSpecialChar && strings.IndexFunc(s, isSpecialChar) < 0 {
+			add(FormErrSpecialChar)
+		}
+		if r.NoWhitespace != nil && *r.NoWhitespace && strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+			add(FormErrWhitespace)
+		}
+	}
+
+	if r.MinNumber != nil || r.MaxNumber != nil {
+		if n, ok := formNumber(f.value); !ok {
+			add(FormErrNotNumber)
+		} else {
+			if r.MinNumber != nil && n < *r.MinNumber {
+				add(FormErrMinNumber, formatFormNumber(*r.MinNumber))
+			}
+			if r.MaxNumber != nil && n > *r.MaxNumber {
+				add(FormErrMaxNumber, formatFormNumber(*r.MaxNumber))
+			}
+		}
+	}
+
+	if list, ok := f.value.([]interface{}); ok {
+		if r.MinSelections != nil && len(list) < *r.MinSelections {
+			add(FormErrMinSelections, *r.MinSelections)
+		}
+		if r.MaxSelections != nil && len(list) > *r.MaxSelections {
+			add(FormErrMaxSelections, *r.MaxSelections)
+		}
+	}
+
+	if r.MinDate != nil || r.MaxDate != nil || r.MinLocalDate != nil || r.MaxLocalDate != nil ||
+		(r.FutureDateOnly != nil && *r.FutureDateOnly) || (r.PastDateOnly != nil && *r.PastDateOnly) {
+		v.checkDate(f.value, r, add)
+	}
+
+	if r.MinFiles != nil || r.MaxFiles != nil || r.MaxFileSize != nil || len(r.AllowedFileTypes) > 0 {
+		checkFiles(f.value, r, add)
+	}
+
+	if len(r.AllowedCountries) > 0 {
+		country := formValueCountry(f.value)
+		allowed := false
+		for _, c := range r.AllowedCountries {
+			if strings.EqualFold(c, country) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			if country == "" {
+				country = "-"
+			}
+			add(FormErrCountry, country)
+		}
+	}
+}
+
+func isSpecialChar(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+func formNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func formatFormNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// parseFormDate reads a date or date-time answer. Values without a zone are
+// local times.
+func parseFormDate(s string) (time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	for _, layout := range formDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date %q", s)
+}
+
+// checkDate applies the date window of a rule. A bound given as a date covers
+// the whole day; bounds that cannot be read are ignored.
+func (v *formValidator) checkDate(value interface{}, r *model.FormRule, add func(string, ...interface{})) {
+	s, ok := value.(string)
+	if !ok {
+		add(FormErrDate)
+		return
+	}
+	t, dateOnly, err := parseFormDate(s)
+	if err != nil {
+		add(FormErrDate)
+		return
+	}
+	for _, min := range []*string{r.MinDate, r.MinLocalDate} {
+		if min == nil {
+			continue
+		}
+		if bound, _, err := parseFormDate(*min); err == nil && t.Before(bound) {
+			add(FormErrMinDate, *min)
+		}
+	}
+	for _, max := range []*string{r.MaxDate, r.MaxLocalDate} {
+		if max == nil {
+			continue
+		}
+		bound, boundDateOnly, err := parseFormDate(*max)
+		if err != nil {
+			continue
+		}
+		if boundDateOnly {
+			bound = bound.AddDate(0, 0, 1)
+			if !t.Before(bound) {
+				add(FormErrMaxDate, *max)
+			}
+		} else if t.After(bound) {
+			add(FormErrMaxDate, *max)
+		}
+	}
+	now := v.now.In(time.Local)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if r.FutureDateOnly != nil && *r.FutureDateOnly {
+		if (dateOnly && t.Before(today)) || (!dateOnly && !t.After(now)) {
+			add(FormErrFutureDate)
+		}
+	}
+	if r.PastDateOnly != nil && *r.PastDateOnly {
+		if (dateOnly && t.After(today)) || (!dateOnly && t.After(now)) {
+			add(FormErrPastDate)
+		}
+	}
+}
+
+// checkFiles applies the file rules. Each file is a url or name, or an object
+// with a name or url, a size in bytes and a mime type. maxFileSize is in MB.
+func checkFiles(value interface{}, r *model.FormRule, add func(string, ...interface{})) {
+	files, ok := value.([]interface{})
+	if !ok {
+		files = []interface{}{value}
+	}
+	if r.MinFiles != nil && len(files) < *r.MinFiles {
+		add(FormErrMinFiles, *r.MinFiles)
+	}
+	if r.MaxFiles != nil && len(files) > *r.MaxFiles {
+		add(FormErrMaxFiles, *r.MaxFiles)
+	}
+	for _, file := range files {
+		name, mimeType, size := formFileInfo(file)
+		if r.MaxFileSize != nil && size > float64(*r.MaxFileSize)*1024*1024 {
+			add(FormErrFileSize, name, *r.MaxFileSize)
+		}
+		if len(r.AllowedFileTypes) > 0 && !formFileTypeAllowed(name, mimeType, r.AllowedFileTypes) {
+			add(FormErrFileType, name)
+		}
+	}
+}
+
+func formFileInfo(file interface{}) (string, string, float64) {
+	switch f := file.(type) {
+	case string:
+		return f, "", 0
+	case map[string]interface{}:
+		var name, mimeType string
+		for _, key := range []string{"name", "attName", "filename", "url", "attUrl"} {
+			if s, ok := f[key].(string); ok && s != "" {
+				name = s
+				break
+			}
+		}
+		for _, key := range []string{"mimeType", "contentType", "type"} {
+			if s, ok := f[key].(string); ok && strings.Contains(s, "/") {
+				mimeType = s
+				break
+			}
+		}
+		size, _ := formNumber(f["size"])
+		return name, mimeType, size
+	}
+	return "", "", 0
+}
+
+// formFileTypeAllowed matches a file against extensions (".pdf", "pdf") and
+// mime types ("image/png", "image/*").
+func formFileTypeAllowed(name string, mimeType string, allowed []string) bool {
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	if mimeType == "" && ext != "" {
+		mimeType, _, _ = strings.Cut(mime.TypeByExtension("."+ext), ";")
+	}
+	mimeType = strings.ToLower(mimeType)
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch {
+		case strings.HasSuffix(a, "/*"):
+			if mimeType != "" && strings.HasPrefix(mimeType, strings.TrimSuffix(a, "*")) {
+				return true
+			}
+		case strings.Contains(a, "/"):
+			if mimeType == a {
+				return true
+			}
+		case ext != "" && ext == strings.TrimPrefix(a, "."):
+			return true
+		}
+	}
+	return false
+}
+
+// formValueCountry returns the country of a phone number or of an object
+// with a country code. National numbers starting with 0 are Thai.
+func formValueCountry(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"countryCode", "country"} {
+			if s, ok := v[key].(string); ok && s != "" {
+				return strings.ToUpper(s)
+			}
+		}
+	case string:
+		phone := strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) || r == '+' {
+				return r
+			}
+			return -1
+		}, v)
+		switch {
+		case strings.HasPrefix(phone, "+"):
+			phone = phone[1:]
+		case strings.HasPrefix(phone, "00"):
+			phone = phone[2:]
+		case strings.HasPrefix(phone, "0"):
+			return "TH"
+		default:
+			return ""
+		}
+		for n := 3; n >= 1; n-- {
+			if len(phone) >= n {
+				if c, ok := countryDialCodes[phone[:n]]; ok {
+					return c
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// checkFormAnswer validates an answer against the stored version of its form.
+// The rules sent with the answer are used when the form cannot be found.
+func checkFormAnswer(ctx context.Context, db repository.DBTX, orgId string, formId string, version string, fields []model.IndividualFormField) ([]model.FormFieldError, error) {
+	if version == "" {
+		err := db.QueryRow(ctx, `
+			SELECT versions FROM public.form_builder
+			WHERE "orgId"::text = $1 AND "formId"::text = $2`, orgId, formId).Scan(&version)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+	var def []model.IndividualFormField
+	if version != "" {
+		form, err := loadForm(ctx, db, orgId, formId, version)
+		if err != nil {
+			return nil, err
+		}
+		if form != nil {
+			def = form.FormFieldJson
+		}
+	}
+	return ValidateFormAnswers(def, fields, time.Now()), nil
+}
+
+// validateFormAnswer returns a *FormAnswerError when the answer breaks the
+// rules of its form.
+func validateFormAnswer(ctx context.Context, db repository.DBTX, orgId string, fa model.FormAnswerRequest) error {
+	errs, err := checkFormAnswer(ctx, db, orgId, fa.FormId, fa.Versions, fa.FormFieldJson)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &FormAnswerError{Errors: errs}
+	}
+	return nil
+}
+
+// formAnswerFailure returns the status and response for an error of
+// InsertFormAnswer or UpdateFormAnswer.
+func formAnswerFailure(err error) (int, model.Response) {
+	var invalid *FormAnswerError
+	if errors.As(err, &invalid) {
+		return http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   formAnswerInvalidDesc,
+			Data:   invalid.Errors,
+		}
+	}
+	return http.StatusInternalServerError, model.Response{
+		Status: "-1",
+		Msg:    "Failure",
+		Desc:   err.Error(),
+	}
+}
+
+// @summary Validate Form Answer
+// @description Checks a form answer against the rules of its form without saving it. Errors are listed per field in data with messages in English and Thai.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Validate Form Answer
+// @accept json
+// @produce json
+// @param Body body model.FormAnswerValidateRequest true "Form answer"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/forms/validate [post]
+func FormAnswerValidate(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.FormAnswerValidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+
+	errs, err := checkFormAnswer(ctx, conn, orgId.(string), req.FormId, req.Versions, req.FormFieldJson)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, req.FormId, "Form", "FormAnswerValidate", "",
+			"view", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusOK, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   formAnswerInvalidDesc,
+			Data:   errs,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Form answer is valid",
+		Data:   errs,
+	})
+}
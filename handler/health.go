@@ -25,10 +25,21 @@ var RatePeriod time.Duration
 // @Router /health [get]
 func Health(c *gin.Context) {
 	currentTime := time.Now().Format("06/01/02 15:04:05")
+	workers := Workers.Status()
+	// A restarting worker does not fail the check: the server still serves
+	// requests and the supervisor keeps retrying it.
+	msg := "Success"
+	for _, w := range workers {
+		if w.State != WorkerRunning {
+			msg = "Degraded"
+			break
+		}
+	}
 	c.JSON(http.StatusOK, model.Response{
 		Status: "0",
-		Msg:    "Success",
+		Msg:    msg,
 		Desc:   fmt.Sprintf("HealthCheck OK - %s", currentTime),
+		Data:   workers,
 	})
 
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"mainPackage/model"
+	"mainPackage/repository"
 	"mainPackage/utils"
 	"net/http"
 	"os"
@@ -30,8 +31,7 @@ func IntegrateCreateCaseFromWorkOrder(ctx *gin.Context, conn *pgx.Conn, workOrde
 	var id int
 	caseData, err := GetCaseByID(ctx, conn, orgId, caseId)
 	if err != nil {
-		log.Printf("Error getting case: %v", err)
-		return nil
+		return fmt.Errorf("error getting case: %w", err)
 	}
 
 	if caseData != nil {
@@ -344,23 +344,31 @@ func IntegrateCaseCurrentStageInsert(username string, orgId string, conn *pgx.Co
 	// 	return err
 	// }
 
-	// Step 2: Load workflow node from DB
+	// Step 2: Load workflow node of the version the case is pinned to
+	wfId := ""
+	if req.WfID != nil {
+		wfId = *req.WfID
+	}
+	version, err := pinCaseWorkflow(ctx, conn, orgId, req.CaseID, wfId, username)
+	if err != nil {
+		return fmt.Errorf("pin workflow version: %w", err)
+	}
 	query := `
 	SELECT t1.id, t1."orgId", t1."wfId", t1."nodeId", t1.versions, t1.type, t1.section, t1.data,
 	       t1.pic, t1."group", t1."formId", t1."createdAt", t1."updatedAt", t1."createdBy", t1."updatedBy"
 	FROM public.wf_nodes t1
 	JOIN public.wf_definitions t2
-	  ON t1."versions" = t2."versions" AND t1."wfId" = t2."wfId"
-	WHERE t2."wfId" = $1 AND t1."nodeId" = $2 AND t2."orgId" = $3
+	  ON t1."wfId" = t2."wfId"
+	WHERE t2."wfId" = $1 AND t1."nodeId" = $2 AND t2."orgId" = $3 AND t1."versions" = $4
 	`
 
 	logger.Debug("Loading workflow node",
 		zap.String("query", query),
-		zap.Any("params", []any{req.WfID, req.NodeID, orgId}),
+		zap.Any("params", []any{req.WfID, req.NodeID, orgId, version}),
 	)
 
 	var workflow model.WfNode
-	err := conn.QueryRow(ctx, query, req.WfID, req.NodeID, orgId).Scan(
+	err = conn.QueryRow(ctx, query, req.WfID, req.NodeID, orgId, version).Scan(
 		&workflow.ID, &workflow.OrgID, &workflow.WfID, &workflow.NodeID,
 		&workflow.Versions, &workflow.Type, &workflow.Section,
 		&workflow.Data, &workflow.Pic, &workflow.Group, &workflow.FormID,
@@ -412,56 +420,7 @@ func IntegrateCaseCurrentStageInsert(username string, orgId string, conn *pgx.Co
 }
 
 func GetCaseByID(ctx context.Context, conn *pgx.Conn, orgId string, caseId string) (*model.Case, error) {
-	query := `
-	SELECT 
-		"caseId", "integration_ref_number", "distId", "statusId", "caseTypeId", "caseSTypeId", "priority", "caseLat", "caseLon", "caseDetail", "deviceMetaData", "wfId",
-		"countryId", "provId", "distId", "createdDate", "scheduleFlag", "scheduleDate", "createdBy"
-	FROM public."tix_cases"
-	WHERE "orgId" = $1 AND "caseId" = $2
-	LIMIT 1;
-	`
-
-	var c model.Case
-	var deviceMetaJSON []byte
-
-	err := conn.QueryRow(ctx, query, orgId, caseId).Scan(
-		&c.CaseID,
-		&c.IntegrationRefNumber,
-		&c.DistID,
-		&c.StatusID,
-		&c.CaseTypeID,
-		&c.CaseSTypeID,
-		&c.Priority,
-		&c.CaseLat,
-		&c.CaseLon,
-		&c.CaseDetail,
-		&c.DeviceMetaData,
-		&c.WfID,
-		&c.CountryID,
-		&c.ProvID,
-		&c.DistID,
-		&c.CreatedDate,
-		&c.ScheduleFlag,
-		&c.ScheduleDate,
-		&c.CreatedBy,
-	)
-
-	// ✅ case not found
-	if err == pgx.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, fmt.Errorf("query case failed: %w", err)
-	}
-
-	// ✅ handle JSON column (TEXT / JSONB)
-	if len(deviceMetaJSON) > 0 {
-		if err := json.Unmarshal(deviceMetaJSON, &c.DeviceMetaData); err != nil {
-			log.Printf("Warning: cannot unmarshal deviceMetaData: %v", err)
-		}
-	}
-
-	return &c, nil
+	return repository.NewCaseRepository(conn).GetByID(ctx, orgId, caseId)
 }
 
 func IntegrateUpdateCaseFromWorkOrder(ctx *gin.Context, conn *pgx.Conn, workOrder model.WorkOrder, username, orgId string) error {
@@ -703,7 +662,7 @@ func CreateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.CaseInsert, s
 	jsonStr := string(jsonBytes)
 	fmt.Println(jsonStr)
 	log.Print("===END===CreateBusKafka_WO=")
-	res, err := callAPI(os.Getenv("METTTER_SERVER")+"/mettriq/v1/work_order/create", "POST", data)
+	res, err := publishWorkOrder(ctx, "ESB_WO_OUT_CREATE", "/mettriq/v1/work_order/create", data)
 	if err != nil {
 		return err
 	}
@@ -716,16 +675,22 @@ func CreateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.CaseInsert, s
 }
 
 func UpdateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.UpdateStageRequest) error {
+	orgId := GetVariableFromToken(ctx, "orgId")
+	return publishWorkOrderUpdate(ctx, pgWorkOrderReader{conn: conn}, orgId.(string), req)
+}
+
+// publishWorkOrderUpdate publishes the outbound work order of a case after a
+// stage update, reading the case through r.
+func publishWorkOrderUpdate(ctx context.Context, r WorkOrderReader, orgId string, req model.UpdateStageRequest) error {
 	log.Print("=====UpdateBusKafka_WO===")
 	currentDate := time.Now().Format("2006-01-02")
-	orgId := GetVariableFromToken(ctx, "orgId")
 
-	caseData, err := GetCaseByID(ctx, conn, orgId.(string), req.CaseId)
+	caseData, err := r.GetCase(ctx, orgId, req.CaseId)
 	if err != nil {
-		log.Printf("Error getting case: %v", err)
+		return fmt.Errorf("get case %s: %w", req.CaseId, err)
 	}
 
-	areaDist, err := utils.GetAreaById(ctx, conn, orgId.(string), caseData.DistID)
+	areaDist, err := r.GetArea(ctx, orgId, caseData.DistID)
 	if err != nil {
 		log.Printf("areaDist Error: %v", err)
 	}
@@ -744,14 +709,14 @@ func UpdateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.UpdateStageRe
 		stName = "DONE"
 	}
 
-	if *caseData.ScheduleFlag {
+	if caseData.ScheduleFlag != nil && *caseData.ScheduleFlag {
 		log.Print("=====ScheduleDate===", caseData.ScheduleDate.String())
 		currentDate = ConvertDateSafe(caseData.ScheduleDate.String())
 	}
 
 	var uAssign interface{} = "" // default empty string
 	if req.UnitUser != "" {
-		user, err := utils.GetUserByUsername(ctx, conn, orgId.(string), req.UnitUser)
+		user, err := r.GetUser(ctx, orgId, req.UnitUser)
 		if err != nil {
 			log.Printf("Error getting user: %v", err)
 		} else if user != nil {
@@ -768,14 +733,13 @@ func UpdateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.UpdateStageRe
 	if stName == "CANCEL" {
 		state = "CLOSED"
 		uAssign = ""
-		assign_ := os.Getenv("ASSIGNED")
-		unitLists, count, err := GetUnits(ctx, conn, orgId.(string), req.CaseId, assign_, "")
+		unitLists, err := r.GetAssignedUnits(ctx, orgId, req.CaseId)
 		if err != nil {
 			return fmt.Errorf("get unitLists failed: %w", err)
 		}
-		if count > 0 {
+		if len(unitLists) > 0 {
 			firstUnitId := unitLists[0].UnitID
-			user, err := utils.GetUserByUsername(ctx, conn, orgId.(string), firstUnitId)
+			user, err := r.GetUser(ctx, orgId, firstUnitId)
 			if err != nil {
 				log.Printf("Error getting user: %v", err)
 			} else if user != nil {
@@ -791,12 +755,15 @@ func UpdateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.UpdateStageRe
 
 	}
 
-	sType, err := utils.GetCaseSubTypeByCode(ctx, conn, orgId.(string), caseData.CaseSTypeID)
+	sType, err := r.GetCaseSubType(ctx, orgId, caseData.CaseSTypeID)
 	if err != nil {
 		log.Printf("sType Error: %v", err)
 	}
+	if sType == nil {
+		return fmt.Errorf("case sub type not found: %s", caseData.CaseSTypeID)
+	}
 
-	attachments, err := GetCaseAttachments_(ctx, conn, orgId.(string), req.CaseId)
+	attachments, err := r.GetAttachments(ctx, orgId, req.CaseId)
 	if err != nil {
 
 	}
@@ -870,7 +837,7 @@ func UpdateBusKafka_WO(ctx *gin.Context, conn *pgx.Conn, req model.UpdateStageRe
 
 	jsonStr := string(jsonBytes)
 	fmt.Println(jsonStr)
-	res, err := callAPI(os.Getenv("METTTER_SERVER")+"/mettriq/v1/work_order/update", "POST", data)
+	res, err := publishWorkOrder(ctx, "ESB_WO_OUT_UPDATE", "/mettriq/v1/work_order/update", data)
 	if err != nil {
 		return err
 	}
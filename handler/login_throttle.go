@@ -0,0 +1,405 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Failed password logins are counted per username and per client address,
+// both within the organization, so each organization sees and lifts only
+// the locks of its own logins.
+// Once a counter passes its delay threshold every further failure makes the
+// next attempt wait twice as long, and once it reaches the lock threshold the
+// username or address is locked for LOGIN_LOCK_DURATION. Counters are
+// forgotten after LOGIN_FAIL_WINDOW minutes without a failure, and a
+// successful login clears the counter of the username (not of the address).
+//
+//	LOGIN_FAIL_WINDOW     minutes (default 15)
+//	LOGIN_DELAY_AFTER     failures per username before delays start (default 3)
+//	LOGIN_IP_DELAY_AFTER  failures per address before delays start (default 10)
+//	LOGIN_DELAY_BASE      first delay in seconds (default 1)
+//	LOGIN_DELAY_MAX       longest delay in seconds (default 30)
+//	LOGIN_LOCK_AFTER      failures per username that lock it (default 10)
+//	LOGIN_IP_LOCK_AFTER   failures per address that lock it (default 50)
+//	LOGIN_LOCK_DURATION   minutes (default 15)
+
+// loginThrottledError is returned by loginUser when the username or address
+// may not try yet.
+type loginThrottledError struct {
+	retryAfter time.Duration
+	locked     bool
+}
+
+func (e *loginThrottledError) Error() string {
+	if e.locked {
+		return "too many failed logins, locked for " + e.retryAfter.Round(time.Second).String()
+	}
+	return "too many failed logins, retry in " + e.retryAfter.Round(time.Second).String()
+}
+
+// loginFailedStatus is the HTTP status for an error of loginUser. Throttled
+// logins get 429 with Retry-After, everything else 401.
+func loginFailedStatus(c *gin.Context, err error) int {
+	if e, ok := err.(*loginThrottledError); ok {
+		secs := int((e.retryAfter + time.Second - 1) / time.Second)
+		c.Header("Retry-After", strconv.Itoa(secs))
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
+
+func loginUserSubject(orgId string, username string) string {
+	return "user:" + orgId + ":" + username
+}
+
+func loginIPSubject(orgId string, ip string) string {
+	return "ip:" + orgId + ":" + ip
+}
+
+// loginDelay is the wait after the given number of failures.
+func loginDelay(failures int64, after int) time.Duration {
+	over := failures - int64(after)
+	if over <= 0 {
+		return 0
+	}
+	delay := time.Duration(utils.EnvInt("LOGIN_DELAY_BASE", 1)) * time.Second
+	limit := time.Duration(utils.EnvInt("LOGIN_DELAY_MAX", 30)) * time.Second
+	for i := int64(1); i < over && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// checkLoginThrottle returns a *loginThrottledError when the username or the
+// address is locked or still has to wait. Redis errors let the login through.
+func checkLoginThrottle(orgId string, username string, ip string) error {
+	logger := utils.GetLog()
+	for _, subject := range []string{loginIPSubject(orgId, ip), loginUserSubject(orgId, username)} {
+		val, err := utils.LoginLockGet(subject)
+		if err != nil {
+			logger.Warn("Login lock lookup failed", zap.Error(err))
+			return nil
+		}
+		if val != "" {
+			var lock model.LoginLock
+			_ = json.Unmarshal([]byte(val), &lock)
+			return &loginThrottledError{retryAfter: time.Until(lock.ExpiresAt), locked: true}
+		}
+		wait, err := utils.LoginWaitGet(subject)
+		if err != nil {
+			logger.Warn("Login delay lookup failed", zap.Error(err))
+			return nil
+		}
+		if wait > 0 {
+			return &loginThrottledError{retryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login and sets the delay or lock it
+// earns.
+func recordLoginFailure(ctx context.Context, c *gin.Context, conn *pgx.Conn, orgId string, username string, ip string) {
+	logger := utils.GetLog()
+	window := time.Duration(utils.EnvInt("LOGIN_FAIL_WINDOW", 15)) * time.Minute
+
+	subjects := []struct {
+		subject    string
+		lock       model.LoginLock
+		delayAfter int
+		lockAfter  int
+	}{
+		{loginUserSubject(orgId, username), model.LoginLock{Kind: "user", OrgID: orgId, Username: username},
+			utils.EnvInt("LOGIN_DELAY_AFTER", 3), utils.EnvInt("LOGIN_LOCK_AFTER", 10)},
+		{loginIPSubject(orgId, ip), model.LoginLock{Kind: "ip", OrgID: orgId, IP: ip},
+			utils.EnvInt("LOGIN_IP_DELAY_AFTER", 10), utils.EnvInt("LOGIN_IP_LOCK_AFTER", 50)},
+	}
+	for _, s := range subjects {
+		failures, err := utils.LoginFailHit(s.subject, window)
+		if err != nil {
+			logger.Warn("Login failure count failed", zap.Error(err))
+			return
+		}
+		if s.lockAfter > 0 && failures >= int64(s.lockAfter) {
+			s.lock.Failures = failures
+			lockLogin(ctx, c, conn, s.subject, s.lock)
+			continue
+		}
+		if delay := loginDelay(failures, s.delayAfter); delay > 0 {
+			if err := utils.LoginWaitSet(s.subject, delay); err != nil {
+				logger.Warn("Login delay failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func recordLoginSuccess(orgId string, username string) {
+	if err := utils.LoginFailDel(loginUserSubject(orgId, username)); err != nil {
+		utils.GetLog().Warn("Login failure reset failed", zap.Error(err))
+	}
+}
+
+func lockLogin(ctx context.Context, c *gin.Context, conn *pgx.Conn, subject string, lock model.LoginLock) {
+	logger := utils.GetLog()
+	duration := time.Duration(utils.EnvInt("LOGIN_LOCK_DURATION", 15)) * time.Minute
+	lock.LockedAt = time.Now()
+	lock.ExpiresAt = lock.LockedAt.Add(duration)
+	value, _ := json.Marshal(lock)
+	if err := utils.LoginLockSet(subject, string(value), duration); err != nil {
+		logger.Warn("Login lock failed", zap.Error(err))
+		return
+	}
+	// The lock replaces the counter, so the subject starts over once the lock
+	// ends or is lifted.
+	_ = utils.LoginFailDel(subject)
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   lock,
+		Desc:   "Login locked",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, lock.OrgID, lock.Username,
+		uuid.New().String(), lock.Username+lock.IP, "Authentication", "LoginLockout", "",
+		"lock", 0, lock.LockedAt, GetQueryParams(c), response,
+		fmt.Sprintf("Locked %s after %d failed logins.", lock.Kind, lock.Failures),
+	)
+	//=======AUDIT_END=====//
+
+	msg := fmt.Sprintf("ระงับการเข้าสู่ระบบของบัญชี %s เป็นเวลา %d นาที เนื่องจากใส่รหัสผ่านผิด %d ครั้ง",
+		lock.Username, int(duration.Minutes()), lock.Failures)
+	if lock.Kind == "ip" {
+		msg = fmt.Sprintf("ระงับการเข้าสู่ระบบจาก IP %s เป็นเวลา %d นาที เนื่องจากใส่รหัสผ่านผิด %d ครั้ง",
+			lock.IP, int(duration.Minutes()), lock.Failures)
+	}
+	notifyLoginAdmins(ctx, conn, lock.OrgID, "LOGIN-LOCKED", "Locked", msg, lock)
+}
+
+// notifyLoginAdmins sends a websocket notification to the roles of the org
+// that may manage users.
+func notifyLoginAdmins(ctx context.Context, conn *pgx.Conn, orgId string, event string, title string, msg string, lock model.LoginLock) {
+	rows, err := conn.Query(ctx, `
+		SELECT DISTINCT rp."roleId"
+		FROM public.um_role_with_permissions rp
+		LEFT JOIN public.um_permissions p
+		       ON p."permId" = rp."permId"
+		      AND p.active = true
+		WHERE rp."orgId" = $1 AND rp.active = true
+		  AND (rp."permId" = $2 OR p."permName" = $2)`, orgId, PermUserManage)
+	if err != nil {
+		utils.GetLog().Warn("Load admin roles failed", zap.Error(err))
+		return
+	}
+	var roles []string
+	for rows.Next() {
+		var roleId string
+		if err := rows.Scan(&roleId); err == nil {
+			roles = append(roles, roleId)
+		}
+	}
+	rows.Close()
+	if len(roles) == 0 {
+		return
+	}
+
+	recipients := []model.Recipient{}
+	for _, roleId := range roles {
+		recipients = append(recipients, model.Recipient{Type: "roleId", Value: roleId})
+	}
+	additionalJSON, _ := json.Marshal(map[string]interface{}{
+		"event": event,
+		"lock":  lock,
+	})
+	additionalData := json.RawMessage(additionalJSON)
+	if err := genNotiCustom(ctx, conn, orgId, "System", "System", "", title, nil, msg, recipients, "", "System", event, &additionalData); err != nil {
+		utils.GetLog().Warn("Login lock notification failed", zap.Error(err))
+	}
+}
+
+// @summary Get Login Locks
+// @description Lists the usernames and addresses of the org that are locked after failed logins
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Get Login Locks
+// @accept json
+// @produce json
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/login_locks [get]
+func GetLoginLocks(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	values, err := utils.GetAllLoginLocks(ctx)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "GetLoginLocks", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	locks := []model.LoginLock{}
+	for _, val := range values {
+		var lock model.LoginLock
+		if json.Unmarshal([]byte(val), &lock) == nil && lock.OrgID == orgId.(string) {
+			locks = append(locks, lock)
+		}
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   locks,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "GetLoginLocks", "",
+		"search", 0, start_time, GetQueryParams(c), response, "GetLoginLocks Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// unlockLogin lifts a lock of the caller's org and reports it like
+// lockLogin does.
+func unlockLogin(c *gin.Context, funcName string, subject string, lock model.LoginLock) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	id := lock.Username + lock.IP
+
+	val, err := utils.LoginLockGet(subject)
+	status := http.StatusInternalServerError
+	if err == nil {
+		var current model.LoginLock
+		if val == "" || json.Unmarshal([]byte(val), &current) != nil || current.OrgID != orgId.(string) {
+			// Clear any delay left behind even when there is no lock.
+			if lock.Kind == "user" {
+				_ = utils.LoginFailDel(subject)
+			}
+			err = fmt.Errorf("no lock found")
+			status = http.StatusNotFound
+		}
+	}
+	if err == nil {
+		_, err = utils.LoginLockDel(subject)
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "Authentication", funcName, "",
+			"unlock", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Login unlocked",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, id, "Authentication", funcName, "",
+		"unlock", 0, start_time, GetQueryParams(c), response, funcName+" Success.",
+	)
+	//=======AUDIT_END=====//
+
+	msg := fmt.Sprintf("ปลดล็อกการเข้าสู่ระบบของบัญชี %s โดย %s", lock.Username, username.(string))
+	if lock.Kind == "ip" {
+		msg = fmt.Sprintf("ปลดล็อกการเข้าสู่ระบบจาก IP %s โดย %s", lock.IP, username.(string))
+	}
+	lock.OrgID = orgId.(string)
+	notifyLoginAdmins(ctx, conn, lock.OrgID, "LOGIN-UNLOCKED", "Unlocked", msg, lock)
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Unlock Login User
+// @description Lifts the lock and clears the failed logins of a username
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Unlock Login User
+// @accept json
+// @produce json
+// @Param username path string true "username"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/login_locks/users/{username} [delete]
+func UnlockLoginUser(c *gin.Context) {
+	orgId := GetVariableFromToken(c, "orgId")
+	if orgId == nil {
+		return
+	}
+	target := c.Param("username")
+	unlockLogin(c, "UnlockLoginUser", loginUserSubject(orgId.(string), target),
+		model.LoginLock{Kind: "user", Username: target})
+}
+
+// @summary Unlock Login IP
+// @description Lifts the lock and clears the failed logins of a client address
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Unlock Login IP
+// @accept json
+// @produce json
+// @Param ip path string true "client address"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/login_locks/ips/{ip} [delete]
+func UnlockLoginIP(c *gin.Context) {
+	orgId := GetVariableFromToken(c, "orgId")
+	if orgId == nil {
+		return
+	}
+	target := c.Param("ip")
+	unlockLogin(c, "UnlockLoginIP", loginIPSubject(orgId.(string), target),
+		model.LoginLock{Kind: "ip", IP: target})
+}
@@ -0,0 +1,905 @@
+package handler
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Two-factor login with TOTP. Users may enroll on their own; users of a role
+// with mfaRequired must. When the password is right, or an SSO token has
+// been verified, and the user has, or must have, a second factor, loginUser returns a short lived "mfa" token
+// instead of a session. VerifyMfaLogin trades it and a code for the session;
+// a user who still has to enroll first gets a secret from EnrollMfaLogin and
+// confirms it with the first code in VerifyMfaLogin.
+//
+//	MFA_ISSUER          name shown in authenticator apps (default CMS)
+//	MFA_SECRET_KEY      key that seals TOTP secrets (default SECRET_KEY)
+//	MFA_TOKEN_TIMEOUT   minutes an mfa token is valid (default 5)
+//	MFA_RECOVERY_CODES  recovery codes issued at a time (default 10)
+
+// mfaNow is the clock of the second factor; tests may fix it.
+var mfaNow = time.Now
+
+var (
+	errMfaInvalidCode = errors.New("invalid verification code")
+	errMfaEnabled     = errors.New("two-factor authentication is already enabled")
+	errMfaNotEnrolled = errors.New("two-factor authentication is not enrolled")
+	errMfaRequired    = errors.New("two-factor authentication is required by your role")
+)
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errMfaInvalidCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, errMfaEnabled):
+		return http.StatusConflict
+	case errors.Is(err, errMfaNotEnrolled):
+		return http.StatusBadRequest
+	case errors.Is(err, errMfaRequired):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// TOTP secrets have to be read back to check codes, so unlike passwords they
+// are encrypted rather than hashed.
+func mfaSecretGCM() (cipher.AEAD, error) {
+	key := os.Getenv("MFA_SECRET_KEY")
+	if key == "" {
+		key = os.Getenv("SECRET_KEY")
+	}
+	block, err := aes.NewCipher(deriveKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealMfaSecret(secret string) (string, error) {
+	gcm, err := mfaSecretGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func openMfaSecret(sealed string) (string, error) {
+	gcm, err := mfaSecretGCM()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("sealed secret too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func getUserMfa(ctx context.Context, conn *pgx.Conn, orgId string, username string) (*model.UserMfa, error) {
+	var m model.UserMfa
+	err := conn.QueryRow(ctx, `
+		SELECT "orgId", username, secret, enabled, "recoveryCodes", "lastStep",
+		       "enrolledAt", "createdAt", "updatedAt"
+		FROM public.um_user_mfa WHERE "orgId" = $1 AND username = $2`, orgId, username).Scan(
+		&m.OrgID, &m.Username, &m.Secret, &m.Enabled, &m.RecoveryCodes, &m.LastStep,
+		&m.EnrolledAt, &m.CreatedAt, &m.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// userRequiresMfa tells whether the role of the user has mfaRequired set.
+func userRequiresMfa(ctx context.Context, conn *pgx.Conn, orgId string, username string) (bool, error) {
+	var required bool
+	err := conn.QueryRow(ctx, `
+		SELECT COALESCE(r."mfaRequired", false)
+		FROM public.um_users u
+		JOIN public.um_roles r ON r.id = u."roleId" AND r."orgId" = u."orgId"
+		WHERE u."orgId" = $1 AND u.username = $2`, orgId, username).Scan(&required)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return required, err
+}
+
+// createMfaToken signs the token that stands for a correct password until
+// the second factor is given. It has no session id, so ProtectedHandler
+// refuses it.
+func createMfaToken(username string, orgId string) (string, error) {
+	ttl := time.Duration(utils.EnvInt("MFA_TOKEN_TIMEOUT", 5)) * time.Minute
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
+		jwt.MapClaims{
+			"username": username,
+			"orgId":    orgId,
+			"typ":      "mfa",
+			"exp":      mfaNow().Add(ttl).Unix(),
+		})
+	return token.SignedString([]byte(os.Getenv("TOKEN_SECRET_KEY")))
+}
+
+func verifyMfaToken(tokenString string) (string, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("TOKEN_SECRET_KEY")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithTimeFunc(mfaNow))
+	if err != nil {
+		return "", "", err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	typ, _ := claims["typ"].(string)
+	username, _ := claims["username"].(string)
+	orgId, _ := claims["orgId"].(string)
+	if typ != "mfa" || username == "" || orgId == "" {
+		return "", "", fmt.Errorf("not an mfa token")
+	}
+	return username, orgId, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns codes like "abcd-efgh-ijkl-mnop" and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	n := utils.EnvInt("MFA_RECOVERY_CODES", 10)
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))
+		code := s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// beginMfaEnrollment stores a new secret, not yet enabled, for the user.
+// Calling it again before confirming replaces the secret.
+func beginMfaEnrollment(ctx context.Context, conn *pgx.Conn, orgId string, username string) (model.MfaEnrollment, error) {
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return model.MfaEnrollment{}, err
+	}
+	sealed, err := sealMfaSecret(secret)
+	if err != nil {
+		return model.MfaEnrollment{}, err
+	}
+	tag, err := conn.Exec(ctx, `
+		INSERT INTO public.um_user_mfa ("orgId", username, secret, enabled, "recoveryCodes", "lastStep", "createdAt", "updatedAt")
+		VALUES ($1, $2, $3, false, '[]'::jsonb, 0, $4, $4)
+		ON CONFLICT ("orgId", username) DO UPDATE
+		SET secret = EXCLUDED.secret, "updatedAt" = EXCLUDED."updatedAt"
+		WHERE um_user_mfa.enabled = false`, orgId, username, sealed, mfaNow())
+	if err != nil {
+		return model.MfaEnrollment{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.MfaEnrollment{}, errMfaEnabled
+	}
+	issuer := strings.TrimSpace(os.Getenv("MFA_ISSUER"))
+	if issuer == "" {
+		issuer = "CMS"
+	}
+	return model.MfaEnrollment{Secret: secret, OtpauthURI: utils.TOTPURI(issuer, username, secret)}, nil
+}
+
+// confirmMfaEnrollment enables the pending secret when code matches it and
+// returns the first recovery codes.
+func confirmMfaEnrollment(ctx context.Context, conn *pgx.Conn, orgId string, username string, code string) ([]string, error) {
+	m, err := getUserMfa(ctx, conn, orgId, username)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errMfaNotEnrolled
+	}
+	if m.Enabled {
+		return nil, errMfaEnabled
+	}
+	secret, err := openMfaSecret(m.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok, err := utils.TOTPValidate(secret, code, mfaNow(), 1)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errMfaInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashJSON, _ := json.Marshal(hashes)
+	now := mfaNow()
+	tag, err := conn.Exec(ctx, `
+		UPDATE public.um_user_mfa
+		SET enabled = true, "recoveryCodes" = $3::jsonb, "lastStep" = $4, "enrolledAt" = $5, "updatedAt" = $5
+		WHERE "orgId" = $1 AND username = $2 AND enabled = false`,
+		orgId, username, string(hashJSON), step, now)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errMfaEnabled
+	}
+	return codes, nil
+}
+
+// validateMfaCode returns the time step code matches, allowing one step of
+// clock drift. A step at or before lastStep has been used already.
+func validateMfaCode(secret string, code string, lastStep int64) (int64, error) {
+	step, ok, err := utils.TOTPValidate(secret, code, mfaNow(), 1)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || step <= lastStep {
+		return 0, errMfaInvalidCode
+	}
+	return step, nil
+}
+
+// checkMfaCode accepts a TOTP code not used before, or uses up a recovery
+// code.
+func checkMfaCode(ctx context.Context, conn *pgx.Conn, m *model.UserMfa, code string, recoveryCode string) error {
+	if recoveryCode != "" {
+		hash := hashRecoveryCode(recoveryCode)
+		tag, err := conn.Exec(ctx, `
+			UPDATE public.um_user_mfa
+			SET "recoveryCodes" = "recoveryCodes" - $3::text, "updatedAt" = $4
+			WHERE "orgId" = $1 AND username = $2 AND enabled = true
+			  AND jsonb_exists("recoveryCodes", $3::text)`,
+			m.OrgID, m.Username, hash, mfaNow())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errMfaInvalidCode
+		}
+		return nil
+	}
+
+	secret, err := openMfaSecret(m.Secret)
+	if err != nil {
+		return err
+	}
+	step, err := validateMfaCode(secret, code, m.LastStep)
+	if err != nil {
+		return err
+	}
+	tag, err := conn.Exec(ctx, `
+		UPDATE public.um_user_mfa SET "lastStep" = $3, "updatedAt" = $4
+		WHERE "orgId" = $1 AND username = $2 AND enabled = true AND "lastStep" < $3`,
+		m.OrgID, m.Username, step, mfaNow())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// The code was right but its time step has been used already.
+		return errMfaInvalidCode
+	}
+	return nil
+}
+
+// startMfaLogin is called by loginUser once the password is right, or once
+// the auth server has vouched for the user. pending is true, with the
+// response to send, when the user still has to give a second factor.
+func startMfaLogin(ctx context.Context, c *gin.Context, conn *pgx.Conn, orgId string, user model.Um_User_Login) (model.Response, bool, error) {
+	required, err := userRequiresMfa(ctx, conn, orgId, user.Username)
+	if err != nil {
+		return model.Response{Status: "-1", Msg: "Failure", Desc: "Two-factor check failed"}, false, err
+	}
+	m, err := getUserMfa(ctx, conn, orgId, user.Username)
+	if err != nil {
+		return model.Response{Status: "-1", Msg: "Failure", Desc: "Two-factor check failed"}, false, err
+	}
+	enrolled := m != nil && m.Enabled
+	if !required && !enrolled {
+		return model.Response{}, false, nil
+	}
+	token, err := createMfaToken(user.Username, orgId)
+	if err != nil {
+		return model.Response{Status: "-1", Msg: "Failure", Desc: "Token creation failed"}, false, err
+	}
+	return model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Two-factor authentication required",
+		Data: map[string]any{
+			"mfaRequired": true,
+			"mfaEnrolled": enrolled,
+			"mfaToken":    token,
+			"token_type":  "mfa",
+		},
+	}, true, nil
+}
+
+// @summary Verify MFA Login
+// @description Second login step. Takes the mfaToken from login and a TOTP code or a recovery code, and returns the tokens of a new session. A user enrolling during login sends the first code of the new secret and also gets recovery codes.
+// @tags Authentication
+// @id Verify MFA Login
+// @accept json
+// @produce json
+// @param Body body model.MfaVerifyInput true "mfa token and code"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/auth/mfa/verify [post]
+func VerifyMfaLogin(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+
+	fail := func(status int, orgId string, username string, desc string, msg string) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   desc,
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId, username,
+			txtId, "", "Authentication", "VerifyMfaLogin", "",
+			"login", -1, start_time, GetQueryParams(c), response, msg,
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.MfaVerifyInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, "", "", err.Error(), "Failed : "+err.Error())
+		return
+	}
+	username, orgId, err := verifyMfaToken(req.MfaToken)
+	if err != nil {
+		fail(http.StatusUnauthorized, "", "", "Invalid or expired mfa token", "Failed : "+err.Error())
+		return
+	}
+	ip := c.ClientIP()
+	if err := checkLoginThrottle(orgId, username, ip); err != nil {
+		fail(loginFailedStatus(c, err), orgId, username, err.Error(), "throttled")
+		return
+	}
+	user, err := findLoginUser(ctx, conn, orgId, username)
+	if err != nil {
+		fail(http.StatusUnauthorized, orgId, username, "User not found or inactive", "Failed : "+err.Error())
+		return
+	}
+	m, err := getUserMfa(ctx, conn, orgId, username)
+	var recoveryCodes []string
+	switch {
+	case err != nil:
+	case m != nil && m.Enabled:
+		err = checkMfaCode(ctx, conn, m, req.Code, req.RecoveryCode)
+	case req.Code != "":
+		recoveryCodes, err = confirmMfaEnrollment(ctx, conn, orgId, username, req.Code)
+	default:
+		err = errMfaNotEnrolled
+	}
+	if err != nil {
+		if errors.Is(err, errMfaInvalidCode) {
+			recordLoginFailure(ctx, c, conn, orgId, username, ip)
+		}
+		fail(mfaErrorStatus(err), orgId, username, err.Error(), "Failed : "+err.Error())
+		return
+	}
+	recordLoginSuccess(orgId, username)
+
+	resp, err := completeLogin(ctx, c, conn, orgId, user, txtId, start_time)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, resp)
+		return
+	}
+	if data, ok := resp.Data.(map[string]any); ok && recoveryCodes != nil {
+		data["recoveryCodes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// @summary Enroll MFA Login
+// @description For a user whose role requires two-factor authentication but who has not enrolled yet. Takes the mfaToken from login and returns a new TOTP secret; the first code from it goes to Verify MFA Login.
+// @tags Authentication
+// @id Enroll MFA Login
+// @accept json
+// @produce json
+// @param Body body model.MfaTokenInput true "mfa token"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/auth/mfa/enroll [post]
+func EnrollMfaLogin(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+
+	fail := func(status int, orgId string, username string, desc string, msg string) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   desc,
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId, username,
+			txtId, "", "Authentication", "EnrollMfaLogin", "",
+			"create", -1, start_time, GetQueryParams(c), response, msg,
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.MfaTokenInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, "", "", err.Error(), "Failed : "+err.Error())
+		return
+	}
+	username, orgId, err := verifyMfaToken(req.MfaToken)
+	if err != nil {
+		fail(http.StatusUnauthorized, "", "", "Invalid or expired mfa token", "Failed : "+err.Error())
+		return
+	}
+	enrollment, err := beginMfaEnrollment(ctx, conn, orgId, username)
+	if err != nil {
+		fail(mfaErrorStatus(err), orgId, username, err.Error(), "Failed : "+err.Error())
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   enrollment,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId, username,
+		txtId, "", "Authentication", "EnrollMfaLogin", "",
+		"create", 0, start_time, GetQueryParams(c), model.Response{Status: "0", Msg: "Success"}, "EnrollMfaLogin Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Get My MFA
+// @description Two-factor status of the signed in user
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Get My MFA
+// @accept json
+// @produce json
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/mfa [get]
+func GetMyMfa(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var status model.MfaStatus
+	m, err := getUserMfa(ctx, conn, orgId.(string), username.(string))
+	if err == nil {
+		status.Required, err = userRequiresMfa(ctx, conn, orgId.(string), username.(string))
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "GetMyMfa", "",
+			"search", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	if m != nil && m.Enabled {
+		status.Enabled = true
+		status.RecoveryCodesLeft = len(m.RecoveryCodes)
+		status.EnrolledAt = m.EnrolledAt
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   status,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "GetMyMfa", "",
+		"search", 0, start_time, GetQueryParams(c), response, "GetMyMfa Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Enroll MFA
+// @description Starts enrollment of the signed in user and returns a new TOTP secret and otpauth URI. Nothing changes at login until Confirm MFA.
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Enroll MFA
+// @accept json
+// @produce json
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/mfa/enroll [post]
+func EnrollMfa(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	enrollment, err := beginMfaEnrollment(ctx, conn, orgId.(string), username.(string))
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "EnrollMfa", "",
+			"create", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(mfaErrorStatus(err), response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   enrollment,
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "EnrollMfa", "",
+		"create", 0, start_time, GetQueryParams(c), model.Response{Status: "0", Msg: "Success"}, "EnrollMfa Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Confirm MFA
+// @description Enables the secret from Enroll MFA with its current code and returns recovery codes, shown only this once
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Confirm MFA
+// @accept json
+// @produce json
+// @param Body body model.MfaCodeInput true "TOTP code"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/mfa/enroll/confirm [post]
+func ConfirmMfa(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.MfaCodeInput
+	var codes []string
+	err := c.ShouldBindJSON(&req)
+	status := http.StatusBadRequest
+	if err == nil {
+		codes, err = confirmMfaEnrollment(ctx, conn, orgId.(string), username.(string), req.Code)
+		status = mfaErrorStatus(err)
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "ConfirmMfa", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   gin.H{"recoveryCodes": codes},
+		Desc:   "Two-factor authentication enabled",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "ConfirmMfa", "",
+		"update", 0, start_time, GetQueryParams(c), model.Response{Status: "0", Msg: "Success"}, "ConfirmMfa Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Regenerate MFA Recovery Codes
+// @description Replaces the recovery codes of the signed in user after checking a TOTP code
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Regenerate MFA Recovery Codes
+// @accept json
+// @produce json
+// @param Body body model.MfaCodeInput true "TOTP code"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/mfa/recovery_codes [post]
+func RegenerateMfaRecoveryCodes(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.MfaCodeInput
+	var m *model.UserMfa
+	var codes []string
+	err := c.ShouldBindJSON(&req)
+	status := http.StatusBadRequest
+	if err == nil {
+		m, err = getUserMfa(ctx, conn, orgId.(string), username.(string))
+		if err == nil && (m == nil || !m.Enabled) {
+			err = errMfaNotEnrolled
+		}
+		if err == nil {
+			err = checkMfaCode(ctx, conn, m, req.Code, "")
+		}
+		var hashes []string
+		if err == nil {
+			codes, hashes, err = newRecoveryCodes()
+		}
+		if err == nil {
+			hashJSON, _ := json.Marshal(hashes)
+			_, err = conn.Exec(ctx, `
+				UPDATE public.um_user_mfa SET "recoveryCodes" = $3::jsonb, "updatedAt" = $4
+				WHERE "orgId" = $1 AND username = $2`,
+				orgId, username, string(hashJSON), mfaNow())
+		}
+		status = mfaErrorStatus(err)
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "RegenerateMfaRecoveryCodes", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   gin.H{"recoveryCodes": codes},
+		Desc:   "",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "RegenerateMfaRecoveryCodes", "",
+		"update", 0, start_time, GetQueryParams(c), model.Response{Status: "0", Msg: "Success"}, "RegenerateMfaRecoveryCodes Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Disable MFA
+// @description Turns two-factor authentication off for the signed in user after checking a TOTP code. Not allowed when the role requires it.
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Disable MFA
+// @accept json
+// @produce json
+// @param Body body model.MfaCodeInput true "TOTP code"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/mfa [delete]
+func DisableMfa(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.MfaCodeInput
+	var m *model.UserMfa
+	err := c.ShouldBindJSON(&req)
+	status := http.StatusBadRequest
+	if err == nil {
+		var required bool
+		required, err = userRequiresMfa(ctx, conn, orgId.(string), username.(string))
+		if err == nil && required {
+			err = errMfaRequired
+		}
+		if err == nil {
+			m, err = getUserMfa(ctx, conn, orgId.(string), username.(string))
+		}
+		if err == nil && (m == nil || !m.Enabled) {
+			err = errMfaNotEnrolled
+		}
+		if err == nil {
+			err = checkMfaCode(ctx, conn, m, req.Code, "")
+		}
+		if err == nil {
+			_, err = conn.Exec(ctx, `DELETE FROM public.um_user_mfa WHERE "orgId" = $1 AND username = $2`, orgId, username)
+		}
+		status = mfaErrorStatus(err)
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "Authentication", "DisableMfa", "",
+			"delete", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Two-factor authentication disabled",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, "", "Authentication", "DisableMfa", "",
+		"delete", 0, start_time, GetQueryParams(c), response, "DisableMfa Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Reset User MFA
+// @description Removes the second factor of a user who lost it. A user whose role requires one enrolls again at next login.
+// @tags Authentication
+// @security ApiKeyAuth
+// @id Reset User MFA
+// @accept json
+// @produce json
+// @Param username path string true "username"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/users/username/{username}/mfa [delete]
+func ResetUserMfa(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	target := c.Param("username")
+
+	tag, err := conn.Exec(ctx, `DELETE FROM public.um_user_mfa WHERE "orgId" = $1 AND username = $2`, orgId, target)
+	status := http.StatusInternalServerError
+	if err == nil && tag.RowsAffected() == 0 {
+		err = errMfaNotEnrolled
+		status = http.StatusNotFound
+	}
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, target, "Authentication", "ResetUserMfa", "",
+			"delete", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Two-factor authentication reset",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, target, "Authentication", "ResetUserMfa", "",
+		"delete", 0, start_time, GetQueryParams(c), response, "ResetUserMfa Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
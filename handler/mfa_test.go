@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"errors"
+	"mainPackage/utils"
+	"testing"
+	"time"
+)
+
+// setMfaNow fixes the clock of the second factor for one test.
+func setMfaNow(t *testing.T, now *time.Time) {
+	prev := mfaNow
+	mfaNow = func() time.Time { return *now }
+	t.Cleanup(func() { mfaNow = prev })
+}
+
+func TestValidateMfaCodeReplay(t *testing.T) {
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	setMfaNow(t, &now)
+	code := func(t0 time.Time) string {
+		c, err := utils.TOTPCode(secret, t0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	step := utils.TOTPStep(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     int64
+		wantErr  bool
+	}{
+		{"fresh code", code(now), step - 5, step, false},
+		{"same step again", code(now), step, 0, true},
+		{"later step used already", code(now), step + 1, 0, true},
+		{"previous step not used yet", code(now.Add(-30 * time.Second)), step - 2, step - 1, false},
+		{"previous step used already", code(now.Add(-30 * time.Second)), step - 1, 0, true},
+		{"wrong code", "abcdef", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateMfaCode(secret, tt.code, tt.lastStep)
+			if tt.wantErr {
+				if !errors.Is(err, errMfaInvalidCode) {
+					t.Errorf("err = %v, want errMfaInvalidCode", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("step = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("abcd-efgh-ijkl-mnop")
+	if len(want) != 64 {
+		t.Fatalf("hash length = %d, want 64", len(want))
+	}
+	tests := []struct {
+		code string
+		same bool
+	}{
+		{"abcd-efgh-ijkl-mnop", true},
+		{"ABCD-EFGH-IJKL-MNOP", true},
+		{"abcdefghijklmnop", true},
+		{"abcd efgh ijkl mnop", true},
+		{" abcd-efgh-ijkl-mnop ", true},
+		{"abcd-efgh-ijkl-mnoq", false},
+		{"abcd-efgh-ijkl", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := hashRecoveryCode(tt.code) == want; got != tt.same {
+				t.Errorf("hash matches = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestMfaTokenExpiry(t *testing.T) {
+	t.Setenv("TOKEN_SECRET_KEY", "test-secret")
+	t.Setenv("MFA_TOKEN_TIMEOUT", "5")
+	t.Setenv("TOKEN_TIMEOUT", "60")
+	issued := time.Unix(1700000000, 0)
+	now := issued
+	setMfaNow(t, &now)
+
+	token, err := createMfaToken("alice", "org-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		after   time.Duration
+		wantErr bool
+	}{
+		{"just issued", 0, false},
+		{"before expiry", 4*time.Minute + 59*time.Second, false},
+		{"after expiry", 5*time.Minute + time.Second, true},
+		{"much later", time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = issued.Add(tt.after)
+			username, orgId, err := verifyMfaToken(token)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expired token accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username != "alice" || orgId != "org-1" {
+				t.Errorf("got %s/%s, want alice/org-1", username, orgId)
+			}
+		})
+	}
+
+	now = issued
+	session, _, err := CreateToken("alice", "org-1", "session-1", "refresh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verifyMfaToken(session); err == nil {
+		t.Error("session token accepted as mfa token")
+	}
+}
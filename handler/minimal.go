@@ -31,7 +31,6 @@ func MinimalCreateCase(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.MinimalCaseInsert
@@ -151,7 +150,7 @@ func MinimalCreateCase(c *gin.Context) {
 	}
 	additionalJSON, err := json.Marshal(additionalJsonMap)
 	if err != nil {
-		log.Printf("covent additionalData Error :", err)
+		log.Printf("covent additionalData Error : %v", err)
 	}
 	additionalData := json.RawMessage(additionalJSON)
 	genNotiCustom(c, conn, orgId, "System", "MEETRIQ", "", "Create", data, "เปิด Case สำเร็จ : "+caseId, recipients, "", "User", event, &additionalData)
@@ -186,23 +185,31 @@ func MinCaseCurrentStageInsert(username string, orgId string, conn *pgx.Conn, ct
 		return err
 	}
 
-	// Step 2: Load workflow node from DB
+	// Step 2: Load workflow node of the version the case is pinned to
+	wfId := ""
+	if req.WfID != nil {
+		wfId = *req.WfID
+	}
+	version, err := pinCaseWorkflow(ctx, conn, orgId, req.CaseID, wfId, username)
+	if err != nil {
+		return fmt.Errorf("pin workflow version: %w", err)
+	}
 	query := `
 	SELECT t1.id, t1."orgId", t1."wfId", t1."nodeId", t1.versions, t1.type, t1.section, t1.data,
 	       t1.pic, t1."group", t1."formId", t1."createdAt", t1."updatedAt", t1."createdBy", t1."updatedBy"
 	FROM public.wf_nodes t1
 	JOIN public.wf_definitions t2
-	  ON t1."versions" = t2."versions" AND t1."wfId" = t2."wfId"
-	WHERE t2."wfId" = $1 AND t1."nodeId" = $2 AND t2."orgId" = $3
+	  ON t1."wfId" = t2."wfId"
+	WHERE t2."wfId" = $1 AND t1."nodeId" = $2 AND t2."orgId" = $3 AND t1."versions" = $4
 	`
 
 	logger.Debug("Loading workflow node",
 		zap.String("query", query),
-		zap.Any("params", []any{req.WfID, req.NodeID, orgId}),
+		zap.Any("params", []any{req.WfID, req.NodeID, orgId, version}),
 	)
 
 	var workflow model.WfNode
-	err = conn.QueryRow(ctx, query, req.WfID, req.NodeID, orgId).Scan(
+	err = conn.QueryRow(ctx, query, req.WfID, req.NodeID, orgId, version).Scan(
 		&workflow.ID, &workflow.OrgID, &workflow.WfID, &workflow.NodeID,
 		&workflow.Versions, &workflow.Type, &workflow.Section,
 		&workflow.Data, &workflow.Pic, &workflow.Group, &workflow.FormID,
@@ -31,7 +31,6 @@ func GetMmdProperty(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -152,7 +151,6 @@ func GetMmdPropertyById(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT  id, "propId", "orgId", en, th, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.mdm_properties WHERE id=$1 AND "orgId"=$2`
 
@@ -211,7 +209,6 @@ func InsertMmdProperty(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.MmdPropertyInsert
@@ -296,7 +293,6 @@ func UpdateMmdProperty(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -390,7 +386,6 @@ func DeleteMmdProperty(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	start_time := time.Now()
@@ -451,7 +446,6 @@ func GetMmdUnitSources(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -573,7 +567,6 @@ func GetMmdUnitSourcesById(c *gin.Context) {
 	txtId := uuid.New().String()
 
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT  id, "unitSourceId", "orgId", en, th, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.mdm_unit_sources WHERE id=$1 AND "orgId"=$2`
 
@@ -632,7 +625,6 @@ func InsertMmdUnitSources(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.MmdUnitSourceInsert
@@ -713,7 +705,6 @@ func UpdateMmdUnitSources(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -796,7 +787,6 @@ func DeleteMmdUnitSources(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	start_time := time.Now()
@@ -856,7 +846,6 @@ func GetMmdUnitType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -976,7 +965,6 @@ func GetMmdUnitTypeById(c *gin.Context) {
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT  id, "unitTypeId", "orgId", en, th, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.mdm_unit_types WHERE id=$1 AND "orgId"=$2`
 
@@ -1035,7 +1023,6 @@ func InsertMmdUnitType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.MmdUnitTypeInsert
@@ -1116,7 +1103,6 @@ func UpdateMmdUnitType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -1210,7 +1196,6 @@ func DeleteMmdUnitType(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	start_time := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1269,7 +1254,6 @@ func GetMmdCompanies(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -1405,7 +1389,6 @@ func GetMmdCompaniesById(c *gin.Context) {
 	}
 	// orgId := GetVariableFromToken(c, "orgId")
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, name, "legalName", domain, email, "phoneNumber", address, "logoUrl", "websiteUrl", description, "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.mdm_companies WHERE id=$1`
 
@@ -1478,7 +1461,6 @@ func InsertMmdCompanies(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.MmdCompaniesInsert
@@ -1560,7 +1542,6 @@ func UpdateMmdCompanies(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	start_time := time.Now()
@@ -1655,7 +1636,6 @@ func DeleteMmdCompanies(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	// orgId := GetVariableFromToken(c, "orgId")
 	id := c.Param("id")
 	start_time := time.Now()
@@ -1715,7 +1695,6 @@ func GetMmdUnitStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -1843,7 +1822,6 @@ func GetMmdUnitStatusById(c *gin.Context) {
 	}
 	// orgId := GetVariableFromToken(c, "orgId")
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, "sttId", "sttName", "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.mdm_unit_statuses WHERE id=$1`
 
@@ -1909,7 +1887,6 @@ func InsertMmdUnitStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.MmdUnitStatusInsert
 	start_time := time.Now()
@@ -1999,7 +1976,6 @@ func UpdateMmdUnitStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	now := time.Now()
@@ -2090,7 +2066,6 @@ func DeleteMmdUnitStatus(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	// orgId := GetVariableFromToken(c, "orgId")
 	id := c.Param("id")
 	now := time.Now()
@@ -2151,7 +2126,6 @@ func GetMmdUnit(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -2308,7 +2282,6 @@ func GetMmdUnitById(c *gin.Context) {
 	}
 
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT id, "orgId", "unitId", "unitName", "unitSourceId", "unitTypeId", priority, "compId", "deptId", "commId", "stnId", "plateNo", "provinceCode", active, username, "isLogin", "isFreeze", "isOutArea", "locLat", "locLon", "locAlt", "locBearing", "locSpeed", "locProvider", "locGpsTime", "locSatellites", "locAccuracy", "locLastUpdateTime", "breakDuration", "healthChk", "healthChkTime", "sttId", "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.mdm_units WHERE id=$1 AND "orgId"=$2`
 
@@ -2403,7 +2376,6 @@ func InsertMmdUnit(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
@@ -2524,7 +2496,6 @@ func UpdateMmdUnit(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	now := time.Now()
@@ -2627,7 +2598,6 @@ func DeleteMmdUnit(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -2688,7 +2658,6 @@ func GetMmdUnitWithProperty(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -0,0 +1,427 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// AutoDispatchMonitor dispatches cases whose next workflow node is a dispatch
+// node configured with "autoDispatch": true. The node config may also set
+// "ackTimeout" (minutes) and "maxLoad" (unit stages a unit may already hold).
+// A unit that does not acknowledge before the timeout is cancelled and the next
+// candidate is dispatched; once no candidate is left the case is left for
+// manual dispatch. Only the replica holding the AutoDispatchMonitor lease
+// picks units, and its writes are fenced by the lease, so a case never gets
+// two decisions at once.
+func AutoDispatchMonitor(ctx context.Context) error {
+	MONITOR_NAME := os.Getenv("MONITOR_AUTO_DISPATCH_NAME")
+	orgId := os.Getenv("INTEGRATION_ORG_ID")
+	holder := leaseHolder()
+
+	log.Printf("Starting auto dispatch monitor on host: %s", holder)
+	for {
+		ran, err := runWithLease(ctx, "AutoDispatchMonitor", holder, func(leaseCtx context.Context, fence utils.LeaseFence) error {
+			if err := checkAutoDispatchAck(leaseCtx, fence, orgId, MONITOR_NAME); err != nil {
+				log.Printf("Auto dispatch ack check error: %v", err)
+			}
+			return runAutoDispatch(leaseCtx, fence, orgId, MONITOR_NAME)
+		})
+		if err != nil {
+			log.Printf("Auto dispatch error: %v", err)
+		} else if !ran {
+			log.Printf("Auto dispatch lease held by another host (this host: %s)", holder)
+		}
+
+		interval := utils.EnvInt("MONITOR_AUTO_DISPATCH_INTERVAL", 30)
+		if !sleepCtx(ctx, time.Duration(interval)*time.Second) {
+			return nil
+		}
+	}
+}
+
+// runAutoDispatch picks up NEW cases without any unit stage and dispatches the
+// ones whose next node is an auto dispatch node, or that were handed over
+// with Forced set by the schedule monitor.
+func runAutoDispatch(leaseCtx context.Context, fence utils.LeaseFence, orgId string, username string) error {
+	var caseIds []string
+	err := withMonitorConn(leaseCtx, orgId, username, func(c *gin.Context, conn *pgx.Conn) error {
+		query := `
+		SELECT c."caseId"
+		FROM public.tix_cases c
+		JOIN public.tix_case_current_stage s
+		  ON s."caseId" = c."caseId" AND s."stageType" = 'case' AND s."unitId" = ''
+		WHERE c."orgId" = $1
+		  AND c."statusId" = $2
+		  AND (c."scheduleFlag" IS NOT TRUE OR c."scheduleDate" <= NOW())
+		  AND NOT EXISTS (
+		      SELECT 1 FROM public.tix_case_current_stage u
+		      WHERE u."caseId" = c."caseId" AND u."stageType" = 'unit'
+		  )`
+		rows, err := conn.Query(c, query, orgId, os.Getenv("NEW"))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var caseId string
+			if err := rows.Scan(&caseId); err != nil {
+				return err
+			}
+			caseIds = append(caseIds, caseId)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, caseId := range caseIds {
+		if err := leaseCtx.Err(); err != nil {
+			return err
+		}
+		var state model.AutoDispatchState
+		if raw, _ := utils.AutoDispatchGet(caseId); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &state); err == nil {
+				// exhausted is final: the case waits for manual dispatch.
+				if state.Status == "waiting" || state.Status == "exhausted" {
+					continue
+				}
+			}
+		}
+
+		err := withMonitorConn(leaseCtx, orgId, username, func(c *gin.Context, conn *pgx.Conn) error {
+			dispatchNode, config, err := nextAutoDispatchNode(c, conn, orgId, caseId, state.Forced)
+			if err != nil || dispatchNode == nil {
+				return err
+			}
+			state = model.AutoDispatchState{OrgID: orgId, CaseID: caseId, NodeID: dispatchNode.NodeId, Forced: state.Forced}
+			return dispatchNextCandidate(c, conn, fence, &state, *dispatchNode, config, username)
+		})
+		if errors.Is(err, utils.ErrStaleFence) {
+			return fmt.Errorf("auto dispatch stopped: %w", err)
+		}
+		if err != nil {
+			log.Printf("Auto dispatch %s: %v", caseId, err)
+		}
+	}
+	return nil
+}
+
+// checkAutoDispatchAck follows up cases waiting for a unit to acknowledge, and
+// forgets exhausted cases once they no longer wait for a unit.
+func checkAutoDispatchAck(leaseCtx context.Context, fence utils.LeaseFence, orgId string, username string) error {
+	states, err := utils.GetAllAutoDispatch(leaseCtx)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range states {
+		if err := leaseCtx.Err(); err != nil {
+			return err
+		}
+		var state model.AutoDispatchState
+		if err := json.Unmarshal([]byte(item.Value), &state); err != nil {
+			continue
+		}
+		var err error
+		switch state.Status {
+		case "waiting":
+			err = withMonitorConn(leaseCtx, orgId, username, func(c *gin.Context, conn *pgx.Conn) error {
+				return followAutoDispatch(c, conn, fence, state, orgId, username)
+			})
+		case "exhausted":
+			err = withMonitorConn(leaseCtx, orgId, username, func(c *gin.Context, conn *pgx.Conn) error {
+				pending, err := awaitingDispatch(c, conn, orgId, state.CaseID)
+				if err != nil || pending {
+					return err
+				}
+				return utils.AutoDispatchDelFenced(fence, state.CaseID)
+			})
+		default:
+			continue
+		}
+		if errors.Is(err, utils.ErrStaleFence) {
+			return fmt.Errorf("auto dispatch check stopped: %w", err)
+		}
+		if err != nil {
+			log.Printf("Auto dispatch %s: %v", state.CaseID, err)
+		}
+	}
+	return nil
+}
+
+// awaitingDispatch tells whether a case is still NEW without any unit stage.
+func awaitingDispatch(ctx context.Context, conn *pgx.Conn, orgId string, caseId string) (bool, error) {
+	var pending bool
+	err := conn.QueryRow(ctx, `
+		SELECT EXISTS (
+		    SELECT 1 FROM public.tix_cases c
+		    WHERE c."orgId" = $1 AND c."caseId" = $2 AND c."statusId" = $3
+		      AND NOT EXISTS (
+		          SELECT 1 FROM public.tix_case_current_stage u
+		          WHERE u."caseId" = c."caseId" AND u."stageType" = 'unit'
+		      )
+		)`, orgId, caseId, os.Getenv("NEW")).Scan(&pending)
+	return pending, err
+}
+
+// followAutoDispatch checks one waiting case: it clears the state once the
+// unit has acknowledged or was cancelled, and moves on to the next candidate
+// when the unit timed out.
+func followAutoDispatch(c *gin.Context, conn *pgx.Conn, fence utils.LeaseFence, state model.AutoDispatchState, orgId string, username string) error {
+	_, count, err := GetUnits(c, conn, orgId, state.CaseID, os.Getenv("ASSIGNED"), state.UnitID)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		// The unit moved on (acknowledged) or was cancelled by an operator.
+		_, remain, _ := GetUnitsWithDispatch(c, conn, orgId, state.CaseID, "", state.UnitID)
+		decision := "acknowledged"
+		if remain == 0 {
+			decision = "cancelled"
+		}
+		err := withFencedTx(c, conn, fence, func() error {
+			return recordAutoDispatch(c, conn, state, username, decision,
+				fmt.Sprintf("Auto dispatch: unit %s %s", state.UnitID, decision), nil)
+		})
+		if err != nil {
+			return err
+		}
+		return utils.AutoDispatchDelFenced(fence, state.CaseID)
+	}
+
+	if time.Now().Before(state.Deadline) {
+		return nil
+	}
+
+	err = withFencedTx(c, conn, fence, func() error {
+		if err := recordAutoDispatch(c, conn, state, username, "timeout",
+			fmt.Sprintf("Auto dispatch: unit %s did not acknowledge in time", state.UnitID), nil); err != nil {
+			return err
+		}
+		cancelReq := model.CancelUnitRequest{
+			CaseId:    state.CaseID,
+			UnitId:    state.UnitID,
+			UnitUser:  state.UnitUser,
+			ResDetail: "Auto dispatch acknowledge timeout",
+		}
+		if err := DispatchCancelUnitCore(c, conn, cancelReq, orgId, username); err != nil {
+			return fmt.Errorf("cancel unit failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dispatchNode, config, err := nextAutoDispatchNode(c, conn, orgId, state.CaseID, state.Forced)
+	if err != nil || dispatchNode == nil {
+		return utils.AutoDispatchDelFenced(fence, state.CaseID)
+	}
+	return dispatchNextCandidate(c, conn, fence, &state, *dispatchNode, config, username)
+}
+
+// nextAutoDispatchNode returns the dispatch node the case moves to next when
+// that node has auto dispatch enabled, or whatever its config when force is
+// set.
+func nextAutoDispatchNode(c *gin.Context, conn *pgx.Conn, orgId string, caseId string, force bool) (*model.WorkflowNode, map[string]interface{}, error) {
+	logger := utils.GetLog()
+	var caseStage model.CurrentStage
+	err := conn.QueryRow(c, `
+		SELECT "wfId", "nodeId", "versions"
+		FROM public.tix_case_current_stage
+		WHERE "orgId" = $1 AND "caseId" = $2 AND "stageType" = 'case' AND "unitId" = ''`,
+		orgId, caseId).Scan(&caseStage.WfID, &caseStage.NodeId, &caseStage.Versions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, nodeConn, allNodesId, _, err := GetAllNodes(c, conn, orgId, caseStage.WfID, caseStage.Versions, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	caseData, err := GetCaseByID(c, conn, orgId, caseId)
+	if err != nil {
+		return nil, nil, err
+	}
+	decisions := newDecisionEvaluator(c, conn, orgId, caseData)
+	caseNext, _, _, _ := GetNextNode(allNodesId, nodeConn, caseStage, model.CurrentStage{}, logger, func(node model.WorkflowNode) model.WorkflowNode {
+		return decisions.Decide(node, nodeConn, allNodesId)
+	})
+	if decisions.Err != nil {
+		return nil, nil, decisions.Err
+	}
+	if caseNext.Type != "dispatch" {
+		return nil, nil, nil
+	}
+
+	config := nodeConfig(caseNext)
+	if enabled, _ := config["autoDispatch"].(bool); !enabled && !force {
+		return nil, nil, nil
+	}
+	return &caseNext, config, nil
+}
+
+// dispatchNextCandidate dispatches the best eligible unit that has not been
+// tried yet for this case and stores the waiting state. When none is left the
+// case is marked exhausted, once, and left for manual dispatch. Every write is
+// fenced by the AutoDispatchMonitor lease.
+func dispatchNextCandidate(c *gin.Context, conn *pgx.Conn, fence utils.LeaseFence, state *model.AutoDispatchState, dispatchNode model.WorkflowNode, config map[string]interface{}, username string) error {
+	criteria, err := LoadDispatchCriteria(c, conn, state.OrgID, state.CaseID, dispatchNode.NodeId)
+	if err != nil {
+		return err
+	}
+	units, err := LoadUnitCandidates(c, conn, state.OrgID, *criteria)
+	if err != nil {
+		return err
+	}
+	ranked := RankUnitCandidates(units, *criteria, time.Now())
+
+	tried := make(map[string]bool, len(state.Tried))
+	for _, id := range state.Tried {
+		tried[id] = true
+	}
+
+	var chosen *model.UnitCandidate
+	for i := range ranked {
+		u := &ranked[i]
+		if !u.Eligible || tried[u.UnitID] {
+			continue
+		}
+		chosen = u
+		break
+	}
+
+	if chosen == nil {
+		state.Status = "exhausted"
+		state.UnitID = ""
+		state.UnitUser = ""
+		state.Deadline = time.Time{}
+		err := withFencedTx(c, conn, fence, func() error {
+			return recordAutoDispatch(c, conn, *state, username, "exhausted",
+				"Auto dispatch: no eligible unit available, waiting for manual dispatch", nil)
+		})
+		if err != nil {
+			return err
+		}
+		b, _ := json.Marshal(state)
+		return utils.AutoDispatchSetFenced(fence, state.CaseID, string(b))
+	}
+
+	action, _ := nodeConfig(dispatchNode)["action"].(string)
+	req := model.UpdateStageRequest{
+		CaseId:   state.CaseID,
+		Status:   action,
+		UnitId:   chosen.UnitID,
+		UnitUser: chosen.Username,
+		NodeId:   dispatchNode.NodeId,
+	}
+	next := *state
+	next.Attempt++
+	next.UnitID = chosen.UnitID
+	next.UnitUser = chosen.Username
+	next.Tried = append(append([]string{}, state.Tried...), chosen.UnitID)
+	next.Status = "waiting"
+	ackTimeout := configInt(config, "ackTimeout", utils.EnvInt("AUTO_DISPATCH_ACK_TIMEOUT", 3))
+	next.Deadline = time.Now().Add(time.Duration(ackTimeout) * time.Minute)
+
+	// The stage update joins the fenced transaction, so it and its history
+	// entry commit together or not at all.
+	err = withFencedTx(c, conn, fence, func() error {
+		if _, err := UpdateCurrentStageCore(c, conn, req, false); err != nil {
+			return err
+		}
+		return recordAutoDispatch(c, conn, next, username, "dispatched",
+			fmt.Sprintf("Auto dispatch: unit %s dispatched (attempt %d)", chosen.UnitID, next.Attempt), chosen)
+	})
+	if errors.Is(err, utils.ErrStaleFence) {
+		return err
+	}
+	if err != nil {
+		state.Tried = append(state.Tried, chosen.UnitID)
+		if ferr := withFencedTx(c, conn, fence, func() error {
+			return recordAutoDispatch(c, conn, *state, username, "failed",
+				fmt.Sprintf("Auto dispatch: dispatch unit %s failed", chosen.UnitID), chosen)
+		}); ferr != nil {
+			log.Printf("Auto dispatch %s: %v", state.CaseID, ferr)
+		}
+		return err
+	}
+
+	*state = next
+	b, _ := json.Marshal(state)
+	return utils.AutoDispatchSetFenced(fence, state.CaseID, string(b))
+}
+
+// recordAutoDispatch writes an auto dispatch decision to the case history.
+// Callers run it in a fenced transaction.
+func recordAutoDispatch(ctx context.Context, conn *pgx.Conn, state model.AutoDispatchState, username string, decision string, msg string, unit *model.UnitCandidate) error {
+	data := map[string]interface{}{
+		"type":     "AUTO-DISPATCH",
+		"decision": decision,
+		"state":    state,
+	}
+	if unit != nil {
+		data["unit"] = map[string]interface{}{
+			"unitId":     unit.UnitID,
+			"username":   unit.Username,
+			"distanceKm": unit.DistanceKm,
+			"etaMinutes": unit.EtaMinutes,
+			"load":       unit.Load,
+			"reasons":    unit.Reasons,
+		}
+	}
+	err := InsertCaseHistoryEvent(ctx, conn, model.CaseHistoryEvent{
+		OrgID:     state.OrgID,
+		CaseID:    state.CaseID,
+		Username:  username,
+		Type:      "event",
+		FullMsg:   msg,
+		JsonData:  data,
+		CreatedBy: username,
+	})
+	if err != nil {
+		return fmt.Errorf("insert case history failed: %w", err)
+	}
+	return nil
+}
+
+// nodeConfig returns data.config of a workflow node as stored in wf_nodes.
+func nodeConfig(node model.WorkflowNode) map[string]interface{} {
+	dataMap, ok := node.Data.(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	inner, ok := dataMap["data"].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	config, ok := inner["config"].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return config
+}
+
+func configInt(config map[string]interface{}, key string, fallback int) int {
+	switch v := config[key].(type) {
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
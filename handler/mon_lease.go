@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// monitorJobs lists the jobs that run on one replica at a time, with the env
+// key naming the Redis owner key of each.
+var monitorJobs = []struct {
+	Name     string
+	OwnerEnv string
+}{
+	{"SlaMonitor", "CACHE_OWNER_SLA"},
+	{"ScheduleMonitor", "CACHE_OWNER_SCHEDULE"},
+	{"ReSyncCase", "CACHE_OWNER_CASE_SYNC"},
+	{"SummaryReport", "CACHE_OWNER_REPORT"},
+	{"CaseHistory", "CACHE_OWNER_CASE_HISTORY"},
+	{"AutoDispatchMonitor", "CACHE_OWNER_AUTO_DISPATCH"},
+}
+
+func monitorOwner(job string) string {
+	for _, j := range monitorJobs {
+		if j.Name == job {
+			return os.Getenv(j.OwnerEnv)
+		}
+	}
+	return ""
+}
+
+// leaseHolder identifies this process as a lease holder.
+func leaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Println("Hostname error:", err)
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+func leaseTTL() time.Duration {
+	return time.Duration(utils.EnvInt("MONITOR_LEASE_TTL", 60)) * time.Second
+}
+
+// monitorLease is a lease this process holds on a monitor job. It is kept
+// across ticks and renewed in the background, so the job stays on one replica
+// and GetMonitorLeaders shows who runs it between ticks too.
+type monitorLease struct {
+	fence  utils.LeaseFence
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	heldLeasesMu sync.Mutex
+	heldLeases   = map[string]*monitorLease{}
+)
+
+// runWithLease runs fn when this process holds, or gets, the lease of job and
+// reports whether it did. Once acquired the lease is renewed every third of
+// its TTL until parent is cancelled, when it is released; if a renewal finds
+// the lease taken over, or renewals keep failing until the TTL has passed,
+// the context given to fn is cancelled so the job stops before another
+// replica starts it, and the next call tries to acquire the lease again.
+// fn should check ctx between units of work, pass it to the queries it runs
+// and pass the fence to its writes (see beginFenced) so a stale holder cannot
+// write after the lease moved.
+func runWithLease(parent context.Context, job string, holder string, fn func(ctx context.Context, fence utils.LeaseFence) error) (bool, error) {
+	lease, err := holdLease(parent, job, holder)
+	if lease == nil {
+		return false, err
+	}
+	return true, fn(lease.ctx, lease.fence)
+}
+
+func holdLease(parent context.Context, job string, holder string) (*monitorLease, error) {
+	heldLeasesMu.Lock()
+	defer heldLeasesMu.Unlock()
+	if lease := heldLeases[job]; lease != nil && lease.ctx.Err() == nil {
+		return lease, nil
+	}
+	delete(heldLeases, job)
+
+	owner := monitorOwner(job)
+	if owner == "" {
+		return nil, fmt.Errorf("no owner key configured for %s", job)
+	}
+	ttl := leaseTTL()
+	token, ok, err := utils.OwnerLeaseAcquire(owner, holder, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("acquire %s lease: %w", job, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	log.Printf("%s lease acquired by %s, token %d", job, holder, token)
+
+	ctx, cancel := context.WithCancel(parent)
+	lease := &monitorLease{
+		fence:  utils.LeaseFence{Owner: owner, Holder: holder, Token: token},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	heldLeases[job] = lease
+	go lease.keep(job, ttl)
+	return lease, nil
+}
+
+// keep renews the lease until it is lost or its context is cancelled, then
+// releases it.
+func (l *monitorLease) keep(job string, ttl time.Duration) {
+	f := l.fence
+	defer func() {
+		if err := utils.OwnerLeaseRelease(f.Owner, f.Holder, f.Token); err != nil {
+			log.Printf("%s lease release error: %v", job, err)
+		}
+	}()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ok, err := utils.OwnerLeaseRenew(f.Owner, f.Holder, f.Token, ttl)
+		if err != nil {
+			log.Printf("%s lease renew error: %v", job, err)
+			if time.Since(renewed) < ttl {
+				continue
+			}
+		} else if ok {
+			renewed = time.Now()
+			continue
+		}
+		log.Printf("%s lease lost by %s, token %d", job, f.Holder, f.Token)
+		l.cancel()
+		return
+	}
+}
+
+// beginFenced begins a transaction on conn that first moves the fence of the
+// lease in monitor_fences up to f's token, keeping the fence row locked until
+// the transaction ends. It fails with utils.ErrStaleFence when a newer token
+// has been written already, so a replica that lost its lease cannot commit
+// anything after the new holder's first fenced write.
+func beginFenced(ctx context.Context, conn *pgx.Conn, f utils.LeaseFence) (pgx.Tx, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO public.monitor_fences ("owner", "token", "holder", "updatedAt")
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT ("owner") DO UPDATE
+		SET "token" = EXCLUDED."token", "holder" = EXCLUDED."holder", "updatedAt" = EXCLUDED."updatedAt"
+		WHERE monitor_fences."token" <= EXCLUDED."token"`, f.Owner, f.Token, f.Holder)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = utils.ErrStaleFence
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// withFencedTx runs fn in a transaction begun with beginFenced and commits it
+// when fn succeeds. fn writes through conn, on which the transaction is open.
+func withFencedTx(ctx context.Context, conn *pgx.Conn, f utils.LeaseFence, fn func() error) error {
+	tx, err := beginFenced(ctx, conn, f)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := fn(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// monitorEngine only serves to build the gin contexts of withMonitorConn.
+var monitorEngine = func() *gin.Engine {
+	engine := gin.New()
+	engine.ContextWithFallback = true
+	return engine
+}()
+
+// withMonitorConn runs fn on a pooled connection, released when fn returns.
+// The *gin.Context given to fn carries username and orgId for the handler
+// cores that read them, and its Done follows the connection's query timeout
+// and leaseCtx, so every query made through it stops with either.
+func withMonitorConn(leaseCtx context.Context, orgId string, username string, fn func(c *gin.Context, conn *pgx.Conn) error) error {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return fmt.Errorf("DB connection is nil")
+	}
+	defer cancel()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	defer context.AfterFunc(leaseCtx, stop)()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return err
+	}
+	c := gin.CreateTestContextOnly(httptest.NewRecorder(), monitorEngine)
+	c.Request = req
+	c.Set("username", username)
+	c.Set("orgId", orgId)
+	return fn(c, conn)
+}
+
+// @summary Get monitor leaders
+// @description Lists which host currently holds the lease of each background job
+// @tags Monitor
+// @security ApiKeyAuth
+// @id Get Monitor Leaders
+// @accept json
+// @produce json
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/monitors/leaders [get]
+func GetMonitorLeaders(c *gin.Context) {
+	self := leaseHolder()
+	leaders := []model.MonitorLeader{}
+	for _, j := range monitorJobs {
+		owner := os.Getenv(j.OwnerEnv)
+		holder, token, ttl, err := utils.OwnerLeaseGet(owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, model.Response{
+				Status: "-1",
+				Msg:    "Failure",
+				Desc:   err.Error(),
+			})
+			return
+		}
+		host := holder
+		if i := strings.LastIndex(holder, ":"); i >= 0 {
+			host = holder[:i]
+		}
+		leaders = append(leaders, model.MonitorLeader{
+			Job:       j.Name,
+			Owner:     holder,
+			Host:      host,
+			Token:     token,
+			ExpiresIn: ttl.Milliseconds(),
+			Self:      holder != "" && holder == self,
+		})
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   leaders,
+		Desc:   "",
+	})
+}
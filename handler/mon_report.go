@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"mainPackage/utils"
 	"os"
@@ -10,62 +12,33 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SummaryReport(c *gin.Context) error {
-	counter := 0
-	//REPORT_NAME := os.Getenv("REPORT_NAME")
+func SummaryReport(ctx context.Context, c *gin.Context) error {
+	return runProcedureMonitor(ctx, "SummaryReport", true, `CALL store_generate_summary_report();`)
+}
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Println("Hostname error:", err)
-		hostname = "unknown"
-	}
-	hostname = "SummaryReport_" + hostname
+func CaseHistory(ctx context.Context, c *gin.Context) error {
+	return runProcedureMonitor(ctx, "CaseHistory", false, `CALL store_generate_case_history();`)
+}
+
+// runProcedureMonitor calls a report stored procedure every
+// MONITOR_REPORT_INTERVAL minutes on whichever replica holds the job lease.
+func runProcedureMonitor(ctx context.Context, job string, report bool, call string) error {
+	counter := 0
+	holder := leaseHolder()
 
-	log.Printf("Starting SummaryReport on host: %s\n", hostname)
+	log.Printf("Starting %s on host: %s\n", job, holder)
 
 	for {
-		val, err := utils.OwnerReportGet()
+		ran, err := runWithLease(ctx, job, holder, func(leaseCtx context.Context, fence utils.LeaseFence) error {
+			log.Printf("[Tick %d] %s by %s, token %d\n", counter, job, holder, fence.Token)
+			return execMonitorProcedure(leaseCtx, fence, report, call)
+		})
 		if err != nil {
-			log.Println("Redis GET error:", err)
-			//return err
-		}
-
-		log.Printf("[Tick %d] Redis sla key = '%s'\n", counter, val)
-		val = ""
-		// Only this host should write if no one else owns the lock or it owns it
-		if val == "" || val == hostname {
-
-			err = utils.OwnerReportSet(hostname)
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				//return err
-			}
-
-			conn, ctx, cancel := utils.ConnectDB_REPORT()
-			if conn == nil {
-				log.Printf("DB connection is nil")
-				//return nil
-			}
-			defer cancel()
-			defer conn.Close(ctx)
-
-			// ---> Query storeprocedure
-			_, err = conn.Exec(ctx, `CALL store_generate_summary_report();`)
-			if err != nil {
-				log.Println("CALL store_generate_summary_report error:", err)
-				//return err
-			}
-
-			log.Println("Stored procedure store_generate_summary_report executed successfully")
-
-			err = utils.OwnerReportSet("")
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				return err
-			}
-			log.Printf("Report lock acquired/renewed by host: %s\n", hostname)
+			log.Printf("%s error: %v", job, err)
+		} else if ran {
+			log.Printf("%s executed successfully", job)
 		} else {
-			log.Printf("Report lock held by another host: %s (this host: %s)\n", val, hostname)
+			log.Printf("[Tick %d] %s lease held by another host (this host: %s)\n", counter, job, holder)
 		}
 
 		counter++
@@ -79,80 +52,38 @@ func SummaryReport(c *gin.Context) error {
 
 		sleep := time.Duration(interval) * time.Minute
 		log.Print("Sleep : ", sleep)
-		time.Sleep(sleep)
+		if !sleepCtx(ctx, sleep) {
+			return nil
+		}
 	}
 }
 
-func CaseHistory(c *gin.Context) error {
-	counter := 0
-	//REPORT_NAME := os.Getenv("REPORT_NAME")
-
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Println("Hostname error:", err)
-		hostname = "unknown"
+// execMonitorProcedure runs call on the report or main database, in a
+// transaction fenced with the job's lease so a replica that lost the lease
+// cannot regenerate the report over the new holder's. The call is cancelled
+// when the lease is lost.
+func execMonitorProcedure(leaseCtx context.Context, fence utils.LeaseFence, report bool, call string) error {
+	connect := utils.ConnectDB
+	if report {
+		connect = utils.ConnectDB_REPORT
 	}
+	conn, ctx, cancel := connect()
+	if conn == nil {
+		return fmt.Errorf("DB connection is nil")
+	}
+	defer cancel()
 
-	hostname = "CaseHistory_" + hostname
-
-	log.Printf("Starting SummaryReport on host: %s\n", hostname)
-
-	for {
-		val, err := utils.OwnerReportGet()
-		if err != nil {
-			log.Println("Redis GET error:", err)
-			//return err
-		}
-
-		log.Printf("[Tick %d] Redis sla key = '%s'\n", counter, val)
-		val = ""
-		// Only this host should write if no one else owns the lock or it owns it
-		if val == "" || val == hostname {
-
-			err = utils.OwnerReportSet(hostname)
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				//return err
-			}
-
-			conn, ctx, cancel := utils.ConnectDB()
-			if conn == nil {
-				log.Printf("DB connection is nil")
-				//return nil
-			}
-			defer cancel()
-			defer conn.Close(ctx)
-
-			// ---> Query storeprocedure
-			_, err = conn.Exec(ctx, `CALL store_generate_case_history();`)
-			if err != nil {
-				log.Println("CALL store_generate_case_history error:", err)
-				//return err
-			}
-
-			log.Println("Stored procedure store_generate_case_history executed successfully")
-
-			err = utils.OwnerReportSet("")
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				return err
-			}
-			log.Printf("Report lock acquired/renewed by host: %s\n", hostname)
-		} else {
-			log.Printf("Report lock held by another host: %s (this host: %s)\n", val, hostname)
-		}
-
-		counter++
-
-		intervalStr := os.Getenv("MONITOR_REPORT_INTERVAL")
-		interval, err := strconv.Atoi(intervalStr)
-		if err != nil {
-			log.Printf("Invalid MONITOR_REPORT_INTERVAL=%s, fallback to 1 min", intervalStr)
-			interval = 1
-		}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	defer context.AfterFunc(leaseCtx, stop)()
 
-		sleep := time.Duration(interval) * time.Minute
-		log.Print("Sleep : ", sleep)
-		time.Sleep(sleep)
+	tx, err := beginFenced(ctx, conn, fence)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, call); err != nil {
+		return err
 	}
+	return tx.Commit(ctx)
 }
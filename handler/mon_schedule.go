@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// ScheduleMonitor creates the cases of recurring case schedules and activates
+// scheduled cases once their schedule date has come. It runs on the replica
+// holding the lease; each activation and each occurrence is also claimed in
+// the database, so a lease moving between replicas never activates or creates
+// a case twice.
+func ScheduleMonitor(ctx context.Context, c *gin.Context) error {
+	counter := 0
+	MONITOR_NAME := os.Getenv("MONITOR_SCH_NAME")
+	orgId := os.Getenv("INTEGRATION_ORG_ID")
+	holder := leaseHolder()
+
+	log.Printf("Starting schedule monitor on host: %s\n", holder)
+
+	for {
+		ran, err := runWithLease(ctx, "ScheduleMonitor", holder, func(leaseCtx context.Context, fence utils.LeaseFence) error {
+			log.Printf("[Tick %d] Schedule check by %s, token %d\n", counter, holder, fence.Token)
+			c.Set("username", MONITOR_NAME)
+			c.Set("orgId", orgId)
+			if err := runCaseSchedules(leaseCtx, c, orgId, MONITOR_NAME); err != nil {
+				log.Printf("Case schedule error: %v", err)
+			}
+			return activateDueCases(leaseCtx, c, orgId, MONITOR_NAME)
+		})
+		if err != nil {
+			log.Printf("Schedule check error: %v", err)
+		} else if !ran {
+			log.Printf("[Tick %d] Schedule lease held by another host (this host: %s)\n", counter, holder)
+		}
+
+		counter++
+
+		interval := utils.EnvInt("MONITOR_SCH_INTERVAL", 1)
+		if !sleepCtx(ctx, time.Duration(interval)*time.Minute) {
+			return nil
+		}
+	}
+}
+
+// scheduledStatuses are the statuses a case may wait in until its schedule
+// date: the MONITOR_SCH statuses and NEW.
+func scheduledStatuses() []string {
+	statuses := []string{}
+	for _, s := range getEnvList("MONITOR_SCH") {
+		if s = strings.TrimSpace(s); s != "" {
+			statuses = append(statuses, s)
+		}
+	}
+	return append(statuses, os.Getenv("NEW"))
+}
+
+type dueCase struct {
+	caseId       string
+	scheduleDate time.Time
+}
+
+// activateDueCases activates the scheduled cases due since the last
+// MONITOR_SCH_LOOKBACK hours. Older ones are left alone so cases scheduled
+// before the monitor ran are not all woken at once. Every case gets its own
+// pooled connection, like checkSlaStages.
+func activateDueCases(leaseCtx context.Context, c *gin.Context, orgId string, username string) error {
+	var due []dueCase
+	err := func() error {
+		conn, ctx, cancel := utils.ConnectDB()
+		if conn == nil {
+			return fmt.Errorf("DB connection is nil")
+		}
+		defer cancel()
+		rows, err := conn.Query(ctx, `
+			SELECT c."caseId", c."scheduleDate"
+			FROM public.tix_cases c
+			WHERE c."orgId" = $1
+			  AND c."scheduleFlag" IS TRUE
+			  AND c."scheduleDate" <= NOW()
+			  AND c."scheduleDate" > NOW() - make_interval(hours => $2)
+			  AND c."statusId" = ANY($3)
+			  AND NOT EXISTS (
+			      SELECT 1 FROM public.tix_case_schedule_runs r
+			      WHERE r."orgId" = c."orgId" AND r."caseId" = c."caseId" AND r."scheduleDate" = c."scheduleDate"
+			  )
+			ORDER BY c."scheduleDate"`,
+			orgId, utils.EnvInt("MONITOR_SCH_LOOKBACK", 24), scheduledStatuses())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var d dueCase
+			if err := rows.Scan(&d.caseId, &d.scheduleDate); err != nil {
+				return err
+			}
+			due = append(due, d)
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if err := leaseCtx.Err(); err != nil {
+			return fmt.Errorf("schedule check stopped: %w", err)
+		}
+		func() {
+			conn, ctx, cancel := utils.ConnectDB()
+			if conn == nil {
+				log.Printf("DB connection is nil")
+				return
+			}
+			defer cancel()
+			if _, err := ActivateScheduledCase(ctx, c, conn, orgId, d.caseId, d.scheduleDate, username, false); err != nil {
+				log.Printf("Activate scheduled case %s: %v", d.caseId, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// ActivateScheduledCase wakes a scheduled case up: it moves the case to the
+// first stage after the start of its workflow unless it is already further,
+// turns a waiting status into NEW and notifies the groups of the stage. The
+// AutoDispatchMonitor then dispatches it when its dispatch node has auto
+// dispatch enabled, or always when forceDispatch is set. The activation is
+// claimed per case and schedule date first; it reports false when it was
+// claimed already. A failed activation gives its claim up so the next run
+// retries.
+func ActivateScheduledCase(ctx context.Context, c *gin.Context, conn *pgx.Conn, orgId string, caseId string, scheduleDate time.Time, username string, forceDispatch bool) (bool, error) {
+	tag, err := conn.Exec(ctx, `
+		INSERT INTO public.tix_case_schedule_runs ("orgId", "caseId", "scheduleDate", "activatedAt", "activatedBy")
+		VALUES ($1, $2, $3, NOW(), $4)
+		ON CONFLICT DO NOTHING`, orgId, caseId, scheduleDate, username)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := activateCase(ctx, c, conn, orgId, caseId, username, forceDispatch); err != nil {
+		if _, derr := conn.Exec(ctx, `
+			DELETE FROM public.tix_case_schedule_runs
+			WHERE "orgId" = $1 AND "caseId" = $2 AND "scheduleDate" = $3`, orgId, caseId, scheduleDate); derr != nil {
+			log.Printf("Release schedule claim of %s: %v", caseId, derr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func activateCase(ctx context.Context, c *gin.Context, conn *pgx.Conn, orgId string, caseId string, username string, forceDispatch bool) error {
+	logger := utils.GetLog()
+	caseData, err := GetCaseByID(ctx, conn, orgId, caseId)
+	if err != nil {
+		return err
+	}
+	if caseData == nil {
+		return fmt.Errorf("case not found: %s", caseId)
+	}
+	wfId := derefString(caseData.WfID)
+	if wfId == "" {
+		return fmt.Errorf("case %s has no workflow", caseId)
+	}
+
+	var stageNode, version string
+	hasStage := true
+	err = conn.QueryRow(ctx, `
+		SELECT "nodeId", "versions"
+		FROM public.tix_case_current_stage
+		WHERE "orgId" = $1 AND "caseId" = $2 AND "stageType" = 'case' AND "unitId" = ''`,
+		orgId, caseId).Scan(&stageNode, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		hasStage = false
+		if version, err = pinCaseWorkflow(ctx, conn, orgId, caseId, wfId, username); err != nil {
+			return fmt.Errorf("pin workflow version: %w", err)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	_, nodeConn, allNodesId, _, err := GetAllNodes(ctx, conn, orgId, wfId, version, logger)
+	if err != nil {
+		return err
+	}
+	node, ok := allNodesId[stageNode]
+	if !ok {
+		node = model.WorkflowNode{NodeId: stageNode}
+	}
+	if !hasStage || node.Type == "start" {
+		start := ""
+		for id, n := range allNodesId {
+			if n.Type == "start" {
+				start = id
+				break
+			}
+		}
+		decisions := newDecisionEvaluator(ctx, conn, orgId, caseData)
+		next, _, _, _ := GetNextNode(allNodesId, nodeConn, model.CurrentStage{NodeId: start}, model.CurrentStage{}, logger, func(n model.WorkflowNode) model.WorkflowNode {
+			return decisions.Decide(n, nodeConn, allNodesId)
+		})
+		if decisions.Err != nil {
+			return decisions.Err
+		}
+		if next.NodeId == "" {
+			return fmt.Errorf("workflow %s has no stage after its start", wfId)
+		}
+		if hasStage {
+			_, err = UpdateCaseCurrentStage(c, conn, model.UpdateStageRequest{CaseId: caseId}, next, "case", username)
+		} else {
+			err = CaseResponseAndCurrentStageInsert(conn, ctx, c, model.CustomCaseCurrentStage{
+				CaseID:   caseId,
+				WfID:     &wfId,
+				NodeID:   next.NodeId,
+				StatusID: os.Getenv("NEW"),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("move to first stage: %w", err)
+		}
+		node = next
+	}
+
+	_, err = conn.Exec(ctx, `
+		UPDATE public.tix_cases SET "statusId" = $3, "updatedAt" = NOW(), "updatedBy" = $4
+		WHERE "orgId" = $1 AND "caseId" = $2 AND "statusId" = ANY($5)`,
+		orgId, caseId, os.Getenv("NEW"), username, scheduledStatuses())
+	if err != nil {
+		return err
+	}
+
+	notifyScheduledCase(ctx, c, conn, orgId, caseData, node, username)
+
+	// The AutoDispatchMonitor lease holder dispatches the case on its next
+	// run; a forced dispatch is handed over as a pending state.
+	if forceDispatch {
+		b, _ := json.Marshal(model.AutoDispatchState{OrgID: orgId, CaseID: caseId, Forced: true, Status: "pending"})
+		if err := utils.AutoDispatchSet(caseId, string(b)); err != nil {
+			log.Printf("Auto dispatch %s: %v", caseId, err)
+		}
+	}
+	return nil
+}
+
+// nodeGroups returns the groups configured on a workflow node, given either
+// as a list or as a comma separated string.
+func nodeGroups(node model.WorkflowNode) []string {
+	var groups []string
+	switch v := nodeConfig(node)["group"].(type) {
+	case string:
+		groups = strings.Split(v, ",")
+	case []interface{}:
+		for _, g := range v {
+			groups = append(groups, ToString(g))
+		}
+	}
+	out := []string{}
+	for _, g := range groups {
+		if g = strings.TrimSpace(g); g != "" {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// notifyScheduledCase tells the province of the case and the groups of its
+// stage that the case has started, and records it in the case history.
+func notifyScheduledCase(ctx context.Context, c *gin.Context, conn *pgx.Conn, orgId string, caseData *model.Case, node model.WorkflowNode, username string) {
+	msg := "เริ่มดำเนินการตามกำหนดการ"
+	statuses, err := utils.GetCaseStatusList(c, conn, orgId)
+	if err == nil {
+		for _, s := range statuses {
+			if s.StatusID != nil && *s.StatusID == os.Getenv("NEW") && s.Th != nil {
+				msg = *s.Th + " (" + msg + ")"
+			}
+		}
+	}
+	msgAlert := msg + " :: " + caseData.CaseID
+
+	recipients := []model.Recipient{
+		{Type: "provId", Value: caseData.ProvID},
+	}
+	if groups := nodeGroups(node); len(groups) > 0 {
+		recipients = append(recipients, model.Recipient{Type: "grpId", Value: strings.Join(groups, ",")})
+	}
+	data := []model.Data{
+		{Key: "delay", Value: "0"}, //0=white, 1=yellow , 2=red
+	}
+	event := "CASE-SCHEDULE-START"
+	additionalJSON, err := json.Marshal(map[string]interface{}{
+		"event":  event,
+		"caseId": caseData.CaseID,
+		"nodeId": node.NodeId,
+		"status": os.Getenv("NEW"),
+	})
+	if err != nil {
+		log.Print("covent additionalData Error :", err)
+	}
+	additionalData := json.RawMessage(additionalJSON)
+	if err := genNotiCustom(ctx, conn, orgId, username, username, "", msg, data, msgAlert, recipients, "/case/"+caseData.CaseID, "User", event, &additionalData); err != nil {
+		log.Printf("Schedule notification of %s: %v", caseData.CaseID, err)
+	}
+
+	evt := model.CaseHistoryEvent{
+		OrgID:     orgId,
+		CaseID:    caseData.CaseID,
+		Username:  username,
+		Type:      "event",
+		FullMsg:   msg,
+		JsonData:  map[string]interface{}{"type": "SCHEDULE-START", "nodeId": node.NodeId},
+		CreatedBy: username,
+	}
+	if err := InsertCaseHistoryEvent(ctx, conn, evt); err != nil {
+		log.Printf("Schedule history of %s: %v", caseData.CaseID, err)
+	}
+}
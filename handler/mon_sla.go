@@ -3,9 +3,11 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"mainPackage/model"
+	"mainPackage/repository"
 	"mainPackage/utils"
 	"os"
 	"strconv"
@@ -15,110 +17,119 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
-func SlaMonitor(c *gin.Context) error {
+func SlaMonitor(ctx context.Context, c *gin.Context) error {
 	counter := 0
 	MONITOR_NAME := os.Getenv("MONITOR_SLA_NAME")
 	orgId := os.Getenv("INTEGRATION_ORG_ID")
+	holder := leaseHolder()
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Println("Hostname error:", err)
-		hostname = "unknown"
-	}
-
-	log.Printf("Starting SLA monitor on host: %s\n", hostname)
+	log.Printf("Starting SLA monitor on host: %s\n", holder)
 
 	for {
-		val, err := utils.OwnerSLAGet()
+		ran, err := runWithLease(ctx, "SlaMonitor", holder, func(leaseCtx context.Context, fence utils.LeaseFence) error {
+			log.Printf("[Tick %d] SLA check by %s, token %d\n", counter, holder, fence.Token)
+			return checkSlaStages(leaseCtx, fence, c, orgId, MONITOR_NAME)
+		})
 		if err != nil {
-			log.Println("Redis GET error:", err)
-			return err
+			log.Printf("SLA check error: %v", err)
+		} else if !ran {
+			log.Printf("[Tick %d] SLA lease held by another host (this host: %s)\n", counter, holder)
 		}
 
-		log.Printf("[Tick %d] Redis sla key = '%s'\n", counter, val)
-		val = ""
-		// Only this host should write if no one else owns the lock or it owns it
-		if val == "" || val == hostname {
+		counter++
 
-			err = utils.OwnerSLASet(hostname)
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				return err
-			}
+		intervalStr := os.Getenv("MONITOR_SLA_INTERVAL")
+		interval, err := strconv.Atoi(intervalStr)
+		if err != nil {
+			log.Printf("Invalid MONITOR_SLA_INTERVAL=%s, fallback to 1 min", intervalStr)
+			interval = 1
+		}
+
+		sleep := time.Duration(interval) * time.Minute
+		log.Print("Sleep : ", sleep)
+		if !sleepCtx(ctx, sleep) {
+			return nil
+		}
+	}
+}
+
+// checkSlaStages alerts every case stage past its SLA. Each case gets its own
+// pooled connection so a long run cannot outlive the query timeout, and every
+// connection is released before the next one is taken. It stops between cases
+// once leaseCtx is cancelled, and for good once the SLA update of a case finds
+// the fence moved on; the alert is only sent after that update committed.
+func checkSlaStages(leaseCtx context.Context, fence utils.LeaseFence, c *gin.Context, orgId string, monitorName string) error {
+	var stages []model.CaseStageInfo
+	err := func() error {
+		conn, ctx, cancel := utils.ConnectDB()
+		if conn == nil {
+			return fmt.Errorf("DB connection is nil")
+		}
+		defer cancel()
+		var err error
+		stages, err = GetCaseStageData(ctx, conn, orgId)
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+
+	//Set Alert By caseId
+	c.Set("username", monitorName)
+	c.Set("orgId", orgId)
 
+	for _, stage := range stages {
+		if err := leaseCtx.Err(); err != nil {
+			return fmt.Errorf("SLA check stopped: %w", err)
+		}
+		//fmt.Println("Stage Data JSON:", stage)
+		caseId := stage.CaseId
+		req := model.UpdateStageRequest{
+			CaseId:   caseId,
+			Status:   RecheckSLA(stage.StatusId),
+			UnitUser: monitorName, // หรือ set ค่า default
+		}
+		//log.Print(req)
+		delay, err := strconv.Atoi(stage.OverSlaCount)
+		if err != nil {
+			log.Printf("Invalid OverSlaCount=%s, defaulting to 0", stage.OverSlaCount)
+			delay = 1
+		}
+		delay++
+		if delay > 2 {
+			delay = 2
+		}
+		err = func() error {
 			conn, ctx, cancel := utils.ConnectDB()
 			if conn == nil {
 				log.Printf("DB connection is nil")
 				return nil
 			}
 			defer cancel()
-			defer conn.Close(ctx)
-
-			stages, err := GetCaseStageData(ctx, conn, orgId)
-			if err != nil {
-				fmt.Println("Error:", err)
+			tx, err := beginFenced(ctx, conn, fence)
+			if errors.Is(err, utils.ErrStaleFence) {
+				return err
+			} else if err != nil {
+				log.Printf("Failed to update SLA for case %s: %v", caseId, err)
 				return nil
 			}
-
-			//Set Alert By caseId
-			c.Set("username", MONITOR_NAME)
-			c.Set("orgId", orgId)
-
-			for _, stage := range stages {
-				//fmt.Println("Stage Data JSON:", stage)
-				caseId := stage.CaseId
-				req := model.UpdateStageRequest{
-					CaseId:   caseId,
-					Status:   RecheckSLA(stage.StatusId),
-					UnitUser: MONITOR_NAME, // หรือ set ค่า default
-				}
-				//log.Print(req)
-				delay, err := strconv.Atoi(stage.OverSlaCount)
-				if err != nil {
-					log.Printf("Invalid OverSlaCount=%s, defaulting to 0", stage.OverSlaCount)
-					delay = 1
-				}
-				delay++
-				if delay > 2 {
-					delay = 2
-				}
-				conn, ctx, cancel := utils.ConnectDB()
-				if conn == nil {
-					log.Printf("DB connection is nil")
-					return nil
-				}
-				defer cancel()
-				defer conn.Close(ctx)
-				GenerateNotiAndComment(c, conn, req, orgId, strconv.Itoa(delay))
-				err = UpdateCaseSLAPlus(ctx, conn, orgId, caseId, true, time.Now())
-				if err != nil {
-					log.Printf("Failed to update SLA for case %s: %v", caseId, err)
-				}
+			defer tx.Rollback(ctx)
+			if err := UpdateCaseSLAPlus(ctx, conn, orgId, caseId, true, time.Now()); err != nil {
+				log.Printf("Failed to update SLA for case %s: %v", caseId, err)
+				return nil
 			}
-
-			err = utils.OwnerSLASet("")
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				return err
+			if err := tx.Commit(ctx); err != nil {
+				log.Printf("Failed to update SLA for case %s: %v", caseId, err)
+				return nil
 			}
-			log.Printf("SLA lock acquired/renewed by host: %s\n", hostname)
-		} else {
-			log.Printf("SLA lock held by another host: %s (this host: %s)\n", val, hostname)
-		}
-
-		counter++
-
-		intervalStr := os.Getenv("MONITOR_SLA_INTERVAL")
-		interval, err := strconv.Atoi(intervalStr)
+			GenerateNotiAndComment(c, conn, req, orgId, strconv.Itoa(delay))
+			return nil
+		}()
 		if err != nil {
-			log.Printf("Invalid MONITOR_SLA_INTERVAL=%s, fallback to 1 min", intervalStr)
-			interval = 1
+			return fmt.Errorf("SLA check stopped: %w", err)
 		}
-
-		sleep := time.Duration(interval) * time.Minute
-		log.Print("Sleep : ", sleep)
-		time.Sleep(sleep)
 	}
+	return nil
 }
 
 func GetCaseStageData(ctx context.Context, conn *pgx.Conn, orgId string) ([]model.CaseStageInfo, error) {
@@ -135,7 +146,7 @@ func GetCaseStageData(ctx context.Context, conn *pgx.Conn, orgId string) ([]mode
 
 	query := fmt.Sprintf(`
 		SELECT c."caseId", c."statusId", s."data", s."updatedAt", 
-		       c."versions", c."overSlaCount", s."wfId", s."nodeId"
+		       s."versions", c."overSlaCount", s."wfId", s."nodeId"
 		FROM tix_cases c
 		JOIN tix_case_current_stage s ON c."caseId" = s."caseId"
 		WHERE s."stageType" = 'case'
@@ -167,7 +178,7 @@ func GetCaseStageData(ctx context.Context, conn *pgx.Conn, orgId string) ([]mode
 	defer rows.Close()
 
 	var results, results_data []model.CaseStageInfo
-	wfSet := make(map[string]struct{}) // collect unique wfIds
+	wfSet := make(map[string]struct{}) // collect unique wfId versions
 
 	for rows.Next() {
 		var rec model.CaseStageInfo
@@ -179,7 +190,7 @@ func GetCaseStageData(ctx context.Context, conn *pgx.Conn, orgId string) ([]mode
 			fmt.Printf("Case %s has NULL UpdatedAt, skipping\n", rec.CaseId)
 			continue
 		}
-		wfSet[rec.WfId] = struct{}{}
+		wfSet[slaWorkflowKey(rec.WfId, rec.Versions)] = struct{}{}
 		results = append(results, rec)
 	}
 	if rows.Err() != nil {
@@ -194,7 +205,7 @@ func GetCaseStageData(ctx context.Context, conn *pgx.Conn, orgId string) ([]mode
 
 	// 🔹 Step 3: Compute nextNode for each case
 	for i, c := range results {
-		nodes := wfNodesMap[c.WfId]
+		nodes := wfNodesMap[slaWorkflowKey(c.WfId, c.Versions)]
 		if nodes == nil {
 			continue
 		}
@@ -299,26 +310,32 @@ func GetCaseStageData(ctx context.Context, conn *pgx.Conn, orgId string) ([]mode
 	return results_data, nil
 }
 
+// slaWorkflowKey keys the nodes of one workflow version, so cases pinned to
+// different versions of a workflow are not mixed up.
+func slaWorkflowKey(wfId string, version string) string {
+	return wfId + "|" + version
+}
+
 func getWorkflow(ctx context.Context, conn *pgx.Conn, orgId string, wfSet map[string]struct{}) (map[string]map[string]model.WorkflowNode, error) {
-	// Collect wfIds from wfSet
-	var wfIds []string
-	for id := range wfSet {
-		wfIds = append(wfIds, id)
+	// Collect wfId versions from wfSet
+	var wfKeys []string
+	for key := range wfSet {
+		wfKeys = append(wfKeys, key)
 	}
 
 	// If no wfIds, return empty map
-	if len(wfIds) == 0 {
+	if len(wfKeys) == 0 {
 		return map[string]map[string]model.WorkflowNode{}, nil
 	}
 
 	query := `
-		SELECT "wfId", "nodeId", "type", "section", "data"
+		SELECT "wfId" || '|' || "versions", "nodeId", "type", "section", "data"
 		FROM wf_nodes
-		WHERE "orgId" = $1 AND "wfId" = ANY($2)
+		WHERE "orgId" = $1 AND "wfId" || '|' || "versions" = ANY($2)
 		ORDER BY "wfId", "nodeId";
 	`
 
-	rows, err := conn.Query(ctx, query, orgId, wfIds)
+	rows, err := conn.Query(ctx, query, orgId, wfKeys)
 	if err != nil {
 		return nil, fmt.Errorf("fetch workflow nodes error: %w", err)
 	}
@@ -354,20 +371,5 @@ func getWorkflow(ctx context.Context, conn *pgx.Conn, orgId string, wfSet map[st
 }
 
 func UpdateCaseSLAPlus(ctx context.Context, conn *pgx.Conn, orgId, caseId string, overSlaFlag bool, overSlaDate time.Time) error {
-	query := `
-		UPDATE tix_cases
-		SET "overSlaFlag" = $1,
-		    "overSlaDate" = $2,
-		    "overSlaCount" = COALESCE("overSlaCount", 0) + 1,
-		    "updatedAt" = NOW()
-		WHERE "orgId" = $3
-		  AND "caseId" = $4;
-	`
-	//log.Print("====UpdateCaseSLAPlus===", query)
-	_, err := conn.Exec(ctx, query, overSlaFlag, overSlaDate, orgId, caseId)
-	if err != nil {
-		return fmt.Errorf("update case SLA failed: %w", err)
-	}
-
-	return nil
+	return repository.NewCaseRepository(conn).UpdateSLAPlus(ctx, orgId, caseId, overSlaFlag, overSlaDate)
 }
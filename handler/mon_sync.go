@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"mainPackage/model"
@@ -14,7 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func ReSyncCase(c *gin.Context) error {
+func ReSyncCase(ctx context.Context, c *gin.Context) error {
 	username := os.Getenv("INTEGRATION_USR")
 	orgId := os.Getenv("INTEGRATION_ORG_ID")
 
@@ -23,11 +24,7 @@ func ReSyncCase(c *gin.Context) error {
 
 	counter := 0
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Println("Hostname error:", err)
-		hostname = "unknown"
-	}
+	holder := leaseHolder()
 
 	maxRetryStr := os.Getenv("ESB_RE_CREATE_MAX")
 	maxRetry, err := strconv.Atoi(maxRetryStr)
@@ -36,134 +33,150 @@ func ReSyncCase(c *gin.Context) error {
 		maxRetry = 3 // ค่า default
 	}
 
-	log.Printf("Starting Case Sync monitor on host: %s\n", hostname)
+	log.Printf("Starting Case Sync monitor on host: %s\n", holder)
 
 	for {
-		val, err := utils.OwnerCaseSyncGet()
+		ran, err := runWithLease(ctx, "ReSyncCase", holder, func(leaseCtx context.Context, fence utils.LeaseFence) error {
+			log.Printf("[Tick %d] Case Sync by %s, token %d\n", counter, holder, fence.Token)
+			return resyncCases(leaseCtx, fence, c, orgId, maxRetry)
+		})
 		if err != nil {
-			log.Println("Redis GET error:", err)
-			return err
+			log.Printf("Case Sync error: %v", err)
+		} else if !ran {
+			log.Printf("[Tick %d] Case Sync lease held by another host (this host: %s)\n", counter, holder)
 		}
 
-		log.Printf("[Tick %d] Redis sla key = '%s'\n", counter, val)
-		val = ""
-		// Only this host should write if no one else owns the lock or it owns it
-		if val == "" || val == hostname {
+		counter++
 
-			err = utils.OwnerCaseSyncSet(hostname)
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				return err
-			}
+		intervalStr := os.Getenv("ESB_RE_CREATE_INTERVAL")
+		interval, err := strconv.Atoi(intervalStr)
+		if err != nil {
+			log.Printf("Invalid ESB_RE_CREATE_INTERVAL=%s, fallback to 1 min", intervalStr)
+			interval = 1
+		}
+
+		sleep := time.Duration(interval) * time.Minute
+		log.Print("Sleep : ", sleep)
+		if !sleepCtx(ctx, sleep) {
+			return nil
+		}
+	}
+}
+
+// resyncCases retries the work order creates queued in the case sync cache.
+// It stops between cases once leaseCtx is cancelled or the lease of fence is
+// no longer held, and writes the queue back only while it is, so a replica
+// that lost the lease neither publishes again nor overwrites the new holder's
+// retry counts.
+func resyncCases(leaseCtx context.Context, fence utils.LeaseFence, c *gin.Context, orgId string, maxRetry int) error {
+	items, err := utils.GetAllCaseSync(context.Background())
+	if err != nil {
+		return err
+	}
 
-			items, err := utils.GetAllCaseSync(context.Background())
-			if err != nil {
-				log.Println(err)
+	for _, item := range items {
+		if err := leaseCtx.Err(); err != nil {
+			return fmt.Errorf("case sync stopped: %w", err)
+		}
+		var req model.OwnerCaseSyncReq
+		if err := json.Unmarshal([]byte(item.Value), &req); err != nil {
+			log.Println("unmarshal error:", err)
+			continue
+		}
 
-			}
+		//Update ESB WO
+		conn, _, cancel := utils.ConnectDB()
+		if conn == nil {
+			continue
+		}
 
-			for _, item := range items {
-				var req model.OwnerCaseSyncReq
-				if err := json.Unmarshal([]byte(item.Value), &req); err != nil {
-					log.Println("unmarshal error:", err)
-					continue
+		UnitUser := ""
+		unitLists, count, err := GetUnitsWithDispatch(c, conn, orgId, req.CaseId, "S003", "")
+		if err != nil {
+			log.Printf("Case Sync units of %s: %v", req.CaseId, err)
+			cancel()
+			continue
+		}
+		if count > 0 {
+			UnitUser = unitLists[0].Username
+			fmt.Println("First username:", UnitUser)
+		}
+		req_ := model.UpdateStageRequest{
+			CaseId:   req.CaseId,
+			Status:   "S001",
+			UnitUser: UnitUser, // หรือ set ค่า default
+		}
+		log.Print("==xxx==")
+		log.Print(req_)
+		log.Print(c)
+
+		flagError := true
+		if req.Type == "create" {
+			if held, err := utils.OwnerLeaseHeld(fence); err != nil || !held {
+				cancel()
+				if err == nil {
+					err = utils.ErrStaleFence
 				}
+				return fmt.Errorf("case sync stopped: %w", err)
+			}
+			var queueErr error
+			res, error := Re_CreateBusKafka_WO(c, conn, req_, orgId)
+			cancel()
+			if leaseCtx.Err() != nil {
+				// Another replica owns the queue now; leave the entry for it.
+				return fmt.Errorf("case sync stopped: %w", leaseCtx.Err())
+			}
 
-				//Update ESB WO
-				conn, ctx, cancel := utils.ConnectDB()
-				if conn == nil {
-					continue
+			if error != nil {
+				log.Print("--error--")
+				log.Print(error)
+				msg := "Re_CreateBusKafka_WO - Error"
+				req.Message = &msg
+				//count + 1
+			} else {
+				hasWorkOrderNumber := res.Data != nil && res.Data.WorkOrderNumber != ""
+
+				if hasWorkOrderNumber {
+					log.Println("Work order created:", res.Data.WorkOrderNumber)
+					flagError = false
+				} else {
+					log.Println("No work_order_number in response:", res.Message)
 				}
-				defer cancel()
-				defer conn.Close(ctx)
-				defer cancel()
+				log.Print(res)
+				req.Result = res
+			}
 
-				UnitUser := ""
-				unitLists, count, err := GetUnitsWithDispatch(c, conn, orgId, req.CaseId, "S003", "")
+			if flagError {
+				req.Count++
+				b_, err := json.Marshal(req)
 				if err != nil {
-					panic(err)
+					fmt.Println("Re Case Error_2:", req.CaseId)
 				}
-				if count > 0 {
-					UnitUser = unitLists[0].Username
-					fmt.Println("First username:", UnitUser)
-				}
-				req_ := model.UpdateStageRequest{
-					CaseId:   req.CaseId,
-					Status:   "S001",
-					UnitUser: UnitUser, // หรือ set ค่า default
-				}
-				log.Print("==xxx==")
-				log.Print(req_)
-				log.Print(c)
-
-				flagError := true
-				if req.Type == "create" {
-					res, error := Re_CreateBusKafka_WO(c, conn, req_, orgId)
-
-					if error != nil {
-						log.Print("--error--")
-						log.Print(error)
-						msg := "Re_CreateBusKafka_WO - Error"
-						req.Message = &msg
-						//count + 1
-					} else {
-						hasWorkOrderNumber := res.Data != nil && res.Data.WorkOrderNumber != ""
-
-						if hasWorkOrderNumber {
-							log.Println("Work order created:", res.Data.WorkOrderNumber)
-							flagError = false
-						} else {
-							log.Println("No work_order_number in response:", res.Message)
-						}
-						log.Print(res)
-						req.Result = res
-					}
-
-					if flagError {
-						req.Count++
-						b_, err := json.Marshal(req)
-						if err != nil {
-							fmt.Println("Re Case Error_2:", req.CaseId)
-						}
-						if req.Count > maxRetry {
-							utils.CaseSyncTempSet(req.CaseId, string(b_))
-							utils.CaseSyncDel(req.CaseId)
-						} else {
-
-							utils.CaseSyncSet(req.CaseId, string(b_))
-						}
-
-					} else {
-						fmt.Println("Re Case Success:", req.CaseId)
-						utils.CaseSyncDel(req.CaseId)
+				if req.Count > maxRetry {
+					queueErr = utils.CaseSyncTempSetFenced(fence, req.CaseId, string(b_))
+					if queueErr == nil {
+						queueErr = utils.CaseSyncDelFenced(fence, req.CaseId)
 					}
+				} else {
 
+					queueErr = utils.CaseSyncSetFenced(fence, req.CaseId, string(b_))
 				}
 
-				log.Printf("CaseId=%s Count=%d\n", req.CaseId, req.Count)
+			} else {
+				fmt.Println("Re Case Success:", req.CaseId)
+				queueErr = utils.CaseSyncDelFenced(fence, req.CaseId)
 			}
-
-			err = utils.OwnerCaseSyncSet("")
-			if err != nil {
-				log.Println("Redis SET error:", err)
-				return err
+			if errors.Is(queueErr, utils.ErrStaleFence) {
+				return fmt.Errorf("case sync stopped: %w", queueErr)
+			} else if queueErr != nil {
+				log.Printf("Case Sync queue of %s: %v", req.CaseId, queueErr)
 			}
-			log.Printf("Case Sync lock acquired/renewed by host: %s\n", hostname)
-		} else {
-			log.Printf("Case Sync lock held by another host: %s (this host: %s)\n", val, hostname)
-		}
-
-		counter++
 
-		intervalStr := os.Getenv("ESB_RE_CREATE_INTERVAL")
-		interval, err := strconv.Atoi(intervalStr)
-		if err != nil {
-			log.Printf("Invalid ESB_RE_CREATE_INTERVAL=%s, fallback to 1 min", intervalStr)
-			interval = 1
+		} else {
+			cancel()
 		}
 
-		sleep := time.Duration(interval) * time.Minute
-		log.Print("Sleep : ", sleep)
-		time.Sleep(sleep)
+		log.Printf("CaseId=%s Count=%d\n", req.CaseId, req.Count)
 	}
+	return nil
 }
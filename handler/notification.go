@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -166,7 +167,6 @@ func UpdateNotification(c *gin.Context) {
 
 	conn, ctx, cancel := utils.ConnectDB()
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// UPDATED: SQL UPDATE statement to include new updatable fields like expiredAt
 	tag, err := conn.Exec(ctx, `
@@ -278,7 +278,6 @@ func DeleteNotification(c *gin.Context) {
 
 	conn, ctx, cancel := utils.ConnectDB()
 	defer cancel()
-	defer conn.Close(ctx)
 
 	tag, err := conn.Exec(ctx, `DELETE FROM notifications WHERE "id" = $1`, id)
 	if err != nil {
@@ -351,7 +350,6 @@ func GetNotificationsForUser(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// 1) ดึงโปรไฟล์ผู้ใช้ - แคสต์เป็น text ให้หมด และแก้ COALESCE grpId
 	var userProfile model.UserProfile
@@ -603,7 +601,6 @@ func DeleteExpiredNotifications() {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// Delete notifications where expiredAt is not NULL and is older than the current time
 	tag, err := conn.Exec(ctx, `DELETE FROM notifications WHERE "expiredAt" IS NOT NULL AND "expiredAt" < NOW()`)
@@ -619,20 +616,21 @@ func DeleteExpiredNotifications() {
 	}
 }
 
-// StartAutoDeleteScheduler starts a ticker that runs the DeleteExpiredNotifications
-// function at a regular interval (e.g., every hour).
-func StartAutoDeleteScheduler() {
+// StartAutoDeleteScheduler runs the DeleteExpiredNotifications function at a
+// regular interval (e.g., every hour) until ctx is cancelled.
+func StartAutoDeleteScheduler(ctx context.Context) error {
 	log.Println("Starting background scheduler for auto-deleting notifications...")
 	// Run the cleanup job every 1 hour.
 	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
 
-	// Run forever in the background
-	go func() {
-		for {
-			// Wait for the ticker to fire
-			<-ticker.C
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
 			// Run the deletion job
 			DeleteExpiredNotifications()
 		}
-	}()
+	}
 }
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// NotifyMessage is a message sent to a user outside the application. Each
+// Notifier picks the address it delivers to: email for SMTP, mobile number
+// for SMS.
+type NotifyMessage struct {
+	Email   string
+	Mobile  string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages such as password reset tokens to users.
+type Notifier interface {
+	Send(ctx context.Context, msg NotifyMessage) error
+}
+
+// UserNotifier is the notifier used for account messages. It is set by
+// InitNotifier at start up; tests may replace it with a MemoryNotifier.
+var UserNotifier Notifier
+
+// InitNotifier selects the notifier from NOTIFIER ("smtp", "sms" or
+// "memory", default memory). The memory notifier delivers nothing, so it is
+// refused unless APP_ENV names a development environment.
+func InitNotifier() error {
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("NOTIFIER"))); kind {
+	case "smtp":
+		UserNotifier = NewSMTPNotifier()
+	case "sms":
+		UserNotifier = NewSMSNotifier()
+	default:
+		if !devEnvironment() {
+			return fmt.Errorf("NOTIFIER=%q keeps account messages in memory and is only allowed when APP_ENV is development, local or test (APP_ENV=%q); set NOTIFIER to smtp or sms", kind, os.Getenv("APP_ENV"))
+		}
+		log.Println("Notifier: in-memory, messages are not delivered")
+		UserNotifier = NewMemoryNotifier()
+	}
+	return nil
+}
+
+// devEnvironment reports whether APP_ENV names a development environment.
+func devEnvironment() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV"))) {
+	case "dev", "development", "local", "test":
+		return true
+	}
+	return false
+}
+
+func userNotifier() Notifier {
+	if UserNotifier == nil {
+		if err := InitNotifier(); err != nil {
+			log.Printf("WARNING: %v; account messages are not delivered", err)
+			UserNotifier = NewMemoryNotifier()
+		}
+	}
+	return UserNotifier
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// MemoryNotifier keeps messages in memory instead of sending them, for local
+// runs and tests. Only the recipient and subject are logged: bodies carry
+// secrets such as password reset tokens.
+type MemoryNotifier struct {
+	mu   sync.Mutex
+	sent []NotifyMessage
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+func (n *MemoryNotifier) Send(ctx context.Context, msg NotifyMessage) error {
+	n.mu.Lock()
+	n.sent = append(n.sent, msg)
+	n.mu.Unlock()
+	log.Printf("Memory notifier to email=%q mobile=%q: %s", msg.Email, msg.Mobile, msg.Subject)
+	return nil
+}
+
+// Sent returns every message sent so far.
+func (n *MemoryNotifier) Sent() []NotifyMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]NotifyMessage(nil), n.sent...)
+}
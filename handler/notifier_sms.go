@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMSNotifier posts messages to an HTTP SMS gateway as
+// {"to", "sender", "message"} with a bearer token.
+type SMSNotifier struct {
+	url    string
+	token  string
+	sender string
+	client *http.Client
+}
+
+// NewSMSNotifier reads SMS_GATEWAY_URL, SMS_GATEWAY_TOKEN and SMS_SENDER.
+func NewSMSNotifier() *SMSNotifier {
+	return &SMSNotifier{
+		url:    strings.TrimSpace(os.Getenv("SMS_GATEWAY_URL")),
+		token:  strings.TrimSpace(os.Getenv("SMS_GATEWAY_TOKEN")),
+		sender: strings.TrimSpace(os.Getenv("SMS_SENDER")),
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (n *SMSNotifier) Send(ctx context.Context, msg NotifyMessage) error {
+	if msg.Mobile == "" {
+		return fmt.Errorf("no mobile number")
+	}
+	payload, err := json.Marshal(map[string]string{
+		"to":      msg.Mobile,
+		"sender":  n.sender,
+		"message": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms gateway returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
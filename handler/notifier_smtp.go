@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// SMTPNotifier sends messages by email. net/smtp upgrades the connection
+// with STARTTLS when the server offers it.
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPNotifier reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and
+// SMTP_FROM. Authentication is skipped when SMTP_USERNAME is empty.
+func NewSMTPNotifier() *SMTPNotifier {
+	host := strings.TrimSpace(os.Getenv("SMTP_HOST"))
+	port := strings.TrimSpace(os.Getenv("SMTP_PORT"))
+	if port == "" {
+		port = "587"
+	}
+	n := &SMTPNotifier{
+		addr: host + ":" + port,
+		from: strings.TrimSpace(os.Getenv("SMTP_FROM")),
+	}
+	if user := strings.TrimSpace(os.Getenv("SMTP_USERNAME")); user != "" {
+		n.auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return n
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, msg NotifyMessage) error {
+	if msg.Email == "" {
+		return fmt.Errorf("no email address")
+	}
+	if strings.ContainsAny(msg.Email+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+	body := "From: " + n.from + "\r\n" +
+		"To: " + msg.Email + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body + "\r\n"
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{msg.Email}, []byte(body))
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"mainPackage/utils"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Passwords are stored as argon2id hashes in the PHC string format
+//
+//	$argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<threads>$<salt>$<hash>
+//
+// so that the algorithm and its cost travel with each hash and can be raised
+// later. The cost is read from PASSWORD_HASH_MEMORY (KiB, default 19456),
+// PASSWORD_HASH_TIME (default 2) and PASSWORD_HASH_THREADS (default 1).
+// Values without the "$argon2id$" prefix were written by the old reversible
+// AES encrypt and are only ever decrypted to be checked and re-hashed.
+
+const (
+	argon2idPrefix = "$argon2id$"
+	argon2SaltLen  = 16
+	argon2KeyLen   = 32
+)
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func currentArgon2Params() argon2Params {
+	return argon2Params{
+		memory:  uint32(utils.EnvInt("PASSWORD_HASH_MEMORY", 19456)),
+		time:    uint32(utils.EnvInt("PASSWORD_HASH_TIME", 2)),
+		threads: uint8(utils.EnvInt("PASSWORD_HASH_THREADS", 1)),
+	}
+}
+
+// hashPassword returns the value to store for a new password.
+func hashPassword(password string) (string, error) {
+	p := currentArgon2Params()
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword checks password against a stored value. rehash is true when
+// the password matched but the stored value should be replaced by
+// hashPassword, because it is a legacy AES value or uses an older cost.
+func verifyPassword(stored string, password string) (ok bool, rehash bool, err error) {
+	if !strings.HasPrefix(stored, argon2idPrefix) {
+		plain, err := decrypt(stored)
+		if err != nil {
+			return false, false, err
+		}
+		ok = subtle.ConstantTimeCompare([]byte(plain), []byte(password)) == 1
+		return ok, ok, nil
+	}
+
+	var version int
+	var p argon2Params
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false, fmt.Errorf("invalid password hash")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return false, false, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid argon2 hash: %w", err)
+	}
+	got := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(want)))
+	ok = subtle.ConstantTimeCompare(got, want) == 1
+	return ok, ok && p != currentArgon2Params(), nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// burnPasswordCheck takes as long as verifyPassword on a real hash. Logins of
+// unknown users call it so the response time does not tell which usernames
+// exist.
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() { dummyHash, _ = hashPassword("") })
+	_, _, _ = verifyPassword(dummyHash, password)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"fmt"
+	"mainPackage/utils"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy is the set of rules a new password must follow. It is read
+// from the environment on every check so it can be changed without a
+// rebuild:
+//
+//	PASSWORD_MIN_LENGTH      minimum length in characters (default 8)
+//	PASSWORD_MAX_LENGTH      maximum length in characters (default 72)
+//	PASSWORD_REQUIRE_UPPER   at least one upper case letter (default true)
+//	PASSWORD_REQUIRE_LOWER   at least one lower case letter (default true)
+//	PASSWORD_REQUIRE_DIGIT   at least one digit (default true)
+//	PASSWORD_REQUIRE_SYMBOL  at least one other character (default false)
+//
+// A password that contains the username is always rejected.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+func passwordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     utils.EnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:     utils.EnvInt("PASSWORD_MAX_LENGTH", 72),
+		RequireUpper:  utils.EnvBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:  utils.EnvBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:  utils.EnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: utils.EnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+	}
+}
+
+// Check returns an error naming every rule the password breaks.
+func (p PasswordPolicy) Check(password string, username string) error {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	var problems []string
+	n := len([]rune(password))
+	if n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && n > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("at most %d characters", p.MaxLength))
+	}
+	if p.RequireUpper && !upper {
+		problems = append(problems, "an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		problems = append(problems, "a symbol")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("password must have %s", strings.Join(problems, ", "))
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("password must not contain the username")
+	}
+	return nil
+}
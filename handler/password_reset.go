@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Self-service password reset runs in two steps. RequestPasswordReset sends a
+// random token to the user through UserNotifier and keeps only its SHA-256
+// hash in Redis for PASSWORD_RESET_EXPIRE minutes; ConfirmPasswordReset takes
+// the token once and sets the new password. Requests are limited to
+// PASSWORD_RESET_LIMIT per account every PASSWORD_RESET_WINDOW minutes, and
+// are answered no sooner than PASSWORD_RESET_DELAY milliseconds after they
+// came in, before the token is made or sent, so the response time does not
+// tell whether the account exists.
+
+const passwordResetSent = "If the account exists, a reset token has been sent to it"
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// passwordResetMessage puts the token in PASSWORD_RESET_URL at "{token}" when
+// it is set, so the user gets a link, or sends the token itself otherwise.
+func passwordResetMessage(email string, mobile string, token string, ttl time.Duration) NotifyMessage {
+	secret := token
+	if link := strings.TrimSpace(os.Getenv("PASSWORD_RESET_URL")); link != "" {
+		secret = strings.ReplaceAll(link, "{token}", url.QueryEscape(token))
+	}
+	return NotifyMessage{
+		Email:   email,
+		Mobile:  mobile,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("A password reset was requested for your account.\n\n%s\n\n"+
+			"This is valid once, for %d minutes. If you did not ask for it you can ignore this message.",
+			secret, int(ttl.Minutes())),
+	}
+}
+
+// @summary Request Password Reset
+// @description Sends a one-time reset token to the user. The response is the same whether or not the account exists.
+// @tags User
+// @id Request Password Reset
+// @accept json
+// @produce json
+// @param Body body model.ResetPasswordRequest true "username and email of the account"
+// @response 200 {object} model.Response "OK - Request successful"
+// @response 429 {object} model.Response "Too many requests for this account"
+// @Router /api/v1/users/reset_password/request [post]
+func RequestPasswordReset(c *gin.Context) {
+	logger := utils.GetLog()
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+
+	var req model.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, "", "",
+			txtId, "", "um_user", "RequestPasswordReset", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	window := time.Duration(utils.EnvInt("PASSWORD_RESET_WINDOW", 60)) * time.Minute
+	hits, err := utils.PasswordResetHit(strings.ToLower(req.Username), window)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   "Cannot process the request",
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, "", req.Username,
+			txtId, "", "um_user", "RequestPasswordReset", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusServiceUnavailable, response)
+		logger.Warn("Password reset rate check failed", zap.Error(err))
+		return
+	}
+	if hits > int64(utils.EnvInt("PASSWORD_RESET_LIMIT", 3)) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   "Too many reset requests, please try again later",
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, "", req.Username,
+			txtId, "", "um_user", "RequestPasswordReset", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Rate limited.",
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusTooManyRequests, response)
+		return
+	}
+
+	// From here on the caller gets the same answer whatever happens, so the
+	// endpoint cannot be used to find out which accounts exist. It is sent
+	// after the same lookup and the same delay on every path; the token is
+	// only made and delivered once the caller has it.
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   passwordResetSent,
+	}
+	answer := func() {
+		delay := time.Duration(utils.EnvInt("PASSWORD_RESET_DELAY", 500)) * time.Millisecond
+		time.Sleep(time.Until(start_time.Add(delay)))
+		c.JSON(http.StatusOK, response)
+		c.Writer.Flush()
+	}
+
+	var target model.PasswordResetToken
+	var email, mobile *string
+	err = conn.QueryRow(ctx, `
+		SELECT id, "orgId", username, email, "mobileNo" FROM public.um_users
+		WHERE username=$1 AND email=$2 AND active=true`, req.Username, req.Email).
+		Scan(&target.UserID, &target.OrgID, &target.Username, &email, &mobile)
+	answer()
+	if err != nil {
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, "", req.Username,
+			txtId, "", "um_user", "RequestPasswordReset", "",
+			"update", -1, start_time, GetQueryParams(c), response, "User not found or inactive.",
+		)
+		//=======AUDIT_END=====//
+		return
+	}
+
+	token, err := newResetToken()
+	var value []byte
+	if err == nil {
+		value, err = json.Marshal(target)
+	}
+	ttl := time.Duration(utils.EnvInt("PASSWORD_RESET_EXPIRE", 30)) * time.Minute
+	if err == nil {
+		err = utils.PasswordResetSet(target.OrgID, target.Username, hashResetToken(token), string(value), ttl)
+	}
+	if err == nil {
+		msg := passwordResetMessage(derefString(email), derefString(mobile), token, ttl)
+		err = userNotifier().Send(ctx, msg)
+	}
+	if err != nil {
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, target.OrgID, target.Username,
+			txtId, target.UserID, "um_user", "RequestPasswordReset", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		logger.Warn("Password reset token not delivered", zap.String("username", target.Username), zap.Error(err))
+		return
+	}
+
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, target.OrgID, target.Username,
+		txtId, target.UserID, "um_user", "RequestPasswordReset", "",
+		"update", 0, start_time, GetQueryParams(c), response, "RequestPasswordReset Success.",
+	)
+	//=======AUDIT_END=====//
+}
+
+// @summary Confirm Password Reset
+// @description Sets a new password with a token from Request Password Reset. The token works once and every session of the user is ended.
+// @tags User
+// @id Confirm Password Reset
+// @accept json
+// @produce json
+// @param Body body model.ResetPasswordConfirm true "token and new password"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/users/reset_password/confirm [post]
+func ConfirmPasswordReset(c *gin.Context) {
+	logger := utils.GetLog()
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+	start_time := time.Now()
+	txtId := uuid.New().String()
+
+	fail := func(status int, orgId string, username string, id string, desc string, msg string) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   desc,
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId, username,
+			txtId, id, "um_user", "ConfirmPasswordReset", "",
+			"update", -1, start_time, GetQueryParams(c), response, msg,
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.ResetPasswordConfirm
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, "", "", "", err.Error(), "Failed : "+err.Error())
+		return
+	}
+
+	// The token is only looked at here and taken after the new password
+	// passes the policy, so a rejected password does not use it up.
+	tokenHash := hashResetToken(req.Token)
+	value, err := utils.PasswordResetGet(tokenHash)
+	if err != nil {
+		logger.Warn("Password reset lookup failed", zap.Error(err))
+		fail(http.StatusServiceUnavailable, "", "", "", "Cannot process the request", "Failed : "+err.Error())
+		return
+	}
+	var target model.PasswordResetToken
+	if value == "" || json.Unmarshal([]byte(value), &target) != nil {
+		fail(http.StatusBadRequest, "", "", "", "Invalid or expired token", "Invalid or expired token.")
+		return
+	}
+
+	if err := passwordPolicy().Check(req.NewPassword, target.Username); err != nil {
+		fail(http.StatusBadRequest, target.OrgID, target.Username, target.UserID, err.Error(), "Password policy : "+err.Error())
+		return
+	}
+
+	value, err = utils.PasswordResetTake(tokenHash)
+	if err != nil || value == "" {
+		fail(http.StatusBadRequest, target.OrgID, target.Username, target.UserID, "Invalid or expired token", "Token already used.")
+		return
+	}
+
+	encPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		fail(http.StatusInternalServerError, target.OrgID, target.Username, target.UserID, "Password hashing failed", "Failed : "+err.Error())
+		return
+	}
+
+	tag, err := conn.Exec(ctx, `
+		UPDATE public.um_users SET password=$1, "updatedAt"=$2, "updatedBy"=$3
+		WHERE id=$4 AND "orgId"=$5 AND active=true`,
+		encPassword, time.Now(), target.Username, target.UserID, target.OrgID)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = fmt.Errorf("user not found or inactive")
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, target.OrgID, target.Username, target.UserID, err.Error(), "Failed : "+err.Error())
+		return
+	}
+
+	_ = utils.PasswordResetUserDel(target.OrgID, target.Username)
+	RevokeUserSessions(target.OrgID, target.Username, "", "password reset")
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Password reset successfully",
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, target.OrgID, target.Username,
+		txtId, target.UserID, "um_user", "ConfirmPasswordReset", "",
+		"update", 0, start_time, GetQueryParams(c), response, "ConfirmPasswordReset Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
@@ -32,7 +32,6 @@ func GetPermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -153,7 +152,6 @@ func GetPermissionById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT  id, "groupName", "permId", "permName", active, "createdAt", "updatedAt", "createdBy", "updatedBy" 
 	FROM public.um_permissions WHERE "permId"=$1`
 
@@ -235,7 +233,6 @@ func InsertPermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.PermissionInsert
@@ -318,7 +315,6 @@ func UpdatePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -408,7 +404,6 @@ func DeletePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	orgId := GetVariableFromToken(c, "orgId")
 	id := c.Param("id")
@@ -0,0 +1,429 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Permission keys required by protected routes. A role holds a key when one of
+// its active um_role_with_permissions rows points at a um_permissions row whose
+// permId or permName equals the key.
+// migrations/0001_permission_keys.sql creates these keys; keep it in step.
+const (
+	PermAny = "" // any authenticated user
+
+	PermFormView      = "form.view"
+	PermFormManage    = "form.manage"
+	PermFormDelete    = "form.delete"
+	PermWorkflowView  = "workflow.view"
+	PermWorkflowEdit  = "workflow.manage"
+	PermWorkflowDel   = "workflow.delete"
+	PermWorkflowMig   = "workflow.migrate"
+	PermCaseView      = "case.view"
+	PermCaseManage    = "case.manage"
+	PermCaseDelete    = "case.delete"
+	PermMasterView    = "master.view"
+	PermMasterManage  = "master.manage"
+	PermMasterDelete  = "master.delete"
+	PermRoleView      = "role.view"
+	PermRoleManage    = "role.manage"
+	PermRoleDelete    = "role.delete"
+	PermUserView      = "user.view"
+	PermUserManage    = "user.manage"
+	PermUserDelete    = "user.delete"
+	PermCustomerView  = "customer.view"
+	PermCustomerEdit  = "customer.manage"
+	PermCustomerDel   = "customer.delete"
+	PermDispatch      = "dispatch.manage"
+	PermDispatchView  = "dispatch.view"
+	PermAuditView     = "audit.view"
+	PermFileManage    = "file.manage"
+	PermHistoryView   = "history.view"
+	PermHistoryManage = "history.manage"
+	PermEsbView       = "integration.view"
+	PermEsbManage     = "integration.manage"
+	PermMonitorView   = "monitor.view"
+)
+
+// RoutePermissions maps "METHOD /full/route/path" (as registered in main.go) to
+// the permission key needed to call it. Routes missing from this table are
+// rejected so a new endpoint cannot go live without a permission decision.
+var RoutePermissions = map[string]string{
+	"GET /api/v1/area/country_province_districts": PermAny,
+	"POST /api/v1/logout":                         PermAny,
+	"GET /api/v1/sessions":                        PermAny,
+	"GET /api/v1/mfa":                             PermAny,
+	"POST /api/v1/mfa/enroll":                     PermAny,
+	"POST /api/v1/mfa/enroll/confirm":             PermAny,
+	"POST /api/v1/mfa/recovery_codes":             PermAny,
+	"DELETE /api/v1/mfa":                          PermAny,
+
+	"GET /api/v1/forms":                       PermFormView,
+	"GET /api/v1/forms/:formId":               PermFormView,
+	"GET /api/v1/forms/:formId/diff":          PermFormView,
+	"GET /api/v1/forms/:formId/lineage":       PermFormView,
+	"GET /api/v1/forms/GetFormlinkWf":         PermFormView,
+	"GET /api/v1/forms/getAllFormslinkWf":     PermFormView,
+	"GET /api/v1/forms/getAllForms":           PermFormView,
+	"POST /api/v1/forms/casesubtype":          PermFormView,
+	"POST /api/v1/forms/validate":             PermFormView,
+	"POST /api/v1/forms/answers/reindex":      PermFormManage,
+	"POST /api/v1/forms":                      PermFormManage,
+	"PATCH /api/v1/forms/:uuid":               PermFormManage,
+	"PATCH /api/v1/forms/active":              PermFormManage,
+	"PATCH /api/v1/forms/lock":                PermFormManage,
+	"PATCH /api/v1/forms/publish":             PermFormManage,
+	"PATCH /api/v1/forms/version":             PermFormManage,
+	"DELETE /api/v1/forms/:formId":            PermFormDelete,
+	"GET /api/v1/workflows":                   PermWorkflowView,
+	"GET /api/v1/workflows/:id":               PermWorkflowView,
+	"GET /api/v1/workflows/:id/versions":      PermWorkflowView,
+	"GET /api/v1/workflows/:id/export":        PermWorkflowView,
+	"GET /api/v1/workflows/:id/diff":          PermWorkflowView,
+	"POST /api/v1/workflows":                  PermWorkflowEdit,
+	"POST /api/v1/workflows/validate":         PermWorkflowEdit,
+	"POST /api/v1/workflows/simulate":         PermWorkflowEdit,
+	"POST /api/v1/workflows/import":           PermWorkflowEdit,
+	"POST /api/v1/workflows/migrate":          PermWorkflowMig,
+	"POST /api/v1/workflows/migrate/rollback": PermWorkflowMig,
+	"PATCH /api/v1/workflows/:uuid":           PermWorkflowEdit,
+	"DELETE /api/v1/workflows/:uuid":          PermWorkflowDel,
+	"GET /api/v1/case":                        PermCaseView,
+	"GET /api/v1/case/:id":                    PermCaseView,
+	"GET /api/v1/case/caseId/:caseId":         PermCaseView,
+	"GET /api/v1/case/result/":                PermCaseView,
+	"POST /api/v1/case/answers/query":         PermCaseView,
+	"POST /api/v1/case/add":                   PermCaseManage,
+	"PATCH /api/v1/case/:id":                  PermCaseManage,
+	"DELETE /api/v1/case/:id":                 PermCaseDelete,
+	"GET /api/v1/case_schedules":              PermCaseView,
+	"GET /api/v1/case_schedules/:id":          PermCaseView,
+	"GET /api/v1/case_schedules/:id/runs":     PermCaseView,
+	"POST /api/v1/case_schedules/add":         PermCaseManage,
+	"PATCH /api/v1/case_schedules/:id":        PermCaseManage,
+	"DELETE /api/v1/case_schedules/:id":       PermCaseManage,
+	"GET /api/v1/case_status":                 PermMasterView,
+	"GET /api/v1/case_status/:id":             PermMasterView,
+	"POST /api/v1/case_status/add":            PermMasterManage,
+	"PATCH /api/v1/case_status/:id":           PermMasterManage,
+	"DELETE /api/v1/case_status/:id":          PermMasterDelete,
+	"GET /api/v1/casetypes":                   PermMasterView,
+	"POST /api/v1/casetypes/add":              PermMasterManage,
+	"PATCH /api/v1/casetypes/:id":             PermMasterManage,
+	"DELETE /api/v1/casetypes/:id":            PermMasterDelete,
+	"GET /api/v1/casetypes_with_subtype":      PermMasterView,
+	"GET /api/v1/casesubtypes":                PermMasterView,
+	"POST /api/v1/casesubtypes/add":           PermMasterManage,
+	"PATCH /api/v1/casesubtypes/:id":          PermMasterManage,
+	"DELETE /api/v1/casesubtypes/:id":         PermMasterDelete,
+	"GET /api/v1/departments":                 PermMasterView,
+	"GET /api/v1/departments/:id":             PermMasterView,
+	"POST /api/v1/departments/add":            PermMasterManage,
+	"PATCH /api/v1/departments/:id":           PermMasterManage,
+	"DELETE /api/v1/departments/:id":          PermMasterDelete,
+	"GET /api/v1/commands":                    PermMasterView,
+	"GET /api/v1/commands/:id":                PermMasterView,
+	"POST /api/v1/commands/add":               PermMasterManage,
+	"PATCH /api/v1/commands/:id":              PermMasterManage,
+	"DELETE /api/v1/commands/:id":             PermMasterDelete,
+	"GET /api/v1/department_command_stations": PermMasterView,
+	"GET /api/v1/stations":                    PermMasterView,
+	"GET /api/v1/stations/:id":                PermMasterView,
+	"POST /api/v1/stations/add":               PermMasterManage,
+	"PATCH /api/v1/stations/:id":              PermMasterManage,
+	"DELETE /api/v1/stations/:id":             PermMasterDelete,
+
+	"GET /api/v1/role":                                 PermRoleView,
+	"GET /api/v1/role/:id":                             PermRoleView,
+	"POST /api/v1/role/add":                            PermRoleManage,
+	"PATCH /api/v1/role/:id":                           PermRoleManage,
+	"DELETE /api/v1/role/:id":                          PermRoleDelete,
+	"GET /api/v1/permission":                           PermRoleView,
+	"GET /api/v1/permission/:permId":                   PermRoleView,
+	"POST /api/v1/permission/add":                      PermRoleManage,
+	"PATCH /api/v1/permission/:permId":                 PermRoleManage,
+	"DELETE /api/v1/permission/:permId":                PermRoleDelete,
+	"GET /api/v1/role_permission":                      PermRoleView,
+	"GET /api/v1/role_permission/:id":                  PermRoleView,
+	"GET /api/v1/role_permission/roleId/:roleId":       PermRoleView,
+	"POST /api/v1/role_permission/add":                 PermRoleManage,
+	"PATCH /api/v1/role_permission/:roleId":            PermRoleManage,
+	"PATCH /api/v1/role_permission/multi":              PermRoleManage,
+	"DELETE /api/v1/role_permission/:id":               PermRoleDelete,
+	"GET /api/v1/customer":                             PermCustomerView,
+	"POST /api/v1/customer/add":                        PermCustomerEdit,
+	"GET /api/v1/customer/:id":                         PermCustomerView,
+	"GET /api/v1/customer/byPhoneNo/:phoneNo":          PermCustomerView,
+	"PATCH /api/v1/customer/:id":                       PermCustomerEdit,
+	"DELETE /api/v1/customer/:id":                      PermCustomerDel,
+	"GET /api/v1/customer_contacts":                    PermCustomerView,
+	"POST /api/v1/customer_contacts/add":               PermCustomerEdit,
+	"GET /api/v1/customer_contacts/:id":                PermCustomerView,
+	"PATCH /api/v1/customer_contacts/:id":              PermCustomerEdit,
+	"DELETE /api/v1/customer_contacts/:id":             PermCustomerDel,
+	"GET /api/v1/customer_with_socials":                PermCustomerView,
+	"POST /api/v1/customer_with_socials/add":           PermCustomerEdit,
+	"GET /api/v1/customer_with_socials/:id":            PermCustomerView,
+	"PATCH /api/v1/customer_with_socials/:id":          PermCustomerEdit,
+	"DELETE /api/v1/customer_with_socials/:id":         PermCustomerDel,
+	"GET /api/v1/users":                                PermUserView,
+	"GET /api/v1/users/:id":                            PermUserView,
+	"POST /api/v1/users/add":                           PermUserManage,
+	"PATCH /api/v1/users/:id":                          PermUserManage,
+	"DELETE /api/v1/users/:id":                         PermUserDelete,
+	"PATCH /api/v1/users/change_password/:id":          PermAny,
+	"GET /api/v1/users/username/:username":             PermAny,
+	"GET /api/v1/users/username/ForCaseInfo/:username": PermCaseView,
+	"PATCH /api/v1/users/username/:username":           PermUserManage,
+	"GET /api/v1/users_with_skills":                    PermUserView,
+	"GET /api/v1/users_with_skills/:id":                PermUserView,
+	"GET /api/v1/users_with_skills/skillId/:skillId":   PermUserView,
+	"GET /api/v1/users_with_skills/username/:username": PermUserView,
+	"POST /api/v1/users_with_skills/add":               PermUserManage,
+	"POST /api/v1/users_with_skills_batch/add":         PermUserManage,
+	"PATCH /api/v1/users_with_skills/:id":              PermUserManage,
+	"DELETE /api/v1/users_with_skills/:id":             PermUserDelete,
+	"GET /api/v1/users_with_contacts":                  PermUserView,
+	"GET /api/v1/users_with_contacts/:id":              PermUserView,
+	"POST /api/v1/users_with_contacts/add":             PermUserManage,
+	"PATCH /api/v1/users_with_contacts/:id":            PermUserManage,
+	"DELETE /api/v1/users_with_contacts/:id":           PermUserDelete,
+	"GET /api/v1/users_with_socials":                   PermUserView,
+	"GET /api/v1/users_with_socials/:id":               PermUserView,
+	"POST /api/v1/users_with_socials/add":              PermUserManage,
+	"PATCH /api/v1/users_with_socials/:id":             PermUserManage,
+	"DELETE /api/v1/users_with_socials/:id":            PermUserDelete,
+	"GET /api/v1/user_groups/all":                      PermUserView,
+
+	"GET /api/v1/users/username/:username/sessions":               PermUserView,
+	"DELETE /api/v1/users/username/:username/sessions":            PermUserManage,
+	"DELETE /api/v1/users/username/:username/sessions/:sessionId": PermUserManage,
+	"DELETE /api/v1/users/username/:username/mfa":                 PermUserManage,
+	"GET /api/v1/login_locks":                                     PermUserView,
+	"DELETE /api/v1/login_locks/users/:username":                  PermUserManage,
+	"DELETE /api/v1/login_locks/ips/:ip":                          PermUserManage,
+
+	"GET /api/v1/skill":                       PermMasterView,
+	"POST /api/v1/skill/add":                  PermMasterManage,
+	"GET /api/v1/skill/:id":                   PermMasterView,
+	"PATCH /api/v1/skill/:id":                 PermMasterManage,
+	"DELETE /api/v1/skill/:id":                PermMasterDelete,
+	"GET /api/v1/mdm/properties":              PermMasterView,
+	"GET /api/v1/mdm/properties/:id":          PermMasterView,
+	"POST /api/v1/mdm/properties/add":         PermMasterManage,
+	"PATCH /api/v1/mdm/properties/:id":        PermMasterManage,
+	"DELETE /api/v1/mdm/properties/:id":       PermMasterDelete,
+	"GET /api/v1/mdm/sources":                 PermMasterView,
+	"GET /api/v1/mdm/sources/:id":             PermMasterView,
+	"POST /api/v1/mdm/sources/add":            PermMasterManage,
+	"PATCH /api/v1/mdm/sources/:id":           PermMasterManage,
+	"DELETE /api/v1/mdm/sources/:id":          PermMasterDelete,
+	"GET /api/v1/mdm/types":                   PermMasterView,
+	"GET /api/v1/mdm/types/:id":               PermMasterView,
+	"POST /api/v1/mdm/types/add":              PermMasterManage,
+	"PATCH /api/v1/mdm/types/:id":             PermMasterManage,
+	"DELETE /api/v1/mdm/types/:id":            PermMasterDelete,
+	"GET /api/v1/mdm/companies":               PermMasterView,
+	"GET /api/v1/mdm/companies/:id":           PermMasterView,
+	"POST /api/v1/mdm/companies/add":          PermMasterManage,
+	"PATCH /api/v1/mdm/companies/:id":         PermMasterManage,
+	"DELETE /api/v1/mdm/companies/:id":        PermMasterDelete,
+	"GET /api/v1/mdm/status":                  PermMasterView,
+	"GET /api/v1/mdm/status/:id":              PermMasterView,
+	"POST /api/v1/mdm/status/add":             PermMasterManage,
+	"PATCH /api/v1/mdm/status/:id":            PermMasterManage,
+	"DELETE /api/v1/mdm/status/:id":           PermMasterDelete,
+	"GET /api/v1/mdm/units":                   PermMasterView,
+	"GET /api/v1/mdm/units/:id":               PermMasterView,
+	"POST /api/v1/mdm/units/add":              PermMasterManage,
+	"PATCH /api/v1/mdm/units/:id":             PermMasterManage,
+	"DELETE /api/v1/mdm/units/:id":            PermMasterDelete,
+	"GET /api/v1/mdm/units/properties/unitId": PermMasterView,
+
+	"GET /api/v1/dispatch/:caseId/SOP":              PermDispatchView,
+	"GET /api/v1/dispatch/:caseId/units":            PermDispatchView,
+	"GET /api/v1/dispatch/:caseId/SOP/unit/:unitId": PermDispatchView,
+	"POST /api/v1/dispatch/event":                   PermDispatch,
+	"POST /api/v1/dispatch/cancel/unit":             PermDispatch,
+	"POST /api/v1/dispatch/cancel/case":             PermDispatch,
+
+	"GET /api/v1/audit_log":           PermAuditView,
+	"GET /api/v1/audit_log/:username": PermAuditView,
+
+	"GET /api/v1/esb/dead_letters":             PermEsbView,
+	"GET /api/v1/esb/dead_letters/:id":         PermEsbView,
+	"PATCH /api/v1/esb/dead_letters/:id":       PermEsbManage,
+	"POST /api/v1/esb/dead_letters/:id/replay": PermEsbManage,
+
+	"GET /api/v1/monitors/leaders": PermMonitorView,
+
+	"GET /api/v1/case_history":         PermHistoryView,
+	"GET /api/v1/case_history/:caseId": PermHistoryView,
+	"POST /api/v1/case_history/add":    PermHistoryManage,
+	"PATCH /api/v1/case_history/:id":   PermHistoryManage,
+	"DELETE /api/v1/case_history/:id":  PermHistoryManage,
+	"GET /api/v1/devices":              PermMasterView,
+	"GET /api/v1/devices/:id":          PermMasterView,
+	"POST /api/v1/upload/:path":        PermFileManage,
+	"DELETE /api/v1/delete/":           PermFileManage,
+}
+
+// PermissionHandler must run after ProtectedHandler. It looks up the permission
+// required by the matched route and rejects the request with 403 when the
+// caller's role does not hold it, as it does for routes missing from
+// RoutePermissions. PERMISSION_ENFORCE=false lets denied requests through
+// with a warning, for rolling out new keys; it is on by default.
+func PermissionHandler(c *gin.Context) {
+	logger := utils.GetLog()
+	route := c.Request.Method + " " + c.FullPath()
+
+	required, ok := RoutePermissions[route]
+	if ok && required == PermAny {
+		c.Next()
+		return
+	}
+
+	username, uOK := c.Get("username")
+	orgId, oOK := c.Get("orgId")
+	if !uOK || !oOK {
+		c.JSON(http.StatusUnauthorized, model.Response{
+			Status: "-1",
+			Msg:    "Failed",
+			Desc:   "Unauthorized",
+		})
+		c.Abort()
+		return
+	}
+
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		c.JSON(http.StatusInternalServerError, model.Response{
+			Status: "-1", Msg: "Failure", Desc: "DB connection error",
+		})
+		c.Abort()
+		return
+	}
+	defer cancel()
+
+	allowed := false
+	if ok {
+		perms, err := GetRolePermissionSet(ctx, conn, orgId.(string), username.(string))
+		if err != nil {
+			logger.Warn("Load role permissions failed", zap.Error(err))
+		}
+		_, allowed = perms[required]
+	} else {
+		required = "unmapped route"
+	}
+
+	if allowed {
+		c.Next()
+		return
+	}
+
+	response := model.Response{
+		Status: "-1",
+		Msg:    "Forbidden",
+		Desc:   "Permission denied : " + required,
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		uuid.New().String(), "", "Permission", "PermissionHandler", route,
+		"access", -1, time.Now(), GetQueryParams(c), response, "Permission denied : "+required,
+	)
+	//=======AUDIT_END=====//
+	enforce := utils.EnvBool("PERMISSION_ENFORCE", true)
+	logger.Warn("Permission denied",
+		zap.String("username", username.(string)),
+		zap.String("route", route),
+		zap.String("required", required),
+		zap.Bool("enforced", enforce),
+	)
+
+	if !enforce {
+		c.Next()
+		return
+	}
+	c.JSON(http.StatusForbidden, response)
+	c.Abort()
+}
+
+// GetRolePermissionSet returns the permission keys (permId and permName) held by
+// the user's role, loading them from Redis when cached.
+func GetRolePermissionSet(ctx context.Context, conn *pgx.Conn, orgId string, username string) (map[string]struct{}, error) {
+	user, err := utils.GetUserByUsername(ctx, conn, orgId, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found: %s", username)
+	}
+
+	key := rolePermissionCacheKey(orgId, user.RoleID)
+	var keys []string
+	cacheData, err := utils.UserPermissionGet(key)
+	if err == nil && cacheData != "" {
+		if jsonErr := json.Unmarshal([]byte(cacheData), &keys); jsonErr != nil {
+			keys = nil
+		}
+	}
+
+	if keys == nil {
+		query := `
+		SELECT rp."permId", COALESCE(p."permName", '')
+		FROM public.um_role_with_permissions rp
+		LEFT JOIN public.um_permissions p
+		       ON p."permId" = rp."permId"
+		      AND p.active = true
+		WHERE rp."orgId" = $1 AND rp."roleId" = $2 AND rp.active = true`
+		rows, err := conn.Query(ctx, query, orgId, user.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		keys = []string{}
+		for rows.Next() {
+			var permId, permName string
+			if err := rows.Scan(&permId, &permName); err != nil {
+				return nil, err
+			}
+			keys = append(keys, permId)
+			if permName != "" {
+				keys = append(keys, permName)
+			}
+		}
+
+		jsonData, _ := json.Marshal(keys)
+		utils.UserPermissionSet(key, string(jsonData))
+	}
+
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return set, nil
+}
+
+// ClearRolePermissionCache drops the cached permission set of a role so the
+// next request reloads it from the database.
+func ClearRolePermissionCache(orgId string, roleId string) {
+	if err := utils.UserPermissionDel(rolePermissionCacheKey(orgId, roleId)); err != nil {
+		utils.GetLog().Warn("Clear role permission cache failed", zap.Error(err))
+	}
+}
+
+func rolePermissionCacheKey(orgId string, roleId string) string {
+	return "role:" + orgId + ":" + roleId
+}
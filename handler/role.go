@@ -36,7 +36,6 @@ func GetRole(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -47,7 +46,7 @@ func GetRole(c *gin.Context) {
 	if err != nil {
 		length = 1000
 	}
-	query := `SELECT id, "orgId", "roleName", active, "createdAt", "updatedAt", "createdBy", "updatedBy"
+	query := `SELECT id, "orgId", "roleName", active, COALESCE("mfaRequired", false), "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.um_roles WHERE "orgId"=$1 LIMIT $2 OFFSET $3`
 
 	var rows pgx.Rows
@@ -78,7 +77,7 @@ func GetRole(c *gin.Context) {
 	for rows.Next() {
 		rowIndex++
 		err := rows.Scan(&Role.ID, &Role.OrgID, &Role.RoleName,
-			&Role.Active, &Role.CreatedAt, &Role.UpdatedAt, &Role.CreatedBy, &Role.UpdatedBy)
+			&Role.Active, &Role.MfaRequired, &Role.CreatedAt, &Role.UpdatedAt, &Role.CreatedBy, &Role.UpdatedBy)
 		if err != nil {
 			logger.Warn("Scan failed", zap.Error(err))
 			response := model.Response{
@@ -151,8 +150,7 @@ func GetRolebyId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
-	query := `SELECT id, "orgId", "roleName", active, "createdAt", "updatedAt", "createdBy", "updatedBy"
+	query := `SELECT id, "orgId", "roleName", active, COALESCE("mfaRequired", false), "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.um_roles WHERE id = $1 AND "orgId"=$2`
 
 	logger.Debug(`Query`, zap.String("query", query),
@@ -165,6 +163,7 @@ func GetRolebyId(c *gin.Context) {
 		&Role.OrgID,
 		&Role.RoleName,
 		&Role.Active,
+		&Role.MfaRequired,
 		&Role.CreatedAt,
 		&Role.UpdatedAt,
 		&Role.CreatedBy,
@@ -221,7 +220,6 @@ func InsertRole(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.RoleInsert
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -242,14 +240,14 @@ func InsertRole(c *gin.Context) {
 	var id uuid.UUID
 	query := `
 		INSERT INTO public."um_roles"(
-			id, "orgId", "roleName", active, "createdAt", "updatedAt", "createdBy", "updatedBy"
+			id, "orgId", "roleName", active, "mfaRequired", "createdAt", "updatedAt", "createdBy", "updatedBy"
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id;
 	`
 
 	err := conn.QueryRow(ctx, query,
-		roleUUID, orgId, req.RoleName, req.Active, now, now, username, username,
+		roleUUID, orgId, req.RoleName, req.Active, req.MfaRequired, now, now, username, username,
 	).Scan(&id)
 
 	if err != nil {
@@ -305,7 +303,6 @@ func UpdateRole(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -327,11 +324,11 @@ func UpdateRole(c *gin.Context) {
 
 	query := `UPDATE public."um_roles"
 	SET "roleName"=$2, active=$3,
-	 "updatedAt"=$4, "updatedBy"=$5
+	 "updatedAt"=$4, "updatedBy"=$5, "mfaRequired"=COALESCE($7, "mfaRequired")
 	WHERE id = $1 AND "orgId"=$6`
 	_, err := conn.Exec(ctx, query,
 		id, req.RoleName, req.Active,
-		now, username, orgId,
+		now, username, orgId, req.MfaRequired,
 	)
 	logger.Debug("Update Case SQL Args",
 		zap.String("query", query),
@@ -391,7 +388,6 @@ func DeleteRole(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	now := time.Now()
@@ -37,7 +37,6 @@ func GetRolePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -152,7 +151,6 @@ func GetRolePermissionbyId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	query := `SELECT id, "orgId", "roleId", "permId", active, "createdAt", "updatedAt", "createdBy", "updatedBy"
 	FROM public.um_role_with_permissions WHERE id = $1 AND "orgId" = $2`
@@ -227,7 +225,6 @@ func GetRolePermissionbyroleId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -333,7 +330,6 @@ func InsertRolePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -401,6 +397,8 @@ func InsertRolePermission(c *gin.Context) {
 
 	}
 
+	ClearRolePermissionCache(orgId.(string), req.RoleID)
+
 	response := model.Response{
 		Status: "0",
 		Msg:    "Success",
@@ -434,7 +432,6 @@ func UpdateRolePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("roleId")
@@ -534,6 +531,8 @@ func UpdateRolePermission(c *gin.Context) {
 
 	}
 
+	ClearRolePermissionCache(orgId.(string), id)
+
 	// Continue logic...
 	c.JSON(http.StatusOK, model.Response{
 		Status: "0",
@@ -558,7 +557,6 @@ func UpdateMultiRolePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -586,40 +584,54 @@ func UpdateMultiRolePermission(c *gin.Context) {
 		return
 	}
 
+	fail := func(err error, msg string) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "Role Permission", "UpdateMultiRolePermission", "",
+			"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		logger.Warn(msg, zap.Error(err))
+	}
+
+	// The roles are replaced in one transaction, and their cached permission
+	// sets dropped only after it commits, so no request can cache a half
+	// written or old set in between.
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		fail(err, "Update failed")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	roleIds := make([]string, 0, len(req.Body))
 	for _, item := range req.Body {
 		logger.Debug("JsonArray", zap.Any("roleId", item.RoleID))
 		id := item.RoleID
 		now := time.Now()
-		username := GetVariableFromToken(c, "username")
-		orgId := GetVariableFromToken(c, "orgId")
 		query := `DELETE FROM public."um_role_with_permissions" WHERE "roleId" = $1 AND "orgId"=$2`
 
 		logger.Debug(`Query`, zap.String("query", query),
 			zap.Any("Input", []any{
 				id, orgId,
 			}))
-		_, err := conn.Exec(ctx, query, id, orgId)
+		_, err := tx.Exec(ctx, query, id, orgId)
 		if err != nil {
-			// log.Printf("Insert failed: %v", err)
-			response := model.Response{
-				Status: "-1",
-				Msg:    "Failure",
-				Desc:   err.Error(),
-			}
-			//=======AUDIT_START=====//
-			_ = utils.InsertAuditLogs(
-				c, conn, orgId.(string), username.(string),
-				txtId, id, "Role Permission", "UpdateMultiRolePermission", "",
-				"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
-			)
-			//=======AUDIT_END=====//
-			c.JSON(http.StatusInternalServerError, response)
-			logger.Warn("Update failed", zap.Error(err))
+			_ = tx.Rollback(ctx)
+			fail(err, "Update failed")
 			return
 		}
 		logger.Debug("Update Case SQL Args",
 			zap.String("query", query),
 			zap.Any("Input", []any{id, orgId}))
+		roleIds = append(roleIds, id)
 
 		for _, items := range item.PermID {
 			logger.Debug("JsonArray", zap.Any("PermID", items.PermID))
@@ -634,30 +646,24 @@ func UpdateMultiRolePermission(c *gin.Context) {
 					req, items,
 				}))
 
-			_, err := conn.Exec(ctx, query,
+			_, err := tx.Exec(ctx, query,
 				orgId, id, items.PermID, items.Active, now, now, username, username)
 
 			if err != nil {
-				// log.Printf("Insert failed: %v", err)
-				response := model.Response{
-					Status: "-1",
-					Msg:    "Failure",
-					Desc:   err.Error(),
-				}
-				//=======AUDIT_START=====//
-				_ = utils.InsertAuditLogs(
-					c, conn, orgId.(string), username.(string),
-					txtId, id, "Role Permission", "UpdateMultiRolePermission", "",
-					"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
-				)
-				//=======AUDIT_END=====//
-				c.JSON(http.StatusInternalServerError, response)
-				logger.Warn("Insert failed", zap.Error(err))
+				_ = tx.Rollback(ctx)
+				fail(err, "Insert failed")
 				return
 			}
 
 		}
 	}
+	if err := tx.Commit(ctx); err != nil {
+		fail(err, "Update failed")
+		return
+	}
+	for _, roleId := range roleIds {
+		ClearRolePermissionCache(orgId.(string), roleId)
+	}
 
 	response := model.Response{
 		Status: "0",
@@ -691,7 +697,6 @@ func DeleteRolePermission(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	now := time.Now()
@@ -699,10 +704,11 @@ func DeleteRolePermission(c *gin.Context) {
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
 
-	query := `DELETE FROM public."um_role_with_permissions" WHERE id = $1 AND "orgId"=$2`
+	query := `DELETE FROM public."um_role_with_permissions" WHERE id = $1 AND "orgId"=$2 RETURNING "roleId"`
 	logger.Debug("Query", zap.String("query", query), zap.Any("id", id))
-	_, err := conn.Exec(ctx, query, id, orgId)
-	if err != nil {
+	var roleId string
+	err := conn.QueryRow(ctx, query, id, orgId).Scan(&roleId)
+	if err != nil && err != pgx.ErrNoRows {
 		// log.Printf("Insert failed: %v", err)
 		response := model.Response{
 			Status: "-1",
@@ -721,6 +727,8 @@ func DeleteRolePermission(c *gin.Context) {
 		return
 	}
 
+	ClearRolePermissionCache(orgId.(string), roleId)
+
 	// Continue logic...
 	response := model.Response{
 		Status: "0",
@@ -71,7 +71,6 @@ func GenerateCaseIDHandler(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	caseID, err := GenerateCaseID(ctx, conn, "I")
 	if err != nil {
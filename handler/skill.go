@@ -38,7 +38,6 @@ func GetSkill(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
@@ -159,7 +158,6 @@ func GetSkillbyId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	query := `
 	SELECT id, "orgId", "skillId", en, th, active, "createdAt", "updatedAt", "createdBy", "updatedBy"
@@ -256,7 +254,6 @@ func InsertSkill(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.SkillInsert
@@ -338,7 +335,6 @@ func UpdateSkill(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	id := c.Param("id")
 	now := time.Now()
@@ -431,7 +427,6 @@ func DeleteSkill(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	now := time.Now()
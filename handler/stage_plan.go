@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"mainPackage/model"
+
+	"go.uber.org/zap"
+)
+
+// Steps of a stage update, named after the branches of UpdateCurrentStageCore.
+const (
+	stageStepClose    = "close"    // the case closes with the unit
+	stageStepFirst    = "first"    // first unit of the case: case moves, unit stage added
+	stageStepCase     = "case"     // unit moves together with the case stage
+	stageStepUnit     = "unit"     // unit follows the SOP behind the case stage
+	stageStepDispatch = "dispatch" // another unit is dispatched to the case
+)
+
+// stagePlan is what one stage update does to the stages of a case. An empty
+// Step leaves the stages alone.
+type stagePlan struct {
+	Step       string
+	CaseNext   model.WorkflowNode
+	UnitNext   model.WorkflowNode
+	CaseBranch string
+}
+
+// planStageUpdate works out the stage update asked for by req from the current
+// stages of the case. It does not write anything. A rejected update returns a
+// response with its status set; the error is nil when the caller may retry
+// later (a wrong status, a join still waiting).
+func planStageUpdate(req *model.UpdateStageRequest, par *parallelFlow, unitStages model.CurrentStage,
+	allNodesId map[string]model.WorkflowNode, nodeConn []model.WorkFlowConnection, dispatchNode model.WorkflowNode,
+	decisions *decisionEvaluator, logger *zap.Logger) (stagePlan, model.Response, error) {
+	decide := func(node model.WorkflowNode) model.WorkflowNode {
+		return decisions.Decide(node, nodeConn, allNodesId)
+	}
+	// With parallel branches open the case stage is the one of the branch the
+	// unit is on.
+	var plan stagePlan
+	var err error
+	wantBranch := req.Branch
+	caseStages, branch := par.StageFor(unitStages, wantBranch)
+	req.Branch = branch
+	plan.CaseBranch = branch
+	CaseNextNode, UnitNextNode, caseCount, unitCount := GetNextNode(allNodesId, nodeConn, caseStages, unitStages, logger, decide)
+	if par.Parallel {
+		if plan.CaseBranch != "" {
+			CaseNextNode = par.StageNext(caseStages, plan.CaseBranch)
+		}
+		var entered string
+		CaseNextNode, entered, err = par.EnterForks(CaseNextNode, wantBranch)
+		if err != nil {
+			return plan, model.Response{Status: "-1", Msg: "Failure.Fork", Desc: err.Error()}, err
+		}
+		if entered != "" {
+			req.Branch = entered
+		}
+		if unitStages.NodeId == "" {
+			UnitNextNode = CaseNextNode
+		}
+		UnitNextNode, err = par.UnitNext(UnitNextNode, req.Branch)
+		if err != nil {
+			log.Printf("Join: %v", err)
+			return plan, model.Response{Status: "-2", Msg: "Failure.Join", Desc: err.Error()}, nil
+		}
+		// Connection order says nothing about progress across branches: a
+		// unit moves with the case stage when both head for the same node.
+		if unitStages.NodeId != "" {
+			unitCount, caseCount = 1, 2
+			if UnitNextNode.NodeId == CaseNextNode.NodeId {
+				caseCount = 1
+			}
+		} else {
+			dispatchNode = par.DispatchNode(req.Branch, dispatchNode)
+		}
+	}
+	if decisions.Err != nil {
+		log.Printf("Decision failed: %v", decisions.Err)
+		return plan, model.Response{Status: "-1", Msg: "Failure.Decision", Desc: decisions.Err.Error()}, decisions.Err
+	}
+	plan.CaseNext, plan.UnitNext = CaseNextNode, UnitNextNode
+
+	fmt.Println("Case Next Node:", CaseNextNode)
+	fmt.Println("Unit Next Node:", UnitNextNode)
+	fmt.Println("caseCount:", caseCount)
+	fmt.Println("unitCount:", unitCount)
+
+	//Check Stage
+	action := nodeConfig(UnitNextNode)["action"]
+	log.Print("======dataMaps==")
+	log.Print(action)
+	log.Print(req)
+
+	//Check Close Case
+	if req.ResID != "" {
+		if open := par.Open(); len(open) > 0 {
+			err = fmt.Errorf("case has %d open parallel branches", len(open))
+			return plan, model.Response{Status: "-2", Msg: "Failure.Branch", Desc: err.Error()}, nil
+		}
+		if action != req.Status {
+			log.Println("Status worng number-1")
+			return plan, model.Response{Status: "-1", Msg: "Failure.3", Desc: "Status worng number!"}, nil
+		}
+		plan.Step = stageStepClose
+		return plan, model.Response{}, nil
+	}
+	if unitCount != 0 && action != req.Status {
+		log.Printf("Status worng number-2 : %s , %s ", action, req.Status)
+		return plan, model.Response{Status: "-2", Msg: "Failure.3", Desc: "Status worng number!"}, nil
+	}
+
+	switch {
+	case unitCount == 0 && CaseNextNode.Type == "dispatch": //--First Unit for case
+		plan.Step = stageStepFirst
+	case unitCount == caseCount: //-- Unit relate Case
+		plan.Step = stageStepCase
+	case unitCount > 0 && unitCount < caseCount: //--Second Unit follow SOP
+		plan.Step = stageStepUnit
+	case unitCount == 0: //--Second Unit - First dispatch
+		plan.Step = stageStepDispatch
+		plan.UnitNext = dispatchNode
+	}
+	return plan, model.Response{}, nil
+}
+
+// Apply moves the stages of the case as planned.
+func (plan stagePlan) Apply(par *parallelFlow, store stageStore, req model.UpdateStageRequest) (model.Response, error) {
+	switch plan.Step {
+	case stageStepClose:
+		log.Print("--> 1. Case Close")
+		return par.MoveCase(req, plan.CaseBranch, plan.CaseNext)
+	case stageStepFirst:
+		//--Update current stage :  case
+		log.Print("--> 1--Update current stage :  case")
+		if res, err := par.MoveCase(req, plan.CaseBranch, plan.CaseNext); err != nil {
+			return res, err
+		}
+		//--insert current stage : unit
+		return store.InsertStage(req, plan.UnitNext, "unit")
+	case stageStepCase:
+		//--Update current stage :  case
+		log.Print("--> 2--Update current stage :  case")
+		if res, err := par.MoveCase(req, plan.CaseBranch, plan.CaseNext); err != nil {
+			return res, err
+		}
+		//--Update current stage :  unit
+		return store.UpdateStage(req, plan.UnitNext, "unit")
+	case stageStepUnit:
+		//--Update current stage :  unit
+		log.Print("--> --Second Unit follow SOP ")
+		return store.UpdateStage(req, plan.UnitNext, "unit")
+	case stageStepDispatch:
+		//--insert current stage : unit
+		log.Print("--> --Second Unit - First dispatch ")
+		return store.InsertStage(req, plan.UnitNext, "unit")
+	}
+	return model.Response{}, nil
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// stageStore keeps the current stages of one case while a stage update is
+// applied. Stage updates write tix_case_current_stage through dbStageStore;
+// workflow simulations keep the stages in memory.
+type stageStore interface {
+	// InsertStage adds a stage on node. Unit stages are keyed by req.UnitId.
+	InsertStage(req model.UpdateStageRequest, node model.WorkflowNode, stageType string) (model.Response, error)
+	// UpdateStage moves a stage to node. Case stages are keyed by req.Branch.
+	UpdateStage(req model.UpdateStageRequest, node model.WorkflowNode, stageType string) (model.Response, error)
+	// DeleteBranches drops the case stages of the given parallel branches.
+	DeleteBranches(branchIds []string) error
+	// History records a case history event.
+	History(msg string, data interface{})
+}
+
+type dbStageStore struct {
+	ctx      *gin.Context
+	conn     *pgx.Conn
+	orgId    string
+	caseId   string
+	username string
+}
+
+func newDBStageStore(ctx *gin.Context, conn *pgx.Conn, orgId string, caseId string, username string) *dbStageStore {
+	return &dbStageStore{ctx: ctx, conn: conn, orgId: orgId, caseId: caseId, username: username}
+}
+
+func (s *dbStageStore) InsertStage(req model.UpdateStageRequest, node model.WorkflowNode, stageType string) (model.Response, error) {
+	return InsertUnitCurrentStage(s.ctx, s.conn, req, node, stageType, s.username)
+}
+
+func (s *dbStageStore) UpdateStage(req model.UpdateStageRequest, node model.WorkflowNode, stageType string) (model.Response, error) {
+	return UpdateCaseCurrentStage(s.ctx, s.conn, req, node, stageType, s.username)
+}
+
+func (s *dbStageStore) DeleteBranches(branchIds []string) error {
+	keys := make([]string, 0, len(branchIds))
+	for _, id := range branchIds {
+		keys = append(keys, caseBranchKey(id))
+	}
+	return repository.NewStageRepository(s.conn).DeleteCaseStages(s.ctx, s.caseId, keys)
+}
+
+func (s *dbStageStore) History(msg string, data interface{}) {
+	evt := model.CaseHistoryEvent{
+		OrgID:     s.orgId,
+		CaseID:    s.caseId,
+		Username:  s.username,
+		Type:      "event",
+		FullMsg:   msg,
+		JsonData:  data,
+		CreatedBy: s.username,
+	}
+	if err := InsertCaseHistoryEvent(s.ctx, s.conn, evt); err != nil {
+		log.Printf("Stage history of %s: %v", s.caseId, err)
+	}
+}
+
+// memStageStore keeps the stages of a simulated case in memory. Every write is
+// stamped with the simulation clock and recorded as a move.
+type memStageStore struct {
+	caseId     string
+	wfId       string
+	version    string
+	allNodesId map[string]model.WorkflowNode
+
+	now     time.Time
+	stages  map[string]model.WorkflowSimulationStage // by stage type and unit id
+	moves   []model.WorkflowSimulationMove
+	history []string
+}
+
+func newMemStageStore(caseId string, wfId string, version string, allNodesId map[string]model.WorkflowNode, now time.Time) *memStageStore {
+	return &memStageStore{
+		caseId:     caseId,
+		wfId:       wfId,
+		version:    version,
+		allNodesId: allNodesId,
+		now:        now,
+		stages:     map[string]model.WorkflowSimulationStage{},
+	}
+}
+
+func memStageKey(stageType string, unitId string) string {
+	return stageType + "|" + unitId
+}
+
+func (s *memStageStore) write(stageType string, unitId string, nodeId string) {
+	key := memStageKey(stageType, unitId)
+	move := model.WorkflowSimulationMove{StageType: stageType, UnitId: unitId, FromNode: s.stages[key].NodeId, ToNode: nodeId, At: s.now}
+	s.moves = append(s.moves, move)
+	if nodeId == "" {
+		delete(s.stages, key)
+		return
+	}
+	s.stages[key] = model.WorkflowSimulationStage{
+		StageType: stageType,
+		UnitId:    unitId,
+		NodeId:    nodeId,
+		Type:      s.allNodesId[nodeId].Type,
+		UpdatedAt: s.now,
+	}
+}
+
+// InsertStage stores the stage on req.NodeId like InsertUnitCurrentStage does,
+// on node when the request names none.
+func (s *memStageStore) InsertStage(req model.UpdateStageRequest, node model.WorkflowNode, stageType string) (model.Response, error) {
+	nodeId := req.NodeId
+	if nodeId == "" {
+		nodeId = node.NodeId
+	}
+	if _, ok := s.allNodesId[nodeId]; !ok {
+		err := fmt.Errorf("node %s not found in workflow %s version %s", nodeId, s.wfId, s.version)
+		return model.Response{Status: "-1", Msg: "Failure.InsertUnitCurrentStage.1", Desc: err.Error()}, err
+	}
+	s.write(stageType, req.UnitId, nodeId)
+	return model.Response{Status: "0", Msg: "Success", Desc: "InsertUnitCurrentStage"}, nil
+}
+
+// UpdateStage moves an existing stage; like the UPDATE it replaces, a stage
+// that does not exist is left alone.
+func (s *memStageStore) UpdateStage(req model.UpdateStageRequest, node model.WorkflowNode, stageType string) (model.Response, error) {
+	if _, ok := s.allNodesId[node.NodeId]; !ok {
+		err := fmt.Errorf("node %s not found in workflow %s version %s", node.NodeId, s.wfId, s.version)
+		return model.Response{Status: "-1", Msg: "Failure.UpdateCaseCurrentStage.1-" + stageType, Desc: err.Error()}, err
+	}
+	if stageType == "case" {
+		req.UnitId = caseBranchKey(req.Branch)
+	}
+	if _, ok := s.stages[memStageKey(stageType, req.UnitId)]; ok {
+		s.write(stageType, req.UnitId, node.NodeId)
+	}
+	return model.Response{Status: "0", Msg: "Success", Desc: "UpdateCaseCurrentStage-" + stageType}, nil
+}
+
+func (s *memStageStore) DeleteBranches(branchIds []string) error {
+	for _, id := range branchIds {
+		if _, ok := s.stages[memStageKey("case", caseBranchKey(id))]; ok {
+			s.write("case", caseBranchKey(id), "")
+		}
+	}
+	return nil
+}
+
+func (s *memStageStore) History(msg string, data interface{}) {
+	s.history = append(s.history, msg)
+}
+
+// Stages returns the stages held, case stages first.
+func (s *memStageStore) Stages() []model.WorkflowSimulationStage {
+	out := make([]model.WorkflowSimulationStage, 0, len(s.stages))
+	for _, st := range s.stages {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].StageType != out[j].StageType {
+			return out[i].StageType == "case"
+		}
+		return out[i].UnitId < out[j].UnitId
+	})
+	return out
+}
+
+// CurrentStages returns the stages as UpdateCurrentStageCore reads them for
+// an update of unitId: the case level stages and the stage of the unit.
+func (s *memStageStore) CurrentStages(unitId string) ([]model.CurrentStage, model.CurrentStage) {
+	var caseRows []model.CurrentStage
+	var unit model.CurrentStage
+	for _, st := range s.Stages() {
+		node := s.allNodesId[st.NodeId]
+		cur := model.CurrentStage{
+			CaseId:    s.caseId,
+			WfID:      s.wfId,
+			NodeId:    st.NodeId,
+			Versions:  s.version,
+			Type:      node.Type,
+			Section:   node.Section,
+			Data:      node.Data,
+			StageType: st.StageType,
+			UnitID:    st.UnitId,
+		}
+		switch {
+		case st.StageType == "case":
+			caseRows = append(caseRows, cur)
+		case st.UnitId == unitId:
+			unit = cur
+		}
+	}
+	return caseRows, unit
+}
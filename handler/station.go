@@ -38,7 +38,6 @@ func GetStation(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -178,7 +177,6 @@ func GetDepartmentCommandStation(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// เรียก service layer
 	stations, err := utils.GetDepartmentCommandStationOrLoad(ctx, conn, orgId.(string))
@@ -247,7 +245,6 @@ func GetStationbyId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	query := `SELECT id, "orgId", "deptId", "commId", "stnId", en, th, active,
 	                 "createdAt", "updatedAt", "createdBy", "updatedBy"
@@ -331,7 +328,6 @@ func InsertStations(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.StationInsert
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -482,7 +478,6 @@ func UpdateStations(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -592,7 +587,6 @@ func DeleteStations(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	print("====")
 	id := c.Param("id")
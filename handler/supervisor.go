@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	WorkerRunning    = "running"
+	WorkerRestarting = "restarting"
+	WorkerStopped    = "stopped"
+)
+
+// WorkerFunc is a long running background job. It should return when ctx is
+// cancelled; any other return, including a panic, makes the supervisor start
+// it again.
+type WorkerFunc func(ctx context.Context) error
+
+// Supervisor runs background workers with a shared cancellable context and
+// restarts the ones that exit, waiting WORKER_BACKOFF_MIN seconds after the
+// first failure and doubling up to WORKER_BACKOFF_MAX. A worker that stayed up
+// longer than the maximum backoff starts over from the minimum.
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	order   []string
+	workers map[string]*model.WorkerStatus
+}
+
+// Workers supervises the workers started from main and is reported on /health.
+var Workers = NewSupervisor()
+
+func NewSupervisor() *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{ctx: ctx, cancel: cancel, workers: map[string]*model.WorkerStatus{}}
+}
+
+// Go starts fn under the supervisor. Names must be unique.
+func (s *Supervisor) Go(name string, fn WorkerFunc) {
+	s.mu.Lock()
+	if _, ok := s.workers[name]; ok {
+		s.mu.Unlock()
+		log.Printf("Worker %s already started", name)
+		return
+	}
+	s.order = append(s.order, name)
+	s.workers[name] = &model.WorkerStatus{Name: name, State: WorkerRunning}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(name, fn)
+	}()
+}
+
+func (s *Supervisor) run(name string, fn WorkerFunc) {
+	minBackoff := time.Duration(utils.EnvInt("WORKER_BACKOFF_MIN", 1)) * time.Second
+	maxBackoff := time.Duration(utils.EnvInt("WORKER_BACKOFF_MAX", 60)) * time.Second
+	backoff := minBackoff
+
+	for {
+		started := time.Now()
+		s.update(name, func(w *model.WorkerStatus) {
+			w.State = WorkerRunning
+			w.StartedAt = &started
+		})
+
+		err := runWorker(s.ctx, fn)
+		if s.ctx.Err() != nil {
+			if err != nil {
+				log.Printf("Worker %s stopped: %v", name, err)
+			}
+			s.update(name, func(w *model.WorkerStatus) { w.State = WorkerStopped })
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("worker exited")
+		}
+
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		now := time.Now()
+		msg := err.Error()
+		s.update(name, func(w *model.WorkerStatus) {
+			w.State = WorkerRestarting
+			w.Restarts++
+			w.LastError = &msg
+			w.LastErrorAt = &now
+		})
+		log.Printf("Worker %s error: %v (restart in %s)", name, err, backoff)
+
+		if !sleepCtx(s.ctx, backoff) {
+			s.update(name, func(w *model.WorkerStatus) { w.State = WorkerStopped })
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runWorker calls fn and turns a panic into an error.
+func runWorker(ctx context.Context, fn WorkerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx)
+}
+
+func (s *Supervisor) update(name string, fn func(w *model.WorkerStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.workers[name])
+}
+
+// Status returns the state of every worker in start order.
+func (s *Supervisor) Status() []model.WorkerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]model.WorkerStatus, 0, len(s.order))
+	for _, name := range s.order {
+		result = append(result, *s.workers[name])
+	}
+	return result
+}
+
+// Shutdown cancels every worker and waits for them to return, at most until
+// ctx is done. Kafka consumers finish the message in hand and commit it
+// before they return.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		running := []string{}
+		for _, w := range s.Status() {
+			if w.State != WorkerStopped {
+				running = append(running, w.Name)
+			}
+		}
+		return fmt.Errorf("workers still running: %v", running)
+	}
+}
+
+// sleepCtx waits for d and reports false when ctx is cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -3,7 +3,6 @@ package handler
 import (
 	"fmt"
 	"strings"
-	"crypto/subtle"
 	"mainPackage/model"
 	"mainPackage/utils"
 	"net/http"
@@ -34,7 +33,6 @@ func GetUmUserList(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -52,7 +50,7 @@ func GetUmUserList(c *gin.Context) {
 	logger.Debug(`Query`, zap.Any("start", start))
 	logger.Debug(`Query`, zap.Any("length", length))
 	query := `SELECT "id","orgId", "displayName", title, "firstName", "middleName", "lastName", "citizenId", bod,
-	blood, gender, "mobileNo", address, photo, username, password, email, "roleId", "userType", "empId",
+	blood, gender, "mobileNo", address, photo, username, email, "roleId", "userType", "empId",
 	"deptId", "commId", "stnId", active, "activationToken", "lastActivationRequest", "lostPasswordRequest",
 	"signupStamp", islogin, "lastLogin", "createdAt", "updatedAt", "createdBy", "updatedBy" 
 	FROM public.um_users 
@@ -103,7 +101,6 @@ func GetUmUserList(c *gin.Context) {
 			&u.Address,
 			&u.Photo,
 			&u.Username,
-			&u.Password,
 			&u.Email,
 			&u.RoleID,
 			&u.UserType,
@@ -197,10 +194,9 @@ func GetUmUserByUsername(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `
 	SELECT t1."id",t1."orgId", t1."displayName", t1.title, t1."firstName", t1."middleName", t1."lastName",
-		t1."citizenId", t1.bod, t1.blood, t1.gender, t1."mobileNo", t1.address, t1.photo, t1.username, t1.password,
+		t1."citizenId", t1.bod, t1.blood, t1.gender, t1."mobileNo", t1.address, t1.photo, t1.username,
 		t1.email, t1."roleId", t1."userType", t1."empId", t1."deptId", t1."commId", t1."stnId", t1.active,
 		t1."activationToken",t1."lastActivationRequest", t1."lostPasswordRequest", t1."signupStamp",
 		t1.islogin, t1."lastLogin",t1."createdAt", t1."updatedAt", t1."createdBy", t1."updatedBy",t2.name,t3."roleName"
@@ -238,7 +234,7 @@ func GetUmUserByUsername(c *gin.Context) {
 	if rows.Next() {
 		err = rows.Scan(&u.ID,
 			&u.OrgID, &u.DisplayName, &u.Title, &u.FirstName, &u.MiddleName, &u.LastName, &u.CitizenID, &u.Bod, &u.Blood,
-			&u.Gender, &u.MobileNo, &u.Address, &u.Photo, &u.Username, &u.Password, &u.Email, &u.RoleID, &u.UserType,
+			&u.Gender, &u.MobileNo, &u.Address, &u.Photo, &u.Username, &u.Email, &u.RoleID, &u.UserType,
 			&u.EmpID, &u.DeptID, &u.CommID, &u.StnID, &u.Active, &u.ActivationToken, &u.LastActivationRequest,
 			&u.LostPasswordRequest, &u.SignupStamp, &u.IsLogin, &u.LastLogin, &u.CreatedAt, &u.UpdatedAt,
 			&u.CreatedBy, &u.UpdatedBy, &u.OrgName, &u.RoleName,
@@ -300,7 +296,6 @@ func GetUserByUsernameForCaseInfo(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `
 		SELECT "id","displayName","title","firstName","middleName","lastName","gender",
 			"mobileNo","address","photo","username","email","deptId","commId","stnId",
@@ -398,12 +393,11 @@ func GetUmUserById(c *gin.Context) {
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
 	defer cancel()
-	defer conn.Close(ctx)
 
 	// Step 1: Get user profile
 	queryUser := `
 		SELECT "orgId", "displayName", title, "firstName", "middleName", "lastName", "citizenId", bod, blood, gender, 
-		       "mobileNo", address, photo, username, password, email, "roleId", "userType", "empId", "deptId", "commId", 
+		       "mobileNo", address, photo, username, email, "roleId", "userType", "empId", "deptId", "commId", 
 		       "stnId", active, "activationToken", "lastActivationRequest", "lostPasswordRequest", "signupStamp", 
 		       islogin, "lastLogin", "createdAt", "updatedAt", "createdBy", "updatedBy"
 		FROM public.um_users 
@@ -412,7 +406,7 @@ func GetUmUserById(c *gin.Context) {
 	var u model.Um_User
 	err := conn.QueryRow(ctx, queryUser, id, orgId).Scan(
 		&u.OrgID, &u.DisplayName, &u.Title, &u.FirstName, &u.MiddleName, &u.LastName, &u.CitizenID,
-		&u.Bod, &u.Blood, &u.Gender, &u.MobileNo, &u.Address, &u.Photo, &u.Username, &u.Password, &u.Email,
+		&u.Bod, &u.Blood, &u.Gender, &u.MobileNo, &u.Address, &u.Photo, &u.Username, &u.Email,
 		&u.RoleID, &u.UserType, &u.EmpID, &u.DeptID, &u.CommID, &u.StnID, &u.Active, &u.ActivationToken,
 		&u.LastActivationRequest, &u.LostPasswordRequest, &u.SignupStamp, &u.IsLogin, &u.LastLogin,
 		&u.CreatedAt, &u.UpdatedAt, &u.CreatedBy, &u.UpdatedBy,
@@ -529,7 +523,6 @@ func UserAdd(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.UserInput
 	now := time.Now()
@@ -557,10 +550,27 @@ func UserAdd(c *gin.Context) {
 
 	// now req is ready to use
 
+	if err := passwordPolicy().Check(req.Password, req.Username); err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "um_user", "UserAdd", "",
+			"create", -1, now, GetQueryParams(c), response, "Password policy : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
 	var enc string
 	var err error
 	var id int
-	enc, err = encrypt(req.Password)
+	enc, err = hashPassword(req.Password)
 
 	if err != nil {
 		response := model.Response{
@@ -661,7 +671,6 @@ func UserUpdate(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.UserUpdate
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -683,25 +692,30 @@ func UserUpdate(c *gin.Context) {
 	txtId := uuid.New().String()
 	now := time.Now()
 	query := `
-	UPDATE public.um_users
+	WITH old AS (
+		SELECT username FROM public.um_users WHERE id = $29 AND "orgId"=$30 FOR UPDATE
+	)
+	UPDATE public.um_users u
 	SET "displayName"=$1, title=$2, "firstName"=$3, "middleName"=$4, "lastName"=$5, "citizenId"=$6,
 	bod=$7, blood=$8, gender=$9, "mobileNo"=$10, address=$11, photo=$12, username=$13, email=$14, "roleId"=$15,
 	"userType"=$16, "empId"=$17, "deptId"=$18, "commId"=$19, "stnId"=$20, active=$21,
 	"lastActivationRequest"=$22, "lostPasswordRequest"=$23, "signupStamp"=$24, islogin=$25, "lastLogin"=$26,
-	"updatedAt"=$27,"updatedBy"=$28 WHERE id = $29 AND "orgId"=$30`
+	"updatedAt"=$27,"updatedBy"=$28 FROM old WHERE u.id = $29 AND u."orgId"=$30
+	RETURNING old.username`
 
 	logger.Debug(`Query`, zap.String("query", query))
 	logger.Debug(`request input`, zap.Any("Input", []any{req}))
-	_, err = conn.Exec(ctx, query,
+	var oldUsername string
+	err = conn.QueryRow(ctx, query,
 		req.DisplayName, req.Title, req.FirstName, req.MiddleName,
 		req.LastName, req.CitizenID, req.Bod, req.Blood,
 		req.Gender, req.MobileNo, req.Address, req.Photo, req.Username,
 		req.Email, req.RoleID, req.UserType, req.EmpID, req.DeptID, req.CommID, req.StnID,
 		req.Active, req.LastActivationRequest, req.LostPasswordRequest, req.SignupStamp,
 		req.IsLogin, req.LastLogin, now, username, id, orgId,
-	)
+	).Scan(&oldUsername)
 
-	if err != nil {
+	if err != nil && err != pgx.ErrNoRows {
 		// log.Printf("Insert failed: %v", err)
 		response := model.Response{
 			Status: "-1",
@@ -720,6 +734,14 @@ func UserUpdate(c *gin.Context) {
 		return
 	}
 
+	// Sessions belong to the old username; a deactivated or renamed user
+	// has to sign in again.
+	if oldUsername != "" && !req.Active {
+		RevokeUserSessions(orgId.(string), oldUsername, "", "deactivated")
+	} else if oldUsername != "" && oldUsername != req.Username {
+		RevokeUserSessions(orgId.(string), oldUsername, "", "username changed")
+	}
+
 	// Continue logic...
 	response := model.Response{
 		Status: "0",
@@ -753,7 +775,6 @@ func UserUpdateByUsername(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -855,16 +876,16 @@ func UserDelete(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
-	query := `DELETE FROM public."um_users" WHERE id = $1 AND "orgId"=$2`
+	query := `DELETE FROM public."um_users" WHERE id = $1 AND "orgId"=$2 RETURNING username`
 	logger.Debug("Query", zap.String("query", query), zap.Any("id", id))
-	_, err := conn.Exec(ctx, query, id, orgId)
-	if err != nil {
+	var deleted string
+	err := conn.QueryRow(ctx, query, id, orgId).Scan(&deleted)
+	if err != nil && err != pgx.ErrNoRows {
 		// log.Printf("Insert failed: %v", err)
 		response := model.Response{
 			Status: "-1",
@@ -882,6 +903,9 @@ func UserDelete(c *gin.Context) {
 		logger.Warn("Update failed", zap.Error(err))
 		return
 	}
+	if deleted != "" {
+		RevokeUserSessions(orgId.(string), deleted, "", "deleted")
+	}
 
 	// Continue logic...
 	response := model.Response{
@@ -921,7 +945,6 @@ func GetUserWithSkills(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -1046,7 +1069,6 @@ func GetUserWithSkillsById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT "orgId", "userName", "skillId", active, "createdAt", "updatedAt", "createdBy", "updatedBy" 
 	FROM public.um_user_with_skills WHERE id=$1 AND "orgId"=$2`
 
@@ -1140,7 +1162,6 @@ func GetUserWithSkillsBySkillId(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT "orgId", "userName", "skillId", active, "createdAt", "updatedAt", "createdBy", "updatedBy" 
 	FROM public.um_user_with_skills WHERE "skillId" = $1 AND "orgId" = $2`
 
@@ -1248,7 +1269,6 @@ func GetUserWithSkillsByUsername(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT t1."orgId", t1."userName", t1."skillId", t2."th", t2."en", t1.active, t1."createdAt", t1."updatedAt", t1."createdBy", t1."updatedBy" 
 		FROM public.um_user_with_skills t1 
 		JOIN public.um_skills t2 ON t1."skillId" = t2."skillId"
@@ -1346,7 +1366,6 @@ func InsertUserWithSkills(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -1438,7 +1457,6 @@ func InsertUserWithSkillsBatch(c *gin.Context) {
         return
     }
     defer cancel()
-    defer conn.Close(ctx)
     
     now := time.Now()
     username := GetVariableFromToken(c, "username")
@@ -1657,7 +1675,6 @@ func UpdateUserWithSkills(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -1755,7 +1772,6 @@ func DeleteUserWithSkills(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -1824,7 +1840,6 @@ func GetUserWithContacts(c *gin.Context) {
 	orgId := GetVariableFromToken(c, "orgId")
 	txtId := uuid.New().String()
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -1951,7 +1966,6 @@ func GetUserWithContactsById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	query := `SELECT  "orgId", username, "contactName", "contactPhone", "contactAddr", "createdAt", "updatedAt", "createdBy", "updatedBy" 
 	FROM public.um_user_contacts WHERE id=$1 AND "orgId"=$2`
@@ -2042,7 +2056,6 @@ func InsertUserWithContacts(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	now := time.Now()
@@ -2133,7 +2146,6 @@ func UpdateUserWithContacts(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -2224,7 +2236,6 @@ func DeleteUserWithContacts(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -2292,7 +2303,6 @@ func GetUserWithSocials(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	id := c.Param("id")
 	now := time.Now()
 	username := GetVariableFromToken(c, "username")
@@ -2424,7 +2434,6 @@ func GetUserWithSocialsById(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	query := `SELECT  "orgId", username, "socialType", "socialId", "socialName", "createdAt", "updatedAt", "createdBy", "updatedBy" 	
 	FROM public.um_user_with_socials WHERE id=$1 AND "orgId"=$2`
 
@@ -2512,7 +2521,6 @@ func InsertUserWithSocials(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	var req model.UserSocialInsert
@@ -2603,7 +2611,6 @@ func UpdateUserWithSocials(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 
 	id := c.Param("id")
@@ -2698,7 +2705,6 @@ func DeleteUserWithSocials(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 	defer cancel()
 	id := c.Param("id")
 	now := time.Now()
@@ -2747,132 +2753,6 @@ func DeleteUserWithSocials(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// @summary Reset User Password
-// @tags User
-// @id Reset User Password
-// @accept json
-// @produce json
-// @param Body body model.ResetPasswordRequest true "Reset Password Data (username, email, newPassword)"
-// @response 200 {object} model.Response "OK - Request successful"
-// @Router /api/v1/users/reset_password [post]
-func ResetUserPassword(c *gin.Context) {
-	logger := utils.GetLog()
-	conn, ctx, cancel := utils.ConnectDB()
-	if conn == nil {
-		return
-	}
-	defer cancel()
-	defer conn.Close(ctx)
-	id := c.Param("id")
-	now := time.Now()
-	username := GetVariableFromToken(c, "username")
-	orgId := GetVariableFromToken(c, "orgId")
-	txtId := uuid.New().String()
-	var req model.ResetPasswordRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		response := model.Response{
-			Status: "-1",
-			Msg:    "Failure",
-			Desc:   err.Error(),
-		}
-		//=======AUDIT_START=====//
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId.(string), username.(string),
-			txtId, id, "um_user", "ResetUserPassword", "",
-			"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
-		)
-		//=======AUDIT_END=====//
-		c.JSON(http.StatusBadRequest, response)
-		logger.Warn("Reset password failed", zap.Error(err))
-		return
-	}
-
-	// ตรวจสอบว่า user มีอยู่จริงโดยใช้ username และ email
-	var userID string
-	checkQuery := `SELECT id FROM public.um_users WHERE username=$1 AND email=$2 AND active=true`
-	err := conn.QueryRow(ctx, checkQuery, req.Username, req.Email).Scan(&userID)
-	if err != nil {
-		response := model.Response{
-			Status: "-1",
-			Msg:    "Failure",
-			Desc:   "User not found or inactive",
-		}
-		//=======AUDIT_START=====//
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId.(string), username.(string),
-			txtId, id, "um_user", "ResetUserPassword", "",
-			"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
-		)
-		//=======AUDIT_END=====//
-		c.JSON(http.StatusNotFound, response)
-		logger.Warn("User not found", zap.Error(err))
-		return
-	}
-
-	// Encrypt new password (ไม่มีการตรวจสอบเงื่อนไข)
-	encPassword, err := encrypt(req.NewPassword)
-	if err != nil {
-		response := model.Response{
-			Status: "-1",
-			Msg:    "Failure",
-			Desc:   "Password encryption failed",
-		}
-		//=======AUDIT_START=====//
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId.(string), username.(string),
-			txtId, id, "um_user", "ResetUserPassword", "",
-			"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
-		)
-		//=======AUDIT_END=====//
-		c.JSON(http.StatusInternalServerError, response)
-		logger.Warn("Password encryption failed", zap.Error(err))
-		return
-	}
-
-	query := `UPDATE public.um_users SET password=$1, "updatedAt"=$2, "updatedBy"=$3 WHERE username=$4 AND email=$5`
-
-	logger.Debug(`Query`, zap.String("query", query))
-	logger.Debug(`Username`, zap.String("username", req.Username))
-	logger.Debug(`Email`, zap.String("email", req.Email))
-
-	_, err = conn.Exec(ctx, query, encPassword, now, "system", req.Username, req.Email)
-	if err != nil {
-		response := model.Response{
-			Status: "-1",
-			Msg:    "Failure",
-			Desc:   err.Error(),
-		}
-		//=======AUDIT_START=====//
-		_ = utils.InsertAuditLogs(
-			c, conn, orgId.(string), username.(string),
-			txtId, id, "um_user", "ResetUserPassword", "",
-			"update", -1, now, GetQueryParams(c), response, "Failed : "+err.Error(),
-		)
-		//=======AUDIT_END=====//
-		c.JSON(http.StatusInternalServerError, response)
-		logger.Warn("Reset password failed", zap.Error(err))
-		return
-	}
-	response := model.Response{
-		Status: "0",
-		Msg:    "Success",
-		Desc:   "Password reset successfully",
-	}
-	//=======AUDIT_START=====//
-	_ = utils.InsertAuditLogs(
-		c, conn, orgId.(string), username.(string),
-		txtId, id, "um_user", "ResetUserPassword", "",
-		"update", 0, now, GetQueryParams(c), response, "ResetUserPassword Success.",
-	)
-	//=======AUDIT_END=====//
-
-	c.JSON(http.StatusOK, model.Response{
-		Status: "0",
-		Msg:    "Success",
-		Desc:   "Password reset successfully",
-	})
-}
-
 // @summary Change User Password
 // @tags User
 // @security ApiKeyAuth
@@ -2890,7 +2770,6 @@ func ChangeUserPassword(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	var req model.ChangePasswordRequest
 	id := c.Param("id")
@@ -2917,9 +2796,9 @@ func ChangeUserPassword(c *gin.Context) {
 	}
 
 	// First, verify current password
-	var currentPassword string
-	query := `SELECT password FROM public.um_users WHERE id=$1 AND "orgId"=$2`
-	err := conn.QueryRow(ctx, query, id, orgId).Scan(&currentPassword)
+	var currentPassword, targetUsername string
+	query := `SELECT password, username FROM public.um_users WHERE id=$1 AND "orgId"=$2`
+	err := conn.QueryRow(ctx, query, id, orgId).Scan(&currentPassword, &targetUsername)
 	if err != nil {
 		response := model.Response{
 			Status: "-1",
@@ -2938,8 +2817,8 @@ func ChangeUserPassword(c *gin.Context) {
 		return
 	}
 
-	// Decrypt current password and verify
-	decryptedPassword, err := decrypt(currentPassword)
+	// Verify current password
+	matched, _, err := verifyPassword(currentPassword, req.CurrentPassword)
 	if err != nil {
 		response := model.Response{
 			Status: "-1",
@@ -2958,12 +2837,11 @@ func ChangeUserPassword(c *gin.Context) {
 			Msg:    "Failure",
 			Desc:   "Password verification failed",
 		})
-		logger.Warn("Password decryption failed", zap.Error(err))
+		logger.Warn("Password verification failed", zap.Error(err))
 		return
 	}
 
-	// Compare current password
-	if subtle.ConstantTimeCompare([]byte(decryptedPassword), []byte(req.CurrentPassword)) != 1 {
+	if !matched {
 		response := model.Response{
 			Status: "-1",
 			Msg:    "Failure",
@@ -2980,13 +2858,30 @@ func ChangeUserPassword(c *gin.Context) {
 		return
 	}
 
-	// Encrypt new password
-	encPassword, err := encrypt(req.NewPassword)
+	if err := passwordPolicy().Check(req.NewPassword, targetUsername); err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, id, "um_user", "changeUserPassword", "",
+			"update", -1, now, GetQueryParams(c), response, "Password policy : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	// Hash new password
+	encPassword, err := hashPassword(req.NewPassword)
 	if err != nil {
 		response := model.Response{
 			Status: "-1",
 			Msg:    "Failure",
-			Desc:   "Password encryption failed",
+			Desc:   "Password hashing failed",
 		}
 		//=======AUDIT_START=====//
 		_ = utils.InsertAuditLogs(
@@ -2996,7 +2891,7 @@ func ChangeUserPassword(c *gin.Context) {
 		)
 		//=======AUDIT_END=====//
 		c.JSON(http.StatusInternalServerError, response)
-		logger.Warn("Password encryption failed", zap.Error(err))
+		logger.Warn("Password hashing failed", zap.Error(err))
 		return
 	}
 
@@ -3024,6 +2919,14 @@ func ChangeUserPassword(c *gin.Context) {
 		return
 	}
 
+	// The session that made the change stays signed in when users change
+	// their own password.
+	keepSession := ""
+	if targetUsername == username.(string) {
+		keepSession = c.GetString("sessionId")
+	}
+	RevokeUserSessions(orgId.(string), targetUsername, keepSession, "password change")
+
 	response := model.Response{
 		Status: "0",
 		Msg:    "Success",
@@ -3061,7 +2964,6 @@ func GetUmGroupList(c *gin.Context) {
 		return
 	}
 	defer cancel()
-	defer conn.Close(ctx)
 
 	startStr := c.DefaultQuery("start", "0")
 	start, err := strconv.Atoi(startStr)
@@ -3,7 +3,6 @@ package handler
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"mainPackage/model"
 	"mainPackage/utils"
@@ -32,39 +31,67 @@ var upgrader = websocket.Upgrader{
 
 // ---------- Helpers: พื้นที่/จังหวัด ----------
 
-func checkUserInProvince(userDistIdLists []string, provId string) bool {
-	if len(userDistIdLists) == 0 {
-		return false
+var (
+	provinceDistrictsMu    sync.Mutex
+	provinceDistricts      = map[string]map[string]bool{}
+	provinceDistrictsUntil time.Time
+)
+
+// getProvinceDistricts returns the district ids of a province. Districts are
+// master data, so they are loaded once per province and kept for CACHE_EXPIRE
+// seconds instead of querying for every recipient check.
+func getProvinceDistricts(provId string) (map[string]bool, error) {
+	provinceDistrictsMu.Lock()
+	defer provinceDistrictsMu.Unlock()
+	if time.Now().After(provinceDistrictsUntil) {
+		provinceDistricts = map[string]map[string]bool{}
+		provinceDistrictsUntil = time.Now().Add(time.Duration(utils.EnvInt("CACHE_EXPIRE", 3600)) * time.Second)
+	}
+	if dists, ok := provinceDistricts[provId]; ok {
+		return dists, nil
 	}
 
 	dbConn, ctx, cancel := utils.ConnectDB()
 	if dbConn == nil {
-		return false
+		return nil, errors.New("could not connect to database")
 	}
 	defer cancel()
-	defer dbConn.Close(ctx)
-
-	placeholders := make([]string, len(userDistIdLists))
-	args := make([]interface{}, len(userDistIdLists)+1)
-	args[0] = provId
 
-	for i, distId := range userDistIdLists {
-		placeholders[i] = fmt.Sprintf("$%d", i+2)
-		args[i+1] = distId
+	rows, err := dbConn.Query(ctx, `SELECT "distId" FROM area_districts WHERE "provId" = $1`, provId)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
+	dists := map[string]bool{}
+	for rows.Next() {
+		var distId string
+		if err := rows.Scan(&distId); err != nil {
+			return nil, err
+		}
+		dists[distId] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	provinceDistricts[provId] = dists
+	return dists, nil
+}
 
-	var count int
-	query := fmt.Sprintf(`
-		SELECT COUNT(*)
-		FROM area_districts 
-		WHERE "provId" = $1 AND "distId" IN (%s)
-	`, strings.Join(placeholders, ","))
-
-	if err := dbConn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+func checkUserInProvince(userDistIdLists []string, provId string) bool {
+	if len(userDistIdLists) == 0 {
+		return false
+	}
+	dists, err := getProvinceDistricts(provId)
+	if err != nil {
 		log.Printf("ERROR: Failed to check user in province %s: %v", provId, err)
 		return false
 	}
-	return count > 0
+	for _, distId := range userDistIdLists {
+		if dists[distId] {
+			return true
+		}
+	}
+	return false
 }
 
 func checkUserInDistrict(userDistIdLists []string, distId string) bool {
@@ -94,7 +121,6 @@ func upsertUserConnectionToDB(userInfo *model.UserConnectionInfo) error {
 		return errors.New("could not connect to database")
 	}
 	defer cancel()
-	defer dbConn.Close(ctx)
 
 	// หมายเหตุ: คอลัมน์ "grpId" และ "distIdLists" ใน user_connections ควรเป็น text[]/varchar[]
 	query := `
@@ -154,7 +180,6 @@ func removeUserConnectionFromDB(userID string) {
 		return
 	}
 	defer cancel()
-	defer dbConn.Close(ctx)
 
 	if _, err := dbConn.Exec(ctx, `DELETE FROM user_connections WHERE "empId" = $1`, userID); err != nil {
 		log.Printf("ERROR: Failed to remove user connection from DB for EmpID %s: %v", userID, err)
@@ -219,7 +244,6 @@ func WebSocketHandler(c *gin.Context) {
 
 	connInfo, err := utils.GetUserProfileFromDB(ctx, dbConn, regMsg.OrgID, regMsg.Username)
 
-	dbConn.Close(ctx)
 	cancel()
 
 	if err != nil {
@@ -321,7 +345,6 @@ func WebSocketHandler(c *gin.Context) {
 					log.Printf("Dashboard notification error: %v", err)
 				}
 
-				dbConn.Close(ctx)
 				cancel()
 
 			case "OTHER_EVENT":
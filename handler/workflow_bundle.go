@@ -0,0 +1,773 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"mainPackage/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Workflow bundles carry a workflow with the records it references from one
+// organization to another. Every imported record gets a new id and every
+// reference in the bundle is remapped to it.
+const (
+	workflowBundleFormat = "cms-workflow-bundle/1"
+
+	bundleKindWorkflow = "workflow"
+	bundleKindForm     = "form"
+	bundleKindCaseType = "caseType"
+	bundleKindSubType  = "caseSubType"
+	bundleKindSkill    = "skill"
+	bundleKindProperty = "property"
+
+	bundleActionCreate    = "create"
+	bundleActionSkip      = "skip"
+	bundleActionOverwrite = "overwrite"
+	bundleActionRename    = "rename"
+
+	bundleRenameSuffix = "-import"
+)
+
+// bundleTables names the table of each kind of record with the id column and
+// the name column a conflict is detected on.
+var bundleTables = map[string][3]string{
+	bundleKindWorkflow: {"wf_definitions", "wfId", "title"},
+	bundleKindForm:     {"form_builder", "formId", "formName"},
+	bundleKindCaseType: {"case_types", "typeId", "en"},
+	bundleKindSubType:  {"case_sub_types", "sTypeId", "sTypeCode"},
+	bundleKindSkill:    {"um_skills", "skillId", "en"},
+	bundleKindProperty: {"mdm_properties", "propId", "en"},
+}
+
+func workflowBundleChecksum(content model.WorkflowBundleContent) (string, error) {
+	b, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// jsonStrings returns the strings of a decoded jsonb list.
+func jsonStrings(v interface{}) []string {
+	return configStrings(map[string]interface{}{"v": v}, "v")
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func addBundleRefs(set map[string]bool, ids []string) {
+	for _, id := range ids {
+		if id != "" {
+			set[id] = true
+		}
+	}
+}
+
+func sortedSet(set map[string]bool) []string {
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// loadBundleItems loads the skills or unit properties with the given ids.
+// Ids that no longer exist are left out.
+func loadBundleItems(ctx context.Context, db repository.DBTX, kind string, orgId string, ids []string) ([]model.WorkflowBundleItem, error) {
+	items := []model.WorkflowBundleItem{}
+	if len(ids) == 0 {
+		return items, nil
+	}
+	t := bundleTables[kind]
+	rows, err := db.Query(ctx, fmt.Sprintf(`
+		SELECT "%s"::text, COALESCE(en, ''), COALESCE(th, ''), active
+		FROM public.%s
+		WHERE "orgId"::text = $1 AND "%s"::text = ANY($2)
+		ORDER BY "%s"`, t[1], t[0], t[1], t[1]), orgId, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item model.WorkflowBundleItem
+		if err := rows.Scan(&item.Id, &item.En, &item.Th, &item.Active); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ExportWorkflowBundle builds the bundle of the current version of a
+// workflow: the forms named on its nodes, the case subtypes that use it with
+// their case types, and the skills and unit properties required by the
+// subtypes and dispatch nodes.
+func ExportWorkflowBundle(ctx context.Context, db repository.DBTX, orgId string, wfId string, username string) (*model.WorkflowBundle, error) {
+	wf, err := loadWorkflow(ctx, db, orgId, wfId)
+	if err != nil {
+		return nil, err
+	}
+	if len(wf.Nodes) == 0 {
+		return nil, fmt.Errorf("workflow %s not found", wfId)
+	}
+	meta, _ := wf.MetaData.(model.WorkFlowMetadata)
+	content := model.WorkflowBundleContent{
+		Workflow: model.WorkflowBundleWorkflow{
+			WfID:     wfId,
+			Title:    derefString(meta.Title),
+			Desc:     derefString(meta.Desc),
+			Versions: derefString(meta.Status),
+		},
+	}
+	b, err := json.Marshal(wf.Nodes)
+	if err == nil {
+		err = json.Unmarshal(b, &content.Workflow.Nodes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read workflow nodes: %w", err)
+	}
+	b, err = json.Marshal(wf.Connections)
+	if err == nil {
+		err = json.Unmarshal(b, &content.Workflow.Connections)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read workflow connections: %w", err)
+	}
+
+	forms, skills, props := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, n := range content.Workflow.Nodes {
+		addBundleRefs(forms, workflowNodeRefs(n, "formId"))
+		addBundleRefs(skills, workflowNodeRefs(n, "userSkillList"))
+		addBundleRefs(props, workflowNodeRefs(n, "unitPropLists"))
+	}
+
+	rows, _, err := listCaseTypesWithSubtype(ctx, db, orgId)
+	if err != nil {
+		return nil, err
+	}
+	types := map[string]bool{}
+	for _, r := range rows {
+		if r.SubTypeID == nil || derefString(r.WfID) != wfId {
+			continue
+		}
+		sub := model.WorkflowBundleCaseSubType{
+			STypeID:       *r.SubTypeID,
+			TypeID:        r.TypeID,
+			STypeCode:     derefString(r.SubTypeCode),
+			En:            derefString(r.SubTypeEN),
+			Th:            derefString(r.SubTypeTH),
+			WfID:          wfId,
+			CaseSla:       derefString(r.CaseSla),
+			Priority:      r.Priority,
+			UserSkillList: jsonStrings(r.UserSkillList),
+			UnitPropLists: jsonStrings(r.UnitPropLists),
+			Active:        r.SubTypeActive != nil && *r.SubTypeActive,
+		}
+		content.CaseSubTypes = append(content.CaseSubTypes, sub)
+		addBundleRefs(skills, sub.UserSkillList)
+		addBundleRefs(props, sub.UnitPropLists)
+		if !types[r.TypeID] {
+			types[r.TypeID] = true
+			content.CaseTypes = append(content.CaseTypes, model.WorkflowBundleCaseType{
+				TypeID: r.TypeID, En: r.TypeEN, Th: r.TypeTH, Active: r.TypeActive,
+			})
+		}
+	}
+	sort.Slice(content.CaseSubTypes, func(i, j int) bool { return content.CaseSubTypes[i].STypeID < content.CaseSubTypes[j].STypeID })
+	sort.Slice(content.CaseTypes, func(i, j int) bool { return content.CaseTypes[i].TypeID < content.CaseTypes[j].TypeID })
+
+	for _, formId := range sortedSet(forms) {
+		var version string
+		err := db.QueryRow(ctx, `
+			SELECT versions FROM public.form_builder
+			WHERE "orgId"::text = $1 AND "formId"::text = $2`, orgId, formId).Scan(&version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("form %s used by the workflow does not exist", formId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		form, err := loadForm(ctx, db, orgId, formId, version)
+		if err != nil {
+			return nil, err
+		}
+		if form == nil {
+			return nil, fmt.Errorf("form %s has no version %s", formId, version)
+		}
+		content.Forms = append(content.Forms, model.WorkflowBundleForm{
+			FormId:        formId,
+			FormName:      derefString(form.FormName),
+			Versions:      version,
+			Active:        form.Active,
+			Publish:       form.Publish,
+			Locks:         form.Locks,
+			FormColSpan:   form.FormColSpan,
+			FormFieldJson: form.FormFieldJson,
+		})
+	}
+	if content.Skills, err = loadBundleItems(ctx, db, bundleKindSkill, orgId, sortedSet(skills)); err != nil {
+		return nil, err
+	}
+	if content.Properties, err = loadBundleItems(ctx, db, bundleKindProperty, orgId, sortedSet(props)); err != nil {
+		return nil, err
+	}
+
+	checksum, err := workflowBundleChecksum(content)
+	if err != nil {
+		return nil, err
+	}
+	return &model.WorkflowBundle{
+		Format:     workflowBundleFormat,
+		SourceOrg:  orgId,
+		ExportedAt: time.Now(),
+		ExportedBy: username,
+		Checksum:   checksum,
+		Content:    content,
+	}, nil
+}
+
+// bundleImport writes the records of one bundle inside a transaction.
+type bundleImport struct {
+	ctx      context.Context
+	tx       pgx.Tx
+	orgId    string
+	username string
+	req      model.WorkflowImportRequest
+	now      time.Time
+	ids      map[string]string // bundle id to id in the organization
+	report   *model.WorkflowImportReport
+}
+
+// findConflict returns the existing record a bundle record collides with and
+// whether it matched on the id or on the name.
+func (imp *bundleImport) findConflict(kind string, id string, name string) (string, string, error) {
+	t := bundleTables[kind]
+	var existing string
+	var sameId bool
+	err := imp.tx.QueryRow(imp.ctx, fmt.Sprintf(`
+		SELECT "%s"::text, "%s"::text = $2
+		FROM public.%s
+		WHERE "orgId"::text = $1 AND ("%s"::text = $2 OR "%s" = $3)
+		ORDER BY 2 DESC
+		LIMIT 1`, t[1], t[1], t[0], t[1], t[2]), imp.orgId, id, name).Scan(&existing, &sameId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if sameId {
+		return existing, "id", nil
+	}
+	return existing, "name", nil
+}
+
+// plan decides what happens to one bundle record and records the id it gets.
+func (imp *bundleImport) plan(kind string, id string, name string) (model.WorkflowImportItem, error) {
+	item := model.WorkflowImportItem{Kind: kind, SourceId: id, Name: name, Action: bundleActionCreate}
+	existing, conflict, err := imp.findConflict(kind, id, name)
+	if err != nil {
+		return item, err
+	}
+	item.Conflict = conflict
+	if conflict != "" {
+		item.Action = imp.req.Strategy
+		if s, ok := imp.req.Strategies[id]; ok {
+			item.Action = s
+		}
+		switch item.Action {
+		case bundleActionSkip, bundleActionOverwrite:
+			item.TargetId = existing
+		case bundleActionRename:
+			item.Name = name + imp.req.RenameSuffix
+		default:
+			return item, fmt.Errorf("%s %s: unknown strategy %q", kind, id, item.Action)
+		}
+	}
+	if item.TargetId == "" {
+		item.TargetId = uuid.New().String()
+	}
+	imp.ids[id] = item.TargetId
+	imp.report.Items = append(imp.report.Items, item)
+	return item, nil
+}
+
+func (imp *bundleImport) mapIds(ids []string) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if mapped, ok := imp.ids[id]; ok {
+			id = mapped
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// importItems imports skills or unit properties.
+func (imp *bundleImport) importItems(kind string, items []model.WorkflowBundleItem) error {
+	t := bundleTables[kind]
+	for _, it := range items {
+		item, err := imp.plan(kind, it.Id, it.En)
+		if err != nil {
+			return err
+		}
+		th := it.Th
+		if item.Action == bundleActionRename {
+			th += imp.req.RenameSuffix
+		}
+		switch item.Action {
+		case bundleActionCreate, bundleActionRename:
+			_, err = imp.tx.Exec(imp.ctx, fmt.Sprintf(`
+				INSERT INTO public.%s(
+				"%s", "orgId", en, th, active, "createdAt", "updatedAt", "createdBy", "updatedBy")
+				VALUES ($1, $2, $3, $4, $5, $6, $6, $7, $7)`, t[0], t[1]),
+				item.TargetId, imp.orgId, item.Name, th, it.Active, imp.now, imp.username)
+		case bundleActionOverwrite:
+			_, err = imp.tx.Exec(imp.ctx, fmt.Sprintf(`
+				UPDATE public.%s SET en=$3, th=$4, active=$5, "updatedAt"=$6, "updatedBy"=$7
+				WHERE "%s"::text = $1 AND "orgId"::text = $2`, t[0], t[1]),
+				item.TargetId, imp.orgId, it.En, th, it.Active, imp.now, imp.username)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", kind, it.Id, err)
+		}
+	}
+	return nil
+}
+
+func (imp *bundleImport) importCaseTypes(types []model.WorkflowBundleCaseType) error {
+	items := make([]model.WorkflowBundleItem, 0, len(types))
+	for _, t := range types {
+		items = append(items, model.WorkflowBundleItem{Id: t.TypeID, En: t.En, Th: t.Th, Active: t.Active})
+	}
+	return imp.importItems(bundleKindCaseType, items)
+}
+
+func (imp *bundleImport) importForms(forms []model.WorkflowBundleForm) error {
+	for _, f := range forms {
+		item, err := imp.plan(bundleKindForm, f.FormId, f.FormName)
+		if err != nil {
+			return err
+		}
+		if item.Action == bundleActionSkip {
+			continue
+		}
+		version := "1"
+		if item.Action == bundleActionOverwrite {
+			var last int
+			err = imp.tx.QueryRow(imp.ctx, `
+				SELECT COALESCE(MAX(CAST(versions AS INTEGER)), 0)
+				FROM public.form_elements
+				WHERE "formId"::text = $1 AND "orgId"::text = $2 AND versions ~ '^[0-9]+$'`,
+				item.TargetId, imp.orgId).Scan(&last)
+			if err == nil {
+				version = strconv.Itoa(last + 1)
+				_, err = imp.tx.Exec(imp.ctx, `
+					UPDATE public.form_builder
+					SET "formName"=$3, active=$4, publish=$5, locks=$6, versions=$7, "updatedAt"=$8, "updatedBy"=$9
+					WHERE "formId"::text = $1 AND "orgId"::text = $2`,
+					item.TargetId, imp.orgId, item.Name, f.Active, f.Publish, f.Locks, version, imp.now, imp.username)
+			}
+		} else {
+			_, err = imp.tx.Exec(imp.ctx, `
+				INSERT INTO public."form_builder"(
+				"orgId", "formId", "formName", active, publish, versions, locks, "createdAt", "updatedAt", "createdBy", "updatedBy")
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9, $9)`,
+				imp.orgId, item.TargetId, item.Name, f.Active, f.Publish, version, f.Locks, imp.now, imp.username)
+		}
+		if err != nil {
+			return fmt.Errorf("form %s: %w", f.FormId, err)
+		}
+		name := item.Name
+		eleData, err := json.Marshal(model.Form{
+			FormId:        &item.TargetId,
+			FormName:      &name,
+			FormColSpan:   f.FormColSpan,
+			FormFieldJson: f.FormFieldJson,
+		})
+		if err != nil {
+			return fmt.Errorf("form %s: %w", f.FormId, err)
+		}
+		_, err = imp.tx.Exec(imp.ctx, `
+			INSERT INTO public.form_elements(
+			"orgId", "formId", versions, "eleData", "createdAt", "updatedAt", "createdBy", "updatedBy")
+			VALUES ($1, $2, $3, $4, $5, $5, $6, $6)`,
+			imp.orgId, item.TargetId, version, eleData, imp.now, imp.username)
+		if err != nil {
+			return fmt.Errorf("form %s: %w", f.FormId, err)
+		}
+	}
+	return nil
+}
+
+// remapWorkflow returns the workflow with the forms, skills and unit
+// properties on its nodes pointing at the imported records.
+func (imp *bundleImport) remapWorkflow(wf model.WorkflowBundleWorkflow) (model.WorkFlowInsert, error) {
+	out := model.WorkFlowInsert{Connections: wf.Connections}
+	b, err := json.Marshal(wf.Nodes)
+	if err == nil {
+		err = json.Unmarshal(b, &out.Nodes)
+	}
+	if err != nil {
+		return out, err
+	}
+	for _, n := range out.Nodes {
+		if n.Data == nil || n.Data.Config == nil {
+			continue
+		}
+		config := *n.Data.Config
+		if id, ok := config["formId"].(string); ok {
+			if mapped, ok := imp.ids[id]; ok {
+				config["formId"] = mapped
+			}
+		}
+		for _, key := range []string{"userSkillList", "unitPropLists"} {
+			if _, ok := config[key].([]interface{}); ok {
+				config[key] = imp.mapIds(configStrings(config, key))
+			}
+		}
+	}
+	return out, nil
+}
+
+// insertWorkflowDraft writes the nodes and connections of a workflow as its
+// draft, the way WorkFlowInsert does.
+func insertWorkflowDraft(ctx context.Context, db repository.DBTX, orgId string, wfId string, wf model.WorkFlowInsert, username string, now time.Time) error {
+	for _, item := range wf.Nodes {
+		var pic, group, formID interface{}
+		if item.Data != nil && item.Data.Config != nil {
+			config := *item.Data.Config
+			if val, ok := config["pic"].(string); ok {
+				pic = val
+			}
+			if val, ok := config["group"].(string); ok {
+				group = val
+			}
+			if val, ok := config["formId"].(string); ok {
+				formID = val
+			}
+		}
+		_, err := db.Exec(ctx, `
+		INSERT INTO public.wf_nodes(
+	"orgId", "wfId", "nodeId", versions, type, section, data,pic,"group","formId", "createdAt", "updatedAt", "createdBy", "updatedBy")
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11, $12, $12);`,
+			orgId, wfId, item.Id, workflowDraftVersion, item.Type, "nodes", item, pic, group, formID, now, username)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(ctx, `
+		INSERT INTO public.wf_nodes(
+	"orgId", "wfId", "nodeId", versions, type, section, data, "createdAt", "updatedAt", "createdBy", "updatedBy")
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9, $9);`,
+		orgId, wfId, "", workflowDraftVersion, "", "connections", wf.Connections, now, username)
+	return err
+}
+
+// importWorkflow writes the workflow and returns the action taken.
+func (imp *bundleImport) importWorkflow(wf model.WorkflowBundleWorkflow) (string, error) {
+	item, err := imp.plan(bundleKindWorkflow, wf.WfID, wf.Title)
+	if err != nil {
+		return "", err
+	}
+	imp.report.WfID = item.TargetId
+	if item.Action == bundleActionSkip {
+		return item.Action, nil
+	}
+	remapped, err := imp.remapWorkflow(wf)
+	if err != nil {
+		return "", fmt.Errorf("workflow %s: %w", wf.WfID, err)
+	}
+	if item.Action == bundleActionOverwrite {
+		_, err = imp.tx.Exec(imp.ctx, `
+			UPDATE public.wf_definitions
+			SET title=$3, "desc"=$4, versions=$5, "updatedAt"=$6, "updatedBy"=$7
+			WHERE "wfId"::text = $1 AND "orgId"::text = $2`,
+			item.TargetId, imp.orgId, item.Name, wf.Desc, workflowDraftVersion, imp.now, imp.username)
+		if err == nil {
+			_, err = imp.tx.Exec(imp.ctx, `
+				DELETE FROM public.wf_nodes
+				WHERE "wfId"::text = $1 AND "orgId"::text = $2 AND versions = $3`,
+				item.TargetId, imp.orgId, workflowDraftVersion)
+		}
+	} else {
+		_, err = imp.tx.Exec(imp.ctx, `
+			INSERT INTO public.wf_definitions(
+			"orgId", "wfId", title, "desc", active, publish, locks, versions, "createdAt", "updatedAt", "createdBy", "updatedBy")
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10, $10)`,
+			imp.orgId, item.TargetId, item.Name, wf.Desc, true, true, true, workflowDraftVersion, imp.now, imp.username)
+	}
+	if err == nil {
+		err = insertWorkflowDraft(imp.ctx, imp.tx, imp.orgId, item.TargetId, remapped, imp.username, imp.now)
+	}
+	if err != nil {
+		return "", fmt.Errorf("workflow %s: %w", wf.WfID, err)
+	}
+
+	errs, err := ValidateWorkflow(imp.ctx, imp.tx, imp.orgId, remapped)
+	if err != nil {
+		return "", err
+	}
+	imp.report.Errors = append(imp.report.Errors, errs...)
+	return item.Action, nil
+}
+
+func (imp *bundleImport) importSubTypes(subs []model.WorkflowBundleCaseSubType) error {
+	for _, s := range subs {
+		item, err := imp.plan(bundleKindSubType, s.STypeID, s.STypeCode)
+		if err != nil {
+			return err
+		}
+		en, th := s.En, s.Th
+		if item.Action == bundleActionRename {
+			en += imp.req.RenameSuffix
+			th += imp.req.RenameSuffix
+		}
+		typeId := imp.mapIds([]string{s.TypeID})[0]
+		wfId := imp.mapIds([]string{s.WfID})[0]
+		skills, props := imp.mapIds(s.UserSkillList), imp.mapIds(s.UnitPropLists)
+		switch item.Action {
+		case bundleActionCreate, bundleActionRename:
+			_, err = imp.tx.Exec(imp.ctx, `
+				INSERT INTO public."case_sub_types"(
+				"typeId", "sTypeId", "sTypeCode", "orgId", en, th, "wfId", "caseSla", priority,
+				 "userSkillList", "unitPropLists", active, "createdAt", "updatedAt", "createdBy", "updatedBy")
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $13, $14, $14)`,
+				typeId, item.TargetId, item.Name, imp.orgId, en, th, wfId, s.CaseSla, s.Priority,
+				skills, props, s.Active, imp.now, imp.username)
+		case bundleActionOverwrite:
+			_, err = imp.tx.Exec(imp.ctx, `
+				UPDATE public."case_sub_types"
+				SET "sTypeCode"=$3, en=$4, th=$5, "wfId"=$6, "caseSla"=$7,
+				 priority=$8, "userSkillList"=$9, "unitPropLists"=$10, active=$11, "updatedAt"=$12,
+				  "updatedBy"=$13, "typeId"=$14
+				WHERE "sTypeId"::text = $1 AND "orgId"::text = $2`,
+				item.TargetId, imp.orgId, item.Name, en, th, wfId, s.CaseSla, s.Priority,
+				skills, props, s.Active, imp.now, imp.username, typeId)
+		}
+		if err != nil {
+			return fmt.Errorf("case subtype %s: %w", s.STypeID, err)
+		}
+	}
+	return nil
+}
+
+// ImportWorkflowBundle imports a bundle into orgId. Nothing is kept when the
+// imported workflow does not validate or on a dry run; the report then lists
+// what the import would have done. The imported workflow is frozen into a new
+// version once committed.
+func ImportWorkflowBundle(ctx context.Context, conn *pgx.Conn, orgId string, username string, req model.WorkflowImportRequest) (model.WorkflowImportReport, error) {
+	report := model.WorkflowImportReport{
+		DryRun: req.DryRun,
+		Items:  []model.WorkflowImportItem{},
+		Errors: []model.WorkflowValidationError{},
+	}
+	if req.Bundle.Format != workflowBundleFormat {
+		return report, fmt.Errorf("unsupported bundle format %q", req.Bundle.Format)
+	}
+	checksum, err := workflowBundleChecksum(req.Bundle.Content)
+	if err != nil {
+		return report, err
+	}
+	if checksum != req.Bundle.Checksum {
+		return report, fmt.Errorf("bundle checksum mismatch: the bundle was modified after export")
+	}
+	if req.Strategy == "" {
+		req.Strategy = bundleActionSkip
+	}
+	if req.RenameSuffix == "" {
+		req.RenameSuffix = bundleRenameSuffix
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback(ctx)
+	imp := &bundleImport{
+		ctx:      ctx,
+		tx:       tx,
+		orgId:    orgId,
+		username: username,
+		req:      req,
+		now:      time.Now(),
+		ids:      map[string]string{},
+		report:   &report,
+	}
+	content := req.Bundle.Content
+	if err := imp.importItems(bundleKindSkill, content.Skills); err != nil {
+		return report, err
+	}
+	if err := imp.importItems(bundleKindProperty, content.Properties); err != nil {
+		return report, err
+	}
+	if err := imp.importCaseTypes(content.CaseTypes); err != nil {
+		return report, err
+	}
+	if err := imp.importForms(content.Forms); err != nil {
+		return report, err
+	}
+	wfAction, err := imp.importWorkflow(content.Workflow)
+	if err != nil {
+		return report, err
+	}
+	if err := imp.importSubTypes(content.CaseSubTypes); err != nil {
+		return report, err
+	}
+	if req.DryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return report, err
+	}
+	if wfAction != bundleActionSkip {
+		report.Versions, err = SnapshotWorkflowVersion(ctx, conn, orgId, report.WfID, username)
+		if err != nil {
+			return report, fmt.Errorf("imported, but the workflow version could not be frozen: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// @summary Export Workflow Bundle
+// @description Exports the current version of a workflow with the forms, case types and subtypes, skills and unit properties it references as a portable bundle with a checksum.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Export Workflow Bundle
+// @accept json
+// @produce json
+// @Param id path string true "wfId"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/{id}/export [get]
+func WorkflowExport(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+	wfId := c.Param("id")
+
+	bundle, err := ExportWorkflowBundle(ctx, conn, orgId.(string), wfId, username.(string))
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, wfId, "WorkFlow", "WorkflowExport", "",
+			"view", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Data:   bundle,
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, wfId, "WorkFlow", "WorkflowExport", "",
+		"view", 0, start_time, GetQueryParams(c), model.Response{Status: "0", Msg: "Success", Desc: bundle.Checksum}, "WorkflowExport Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Import Workflow Bundle
+// @description Imports a workflow bundle into the caller's organization. Ids are remapped; conflicts with existing records are settled by strategy (skip, overwrite or rename). With dryRun only the report is returned.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Import Workflow Bundle
+// @accept json
+// @produce json
+// @param Body body model.WorkflowImportRequest true "Bundle"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/import [post]
+func WorkflowImport(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	fail := func(status int, response model.Response, msg string) {
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "WorkFlow", "WorkflowImport", "",
+			"create", -1, start_time, GetQueryParams(c), response, "Failed : "+msg,
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.WorkflowImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()}, err.Error())
+		return
+	}
+	report, err := ImportWorkflowBundle(ctx, conn, orgId.(string), username.(string), req)
+	if err != nil {
+		fail(http.StatusBadRequest, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error(), Data: report}, err.Error())
+		return
+	}
+	if len(report.Errors) > 0 {
+		fail(http.StatusBadRequest, model.Response{Status: "-1", Msg: "Failure", Desc: workflowValidationDesc, Data: report},
+			fmt.Sprintf("%s (%d errors)", workflowValidationDesc, len(report.Errors)))
+		return
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   fmt.Sprintf("%d records", len(report.Items)),
+		Data:   report,
+	}
+	action := "create"
+	if req.DryRun {
+		action = "view"
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, report.WfID, "WorkFlow", "WorkflowImport", "",
+		action, 0, start_time, GetQueryParams(c), response, "WorkflowImport Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
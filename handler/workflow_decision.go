@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// WorkflowDecisionNode is the node type that picks one outgoing connection by
+// evaluating the condition on each of them. The first branch whose condition
+// holds, in designer order, is taken; a branch without a condition (or with
+// "else") is taken when none does.
+const WorkflowDecisionNode = "decision"
+
+func isDefaultBranch(conn model.WorkFlowConnection) bool {
+	cond := strings.TrimSpace(conn.Condition)
+	return cond == "" || strings.EqualFold(cond, "else")
+}
+
+// decisionEvaluator resolves decision nodes for one case. Identifiers in a
+// condition are looked up as case fields first (by their JSON name, also as
+// "case.<name>") and then as form answers of the case by field id or label
+// (also as "form.<name>"). Form answers are loaded on first use.
+type decisionEvaluator struct {
+	ctx      context.Context
+	conn     *pgx.Conn
+	orgId    string
+	caseData map[string]interface{}
+	caseId   string
+
+	answers map[string]interface{}
+	chosen  map[string]model.WorkflowNode
+
+	Decisions []model.WorkflowDecision
+	Err       error
+}
+
+func newDecisionEvaluator(ctx context.Context, conn *pgx.Conn, orgId string, caseData *model.Case) *decisionEvaluator {
+	fields := map[string]interface{}{}
+	if caseData != nil {
+		if b, err := json.Marshal(caseData); err == nil {
+			_ = json.Unmarshal(b, &fields)
+		}
+	}
+	caseId, _ := fields["caseId"].(string)
+	return &decisionEvaluator{
+		ctx:      ctx,
+		conn:     conn,
+		orgId:    orgId,
+		caseData: fields,
+		caseId:   caseId,
+		chosen:   map[string]model.WorkflowNode{},
+	}
+}
+
+func (d *decisionEvaluator) lookup(name string) (interface{}, bool) {
+	switch {
+	case strings.HasPrefix(name, "case."):
+		v, ok := d.caseData[strings.TrimPrefix(name, "case.")]
+		return v, ok
+	case strings.HasPrefix(name, "form."):
+		return d.formAnswer(strings.TrimPrefix(name, "form."))
+	}
+	if v, ok := d.caseData[name]; ok {
+		return v, true
+	}
+	return d.formAnswer(name)
+}
+
+func (d *decisionEvaluator) formAnswer(name string) (interface{}, bool) {
+	if d.answers == nil {
+		answers, err := loadCaseFormValues(d.ctx, d.conn, d.orgId, d.caseId)
+		if err != nil {
+			log.Printf("Decision: load form answers of %s: %v", d.caseId, err)
+			answers = map[string]interface{}{}
+		}
+		d.answers = answers
+	}
+	v, ok := d.answers[name]
+	return v, ok
+}
+
+// Decide returns the node the decision leads to. It returns an empty node and
+// sets Err when no branch can be taken. A node already decided for this case
+// returns the same result.
+func (d *decisionEvaluator) Decide(node model.WorkflowNode, nodeConn []model.WorkFlowConnection, allNodesId map[string]model.WorkflowNode) model.WorkflowNode {
+	if next, ok := d.chosen[node.NodeId]; ok {
+		return next
+	}
+	var fallback *model.WorkFlowConnection
+	for i, conn := range nodeConn {
+		if conn.Source != node.NodeId {
+			continue
+		}
+		if isDefaultBranch(conn) {
+			if fallback == nil {
+				fallback = &nodeConn[i]
+			}
+			continue
+		}
+		expr, err := utils.ParseExpression(conn.Condition)
+		if err != nil {
+			d.Err = fmt.Errorf("decision %s: condition %q: %w", node.NodeId, conn.Condition, err)
+			return model.WorkflowNode{}
+		}
+		ok, err := expr.Eval(d.lookup)
+		if err != nil {
+			d.Err = fmt.Errorf("decision %s: condition %q: %w", node.NodeId, conn.Condition, err)
+			return model.WorkflowNode{}
+		}
+		if ok {
+			values := map[string]interface{}{}
+			for _, name := range expr.Identifiers() {
+				values[name], _ = d.lookup(name)
+			}
+			return d.take(node, conn, false, values, allNodesId)
+		}
+	}
+	if fallback != nil {
+		return d.take(node, *fallback, true, nil, allNodesId)
+	}
+	d.Err = fmt.Errorf("decision %s: no branch matched and there is no default branch", node.NodeId)
+	return model.WorkflowNode{}
+}
+
+func (d *decisionEvaluator) take(node model.WorkflowNode, conn model.WorkFlowConnection, isDefault bool, values map[string]interface{}, allNodesId map[string]model.WorkflowNode) model.WorkflowNode {
+	next := allNodesId[conn.Target]
+	d.chosen[node.NodeId] = next
+	d.Decisions = append(d.Decisions, model.WorkflowDecision{
+		NodeId:       node.NodeId,
+		NodeLabel:    nodeLabel(node),
+		ConnectionId: conn.Id,
+		Condition:    conn.Condition,
+		TargetId:     conn.Target,
+		Default:      isDefault,
+		Values:       values,
+	})
+	return next
+}
+
+// WriteHistory records every decision taken as a case history event.
+func (d *decisionEvaluator) WriteHistory(username string) {
+	for _, dec := range d.Decisions {
+		cond := dec.Condition
+		if dec.Default {
+			cond = "default"
+		}
+		evt := model.CaseHistoryEvent{
+			OrgID:     d.orgId,
+			CaseID:    d.caseId,
+			Username:  username,
+			Type:      "event",
+			FullMsg:   fmt.Sprintf("Decision %s :: %s :: %s", dec.NodeLabel, cond, dec.TargetId),
+			JsonData:  dec,
+			CreatedBy: username,
+		}
+		if err := InsertCaseHistoryEvent(d.ctx, d.conn, evt); err != nil {
+			log.Printf("Decision history of %s: %v", d.caseId, err)
+		}
+	}
+	d.Decisions = nil
+}
+
+func nodeLabel(node model.WorkflowNode) string {
+	if dataMap, ok := node.Data.(map[string]interface{}); ok {
+		if inner, ok := dataMap["data"].(map[string]interface{}); ok {
+			if label, ok := inner["label"].(string); ok && label != "" {
+				return label
+			}
+		}
+	}
+	return node.NodeId
+}
+
+// loadCaseFormValues returns the answered values of every form of the case,
+// keyed by field id and by label. Fields shown by a selected option are
+// included.
+func loadCaseFormValues(ctx context.Context, conn *pgx.Conn, orgId string, caseId string) (map[string]interface{}, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT "eleData"
+		FROM form_answers
+		WHERE "orgId"::text = $1 AND "caseId" = $2
+		ORDER BY id`, orgId, caseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]interface{}{}
+	labels := map[string]interface{}{}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var form model.Form
+		if err := json.Unmarshal(raw, &form); err != nil {
+			log.Printf("Decision: unreadable form answer of %s: %v", caseId, err)
+			continue
+		}
+		for _, field := range form.FormFieldJson {
+			collectFormValue(field.ID, field.Label, field.Value, field.Options, values, labels)
+		}
+	}
+	for label, v := range labels {
+		if _, ok := values[label]; !ok {
+			values[label] = v
+		}
+	}
+	return values, rows.Err()
+}
+
+func collectFormValue(id, label string, value interface{}, options []interface{}, values, labels map[string]interface{}) {
+	if id != "" {
+		values[id] = value
+	}
+	if label != "" {
+		labels[label] = value
+	}
+	for _, raw := range options {
+		b, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+		var opt model.FormFieldOption
+		if json.Unmarshal(b, &opt) != nil || len(opt.Form) == 0 || !optionSelected(value, opt.Value) {
+			continue
+		}
+		for _, child := range opt.Form {
+			collectFormValue(child.ID, child.Label, child.Value, child.Options, values, labels)
+		}
+	}
+}
+
+// optionSelected reports whether a field value selects option; multi-select
+// fields hold a list.
+func optionSelected(value interface{}, option string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == option
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == option {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,601 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"mainPackage/utils"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationStage is one tix_case_current_stage row as kept in the before and
+// after images of wf_case_migrations.
+type migrationStage struct {
+	StageType string      `json:"stageType"`
+	UnitId    string      `json:"unitId"`
+	NodeId    string      `json:"nodeId"`
+	Versions  string      `json:"versions"`
+	Type      *string     `json:"type"`
+	Section   *string     `json:"section"`
+	Data      interface{} `json:"data"`
+	Pic       *string     `json:"pic"`
+	Group     *string     `json:"group"`
+	FormId    *string     `json:"formId"`
+}
+
+func (s migrationStage) key() string {
+	return s.StageType + "|" + s.UnitId
+}
+
+// closedCaseStatuses are the statuses of cases that are no longer open.
+func closedCaseStatuses() []string {
+	var statuses []string
+	for _, key := range []string{"REQUESTCLOSE", "CANCEL", "CANCEL_CASE"} {
+		for _, s := range strings.Split(os.Getenv(key), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				statuses = append(statuses, s)
+			}
+		}
+	}
+	return statuses
+}
+
+func loadMigrationStages(ctx context.Context, db repository.DBTX, caseId string) ([]migrationStage, error) {
+	rows, err := db.Query(ctx, `
+		SELECT "stageType", COALESCE("unitId", ''), "nodeId", "versions", "type", "section", "data", "pic", "group", "formId"
+		FROM public.tix_case_current_stage
+		WHERE "caseId" = $1
+		ORDER BY "stageType", "unitId"`, caseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stages []migrationStage
+	for rows.Next() {
+		var st migrationStage
+		if err := rows.Scan(&st.StageType, &st.UnitId, &st.NodeId, &st.Versions, &st.Type, &st.Section, &st.Data, &st.Pic, &st.Group, &st.FormId); err != nil {
+			return nil, err
+		}
+		stages = append(stages, st)
+	}
+	return stages, rows.Err()
+}
+
+// loadVersionNodes returns the nodes of one workflow version as stage rows and
+// the ids of its connections.
+func loadVersionNodes(ctx context.Context, conn *pgx.Conn, orgId string, wfId string, version string) (map[string]migrationStage, map[string]bool, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT "nodeId", "type", "section", "data", "pic", "group", "formId"
+		FROM public.wf_nodes
+		WHERE "orgId" = $1 AND "wfId" = $2 AND "versions" = $3`, orgId, wfId, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	nodes := map[string]migrationStage{}
+	conns := map[string]bool{}
+	for rows.Next() {
+		st := migrationStage{Versions: version}
+		if err := rows.Scan(&st.NodeId, &st.Type, &st.Section, &st.Data, &st.Pic, &st.Group, &st.FormId); err != nil {
+			return nil, nil, err
+		}
+		if st.Section != nil && *st.Section == "connections" {
+			b, _ := json.Marshal(st.Data)
+			var list []model.WorkFlowConnection
+			if err := json.Unmarshal(b, &list); err == nil {
+				for _, c := range list {
+					conns[c.Id] = true
+				}
+			}
+			continue
+		}
+		nodes[st.NodeId] = st
+	}
+	return nodes, conns, rows.Err()
+}
+
+// planCaseMigration maps every stage of a case onto the target version.
+func planCaseMigration(caseId string, before []migrationStage, req model.WorkflowMigrateRequest, toVersion string,
+	nodes map[string]migrationStage, conns map[string]bool) (model.WorkflowMigrationCase, []migrationStage) {
+	result := model.WorkflowMigrationCase{CaseId: caseId, Stages: []model.WorkflowMigrationStage{}, Ok: true}
+	var after []migrationStage
+	for _, st := range before {
+		target := st.NodeId
+		if mapped, ok := req.NodeMap[st.NodeId]; ok && mapped != "" {
+			target = mapped
+		}
+		item := model.WorkflowMigrationStage{StageType: st.StageType, UnitId: st.UnitId, FromNode: st.NodeId, ToNode: target}
+		node, found := nodes[target]
+		switch {
+		case st.Versions != req.FromVersion:
+			item.Error = fmt.Sprintf("stage is on version %s", st.Versions)
+		case !found:
+			item.Error = fmt.Sprintf("node %s is not in version %s", target, toVersion)
+		}
+		if branchId, ok := caseBranchId(st.UnitId); ok && st.StageType == "case" && !conns[branchId] {
+			item.Error = fmt.Sprintf("parallel branch %s is not in version %s", branchId, toVersion)
+		}
+		if item.Error != "" {
+			result.Ok = false
+		} else {
+			node.StageType, node.UnitId = st.StageType, st.UnitId
+			after = append(after, node)
+		}
+		result.Stages = append(result.Stages, item)
+	}
+	if len(before) == 0 {
+		result.Ok = false
+		result.Error = "case has no current stage"
+	}
+	if !result.Ok && result.Error == "" {
+		result.Error = "some stages cannot be mapped"
+	}
+	return result, after
+}
+
+func writeMigrationStages(ctx context.Context, tx pgx.Tx, caseId string, stages []migrationStage, username string) error {
+	for _, st := range stages {
+		_, err := tx.Exec(ctx, `
+			UPDATE public.tix_case_current_stage
+			SET "nodeId" = $4, "versions" = $5, "type" = $6, "section" = $7, "data" = $8,
+			    "pic" = $9, "group" = $10, "formId" = $11, "updatedBy" = $12
+			WHERE "caseId" = $1 AND "stageType" = $2 AND "unitId" = $3`,
+			caseId, st.StageType, st.UnitId, st.NodeId, st.Versions, st.Type, st.Section, st.Data,
+			st.Pic, st.Group, st.FormId, username)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCase moves one case to the target version and keeps the before and
+// after images for rollback.
+func migrateCase(ctx context.Context, conn *pgx.Conn, migrationId string, orgId string, req model.WorkflowMigrateRequest, toVersion string,
+	caseId string, before []migrationStage, after []migrationStage, username string) error {
+	beforeJson, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJson, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := writeMigrationStages(ctx, tx, caseId, after, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE public.tix_cases SET "versions" = $3 WHERE "orgId" = $1 AND "caseId" = $2`, orgId, caseId, toVersion); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO public.wf_case_migrations
+		("migrationId", "orgId", "wfId", "caseId", "fromVersion", "toVersion", "before", "after", "createdAt", "createdBy")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		migrationId, orgId, req.WfID, caseId, req.FromVersion, toVersion, beforeJson, afterJson, time.Now(), username)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func migrationHistory(ctx context.Context, conn *pgx.Conn, orgId string, caseId string, username string, msg string, data interface{}) {
+	evt := model.CaseHistoryEvent{
+		OrgID:     orgId,
+		CaseID:    caseId,
+		Username:  username,
+		Type:      "event",
+		FullMsg:   msg,
+		JsonData:  data,
+		CreatedBy: username,
+	}
+	if err := InsertCaseHistoryEvent(ctx, conn, evt); err != nil {
+		log.Printf("Migration history of %s: %v", caseId, err)
+	}
+}
+
+// @summary List Workflow Versions
+// @description Lists the frozen versions of a workflow with the number of open cases pinned to each
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id List Workflow Versions
+// @accept json
+// @produce json
+// @Param id path string true "wfId"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/{id}/versions [get]
+func GetWorkflowVersions(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	orgId := GetVariableFromToken(c, "orgId")
+	wfId := c.Param("id")
+
+	rows, err := conn.Query(ctx, `
+		SELECT n."versions",
+		       COUNT(*) FILTER (WHERE n."section" = 'nodes'),
+		       MIN(n."createdAt"),
+		       COALESCE(MIN(n."createdBy"), ''),
+		       (SELECT COUNT(DISTINCT s."caseId")
+		          FROM public.tix_case_current_stage s
+		          JOIN public.tix_cases tc ON tc."caseId" = s."caseId"
+		         WHERE s."wfId" = n."wfId" AND s."versions" = n."versions"
+		           AND NOT (tc."statusId" = ANY($3)))
+		FROM public.wf_nodes n
+		WHERE n."orgId" = $1 AND n."wfId" = $2
+		GROUP BY n."wfId", n."versions"
+		ORDER BY NULLIF(regexp_replace(n."versions", '\D', '', 'g'), '')::INT DESC NULLS FIRST`,
+		orgId, wfId, closedCaseStatuses())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+		return
+	}
+	defer rows.Close()
+	versions := []model.WorkflowVersion{}
+	for rows.Next() {
+		v := model.WorkflowVersion{WfID: wfId}
+		if err := rows.Scan(&v.Versions, &v.Nodes, &v.CreatedAt, &v.CreatedBy, &v.OpenCases); err != nil {
+			c.JSON(http.StatusInternalServerError, model.Response{Status: "-1", Msg: "Failure", Desc: err.Error()})
+			return
+		}
+		versions = append(versions, v)
+	}
+	c.JSON(http.StatusOK, model.Response{Status: "0", Msg: "Success", Data: versions})
+}
+
+// @summary Migrate Cases To Workflow Version
+// @description Moves open cases from one workflow version to another. Stages move along nodeMap (old node id to new node id; unmapped ids are kept). With dryRun only the report is returned. A case is migrated only when all its stages map onto the target version.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Migrate Workflow Cases
+// @accept json
+// @produce json
+// @param Body body model.WorkflowMigrateRequest true "Migration"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/migrate [post]
+func WorkflowMigrate(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	fail := func(status int, err error) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "WorkFlow", "WorkflowMigrate", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.WorkflowMigrateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	toVersion := req.ToVersion
+	if toVersion == "" {
+		latest, err := LatestWorkflowVersion(ctx, conn, orgId.(string), req.WfID)
+		if err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		toVersion = latest
+	}
+	if toVersion == "" || toVersion == req.FromVersion {
+		fail(http.StatusBadRequest, fmt.Errorf("no other version of workflow %s to migrate to", req.WfID))
+		return
+	}
+	nodes, conns, err := loadVersionNodes(ctx, conn, orgId.(string), req.WfID, toVersion)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if len(nodes) == 0 {
+		fail(http.StatusBadRequest, fmt.Errorf("version %s of workflow %s not found", toVersion, req.WfID))
+		return
+	}
+
+	query := `
+		SELECT c."caseId"
+		FROM public.tix_cases c
+		WHERE c."orgId" = $1 AND c."wfId" = $2
+		  AND NOT (c."statusId" = ANY($3))
+		  AND EXISTS (
+		      SELECT 1 FROM public.tix_case_current_stage s
+		      WHERE s."caseId" = c."caseId" AND s."versions" = $4)
+		  AND (cardinality($5::text[]) = 0 OR c."caseId" = ANY($5))
+		ORDER BY c."caseId"`
+	caseIds := req.CaseIds
+	if caseIds == nil {
+		caseIds = []string{}
+	}
+	rows, err := conn.Query(ctx, query, orgId, req.WfID, closedCaseStatuses(), req.FromVersion, caseIds)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	var open []string
+	for rows.Next() {
+		var caseId string
+		if err := rows.Scan(&caseId); err != nil {
+			rows.Close()
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		open = append(open, caseId)
+	}
+	rows.Close()
+
+	report := model.WorkflowMigrationReport{
+		WfID:        req.WfID,
+		FromVersion: req.FromVersion,
+		ToVersion:   toVersion,
+		DryRun:      req.DryRun,
+		Cases:       []model.WorkflowMigrationCase{},
+	}
+	if !req.DryRun {
+		report.MigrationId = uuid.New().String()
+	}
+	found := map[string]bool{}
+	for _, caseId := range open {
+		found[caseId] = true
+		before, err := loadMigrationStages(ctx, conn, caseId)
+		if err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		result, after := planCaseMigration(caseId, before, req, toVersion, nodes, conns)
+		if result.Ok && !req.DryRun {
+			if err := migrateCase(ctx, conn, report.MigrationId, orgId.(string), req, toVersion, caseId, before, after, username.(string)); err != nil {
+				result.Ok = false
+				result.Error = err.Error()
+			} else {
+				migrationHistory(ctx, conn, orgId.(string), caseId, username.(string),
+					fmt.Sprintf("Workflow version %s :: %s", req.FromVersion, toVersion), result)
+			}
+		}
+		if result.Ok {
+			report.Migrated++
+		} else {
+			report.Skipped++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+	for _, caseId := range req.CaseIds {
+		if !found[caseId] {
+			found[caseId] = true
+			report.Skipped++
+			report.Cases = append(report.Cases, model.WorkflowMigrationCase{
+				CaseId: caseId,
+				Stages: []model.WorkflowMigrationStage{},
+				Error:  fmt.Sprintf("not an open case on version %s", req.FromVersion),
+			})
+		}
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   fmt.Sprintf("%d migrated, %d skipped", report.Migrated, report.Skipped),
+		Data:   report,
+	}
+	action := "update"
+	if req.DryRun {
+		action = "view"
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, report.MigrationId, "WorkFlow", "WorkflowMigrate", "",
+		action, 0, start_time, GetQueryParams(c), response, "WorkflowMigrate Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+// @summary Roll Back Workflow Migration
+// @description Restores the stages the cases of a migration had before it. A case that moved on after the migration is left alone.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Rollback Workflow Migration
+// @accept json
+// @produce json
+// @param Body body model.WorkflowMigrationRollback true "Migration"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/migrate/rollback [post]
+func WorkflowMigrateRollback(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	fail := func(status int, err error) {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "WorkFlow", "WorkflowMigrateRollback", "",
+			"update", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(status, response)
+	}
+
+	var req model.WorkflowMigrationRollback
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	type migrated struct {
+		caseId, wfId, from, to string
+		before, after          []migrationStage
+	}
+	rows, err := conn.Query(ctx, `
+		SELECT "caseId", "wfId", "fromVersion", "toVersion", "before", "after"
+		FROM public.wf_case_migrations
+		WHERE "orgId" = $1 AND "migrationId" = $2 AND "rolledBackAt" IS NULL
+		ORDER BY "caseId"`, orgId, req.MigrationId)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	var items []migrated
+	for rows.Next() {
+		var m migrated
+		var before, after []byte
+		if err := rows.Scan(&m.caseId, &m.wfId, &m.from, &m.to, &before, &after); err != nil {
+			rows.Close()
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		if err := json.Unmarshal(before, &m.before); err != nil {
+			rows.Close()
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		if err := json.Unmarshal(after, &m.after); err != nil {
+			rows.Close()
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		items = append(items, m)
+	}
+	rows.Close()
+	if len(items) == 0 {
+		fail(http.StatusNotFound, fmt.Errorf("migration %s not found or already rolled back", req.MigrationId))
+		return
+	}
+
+	report := model.WorkflowMigrationReport{
+		MigrationId: req.MigrationId,
+		WfID:        items[0].wfId,
+		FromVersion: items[0].to,
+		ToVersion:   items[0].from,
+		Cases:       []model.WorkflowMigrationCase{},
+	}
+	for _, m := range items {
+		result := model.WorkflowMigrationCase{CaseId: m.caseId, Stages: []model.WorkflowMigrationStage{}, Ok: true}
+		moved := map[string]string{}
+		for _, st := range m.after {
+			moved[st.key()] = st.NodeId
+		}
+		for _, st := range m.before {
+			result.Stages = append(result.Stages, model.WorkflowMigrationStage{StageType: st.StageType, UnitId: st.UnitId, FromNode: moved[st.key()], ToNode: st.NodeId})
+		}
+		if err := rollbackCase(ctx, conn, orgId.(string), req.MigrationId, m.caseId, m.from, m.before, m.after, username.(string)); err != nil {
+			result.Ok = false
+			result.Error = err.Error()
+			report.Skipped++
+		} else {
+			report.Migrated++
+			migrationHistory(ctx, conn, orgId.(string), m.caseId, username.(string),
+				fmt.Sprintf("Workflow version %s :: %s (rollback)", m.to, m.from), result)
+		}
+		report.Cases = append(report.Cases, result)
+	}
+
+	response := model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   fmt.Sprintf("%d rolled back, %d skipped", report.Migrated, report.Skipped),
+		Data:   report,
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId.(string), username.(string),
+		txtId, req.MigrationId, "WorkFlow", "WorkflowMigrateRollback", "",
+		"update", 0, start_time, GetQueryParams(c), response, "WorkflowMigrateRollback Success.",
+	)
+	//=======AUDIT_END=====//
+	c.JSON(http.StatusOK, response)
+}
+
+var errCaseMovedOn = errors.New("case moved on after the migration")
+
+// rollbackCase restores the before image of a migrated case when its stages
+// are still the ones the migration wrote.
+func rollbackCase(ctx context.Context, conn *pgx.Conn, orgId string, migrationId string, caseId string, fromVersion string,
+	before []migrationStage, after []migrationStage, username string) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := loadMigrationStages(ctx, tx, caseId)
+	if err != nil {
+		return err
+	}
+	if len(current) != len(after) {
+		return errCaseMovedOn
+	}
+	want := map[string]migrationStage{}
+	for _, st := range after {
+		want[st.key()] = st
+	}
+	for _, st := range current {
+		w, ok := want[st.key()]
+		if !ok || w.NodeId != st.NodeId || w.Versions != st.Versions {
+			return errCaseMovedOn
+		}
+	}
+
+	if err := writeMigrationStages(ctx, tx, caseId, before, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE public.tix_cases SET "versions" = $3 WHERE "orgId" = $1 AND "caseId" = $2`, orgId, caseId, fromVersion); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE public.wf_case_migrations
+		SET "rolledBackAt" = $4, "rolledBackBy" = $5
+		WHERE "orgId" = $1 AND "migrationId" = $2 AND "caseId" = $3`,
+		orgId, migrationId, caseId, time.Now(), username)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,500 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Parallel branches. A fork node opens one branch per outgoing connection and
+// a join node closes them again. While a fork is open the stage that reached
+// it rests on the fork, and every branch keeps its own case level stage in
+// tix_case_current_stage under the unit id "branch:<connection id>". A branch
+// is complete once the next node on its path is the join. The join fires when
+// the number of complete branches reaches its "required" config (all incoming
+// branches when unset): the branches still open are dropped and the stage
+// resting on the fork moves to the join.
+const (
+	WorkflowForkNode = "fork"
+	WorkflowJoinNode = "join"
+	caseBranchPrefix = "branch:"
+)
+
+func caseBranchKey(branchId string) string {
+	if branchId == "" {
+		return ""
+	}
+	return caseBranchPrefix + branchId
+}
+
+func caseBranchId(unitId string) (string, bool) {
+	return strings.CutPrefix(unitId, caseBranchPrefix)
+}
+
+// joinRequired returns how many of the incoming branches must complete before
+// the join fires.
+func joinRequired(node model.WorkflowNode, incoming int) int {
+	n := ToInt(nodeConfig(node)["required"])
+	if n <= 0 || n > incoming {
+		return incoming
+	}
+	return n
+}
+
+// walkBranch returns the nodes of a branch starting at from and the joins that
+// close it. Forks and joins nested in the branch are followed, so the nodes of
+// an inner branch belong to the outer one as well.
+func walkBranch(from string, next map[string][]string, types map[string]string) (map[string]bool, map[string]bool) {
+	type step struct {
+		id    string
+		depth int
+	}
+	nodes := map[string]bool{}
+	joins := map[string]bool{}
+	seen := map[step]bool{}
+	stack := []step{{from, 0}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[s] || s.depth > len(types) {
+			continue
+		}
+		seen[s] = true
+		depth := s.depth
+		switch types[s.id] {
+		case WorkflowJoinNode:
+			if depth == 0 {
+				joins[s.id] = true
+				continue
+			}
+			depth--
+		case WorkflowForkNode:
+			depth++
+		}
+		nodes[s.id] = true
+		for _, target := range next[s.id] {
+			stack = append(stack, step{target, depth})
+		}
+	}
+	return nodes, joins
+}
+
+// parallelFlow moves the case level stages of one case through forks and
+// joins. It is used by UpdateCurrentStageCore for a single stage update and
+// writes the stages through store.
+type parallelFlow struct {
+	orgId      string
+	caseId     string
+	wfId       string
+	version    string
+	allNodesId map[string]model.WorkflowNode
+	nodeConn   []model.WorkFlowConnection
+	decide     func(node model.WorkflowNode) model.WorkflowNode
+	store      stageStore
+
+	conns    map[string]model.WorkFlowConnection
+	order    map[string]int
+	next     map[string][]string
+	types    map[string]string
+	stages   map[string]model.CurrentStage // case level stages by branch id, "" is the trunk
+	forking  []model.WorkFlowConnection    // branches the case stage enters in this update
+	members  map[string]map[string]bool
+	Parallel bool
+}
+
+func newParallelFlow(orgId string, caseId string, allNodesId map[string]model.WorkflowNode, nodeConn []model.WorkFlowConnection, decide func(node model.WorkflowNode) model.WorkflowNode, stages []model.CurrentStage, store stageStore) *parallelFlow {
+	p := &parallelFlow{
+		orgId:      orgId,
+		caseId:     caseId,
+		allNodesId: allNodesId,
+		nodeConn:   nodeConn,
+		decide:     decide,
+		store:      store,
+		conns:      map[string]model.WorkFlowConnection{},
+		order:      map[string]int{},
+		next:       map[string][]string{},
+		types:      map[string]string{},
+		stages:     map[string]model.CurrentStage{},
+		members:    map[string]map[string]bool{},
+	}
+	for id, node := range allNodesId {
+		p.types[id] = node.Type
+		if node.Type == WorkflowForkNode {
+			p.Parallel = true
+		}
+	}
+	for i, c := range nodeConn {
+		p.conns[c.Id] = c
+		p.order[c.Id] = i
+		p.next[c.Source] = append(p.next[c.Source], c.Target)
+	}
+	for _, st := range stages {
+		id, ok := caseBranchId(st.UnitID)
+		if !ok {
+			id = ""
+		}
+		p.stages[id] = st
+		if p.wfId == "" {
+			p.wfId, p.version = st.WfID, st.Versions
+		}
+	}
+	return p
+}
+
+// Open returns the ids of the open branches in designer order.
+func (p *parallelFlow) Open() []string {
+	var ids []string
+	for id := range p.stages {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return p.order[ids[i]] < p.order[ids[j]] })
+	return ids
+}
+
+func (p *parallelFlow) forkOf(branchId string) string {
+	return p.conns[branchId].Source
+}
+
+func (p *parallelFlow) branchNodes(branchId string) map[string]bool {
+	if nodes, ok := p.members[branchId]; ok {
+		return nodes
+	}
+	nodes, _ := walkBranch(p.conns[branchId].Target, p.next, p.types)
+	p.members[branchId] = nodes
+	return nodes
+}
+
+// selectBranch returns the connection of the fork matching want by id or by
+// target node, or the first one when nothing matches.
+func (p *parallelFlow) selectBranch(forkId string, want string) (model.WorkFlowConnection, bool) {
+	var first *model.WorkFlowConnection
+	for i, c := range p.nodeConn {
+		if c.Source != forkId {
+			continue
+		}
+		if want != "" && (c.Id == want || c.Target == want) {
+			return c, true
+		}
+		if first == nil {
+			first = &p.nodeConn[i]
+		}
+	}
+	if first == nil {
+		return model.WorkFlowConnection{}, false
+	}
+	return *first, true
+}
+
+// StageFor picks the case level stage a unit update belongs to: the trunk
+// when no branch is open, else the branch the unit is on, the branch asked
+// for, or the first open one.
+func (p *parallelFlow) StageFor(unit model.CurrentStage, want string) (model.CurrentStage, string) {
+	open := p.Open()
+	if len(open) == 0 {
+		return p.stages[""], ""
+	}
+	if unit.NodeId != "" {
+		for _, id := range open {
+			if st := p.stages[id]; st.NodeId == unit.NodeId && st.NodeId != p.forkOf(id) {
+				return st, id
+			}
+		}
+		for _, id := range open {
+			if p.branchNodes(id)[unit.NodeId] {
+				return p.stages[id], id
+			}
+		}
+	}
+	for _, id := range open {
+		if want != "" && (id == want || p.conns[id].Target == want) {
+			return p.stages[id], id
+		}
+	}
+	return p.stages[open[0]], open[0]
+}
+
+// StageNext returns the node a case level stage moves to next. A branch that
+// has not left its fork yet moves along its own connection.
+func (p *parallelFlow) StageNext(stage model.CurrentStage, branchId string) model.WorkflowNode {
+	if branchId != "" && stage.NodeId == p.forkOf(branchId) {
+		return resolveStageNode(p.allNodesId[p.conns[branchId].Target], p.allNodesId, p.nodeConn, p.decide)
+	}
+	for _, c := range p.nodeConn {
+		if c.Source == stage.NodeId {
+			return resolveStageNode(p.allNodesId[c.Target], p.allNodesId, p.nodeConn, p.decide)
+		}
+	}
+	return model.WorkflowNode{}
+}
+
+// EnterForks replaces a fork ahead of the case stage with the first node of
+// the chosen branch and remembers the forks to open when the stage moves.
+func (p *parallelFlow) EnterForks(next model.WorkflowNode, want string) (model.WorkflowNode, string, error) {
+	branchId := ""
+	for hops := 0; next.Type == WorkflowForkNode; hops++ {
+		if hops > len(p.allNodesId) {
+			return next, branchId, fmt.Errorf("fork %s: branches loop back to a fork", next.NodeId)
+		}
+		bc, ok := p.selectBranch(next.NodeId, want)
+		if !ok {
+			return next, branchId, fmt.Errorf("fork %s has no branch", next.NodeId)
+		}
+		p.forking = append(p.forking, bc)
+		branchId = bc.Id
+		next = resolveStageNode(p.allNodesId[bc.Target], p.allNodesId, p.nodeConn, p.decide)
+	}
+	return next, branchId, nil
+}
+
+// UnitNext moves the next node of a unit across forks and joins. A unit goes
+// into the branch of the case stage it moves with and may only pass a join
+// once the join has fired.
+func (p *parallelFlow) UnitNext(next model.WorkflowNode, branchId string) (model.WorkflowNode, error) {
+	for hops := 0; hops <= len(p.allNodesId); hops++ {
+		switch next.Type {
+		case WorkflowForkNode:
+			bc, ok := model.WorkFlowConnection{}, false
+			if c, found := p.conns[branchId]; found && c.Source == next.NodeId {
+				bc, ok = c, true
+			} else {
+				bc, ok = p.selectBranch(next.NodeId, branchId)
+			}
+			if !ok {
+				return next, fmt.Errorf("fork %s has no branch", next.NodeId)
+			}
+			next = resolveStageNode(p.allNodesId[bc.Target], p.allNodesId, p.nodeConn, p.decide)
+		case WorkflowJoinNode:
+			for _, id := range p.Open() {
+				if p.StageNext(p.stages[id], id).NodeId == next.NodeId {
+					return next, fmt.Errorf("waiting at join %s for the other branches", nodeLabel(next))
+				}
+			}
+			passed := false
+			for _, c := range p.nodeConn {
+				if c.Source == next.NodeId {
+					next = resolveStageNode(p.allNodesId[c.Target], p.allNodesId, p.nodeConn, p.decide)
+					passed = true
+					break
+				}
+			}
+			if !passed {
+				return next, nil
+			}
+		default:
+			return next, nil
+		}
+	}
+	return next, nil
+}
+
+// DispatchNode returns the first dispatch node of the branch, or fallback.
+func (p *parallelFlow) DispatchNode(branchId string, fallback model.WorkflowNode) model.WorkflowNode {
+	if branchId == "" {
+		return fallback
+	}
+	nodes := p.branchNodes(branchId)
+	for _, c := range p.nodeConn {
+		if nodes[c.Target] && p.types[c.Target] == "dispatch" {
+			return p.allNodesId[c.Target]
+		}
+	}
+	return fallback
+}
+
+// MoveCase moves the case level stage of branchId to next. Forks crossed on
+// the way are opened first and joins completed by the move are fired.
+func (p *parallelFlow) MoveCase(req model.UpdateStageRequest, branchId string, next model.WorkflowNode) (model.Response, error) {
+	current := branchId
+	for _, bc := range p.forking {
+		if res, err := p.openFork(req, current, p.allNodesId[bc.Source]); err != nil {
+			return res, err
+		}
+		current = bc.Id
+	}
+	p.forking = nil
+
+	req.Branch = current
+	res, err := p.store.UpdateStage(req, next, "case")
+	if err != nil {
+		return res, err
+	}
+	st := p.stages[current]
+	st.NodeId = next.NodeId
+	st.Type = next.Type
+	p.stages[current] = st
+
+	for current != "" {
+		fired, res, err := p.fireJoin(req, p.forkOf(current))
+		if err != nil {
+			return res, err
+		}
+		if fired == nil {
+			break
+		}
+		current = *fired
+	}
+	return res, nil
+}
+
+// openFork moves the stage parentId onto the fork and adds one branch stage
+// per outgoing connection, all resting on the fork.
+func (p *parallelFlow) openFork(req model.UpdateStageRequest, parentId string, fork model.WorkflowNode) (model.Response, error) {
+	req.Branch = parentId
+	res, err := p.store.UpdateStage(req, fork, "case")
+	if err != nil {
+		return res, err
+	}
+	parent := p.stages[parentId]
+	parent.NodeId = fork.NodeId
+	parent.Type = fork.Type
+	p.stages[parentId] = parent
+
+	var opened []string
+	for _, c := range p.nodeConn {
+		if c.Source != fork.NodeId {
+			continue
+		}
+		branch := req
+		branch.NodeId = fork.NodeId
+		branch.UnitId = caseBranchKey(c.Id)
+		branch.UnitUser = ""
+		if res, err := p.store.InsertStage(branch, fork, "case"); err != nil {
+			return model.Response{Status: "-1", Msg: "Failure.Fork.1", Desc: res.Desc}, err
+		}
+		p.stages[c.Id] = model.CurrentStage{CaseId: req.CaseId, WfID: p.wfId, NodeId: fork.NodeId, Type: fork.Type, StageType: "case", UnitID: caseBranchKey(c.Id)}
+		opened = append(opened, c.Target)
+	}
+	p.store.History(fmt.Sprintf("Fork %s :: %d branches", nodeLabel(fork), len(opened)), map[string]interface{}{
+		"forkId":   fork.NodeId,
+		"branches": opened,
+	})
+	return model.Response{Status: "0", Msg: "Success", Desc: "Fork"}, nil
+}
+
+// fireJoin fires the join closing the branches of forkId once enough of them
+// are complete. It returns the branch id of the stage that moved to the join,
+// or nil when the join is still waiting.
+func (p *parallelFlow) fireJoin(req model.UpdateStageRequest, forkId string) (*string, model.Response, error) {
+	var siblings, done []string
+	var join model.WorkflowNode
+	for _, id := range p.Open() {
+		if p.forkOf(id) != forkId {
+			continue
+		}
+		siblings = append(siblings, id)
+		if next := p.StageNext(p.stages[id], id); next.Type == WorkflowJoinNode {
+			join = next
+			done = append(done, id)
+		}
+	}
+	if join.NodeId == "" {
+		return nil, model.Response{}, nil
+	}
+	incoming := 0
+	for _, c := range p.nodeConn {
+		if c.Target == join.NodeId {
+			incoming++
+		}
+	}
+	required := joinRequired(join, incoming)
+	if len(done) < required {
+		return nil, model.Response{}, nil
+	}
+
+	parentId, found := "", false
+	for id, st := range p.stages {
+		if st.NodeId == forkId && p.forkOf(id) != forkId {
+			parentId, found = id, true
+			break
+		}
+	}
+	if !found {
+		err := fmt.Errorf("join %s: no stage rests on fork %s", join.NodeId, forkId)
+		return nil, model.Response{Status: "-1", Msg: "Failure.Join.1", Desc: err.Error()}, err
+	}
+
+	if err := p.store.DeleteBranches(siblings); err != nil {
+		return nil, model.Response{Status: "-1", Msg: "Failure.Join.2", Desc: err.Error()}, err
+	}
+	for _, id := range siblings {
+		delete(p.stages, id)
+	}
+
+	req.Branch = parentId
+	res, err := p.store.UpdateStage(req, join, "case")
+	if err != nil {
+		return nil, res, err
+	}
+	parent := p.stages[parentId]
+	parent.NodeId = join.NodeId
+	parent.Type = join.Type
+	p.stages[parentId] = parent
+
+	p.store.History(fmt.Sprintf("Join %s :: %d/%d", nodeLabel(join), len(done), len(siblings)), map[string]interface{}{
+		"joinId":    join.NodeId,
+		"forkId":    forkId,
+		"required":  required,
+		"completed": done,
+		"cancelled": len(siblings) - len(done),
+	})
+	return &parentId, res, nil
+}
+
+// GetCaseBranches returns the open parallel branches of a case with the node
+// each one moves to next.
+func GetCaseBranches(ctx context.Context, conn *pgx.Conn, orgId string, caseId string) ([]model.CaseBranch, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT "wfId", "nodeId", "versions", "type", "section", "data", "pic", "group", "formId", "unitId"
+		FROM tix_case_current_stage
+		WHERE "orgId" = $1 AND "caseId" = $2 AND "stageType" = 'case' AND "unitId" LIKE $3
+		ORDER BY "createdAt"`, orgId, caseId, caseBranchPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	var stages []model.CurrentStage
+	for rows.Next() {
+		st := model.CurrentStage{CaseId: caseId, StageType: "case"}
+		if err := rows.Scan(&st.WfID, &st.NodeId, &st.Versions, &st.Type, &st.Section, &st.Data, &st.Pic, &st.Group, &st.FormId, &st.UnitID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stages = append(stages, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	branches := []model.CaseBranch{}
+	if len(stages) == 0 {
+		return branches, nil
+	}
+
+	_, nodeConn, allNodesId, _, err := GetAllNodes(ctx, conn, orgId, stages[0].WfID, stages[0].Versions, utils.GetLog())
+	if err != nil {
+		return nil, err
+	}
+	p := newParallelFlow(orgId, caseId, allNodesId, nodeConn, nil, stages, nil)
+	for _, id := range p.Open() {
+		st := p.stages[id]
+		next := p.StageNext(st, id)
+		b := model.CaseBranch{
+			BranchId:     id,
+			ForkId:       p.forkOf(id),
+			Label:        p.conns[id].Label,
+			CurrentStage: st,
+			Waiting:      next.Type == WorkflowJoinNode,
+		}
+		if next.NodeId != "" {
+			b.NextStage = &next
+		}
+		branches = append(branches, b)
+	}
+	return branches, nil
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Simulations run the stage logic of UpdateCurrentStageCore (planStageUpdate
+// and stagePlan.Apply) against a memStageStore. Nothing is written: decisions
+// read the case fields and form answers of the request, and notifications are
+// reported instead of sent.
+const (
+	simulationCaseId     = "SIMULATION"
+	simulationEventSla   = "sla"
+	simulationEventState = "status"
+)
+
+type workflowSimulation struct {
+	ctx          *gin.Context
+	conn         *pgx.Conn
+	orgId        string
+	allNodesId   map[string]model.WorkflowNode
+	nodeConn     []model.WorkFlowConnection
+	dispatchNode model.WorkflowNode
+	statuses     map[string]model.CaseStatus
+	caseData     map[string]interface{}
+	answers      map[string]interface{}
+	logger       *zap.Logger
+
+	store   *memStageStore
+	status  string
+	overSla int
+	closed  bool
+}
+
+// simulationVersion returns the version a simulation runs on when the request
+// names none: the version kept in wf_definitions, which the designer edits.
+func simulationVersion(ctx context.Context, conn *pgx.Conn, orgId string, wfId string) (string, error) {
+	var version string
+	err := conn.QueryRow(ctx, `
+		SELECT "versions" FROM public.wf_definitions
+		WHERE "orgId" = $1 AND "wfId" = $2`, orgId, wfId).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("workflow %s not found", wfId)
+	}
+	return version, err
+}
+
+// SimulateWorkflow runs the events of req through a workflow version and
+// returns the trace. Events the real stage update would reject are reported
+// in their step and leave the stages unchanged.
+func SimulateWorkflow(ctx *gin.Context, conn *pgx.Conn, orgId string, req model.WorkflowSimulateRequest) (model.WorkflowSimulation, error) {
+	report := model.WorkflowSimulation{WfID: req.WfID, Versions: req.Versions}
+	if report.Versions == "" {
+		version, err := simulationVersion(ctx, conn, orgId, req.WfID)
+		if err != nil {
+			return report, err
+		}
+		report.Versions = version
+	}
+	logger := utils.GetLog()
+	_, nodeConn, allNodesId, dispatchNode, err := GetAllNodes(ctx, conn, orgId, req.WfID, report.Versions, logger)
+	if err != nil {
+		return report, err
+	}
+	if len(allNodesId) == 0 {
+		return report, fmt.Errorf("version %s of workflow %s not found", report.Versions, req.WfID)
+	}
+	report.StartNodeId = req.StartNodeId
+	if report.StartNodeId == "" {
+		for id, node := range allNodesId {
+			if node.Type == "start" {
+				report.StartNodeId = id
+				break
+			}
+		}
+	}
+	if _, ok := allNodesId[report.StartNodeId]; !ok || report.StartNodeId == "" {
+		return report, fmt.Errorf("start node %q not found in workflow %s version %s", report.StartNodeId, req.WfID, report.Versions)
+	}
+
+	statuses := map[string]model.CaseStatus{}
+	list, err := utils.GetCaseStatusList(ctx, conn, orgId)
+	if err != nil {
+		return report, err
+	}
+	for _, s := range list {
+		if s.StatusID != nil {
+			statuses[*s.StatusID] = s
+		}
+	}
+
+	now := time.Now().UTC()
+	if req.StartAt != nil {
+		now = req.StartAt.UTC()
+	}
+	sim := &workflowSimulation{
+		ctx:          ctx,
+		conn:         conn,
+		orgId:        orgId,
+		allNodesId:   allNodesId,
+		nodeConn:     nodeConn,
+		dispatchNode: dispatchNode,
+		statuses:     statuses,
+		caseData:     req.Case,
+		answers:      req.Answers,
+		logger:       logger,
+		store:        newMemStageStore(simulationCaseId, req.WfID, report.Versions, allNodesId, now),
+	}
+	if sim.caseData == nil {
+		sim.caseData = map[string]interface{}{}
+	}
+	if sim.answers == nil {
+		sim.answers = map[string]interface{}{}
+	}
+	sim.store.write("case", "", report.StartNodeId)
+	visited := map[string]bool{report.StartNodeId: true}
+	report.Visited = []string{report.StartNodeId}
+
+	report.Steps = []model.WorkflowSimulationStep{}
+	for i, evt := range req.Events {
+		step := sim.run(i, evt)
+		for _, m := range step.Moves {
+			if m.ToNode != "" && !visited[m.ToNode] {
+				visited[m.ToNode] = true
+				report.Visited = append(report.Visited, m.ToNode)
+			}
+		}
+		report.Steps = append(report.Steps, step)
+	}
+
+	report.Stages = sim.store.Stages()
+	report.Closed = sim.closed
+	report.Warnings = []string{}
+	if !sim.closed {
+		for _, st := range report.Stages {
+			if st.StageType == "case" {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("case is not closed: stage %s rests on %s", stageLabel(st), nodeLabel(allNodesId[st.NodeId])))
+			}
+		}
+	}
+	for _, step := range report.Steps {
+		if step.Error != "" {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("event %d was rejected: %s", step.Index, step.Error))
+		}
+	}
+	return report, nil
+}
+
+func stageLabel(st model.WorkflowSimulationStage) string {
+	if st.UnitId == "" {
+		return st.StageType
+	}
+	return st.StageType + " " + st.UnitId
+}
+
+// run applies one event and reports what it did.
+func (s *workflowSimulation) run(index int, evt model.WorkflowSimulationEvent) model.WorkflowSimulationStep {
+	s.store.now = s.store.now.Add(time.Duration(evt.After) * time.Minute)
+	if evt.Type == "" {
+		evt.Type = simulationEventState
+	}
+	moves, history := len(s.store.moves), len(s.store.history)
+	step := model.WorkflowSimulationStep{
+		Index:         index,
+		Event:         evt,
+		Notifications: []model.WorkflowSimulationNotification{},
+	}
+
+	switch {
+	case s.closed:
+		step.Error = "case is closed"
+	case evt.Type == simulationEventSla:
+		s.slaTimeout(&step)
+	case evt.Type == simulationEventState:
+		s.updateStage(&step, evt)
+	default:
+		step.Error = fmt.Sprintf("unknown event type %q", evt.Type)
+	}
+
+	step.At = s.store.now
+	step.Moves = append([]model.WorkflowSimulationMove{}, s.store.moves[moves:]...)
+	step.History = append([]string(nil), s.store.history[history:]...)
+	step.Forms = []model.WorkflowSimulationForm{}
+	for _, m := range step.Moves {
+		if formId := ToString(nodeConfig(s.allNodesId[m.ToNode])["formId"]); m.ToNode != "" && formId != "" {
+			step.Forms = append(step.Forms, model.WorkflowSimulationForm{NodeId: m.ToNode, FormId: formId})
+		}
+	}
+	step.Deadlines = s.deadlines()
+	step.Stages = s.store.Stages()
+	return step
+}
+
+// updateStage runs a stage update the way UpdateCurrentStageCore does.
+func (s *workflowSimulation) updateStage(step *model.WorkflowSimulationStep, evt model.WorkflowSimulationEvent) {
+	req := model.UpdateStageRequest{
+		CaseId:    simulationCaseId,
+		Status:    evt.Status,
+		UnitId:    evt.UnitId,
+		UnitUser:  evt.UnitUser,
+		NodeId:    evt.NodeId,
+		ResID:     evt.ResID,
+		ResDetail: evt.ResDetail,
+		Branch:    evt.Branch,
+	}
+	caseRows, unitStages := s.store.CurrentStages(req.UnitId)
+
+	decisions := newDecisionEvaluator(s.ctx, s.conn, s.orgId, nil)
+	decisions.caseId = simulationCaseId
+	decisions.caseData = s.caseData
+	decisions.answers = s.answers
+	decide := func(node model.WorkflowNode) model.WorkflowNode {
+		return decisions.Decide(node, s.nodeConn, s.allNodesId)
+	}
+	par := newParallelFlow(s.orgId, simulationCaseId, s.allNodesId, s.nodeConn, decide, caseRows, s.store)
+	plan, res, err := planStageUpdate(&req, par, unitStages, s.allNodesId, s.nodeConn, s.dispatchNode, decisions, s.logger)
+	step.Decisions = decisions.Decisions
+	if res.Status != "" || err != nil {
+		step.Error = res.Desc
+		if step.Error == "" && err != nil {
+			step.Error = err.Error()
+		}
+		return
+	}
+	step.Step = plan.Step
+	if res, err := plan.Apply(par, s.store, req); err != nil {
+		step.Error = res.Desc
+		return
+	}
+	if plan.Step == "" {
+		return
+	}
+	s.status = req.Status
+	if plan.Step == stageStepClose {
+		s.closed = true
+	}
+	step.Notifications = append(step.Notifications, s.notification(req.Status, "0"))
+}
+
+// slaTimeout moves the clock to the earliest SLA deadline of the case and
+// alerts the way checkSlaStages does.
+func (s *workflowSimulation) slaTimeout(step *model.WorkflowSimulationStep) {
+	deadlines := s.deadlines()
+	if len(deadlines) == 0 {
+		step.Error = "no SLA runs on the case stage"
+		return
+	}
+	first := deadlines[0]
+	for _, d := range deadlines[1:] {
+		if d.Deadline.Before(first.Deadline) {
+			first = d
+		}
+	}
+	if first.Deadline.After(s.store.now) {
+		s.store.now = first.Deadline
+	}
+	s.overSla++
+	delay := s.overSla
+	if delay > 2 {
+		delay = 2
+	}
+	step.Notifications = append(step.Notifications, s.notification(RecheckSLA(s.status), strconv.Itoa(delay)))
+}
+
+// notification is the alert GenerateNotiAndComment would send.
+func (s *workflowSimulation) notification(status string, delay string) model.WorkflowSimulationNotification {
+	n := model.WorkflowSimulationNotification{
+		Event:      "CASE-STATUS-UPDATE",
+		Status:     status,
+		Delay:      delay,
+		Recipients: []model.Recipient{{Type: "provId", Value: ToString(s.caseData["provId"])}},
+	}
+	name, ok := s.statuses[status]
+	if !ok || name.Th == nil {
+		n.Message = fmt.Sprintf("status %s has no name; no notification is sent", status)
+		return n
+	}
+	n.Message = *name.Th + " :: " + simulationCaseId
+	return n
+}
+
+// deadlines returns the SLA deadline of every case stage whose next node has
+// an SLA. Stages still resting on a fork have none.
+func (s *workflowSimulation) deadlines() []model.WorkflowSimulationDeadline {
+	caseRows, _ := s.store.CurrentStages("")
+	par := newParallelFlow(s.orgId, simulationCaseId, s.allNodesId, s.nodeConn, nil, caseRows, nil)
+	out := []model.WorkflowSimulationDeadline{}
+	if s.closed {
+		return out
+	}
+	for _, st := range s.store.Stages() {
+		if st.StageType != "case" {
+			continue
+		}
+		branchId, _ := caseBranchId(st.UnitId)
+		if branchId == "" && st.UnitId == "" && len(par.Open()) > 0 {
+			continue
+		}
+		next := par.StageNext(par.stages[branchId], branchId)
+		sla, err := strconv.Atoi(strings.TrimSpace(ToString(nodeConfig(next)["sla"])))
+		if err != nil || sla <= 0 {
+			continue
+		}
+		out = append(out, model.WorkflowSimulationDeadline{
+			UnitId:     st.UnitId,
+			NodeId:     st.NodeId,
+			NextNodeId: next.NodeId,
+			SlaMinutes: sla,
+			Deadline:   st.UpdatedAt.Add(time.Duration(sla) * time.Minute),
+		})
+	}
+	return out
+}
+
+// @summary Simulate Workflow
+// @description Runs a workflow version against scripted events (unit status updates, SLA timeouts) without creating a case. Returns the trace: nodes visited, SLA deadlines, notifications that would be sent and forms required.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Simulate Workflow
+// @accept json
+// @produce json
+// @param Body body model.WorkflowSimulateRequest true "Simulation"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/simulate [post]
+func WorkflowSimulate(c *gin.Context) {
+	conn, _, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.WorkflowSimulateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+
+	report, err := SimulateWorkflow(c, conn, orgId.(string), req)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, req.WfID, "WorkFlow", "WorkflowSimulate", "",
+			"view", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   fmt.Sprintf("%d events, %d warnings", len(report.Steps), len(report.Warnings)),
+		Data:   report,
+	})
+}
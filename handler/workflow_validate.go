@@ -0,0 +1,473 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"mainPackage/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Workflow validation error codes.
+const (
+	WfErrNodeIdEmpty       = "NODE_ID_EMPTY"
+	WfErrNodeDuplicate     = "NODE_DUPLICATE"
+	WfErrStartMissing      = "START_MISSING"
+	WfErrStartDuplicate    = "START_DUPLICATE"
+	WfErrEndMissing        = "END_MISSING"
+	WfErrEndUnreachable    = "END_UNREACHABLE"
+	WfErrSourceUnknown     = "CONNECTION_SOURCE_UNKNOWN"
+	WfErrTargetUnknown     = "CONNECTION_TARGET_UNKNOWN"
+	WfErrUnreachable       = "NODE_UNREACHABLE"
+	WfErrNoPathToEnd       = "NO_PATH_TO_END"
+	WfErrSlaYesMissing     = "SLA_YES_MISSING"
+	WfErrSlaNoMissing      = "SLA_NO_MISSING"
+	WfErrSlaLabelInvalid   = "SLA_LABEL_INVALID"
+	WfErrDecisionBranches  = "DECISION_BRANCH_MISSING"
+	WfErrDecisionCondition = "DECISION_CONDITION_INVALID"
+	WfErrDecisionDefault   = "DECISION_DEFAULT_DUPLICATE"
+	WfErrForkBranches      = "FORK_BRANCH_MISSING"
+	WfErrForkJoin          = "FORK_JOIN_MISSING"
+	WfErrJoinBranches      = "JOIN_BRANCH_MISSING"
+	WfErrJoinRequired      = "JOIN_REQUIRED_INVALID"
+	WfErrFormRequired      = "FORM_REQUIRED"
+	WfErrFormNotFound      = "FORM_NOT_FOUND"
+	WfErrFormNotPublished  = "FORM_NOT_PUBLISHED"
+	WfErrPicNotFound       = "PIC_NOT_FOUND"
+	WfErrGroupNotFound     = "GROUP_NOT_FOUND"
+	workflowValidationDesc = "Workflow validation failed"
+)
+
+// ValidateWorkflowGraph checks the shape of a workflow: one start node, every
+// node reachable from it and able to reach an end, connections between known
+// nodes, sla nodes with both a "yes" and a "no" branch, decision branches with
+// valid conditions, forks whose branches meet again at a join and a form on
+// every dispatch node. A case finishes on a node without outgoing connections, so
+// such nodes are the ends of the graph. It does not touch the database.
+func ValidateWorkflowGraph(wf model.WorkFlowInsert) []model.WorkflowValidationError {
+	errs := []model.WorkflowValidationError{}
+	add := func(e model.WorkflowValidationError) { errs = append(errs, e) }
+
+	nodes := map[string]model.WorkFlowNode{}
+	start := ""
+	for _, n := range wf.Nodes {
+		if n.Id == "" {
+			add(model.WorkflowValidationError{Code: WfErrNodeIdEmpty, Message: fmt.Sprintf("a %s node has no id", n.Type)})
+			continue
+		}
+		if _, ok := nodes[n.Id]; ok {
+			add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrNodeDuplicate, Message: "node id is used more than once"})
+			continue
+		}
+		nodes[n.Id] = n
+		switch n.Type {
+		case "start":
+			if start != "" {
+				add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrStartDuplicate, Message: "workflow has more than one start node"})
+			} else {
+				start = n.Id
+			}
+		case "dispatch":
+			if len(workflowNodeRefs(n, "formId")) == 0 {
+				add(model.WorkflowValidationError{NodeId: n.Id, Field: "formId", Code: WfErrFormRequired, Message: "dispatch node has no form"})
+			}
+		}
+	}
+	if start == "" {
+		add(model.WorkflowValidationError{Code: WfErrStartMissing, Message: "workflow has no start node"})
+	}
+
+	next := map[string][]string{}
+	prev := map[string][]string{}
+	labels := map[string]map[string]bool{}
+	defaults := map[string]int{}
+	for _, conn := range wf.Connections {
+		_, okSource := nodes[conn.Source]
+		_, okTarget := nodes[conn.Target]
+		if !okSource {
+			add(model.WorkflowValidationError{ConnectionId: conn.Id, Field: "source", Code: WfErrSourceUnknown, Message: fmt.Sprintf("connection starts at unknown node %q", conn.Source)})
+		}
+		if !okTarget {
+			add(model.WorkflowValidationError{ConnectionId: conn.Id, Field: "target", Code: WfErrTargetUnknown, Message: fmt.Sprintf("connection ends at unknown node %q", conn.Target)})
+		}
+		if !okSource || !okTarget {
+			continue
+		}
+		next[conn.Source] = append(next[conn.Source], conn.Target)
+		prev[conn.Target] = append(prev[conn.Target], conn.Source)
+		if nodes[conn.Source].Type == "sla" {
+			label := strings.ToLower(strings.TrimSpace(conn.Label))
+			if label != "yes" && label != "no" {
+				add(model.WorkflowValidationError{NodeId: conn.Source, ConnectionId: conn.Id, Field: "label", Code: WfErrSlaLabelInvalid, Message: "sla branch must be labelled yes or no"})
+				continue
+			}
+			if labels[conn.Source] == nil {
+				labels[conn.Source] = map[string]bool{}
+			}
+			labels[conn.Source][label] = true
+		}
+		if nodes[conn.Source].Type == WorkflowDecisionNode {
+			if isDefaultBranch(conn) {
+				defaults[conn.Source]++
+				if defaults[conn.Source] > 1 {
+					add(model.WorkflowValidationError{NodeId: conn.Source, ConnectionId: conn.Id, Field: "condition", Code: WfErrDecisionDefault, Message: "decision has more than one default branch"})
+				}
+			} else if _, err := utils.ParseExpression(conn.Condition); err != nil {
+				add(model.WorkflowValidationError{NodeId: conn.Source, ConnectionId: conn.Id, Field: "condition", Code: WfErrDecisionCondition, Message: err.Error()})
+			}
+		}
+	}
+
+	types := map[string]string{}
+	for id, n := range nodes {
+		types[id] = n.Type
+	}
+	for _, n := range wf.Nodes {
+		if n.Id == "" {
+			continue
+		}
+		switch n.Type {
+		case WorkflowForkNode:
+			if len(next[n.Id]) < 2 {
+				add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrForkBranches, Message: "fork node needs at least two branches"})
+			}
+			for _, conn := range wf.Connections {
+				if conn.Source != n.Id {
+					continue
+				}
+				if _, joins := walkBranch(conn.Target, next, types); len(joins) == 0 {
+					add(model.WorkflowValidationError{NodeId: n.Id, ConnectionId: conn.Id, Code: WfErrForkJoin, Message: "fork branch never reaches a join"})
+				}
+			}
+		case WorkflowJoinNode:
+			if len(prev[n.Id]) < 2 {
+				add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrJoinBranches, Message: "join node needs at least two incoming branches"})
+			}
+			if n.Data != nil && n.Data.Config != nil {
+				if v, ok := (*n.Data.Config)["required"]; ok && v != nil && v != "" {
+					if required := ToInt(v); required < 1 || required > len(prev[n.Id]) {
+						add(model.WorkflowValidationError{NodeId: n.Id, Field: "required", Code: WfErrJoinRequired, Message: fmt.Sprintf("join requires %v of %d incoming branches", v, len(prev[n.Id]))})
+					}
+				}
+			}
+		}
+	}
+
+	for _, n := range wf.Nodes {
+		if n.Type == WorkflowDecisionNode && n.Id != "" && len(next[n.Id]) == 0 {
+			add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrDecisionBranches, Message: "decision node has no branch"})
+		}
+		if n.Type != "sla" || n.Id == "" {
+			continue
+		}
+		if !labels[n.Id]["yes"] {
+			add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrSlaYesMissing, Message: "sla node has no yes branch"})
+		}
+		if !labels[n.Id]["no"] {
+			add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrSlaNoMissing, Message: "sla node has no no branch"})
+		}
+	}
+
+	ends := []string{}
+	for _, n := range wf.Nodes {
+		if _, ok := nodes[n.Id]; ok && len(next[n.Id]) == 0 && n.Type != "start" {
+			ends = append(ends, n.Id)
+		}
+	}
+	if len(nodes) > 0 && len(ends) == 0 {
+		add(model.WorkflowValidationError{Code: WfErrEndMissing, Message: "every node has an outgoing connection, so no case can finish"})
+	}
+
+	if start == "" {
+		return errs
+	}
+	reached := walkWorkflow([]string{start}, next)
+	leadsToEnd := walkWorkflow(ends, prev)
+
+	endReached := false
+	for _, id := range ends {
+		if reached[id] {
+			endReached = true
+		}
+	}
+	if len(ends) > 0 && !endReached {
+		add(model.WorkflowValidationError{NodeId: start, Code: WfErrEndUnreachable, Message: "no end node can be reached from start"})
+	}
+
+	for _, n := range wf.Nodes {
+		if n.Id == "" || n.Id == start && !endReached {
+			continue
+		}
+		if !reached[n.Id] {
+			add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrUnreachable, Message: "node cannot be reached from start"})
+		} else if len(ends) > 0 && !leadsToEnd[n.Id] {
+			add(model.WorkflowValidationError{NodeId: n.Id, Code: WfErrNoPathToEnd, Message: "no end node can be reached from this node"})
+		}
+	}
+	return errs
+}
+
+func walkWorkflow(from []string, edges map[string][]string) map[string]bool {
+	seen := map[string]bool{}
+	stack := append([]string(nil), from...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, edges[id]...)
+	}
+	return seen
+}
+
+// workflowNodeRefs returns the non-empty values of a config key, which the
+// designer sends either as a string or as a list of strings.
+func workflowNodeRefs(n model.WorkFlowNode, key string) []string {
+	if n.Data == nil || n.Data.Config == nil {
+		return nil
+	}
+	var refs []string
+	switch v := (*n.Data.Config)[key].(type) {
+	case string:
+		if strings.TrimSpace(v) != "" {
+			refs = append(refs, strings.TrimSpace(v))
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+				refs = append(refs, strings.TrimSpace(s))
+			}
+		}
+	}
+	return refs
+}
+
+// validateWorkflowRefs checks that the forms, pic users and groups named on
+// the nodes exist in the organization. Forms must be published; a group must
+// have at least one active member.
+func validateWorkflowRefs(ctx context.Context, conn repository.DBTX, orgId string, wf model.WorkFlowInsert) ([]model.WorkflowValidationError, error) {
+	refs := map[string]map[string][]string{"formId": {}, "pic": {}, "group": {}}
+	for _, n := range wf.Nodes {
+		for key := range refs {
+			for _, ref := range workflowNodeRefs(n, key) {
+				refs[key][ref] = append(refs[key][ref], n.Id)
+			}
+		}
+	}
+
+	published := map[string]bool{}
+	if ids := sortedKeys(refs["formId"]); len(ids) > 0 {
+		rows, err := conn.Query(ctx, `
+		SELECT "formId"::text, publish
+		FROM public.form_builder
+		WHERE "orgId"::text = $1 AND "formId"::text = ANY($2)`, orgId, ids)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			var publish bool
+			if err := rows.Scan(&id, &publish); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			published[id] = publish
+		}
+		rows.Close()
+	}
+
+	users, err := existingWorkflowRefs(ctx, conn, `
+		SELECT "username"
+		FROM public.um_users
+		WHERE "orgId"::text = $1 AND "username" = ANY($2) AND "active" = true`, orgId, sortedKeys(refs["pic"]))
+	if err != nil {
+		return nil, err
+	}
+	groups, err := existingWorkflowRefs(ctx, conn, `
+		SELECT DISTINCT ug."grpId"::text
+		FROM public.um_user_with_groups ug
+		JOIN public.um_users u ON u."username" = ug."username"
+		WHERE u."orgId"::text = $1 AND ug."grpId"::text = ANY($2) AND u."active" = true`, orgId, sortedKeys(refs["group"]))
+	if err != nil {
+		return nil, err
+	}
+
+	errs := []model.WorkflowValidationError{}
+	for _, id := range sortedKeys(refs["formId"]) {
+		publish, found := published[id]
+		for _, nodeId := range refs["formId"][id] {
+			switch {
+			case !found:
+				errs = append(errs, model.WorkflowValidationError{NodeId: nodeId, Field: "formId", Code: WfErrFormNotFound, Message: fmt.Sprintf("form %s does not exist", id)})
+			case !publish:
+				errs = append(errs, model.WorkflowValidationError{NodeId: nodeId, Field: "formId", Code: WfErrFormNotPublished, Message: fmt.Sprintf("form %s is not published", id)})
+			}
+		}
+	}
+	for _, name := range sortedKeys(refs["pic"]) {
+		if users[name] {
+			continue
+		}
+		for _, nodeId := range refs["pic"][name] {
+			errs = append(errs, model.WorkflowValidationError{NodeId: nodeId, Field: "pic", Code: WfErrPicNotFound, Message: fmt.Sprintf("user %s does not exist or is inactive", name)})
+		}
+	}
+	for _, grp := range sortedKeys(refs["group"]) {
+		if groups[grp] {
+			continue
+		}
+		for _, nodeId := range refs["group"][grp] {
+			errs = append(errs, model.WorkflowValidationError{NodeId: nodeId, Field: "group", Code: WfErrGroupNotFound, Message: fmt.Sprintf("group %s has no active member", grp)})
+		}
+	}
+	return errs, nil
+}
+
+func existingWorkflowRefs(ctx context.Context, conn repository.DBTX, query string, orgId string, values []string) (map[string]bool, error) {
+	found := map[string]bool{}
+	if len(values) == 0 {
+		return found, nil
+	}
+	rows, err := conn.Query(ctx, query, orgId, values)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		found[v] = true
+	}
+	return found, rows.Err()
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateWorkflow runs the graph checks and, when the graph is sound, the
+// reference checks against the database.
+func ValidateWorkflow(ctx context.Context, conn repository.DBTX, orgId string, wf model.WorkFlowInsert) ([]model.WorkflowValidationError, error) {
+	errs := ValidateWorkflowGraph(wf)
+	refErrs, err := validateWorkflowRefs(ctx, conn, orgId, wf)
+	if err != nil {
+		return nil, err
+	}
+	return append(errs, refErrs...), nil
+}
+
+// @summary Validate Workflow
+// @description Checks a workflow graph without saving it. Errors are listed per node or connection in data.
+// @tags Form and Workflow
+// @security ApiKeyAuth
+// @id Validate Workflow
+// @accept json
+// @produce json
+// @param Body body model.WorkFlowInsert true "Workflow"
+// @response 200 {object} model.Response "OK - Request successful"
+// @Router /api/v1/workflows/validate [post]
+func WorkFlowValidate(c *gin.Context) {
+	conn, ctx, cancel := utils.ConnectDB()
+	if conn == nil {
+		return
+	}
+	defer cancel()
+
+	start_time := time.Now()
+	txtId := uuid.New().String()
+	username := GetVariableFromToken(c, "username")
+	orgId := GetVariableFromToken(c, "orgId")
+
+	var req model.WorkFlowInsert
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		})
+		return
+	}
+
+	errs, err := ValidateWorkflow(ctx, conn, orgId.(string), req)
+	if err != nil {
+		response := model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   err.Error(),
+		}
+		//=======AUDIT_START=====//
+		_ = utils.InsertAuditLogs(
+			c, conn, orgId.(string), username.(string),
+			txtId, "", "WorkFlow", "WorkFlowValidate", "",
+			"view", -1, start_time, GetQueryParams(c), response, "Failed : "+err.Error(),
+		)
+		//=======AUDIT_END=====//
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusOK, model.Response{
+			Status: "-1",
+			Msg:    "Failure",
+			Desc:   workflowValidationDesc,
+			Data:   errs,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, model.Response{
+		Status: "0",
+		Msg:    "Success",
+		Desc:   "Workflow is valid",
+		Data:   errs,
+	})
+}
+
+// rejectInvalidWorkflow validates a workflow before it is saved. It writes the
+// failure response and returns true when the workflow must not be saved.
+func rejectInvalidWorkflow(c *gin.Context, ctx context.Context, conn *pgx.Conn, req model.WorkFlowInsert,
+	orgId string, username string, txtId string, id string, subFunc string, action string, start_time time.Time) bool {
+	errs, err := ValidateWorkflow(ctx, conn, orgId, req)
+	if err == nil && len(errs) == 0 {
+		return false
+	}
+	status := http.StatusBadRequest
+	response := model.Response{
+		Status: "-1",
+		Msg:    "Failure",
+		Desc:   workflowValidationDesc,
+		Data:   errs,
+	}
+	msg := fmt.Sprintf("Failed : %s (%d errors)", workflowValidationDesc, len(errs))
+	if err != nil {
+		status = http.StatusInternalServerError
+		response.Desc = err.Error()
+		response.Data = nil
+		msg = "Failed : " + err.Error()
+	}
+	//=======AUDIT_START=====//
+	_ = utils.InsertAuditLogs(
+		c, conn, orgId, username,
+		txtId, id, "WorkFlow", subFunc, "",
+		action, -1, start_time, GetQueryParams(c), response, msg,
+	)
+	//=======AUDIT_END=====//
+	c.JSON(status, response)
+	return true
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mainPackage/model"
+	"mainPackage/repository"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Workflow versions. The designer always edits the "draft" rows of wf_nodes.
+// Every save also freezes the draft into the next numbered version (1, 2, ...)
+// unless it equals the latest one, and a case is pinned to the latest numbered
+// version when it starts. Numbered versions are never rewritten, so editing a
+// workflow does not change the nodes of cases in progress.
+const workflowDraftVersion = "draft"
+
+// LatestWorkflowVersion returns the highest numbered version of a workflow,
+// or "" when none was frozen yet.
+func LatestWorkflowVersion(ctx context.Context, db repository.DBTX, orgId string, wfId string) (string, error) {
+	var version string
+	err := db.QueryRow(ctx, `
+		SELECT "versions"
+		FROM public.wf_nodes
+		WHERE "orgId" = $1 AND "wfId" = $2 AND "versions" ~ '^[0-9]+$'
+		ORDER BY CAST("versions" AS INTEGER) DESC
+		LIMIT 1`, orgId, wfId).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return version, err
+}
+
+// workflowChecksum digests the nodes and connections of one workflow version.
+func workflowChecksum(ctx context.Context, db repository.DBTX, orgId string, wfId string, version string) (string, error) {
+	var sum *string
+	err := db.QueryRow(ctx, `
+		SELECT md5(string_agg("section" || ':' || "nodeId" || ':' || COALESCE("data"::text, ''), '|' ORDER BY "section", "nodeId"))
+		FROM public.wf_nodes
+		WHERE "orgId" = $1 AND "wfId" = $2 AND "versions" = $3`, orgId, wfId, version).Scan(&sum)
+	if err != nil || sum == nil {
+		return "", err
+	}
+	return *sum, nil
+}
+
+// SnapshotWorkflowVersion freezes the draft of a workflow into a new numbered
+// version and returns it. When the draft equals the latest numbered version
+// that version is returned instead. Snapshots of one workflow are serialized
+// so replicas never number two versions alike. Called inside a transaction
+// of the caller, the snapshot joins it and keeps the lock until it ends.
+func SnapshotWorkflowVersion(ctx context.Context, conn *pgx.Conn, orgId string, wfId string, username string) (string, error) {
+	if conn.PgConn().TxStatus() != 'I' {
+		return snapshotWorkflowVersion(ctx, conn, orgId, wfId, username)
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+	version, err := snapshotWorkflowVersion(ctx, tx, orgId, wfId, username)
+	if err != nil {
+		return "", err
+	}
+	return version, tx.Commit(ctx)
+}
+
+func snapshotWorkflowVersion(ctx context.Context, tx repository.DBTX, orgId string, wfId string, username string) (string, error) {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "wf_version:"+wfId); err != nil {
+		return "", err
+	}
+
+	draft, err := workflowChecksum(ctx, tx, orgId, wfId, workflowDraftVersion)
+	if err != nil {
+		return "", err
+	}
+	latest, err := LatestWorkflowVersion(ctx, tx, orgId, wfId)
+	if err != nil {
+		return "", err
+	}
+	if draft == "" {
+		if latest == "" {
+			return "", fmt.Errorf("workflow %s has no nodes", wfId)
+		}
+		return latest, nil
+	}
+	if latest != "" {
+		sum, err := workflowChecksum(ctx, tx, orgId, wfId, latest)
+		if err != nil {
+			return "", err
+		}
+		if sum == draft {
+			return latest, nil
+		}
+	}
+	n, _ := strconv.Atoi(latest)
+	version := strconv.Itoa(n + 1)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO public.wf_nodes(
+			"orgId", "wfId", "nodeId", versions, type, section, data, pic, "group", "formId",
+			"createdAt", "updatedAt", "createdBy", "updatedBy")
+		SELECT "orgId", "wfId", "nodeId", $4, type, section, data, pic, "group", "formId",
+			NOW(), NOW(), $5, $5
+		FROM public.wf_nodes
+		WHERE "orgId" = $1 AND "wfId" = $2 AND "versions" = $3`,
+		orgId, wfId, workflowDraftVersion, version, username)
+	if err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// pinCaseWorkflow pins a starting case to the current workflow version and
+// returns it.
+func pinCaseWorkflow(ctx context.Context, conn *pgx.Conn, orgId string, caseId string, wfId string, username string) (string, error) {
+	version, err := SnapshotWorkflowVersion(ctx, conn, orgId, wfId, username)
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Exec(ctx, `
+		UPDATE public.tix_cases SET "versions" = $3
+		WHERE "orgId" = $1 AND "caseId" = $2`, orgId, caseId, version)
+	if err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// pinnedNode loads a node of the workflow version a case is pinned to. Cases
+// started before versions were pinned fall back to the current version.
+func pinnedNode(ctx context.Context, stages *repository.StageRepository, nodeId string, wfId string, version string) (*model.WfNode, error) {
+	node, err := stages.GetNode(ctx, nodeId, wfId, version)
+	if errors.Is(err, pgx.ErrNoRows) && version != "" {
+		return stages.GetNode(ctx, nodeId, wfId, "")
+	}
+	return node, err
+}
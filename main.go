@@ -17,12 +17,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	_ "mainPackage/docs"
 	"mainPackage/handler"
 	"mainPackage/utils"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -67,99 +71,56 @@ func main() {
 		Limit:  int64(rateLimitInt),
 	}
 
-	go handler.StartAutoDeleteScheduler()
 	utils.InitRedis()
+	if err := utils.InitDB(); err != nil {
+		log.Printf("Database pool error: %v", err)
+	}
 	utils.InitMinio()
+	handler.InitEsbBus()
+	if err := handler.InitNotifier(); err != nil {
+		log.Fatalf("Notifier: %v", err)
+	}
 
-	go func() {
-		if err := handler.ESB_WORK_ORDER_CREATE(); err != nil {
-			log.Printf("Kafka ESB_WORK_ORDER_CREATE error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_WORK_ORDER_UPDATE(); err != nil {
-			log.Printf("Kafka ESB_WORK_ORDER_UPDATE error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_STATUS(); err != nil {
-			log.Printf("Kafka ESB_USER_STATUS error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_CREATE("USER"); err != nil {
-			log.Printf("Kafka ESB_USER_CREATE - USER error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_UPDATE("USER"); err != nil {
-			log.Printf("Kafka ESB_USER_UPDATE - USER error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_DELETE("USER"); err != nil {
-			log.Printf("Kafka ESB_USER_STAFF_DELETE error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_CREATE("ADMIN"); err != nil {
-			log.Printf("Kafka ESB_USER_CREATE - ADMIN error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_UPDATE("ADMIN"); err != nil {
-			log.Printf("Kafka ESB_USER_UPDATE - ADMIN error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_USER_DELETE("ADMIN"); err != nil {
-			log.Printf("Kafka ESB_USER_DELETE - ADMIN error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ESB_NOTIFICATIONS(); err != nil {
-			log.Printf("Kafka ESB_NOTIFICATIONS error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.SlaMonitor(&gin.Context{}); err != nil {
-			log.Printf("SlaMonitor error: %v", err)
-		}
-	}()
-
-	// go func() {
-	// 	if err := handler.ScheduleMonitor(&gin.Context{}); err != nil {
-	// 		log.Printf("ScheduleMonitor error: %v", err)
-	// 	}
-	// }()
-
-	go func() {
-		if err := handler.SummaryReport(&gin.Context{}); err != nil {
-			log.Printf("SummaryReport error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.CaseHistory(&gin.Context{}); err != nil {
-			log.Printf("CaseHistory error: %v", err)
-		}
-	}()
-
-	go func() {
-		if err := handler.ReSyncCase(&gin.Context{}); err != nil {
-			log.Printf("SummaryReport error: %v", err)
-		}
-	}()
+	workers := handler.Workers
+	workers.Go("AutoDeleteScheduler", handler.StartAutoDeleteScheduler)
+	workers.Go("ESB_WORK_ORDER_CREATE", handler.ESB_WORK_ORDER_CREATE)
+	workers.Go("ESB_WORK_ORDER_UPDATE", handler.ESB_WORK_ORDER_UPDATE)
+	workers.Go("ESB_USER_STATUS", handler.ESB_USER_STATUS)
+	workers.Go("ESB_USER_CREATE - USER", func(ctx context.Context) error {
+		return handler.ESB_USER_CREATE(ctx, "USER")
+	})
+	workers.Go("ESB_USER_UPDATE - USER", func(ctx context.Context) error {
+		return handler.ESB_USER_UPDATE(ctx, "USER")
+	})
+	workers.Go("ESB_USER_DELETE - USER", func(ctx context.Context) error {
+		return handler.ESB_USER_DELETE(ctx, "USER")
+	})
+	workers.Go("ESB_USER_CREATE - ADMIN", func(ctx context.Context) error {
+		return handler.ESB_USER_CREATE(ctx, "ADMIN")
+	})
+	workers.Go("ESB_USER_UPDATE - ADMIN", func(ctx context.Context) error {
+		return handler.ESB_USER_UPDATE(ctx, "ADMIN")
+	})
+	workers.Go("ESB_USER_DELETE - ADMIN", func(ctx context.Context) error {
+		return handler.ESB_USER_DELETE(ctx, "ADMIN")
+	})
+	workers.Go("ESB_NOTIFICATIONS", handler.ESB_NOTIFICATIONS)
+	workers.Go("SlaMonitor", func(ctx context.Context) error {
+		return handler.SlaMonitor(ctx, &gin.Context{})
+	})
+	workers.Go("AutoDispatchMonitor", handler.AutoDispatchMonitor)
+	workers.Go("ScheduleMonitor", func(ctx context.Context) error {
+		return handler.ScheduleMonitor(ctx, &gin.Context{})
+	})
+	workers.Go("SummaryReport", func(ctx context.Context) error {
+		return handler.SummaryReport(ctx, &gin.Context{})
+	})
+	workers.Go("CaseHistory", func(ctx context.Context) error {
+		return handler.CaseHistory(ctx, &gin.Context{})
+	})
+	workers.Go("ReSyncCase", func(ctx context.Context) error {
+		return handler.ReSyncCase(ctx, &gin.Context{})
+	})
 
 	store := memory.NewStore()
 	instance := limiter.New(store, rate)
@@ -199,14 +160,19 @@ func main() {
 		auth.POST("/add", handler.UserAddAuth)
 		auth.POST("/refresh", handler.RefreshToken)
 		auth.POST("/verify", handler.VerifyTokenHandler)
+		auth.POST("/mfa/verify", handler.VerifyMfaLogin)
+		auth.POST("/mfa/enroll", handler.EnrollMfaLogin)
 	}
 	v1 := router.Group("/api/v1")
 	{
 		v1.Use(handler.ProtectedHandler)
+		v1.Use(handler.PermissionHandler)
 		v1.GET("/area/country_province_districts", handler.GetCountryProvinceDistricts)
 
 		v1.GET("/forms", handler.GetForm)
 		v1.GET("/forms/:formId", handler.GetFormById)
+		v1.GET("/forms/:formId/diff", handler.GetFormVersionDiff)
+		v1.GET("/forms/:formId/lineage", handler.GetFormLineage)
 		v1.DELETE("/forms/:formId", handler.DeleteForm)
 		v1.GET("/forms/GetFormlinkWf", handler.GetFormlinkWf)
 		v1.GET("/forms/getAllFormslinkWf", handler.GetAllFormlinkWf)
@@ -218,20 +184,38 @@ func main() {
 		v1.PATCH("/forms/publish", handler.FormPublish)
 		v1.PATCH("/forms/version", handler.FormChangeVersion)
 		v1.POST("/forms/casesubtype", handler.GetFormByCaseSubType)
+		v1.POST("/forms/validate", handler.FormAnswerValidate)
+		v1.POST("/forms/answers/reindex", handler.FormAnswerReindex)
 		v1.GET("/workflows", handler.GetWorkFlowList)
 		v1.GET("/workflows/:id", handler.GetWorkFlow)
+		v1.GET("/workflows/:id/versions", handler.GetWorkflowVersions)
+		v1.GET("/workflows/:id/export", handler.WorkflowExport)
+		v1.GET("/workflows/:id/diff", handler.GetWorkflowVersionDiff)
 		v1.POST("/workflows", handler.WorkFlowInsert)
+		v1.POST("/workflows/validate", handler.WorkFlowValidate)
+		v1.POST("/workflows/simulate", handler.WorkflowSimulate)
+		v1.POST("/workflows/import", handler.WorkflowImport)
+		v1.POST("/workflows/migrate", handler.WorkflowMigrate)
+		v1.POST("/workflows/migrate/rollback", handler.WorkflowMigrateRollback)
 		v1.PATCH("/workflows/:uuid", handler.WorkFlowUpdate)
 		v1.DELETE("/workflows/:uuid", handler.WorkflowDelete)
 
 		v1.GET("/case", handler.ListCase)
 		v1.GET("/case/:id", handler.CaseById)
 		v1.GET("/case/caseId/:caseId", handler.CaseByCaseId)
+		v1.POST("/case/answers/query", handler.FormAnswerQuery)
 		v1.POST("/case/add", handler.InsertCase)
 		v1.PATCH("/case/:id", handler.UpdateCase)
 		v1.DELETE("/case/:id", handler.DeleteCase)
 		v1.GET("/case/result/", handler.CaseResult)
 
+		v1.GET("/case_schedules", handler.ListCaseSchedules)
+		v1.GET("/case_schedules/:id", handler.GetCaseSchedule)
+		v1.GET("/case_schedules/:id/runs", handler.GetCaseScheduleRuns)
+		v1.POST("/case_schedules/add", handler.InsertCaseSchedule)
+		v1.PATCH("/case_schedules/:id", handler.UpdateCaseSchedule)
+		v1.DELETE("/case_schedules/:id", handler.DeleteCaseSchedule)
+
 		v1.GET("/case_status", handler.GetCaseStatus)
 		v1.GET("/case_status/:id", handler.GetCaseStatusById)
 		v1.POST("/case_status/add", handler.InsertCaseStatus)
@@ -316,6 +300,13 @@ func main() {
 		v1.GET("/users/username/:username", handler.GetUmUserByUsername)
 		v1.GET("/users/username/ForCaseInfo/:username", handler.GetUserByUsernameForCaseInfo)
 		v1.PATCH("/users/username/:username", handler.UserUpdateByUsername)
+		v1.GET("/users/username/:username/sessions", handler.GetUserSessions)
+		v1.DELETE("/users/username/:username/sessions", handler.KickUserSessions)
+		v1.DELETE("/users/username/:username/sessions/:sessionId", handler.KickUserSession)
+		v1.DELETE("/users/username/:username/mfa", handler.ResetUserMfa)
+		v1.GET("/login_locks", handler.GetLoginLocks)
+		v1.DELETE("/login_locks/users/:username", handler.UnlockLoginUser)
+		v1.DELETE("/login_locks/ips/:ip", handler.UnlockLoginIP)
 		v1.GET("/users_with_skills", handler.GetUserWithSkills)
 		v1.GET("/users_with_skills/:id", handler.GetUserWithSkillsById)
 		v1.GET("/users_with_skills/skillId/:skillId", handler.GetUserWithSkillsBySkillId)
@@ -390,6 +381,13 @@ func main() {
 		v1.GET("/audit_log", handler.GetAuditlog)
 		v1.GET("/audit_log/:username", handler.GetAuditlogByUsername)
 
+		v1.GET("/esb/dead_letters", handler.GetEsbDeadLetters)
+		v1.GET("/esb/dead_letters/:id", handler.GetEsbDeadLetterById)
+		v1.PATCH("/esb/dead_letters/:id", handler.UpdateEsbDeadLetter)
+		v1.POST("/esb/dead_letters/:id/replay", handler.ReplayEsbDeadLetter)
+
+		v1.GET("/monitors/leaders", handler.GetMonitorLeaders)
+
 		v1.GET("/case_history", handler.GetCaseHistory)
 		v1.GET("/case_history/:caseId", handler.GetCaseHistoryByCaseId)
 		v1.POST("/case_history/add", handler.InsertCaseHistory)
@@ -403,6 +401,12 @@ func main() {
 		v1.DELETE("/delete/", handler.DeleteFile)
 
 		v1.POST("/logout", handler.UserLogout)
+		v1.GET("/sessions", handler.GetMySessions)
+		v1.GET("/mfa", handler.GetMyMfa)
+		v1.POST("/mfa/enroll", handler.EnrollMfa)
+		v1.POST("/mfa/enroll/confirm", handler.ConfirmMfa)
+		v1.POST("/mfa/recovery_codes", handler.RegenerateMfaRecoveryCodes)
+		v1.DELETE("/mfa", handler.DisableMfa)
 
 	}
 
@@ -413,7 +417,8 @@ func main() {
 
 	nonAuth := router.Group("/api/v1")
 	{
-		nonAuth.POST("/users/reset_password", handler.ResetUserPassword)
+		nonAuth.POST("/users/reset_password/request", handler.RequestPasswordReset)
+		nonAuth.POST("/users/reset_password/confirm", handler.ConfirmPasswordReset)
 		nonAuth.GET("/generate_caseid", handler.GenerateCaseIDHandler)
 	}
 	health := router.Group("/")
@@ -442,7 +447,44 @@ func main() {
 	for _, env := range os.Environ() {
 		logger.Info(env)
 	}
-	if err := router.Run(SERV_ADDR + ":" + SERV_PORT); err != nil {
-		logger.Fatal("Failed to start server", zap.Error(err))
+	srv := &http.Server{Addr: SERV_ADDR + ":" + SERV_PORT, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Failed to start server", zap.Error(err))
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	logger.Info("Shutting down", zap.String("signal", sig.String()))
+
+	// Stop taking requests and let the ones in flight finish, then stop the
+	// workers; Kafka consumers commit the message in hand before they return.
+	timeout := time.Duration(shutdownTimeout()) * time.Second
+	httpCtx, cancelHttp := context.WithTimeout(context.Background(), timeout)
+	defer cancelHttp()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		logger.Warn("HTTP shutdown", zap.Error(err))
+	}
+	workerCtx, cancelWorkers := context.WithTimeout(context.Background(), timeout)
+	defer cancelWorkers()
+	if err := workers.Shutdown(workerCtx); err != nil {
+		logger.Warn("Worker shutdown", zap.Error(err))
+	}
+	if handler.EsbBus != nil {
+		if err := handler.EsbBus.Close(); err != nil {
+			logger.Warn("ESB bus close", zap.Error(err))
+		}
+	}
+	utils.CloseDB()
+	logger.Info("Server stopped")
+}
+
+func shutdownTimeout() int {
+	timeout := utils.EnvInt("SHUTDOWN_TIMEOUT", 30)
+	if timeout <= 0 {
+		timeout = 30 // fallback
 	}
+	return timeout
 }
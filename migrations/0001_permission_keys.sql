@@ -0,0 +1,86 @@
+-- Permission keys checked by PermissionHandler (handler/permission_guard.go).
+-- A role holds a key through um_role_with_permissions pointing at the
+-- um_permissions row whose permName is the key. Keep this list in step with
+-- the Perm* constants.
+--
+-- Before the check every signed in user could call every route, so roles are
+-- seeded to keep the work they already do:
+--   * roles whose name contains "admin" get every key;
+--   * every role gets the keys marked everyone: viewing, and the day to day
+--     case, dispatch, history, customer and file work;
+--   * a role that already holds a permission of a group (um_permissions
+--     "groupName", e.g. Workflow or User) gets every key of that group.
+-- Only roles holding none of the keys yet are seeded, so running this again
+-- does not give back keys taken away since. Change grants afterwards with
+-- PATCH /api/v1/role_permission/multi.
+
+BEGIN;
+
+CREATE TEMP TABLE perm_keys ("groupName", "permName", everyone) ON COMMIT DROP AS
+VALUES
+    ('Form', 'form.view', true),
+    ('Form', 'form.manage', false),
+    ('Form', 'form.delete', false),
+    ('Workflow', 'workflow.view', true),
+    ('Workflow', 'workflow.manage', false),
+    ('Workflow', 'workflow.delete', false),
+    ('Workflow', 'workflow.migrate', false),
+    ('Case', 'case.view', true),
+    ('Case', 'case.manage', true),
+    ('Case', 'case.delete', false),
+    ('Master Data', 'master.view', true),
+    ('Master Data', 'master.manage', false),
+    ('Master Data', 'master.delete', false),
+    ('Role', 'role.view', false),
+    ('Role', 'role.manage', false),
+    ('Role', 'role.delete', false),
+    ('User', 'user.view', false),
+    ('User', 'user.manage', false),
+    ('User', 'user.delete', false),
+    ('Customer', 'customer.view', true),
+    ('Customer', 'customer.manage', true),
+    ('Customer', 'customer.delete', false),
+    ('Dispatch', 'dispatch.view', true),
+    ('Dispatch', 'dispatch.manage', true),
+    ('Audit', 'audit.view', false),
+    ('File', 'file.manage', true),
+    ('Case History', 'history.view', true),
+    ('Case History', 'history.manage', true),
+    ('Integration', 'integration.view', false),
+    ('Integration', 'integration.manage', false),
+    ('Monitor', 'monitor.view', false);
+
+INSERT INTO public.um_permissions ("groupName", "permId", "permName", active, "createdAt", "updatedAt", "createdBy", "updatedBy")
+SELECT k."groupName", gen_random_uuid(), k."permName", true, now(), now(), 'migration', 'migration'
+FROM perm_keys k
+WHERE NOT EXISTS (
+    SELECT 1 FROM public.um_permissions p WHERE p."permName" = k."permName"
+);
+
+INSERT INTO public.um_role_with_permissions ("orgId", "roleId", "permId", active, "createdAt", "updatedAt", "createdBy", "updatedBy")
+SELECT r."orgId", r.id, p."permId", true, now(), now(), 'migration', 'migration'
+FROM public.um_roles r
+CROSS JOIN perm_keys k
+JOIN public.um_permissions p ON p."permName" = k."permName"
+WHERE (
+        r."roleName" ILIKE '%admin%'
+     OR k.everyone
+     OR EXISTS (
+            SELECT 1
+            FROM public.um_role_with_permissions rp
+            JOIN public.um_permissions old ON old."permId"::text = rp."permId"::text
+            WHERE rp."orgId" = r."orgId" AND rp."roleId"::text = r.id::text
+              AND rp.active = true
+              AND old."groupName" = k."groupName"
+              AND old."permName" NOT IN (SELECT "permName" FROM perm_keys)
+        )
+    )
+  AND NOT EXISTS (
+      SELECT 1
+      FROM public.um_role_with_permissions rp
+      JOIN public.um_permissions held ON held."permId"::text = rp."permId"::text
+      WHERE rp."orgId" = r."orgId" AND rp."roleId"::text = r.id::text
+        AND held."permName" IN (SELECT "permName" FROM perm_keys)
+  );
+
+COMMIT;
@@ -0,0 +1,27 @@
+-- Failed ESB messages kept for inspection and replay (handler/esb_dead_letter.go).
+-- Offsets only identify a message within its source: "kafka", or one value
+-- per in-memory bus, whose offsets start at 0 again on every start.
+
+CREATE TABLE IF NOT EXISTS public.esb_dead_letters (
+    id          uuid PRIMARY KEY,
+    "orgId"     text        NOT NULL,
+    source      text        NOT NULL DEFAULT 'kafka',
+    topic       text        NOT NULL,
+    "partition" integer     NOT NULL,
+    "offset"    bigint      NOT NULL,
+    "msgKey"    text,
+    payload     text        NOT NULL,
+    error       text        NOT NULL,
+    attempts    integer     NOT NULL DEFAULT 1,
+    status      text        NOT NULL DEFAULT 'FAILED',
+    "createdAt" timestamptz NOT NULL DEFAULT now(),
+    "updatedAt" timestamptz NOT NULL DEFAULT now(),
+    "updatedBy" text,
+    "replayAt"  timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS esb_dead_letters_message_key
+    ON public.esb_dead_letters (source, topic, "partition", "offset");
+
+CREATE INDEX IF NOT EXISTS esb_dead_letters_org_status
+    ON public.esb_dead_letters ("orgId", status, "createdAt" DESC);
@@ -0,0 +1,12 @@
+-- Highest lease fencing token seen per monitor owner key (handler/mon_lease.go,
+-- beginFenced). A fenced write first moves the row up to its token and fails
+-- when a newer one is already there.
+-- SummaryReport writes through the report database, so apply this file there
+-- as well.
+
+CREATE TABLE IF NOT EXISTS public.monitor_fences (
+    "owner"     text PRIMARY KEY,
+    "token"     bigint      NOT NULL,
+    "holder"    text        NOT NULL,
+    "updatedAt" timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,22 @@
+-- Cases moved between workflow versions (handler/workflow_migrate.go), with
+-- their tix_case_current_stage rows before and after the move so a migration
+-- can be rolled back case by case.
+
+CREATE TABLE IF NOT EXISTS public.wf_case_migrations (
+    "migrationId"  text        NOT NULL,
+    "orgId"        text        NOT NULL,
+    "wfId"         text        NOT NULL,
+    "caseId"       text        NOT NULL,
+    "fromVersion"  text        NOT NULL,
+    "toVersion"    text        NOT NULL,
+    "before"       jsonb       NOT NULL,
+    "after"        jsonb       NOT NULL,
+    "createdAt"    timestamptz NOT NULL DEFAULT now(),
+    "createdBy"    text,
+    "rolledBackAt" timestamptz,
+    "rolledBackBy" text,
+    PRIMARY KEY ("orgId", "migrationId", "caseId")
+);
+
+CREATE INDEX IF NOT EXISTS wf_case_migrations_case
+    ON public.wf_case_migrations ("orgId", "caseId", "createdAt" DESC);
@@ -0,0 +1,34 @@
+-- Typed values of the answered form fields of cases, projected from
+-- form_answers (handler/form_answer_values.go) for answer filters and
+-- queries. POST /api/v1/forms/answers/reindex rebuilds it.
+
+CREATE TABLE IF NOT EXISTS public.form_answer_values (
+    id          bigserial PRIMARY KEY,
+    "orgId"     text             NOT NULL,
+    "caseId"    text             NOT NULL,
+    "formId"    text             NOT NULL,
+    versions    text             NOT NULL DEFAULT '',
+    "fieldId"   text             NOT NULL,
+    "fieldUid"  text,
+    "parentId"  text             NOT NULL DEFAULT '',
+    label       text             NOT NULL DEFAULT '',
+    "fieldType" text             NOT NULL DEFAULT '',
+    "valueType" text             NOT NULL,
+    "numValue"  double precision,
+    "dateValue" timestamptz,
+    "textValue" text             NOT NULL DEFAULT '',
+    "createdAt" timestamptz      NOT NULL DEFAULT now(),
+    "updatedAt" timestamptz      NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS form_answer_values_answer
+    ON public.form_answer_values ("orgId", "caseId", "formId");
+
+CREATE INDEX IF NOT EXISTS form_answer_values_field
+    ON public.form_answer_values ("orgId", "fieldId");
+
+CREATE INDEX IF NOT EXISTS form_answer_values_field_uid
+    ON public.form_answer_values ("orgId", "fieldUid");
+
+CREATE INDEX IF NOT EXISTS form_answer_values_label
+    ON public.form_answer_values ("orgId", label);
@@ -0,0 +1,49 @@
+-- Recurring case schedules (handler/case_schedule.go) and the claims
+-- ScheduleMonitor takes with INSERT ... ON CONFLICT DO NOTHING: one row per
+-- schedule occurrence in case_schedule_runs, and one per activation of a
+-- scheduled case and schedule date in tix_case_schedule_runs
+-- (handler/mon_schedule.go).
+-- The primary keys are what make those claims exclusive.
+
+CREATE TABLE IF NOT EXISTS public.case_schedules (
+    id             serial,
+    "orgId"        text        NOT NULL,
+    "scheduleId"   text        NOT NULL,
+    title          text        NOT NULL,
+    rule           text        NOT NULL,
+    "timeZone"     text        NOT NULL DEFAULT '',
+    template       jsonb       NOT NULL,
+    "autoDispatch" boolean     NOT NULL DEFAULT false,
+    active         boolean     NOT NULL DEFAULT true,
+    "startAt"      timestamptz,
+    "endAt"        timestamptz,
+    "nextRunAt"    timestamptz,
+    "lastRunAt"    timestamptz,
+    "lastCaseId"   text,
+    "createdAt"    timestamptz NOT NULL DEFAULT now(),
+    "updatedAt"    timestamptz NOT NULL DEFAULT now(),
+    "createdBy"    text,
+    "updatedBy"    text,
+    PRIMARY KEY ("orgId", "scheduleId")
+);
+
+CREATE INDEX IF NOT EXISTS case_schedules_due
+    ON public.case_schedules ("orgId", "nextRunAt") WHERE active;
+
+CREATE TABLE IF NOT EXISTS public.case_schedule_runs (
+    "orgId"      text        NOT NULL,
+    "scheduleId" text        NOT NULL,
+    "runAt"      timestamptz NOT NULL,
+    "caseId"     text,
+    "createdAt"  timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("orgId", "scheduleId", "runAt")
+);
+
+CREATE TABLE IF NOT EXISTS public.tix_case_schedule_runs (
+    "orgId"        text        NOT NULL,
+    "caseId"       text        NOT NULL,
+    "scheduleDate" timestamptz NOT NULL,
+    "activatedAt"  timestamptz NOT NULL DEFAULT now(),
+    "activatedBy"  text,
+    PRIMARY KEY ("orgId", "caseId", "scheduleDate")
+);
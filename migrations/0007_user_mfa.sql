@@ -0,0 +1,22 @@
+-- Two-factor login (handler/mfa.go). um_user_mfa holds the sealed TOTP
+-- secret of a user, the hashes of the recovery codes still unused and the
+-- last time step accepted, which checkMfaCode only lets move forward so a
+-- code cannot be used twice. A row with enabled = false is an enrollment
+-- waiting for its first code.
+-- um_roles."mfaRequired" makes every user of the role enroll.
+
+CREATE TABLE IF NOT EXISTS public.um_user_mfa (
+    "orgId"         text        NOT NULL,
+    username        text        NOT NULL,
+    secret          text        NOT NULL,
+    enabled         boolean     NOT NULL DEFAULT false,
+    "recoveryCodes" jsonb       NOT NULL DEFAULT '[]'::jsonb,
+    "lastStep"      bigint      NOT NULL DEFAULT 0,
+    "enrolledAt"    timestamptz,
+    "createdAt"     timestamptz NOT NULL DEFAULT now(),
+    "updatedAt"     timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("orgId", username)
+);
+
+ALTER TABLE public.um_roles
+    ADD COLUMN IF NOT EXISTS "mfaRequired" boolean NOT NULL DEFAULT false;
@@ -99,3 +99,32 @@ type TokenIntrospectResponse struct {
 	Sub               string `json:"sub,omitempty"`
 	Error             string `json:"error,omitempty"`
 }
+
+// AuthSession is a login session held in Redis. Every access and refresh token
+// carries its ID as the jti claim; the refresh token also carries RefreshID,
+// which changes on every refresh so that a replayed refresh token can be told
+// apart from the current one.
+type AuthSession struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"orgId"`
+	Username  string    `json:"username"`
+	RefreshID string    `json:"-"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current,omitempty"`
+}
+
+// LoginLock is a temporary block on logins for a username (Kind "user") or a
+// client address (Kind "ip") after too many failed attempts.
+type LoginLock struct {
+	Kind      string    `json:"kind"`
+	OrgID     string    `json:"orgId"`
+	Username  string    `json:"username,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Failures  int64     `json:"failures"`
+	LockedAt  time.Time `json:"lockedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -54,6 +54,7 @@ type Case struct {
 	CurrentStage         interface{}      `json:"currentStage"`
 	NextStage            interface{}      `json:"nextStage"`
 	DispatchStage        interface{}      `json:"dispatchStage"`
+	Branches             interface{}      `json:"branches,omitempty"`
 	ReferCaseLists       []string         `json:"referCaseLists"`
 	UnitLists            interface{}      `json:"unitLists"`
 	FormAnswer           interface{}      `json:"formAnswer"`
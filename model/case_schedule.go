@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+// CaseSchedule creates a new case from Template at every occurrence of Rule, a
+// five field cron rule read in TimeZone.
+type CaseSchedule struct {
+	ID           int         `json:"id"`
+	OrgID        string      `json:"orgId"`
+	ScheduleID   string      `json:"scheduleId"`
+	Title        string      `json:"title"`
+	Rule         string      `json:"rule"`
+	TimeZone     string      `json:"timeZone"`
+	Template     CaseInsert  `json:"template"`
+	AutoDispatch bool        `json:"autoDispatch"`
+	Active       bool        `json:"active"`
+	StartAt      *time.Time  `json:"startAt"`
+	EndAt        *time.Time  `json:"endAt"`
+	NextRunAt    *time.Time  `json:"nextRunAt"`
+	LastRunAt    *time.Time  `json:"lastRunAt"`
+	LastCaseID   *string     `json:"lastCaseId"`
+	NextRuns     []time.Time `json:"nextRuns,omitempty"`
+	CreatedAt    *time.Time  `json:"createdAt"`
+	UpdatedAt    *time.Time  `json:"updatedAt"`
+	CreatedBy    *string     `json:"createdBy"`
+	UpdatedBy    *string     `json:"updatedBy"`
+}
+
+type CaseScheduleInsert struct {
+	Title        string     `json:"title" binding:"required"`
+	Rule         string     `json:"rule" binding:"required"`
+	TimeZone     string     `json:"timeZone"`
+	Template     CaseInsert `json:"template"`
+	AutoDispatch bool       `json:"autoDispatch"`
+	Active       *bool      `json:"active"`
+	StartAt      *time.Time `json:"startAt"`
+	EndAt        *time.Time `json:"endAt"`
+}
+
+type CaseScheduleUpdate struct {
+	Title        string     `json:"title" binding:"required"`
+	Rule         string     `json:"rule" binding:"required"`
+	TimeZone     string     `json:"timeZone"`
+	Template     CaseInsert `json:"template"`
+	AutoDispatch bool       `json:"autoDispatch"`
+	Active       bool       `json:"active"`
+	StartAt      *time.Time `json:"startAt"`
+	EndAt        *time.Time `json:"endAt"`
+}
+
+// CaseScheduleRun is one occurrence of a case schedule and the case created
+// for it.
+type CaseScheduleRun struct {
+	ScheduleID string    `json:"scheduleId"`
+	RunAt      time.Time `json:"runAt"`
+	CaseID     *string   `json:"caseId"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
@@ -80,6 +80,19 @@ type UpdateStageRequest struct {
 	NodeId    string `json:"nodeId"`
 	ResID     string `json:"resId"`
 	ResDetail string `json:"resDetail"`
+	// Branch picks the parallel branch (fork connection id or its first node
+	// id) the update belongs to when the case crosses a fork.
+	Branch string `json:"branch,omitempty"`
+}
+
+// CaseBranch is one open parallel branch of a case.
+type CaseBranch struct {
+	BranchId     string        `json:"branchId"`
+	ForkId       string        `json:"forkId"`
+	Label        string        `json:"label,omitempty"`
+	CurrentStage CurrentStage  `json:"currentStage"`
+	NextStage    *WorkflowNode `json:"nextStage,omitempty"`
+	Waiting      bool          `json:"waiting"`
 }
 
 type StageResult struct {
@@ -161,3 +174,49 @@ type CancelCaseRequest struct {
 	ResDetail string `json:"resDetail"`
 	ResId     string `json:"resId"`
 }
+
+// DispatchCriteria holds what a case needs from a unit when ranking candidates.
+type DispatchCriteria struct {
+	CaseID         string
+	WfID           string
+	Versions       string
+	DistID         string
+	CaseLat        *float64
+	CaseLon        *float64
+	RequiredSkills []string
+	RequiredProps  []string
+	StaleAfter     time.Duration // 0 = do not check GPS freshness
+	AvgSpeedKmh    float64
+	UnitStatuses   []string // sttId of units free to take a case, empty = any
+	MaxLoad        int      // unit stages of open cases a unit may already hold
+}
+
+// UnitCandidate is a unit evaluated against DispatchCriteria. Reasons explains
+// why the unit was included or left out.
+type UnitCandidate struct {
+	UnitUser
+	UnitProps  []string `json:"-"`
+	UserSkills []string `json:"-"`
+	InArea     bool     `json:"inArea"`
+	DistanceKm *float64 `json:"distanceKm"`
+	EtaMinutes *float64 `json:"etaMinutes"`
+	GpsAgeMin  *float64 `json:"gpsAgeMin"`
+	Load       int      `json:"load"` // unit stages currently held
+	Eligible   bool     `json:"eligible"`
+	Reasons    []string `json:"reasons"`
+}
+
+// AutoDispatchState tracks an automatic dispatch attempt for a case while the
+// chosen unit has not acknowledged yet.
+type AutoDispatchState struct {
+	OrgID    string    `json:"orgId"`
+	CaseID   string    `json:"caseId"`
+	NodeID   string    `json:"nodeId"`
+	UnitID   string    `json:"unitId"`
+	UnitUser string    `json:"unitUser"`
+	Attempt  int       `json:"attempt"`
+	Tried    []string  `json:"tried"`
+	Deadline time.Time `json:"deadline"`
+	Status   string    `json:"status"`           // pending | waiting | exhausted
+	Forced   bool      `json:"forced,omitempty"` // started by a case schedule, whatever the node config
+}
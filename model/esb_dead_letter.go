@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// EsbDeadLetter is an ESB message whose handler failed. Rows live in
+// public.esb_dead_letters and can be edited and replayed by an admin.
+type EsbDeadLetter struct {
+	ID        string     `json:"id" db:"id"`
+	OrgID     string     `json:"orgId" db:"orgId"`
+	Source    string     `json:"source" db:"source"`
+	Topic     string     `json:"topic" db:"topic"`
+	Partition int32      `json:"partition" db:"partition"`
+	Offset    int64      `json:"offset" db:"offset"`
+	MsgKey    *string    `json:"msgKey" db:"msgKey"`
+	Payload   string     `json:"payload" db:"payload"`
+	Error     string     `json:"error" db:"error"`
+	Attempts  int        `json:"attempts" db:"attempts"`
+	Status    string     `json:"status" db:"status"` // FAILED | REPLAYED | DISCARDED
+	CreatedAt time.Time  `json:"createdAt" db:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt" db:"updatedAt"`
+	UpdatedBy *string    `json:"updatedBy" db:"updatedBy"`
+	ReplayAt  *time.Time `json:"replayAt" db:"replayAt"`
+}
+
+type EsbDeadLetterUpdate struct {
+	Payload *string `json:"payload"`
+	Status  *string `json:"status"`
+}
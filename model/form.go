@@ -199,10 +199,11 @@ type NodeConfig struct {
 }
 
 type WorkFlowConnection struct {
-	Id     string `json:"id"`
-	Source string `json:"source"`
-	Target string `json:"target"`
-	Label  string `json:"label"`
+	Id        string `json:"id"`
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Label     string `json:"label"`
+	Condition string `json:"condition,omitempty"` // decision branches only; empty or "else" is the default branch
 }
 
 type FormByCasesubtype struct {
@@ -287,3 +288,402 @@ type NodeData struct {
 		Y int `json:"y"`
 	} `json:"position"`
 }
+
+// WorkflowValidationError is one problem found in a workflow graph. NodeId or
+// ConnectionId points at the element the designer should highlight.
+type WorkflowValidationError struct {
+	NodeId       string `json:"nodeId,omitempty"`
+	ConnectionId string `json:"connectionId,omitempty"`
+	Field        string `json:"field,omitempty"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+}
+
+// WorkflowDecision is the outcome of a decision node for a case. Values holds
+// what each identifier of the matched condition resolved to.
+type WorkflowDecision struct {
+	NodeId       string                 `json:"nodeId"`
+	NodeLabel    string                 `json:"nodeLabel"`
+	ConnectionId string                 `json:"connectionId"`
+	Condition    string                 `json:"condition"`
+	TargetId     string                 `json:"targetId"`
+	Default      bool                   `json:"default"`
+	Values       map[string]interface{} `json:"values,omitempty"`
+}
+
+// WorkflowVersion is a frozen version of a workflow that cases are pinned to.
+type WorkflowVersion struct {
+	WfID      string     `json:"wfId"`
+	Versions  string     `json:"versions"`
+	Nodes     int        `json:"nodes,omitempty"`
+	OpenCases int        `json:"openCases,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+}
+
+// WorkflowMigrateRequest moves open cases from one workflow version to
+// another. NodeMap maps old node ids to new ones; unmapped nodes keep their
+// id. An empty CaseIds selects every open case on FromVersion.
+type WorkflowMigrateRequest struct {
+	WfID        string            `json:"wfId" binding:"required"`
+	FromVersion string            `json:"fromVersion" binding:"required"`
+	ToVersion   string            `json:"toVersion"`
+	NodeMap     map[string]string `json:"nodeMap"`
+	CaseIds     []string          `json:"caseIds"`
+	DryRun      bool              `json:"dryRun"`
+}
+
+type WorkflowMigrationStage struct {
+	StageType string `json:"stageType"`
+	UnitId    string `json:"unitId,omitempty"`
+	FromNode  string `json:"fromNode"`
+	ToNode    string `json:"toNode"`
+	Error     string `json:"error,omitempty"`
+}
+
+type WorkflowMigrationCase struct {
+	CaseId string                   `json:"caseId"`
+	Stages []WorkflowMigrationStage `json:"stages"`
+	Ok     bool                     `json:"ok"`
+	Error  string                   `json:"error,omitempty"`
+}
+
+type WorkflowMigrationReport struct {
+	MigrationId string                  `json:"migrationId,omitempty"`
+	WfID        string                  `json:"wfId"`
+	FromVersion string                  `json:"fromVersion"`
+	ToVersion   string                  `json:"toVersion"`
+	DryRun      bool                    `json:"dryRun"`
+	Migrated    int                     `json:"migrated"`
+	Skipped     int                     `json:"skipped"`
+	Cases       []WorkflowMigrationCase `json:"cases"`
+}
+
+type WorkflowMigrationRollback struct {
+	MigrationId string `json:"migrationId" binding:"required"`
+}
+
+// WorkflowSimulateRequest runs a workflow version against scripted events
+// without touching any case. Versions defaults to the version in
+// wf_definitions and StartNodeId to the start node. Case and Answers feed
+// decision nodes as case fields and form answers.
+type WorkflowSimulateRequest struct {
+	WfID        string                    `json:"wfId" binding:"required"`
+	Versions    string                    `json:"versions"`
+	StartNodeId string                    `json:"startNodeId"`
+	StartAt     *time.Time                `json:"startAt"`
+	Case        map[string]interface{}    `json:"case"`
+	Answers     map[string]interface{}    `json:"answers"`
+	Events      []WorkflowSimulationEvent `json:"events"`
+}
+
+// WorkflowSimulationEvent is one scripted step. Type "status" (the default)
+// is a stage update as sent by a unit: accept, arrive, close (with ResID) and
+// so on. Type "sla" lets the SLA of the case stage run out. After moves the
+// simulation clock forward by that many minutes first.
+type WorkflowSimulationEvent struct {
+	Type      string `json:"type"`
+	After     int    `json:"after"`
+	Status    string `json:"status"`
+	UnitId    string `json:"unitId"`
+	UnitUser  string `json:"unitUser"`
+	NodeId    string `json:"nodeId"`
+	ResID     string `json:"resId"`
+	ResDetail string `json:"resDetail"`
+	Branch    string `json:"branch,omitempty"`
+}
+
+// WorkflowSimulationMove is one stage write of a simulation. ToNode is empty
+// when the stage was dropped.
+type WorkflowSimulationMove struct {
+	StageType string    `json:"stageType"`
+	UnitId    string    `json:"unitId,omitempty"`
+	FromNode  string    `json:"fromNode,omitempty"`
+	ToNode    string    `json:"toNode"`
+	At        time.Time `json:"at"`
+}
+
+type WorkflowSimulationStage struct {
+	StageType string    `json:"stageType"`
+	UnitId    string    `json:"unitId,omitempty"`
+	NodeId    string    `json:"nodeId"`
+	Type      string    `json:"type"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WorkflowSimulationDeadline is when the SLA of a case stage runs out: the
+// SLA of the node it moves to next, counted from its last move.
+type WorkflowSimulationDeadline struct {
+	UnitId     string    `json:"unitId,omitempty"`
+	NodeId     string    `json:"nodeId"`
+	NextNodeId string    `json:"nextNodeId"`
+	SlaMinutes int       `json:"slaMinutes"`
+	Deadline   time.Time `json:"deadline"`
+}
+
+type WorkflowSimulationNotification struct {
+	Event      string      `json:"event"`
+	Status     string      `json:"status"`
+	Delay      string      `json:"delay"`
+	Message    string      `json:"message"`
+	Recipients []Recipient `json:"recipients"`
+}
+
+type WorkflowSimulationForm struct {
+	NodeId string `json:"nodeId"`
+	FormId string `json:"formId"`
+}
+
+// WorkflowSimulationStep is the outcome of one event. Error is set when the
+// real stage update would have been rejected; the stages are left as they
+// were.
+type WorkflowSimulationStep struct {
+	Index         int                              `json:"index"`
+	Event         WorkflowSimulationEvent          `json:"event"`
+	At            time.Time                        `json:"at"`
+	Step          string                           `json:"step,omitempty"`
+	Error         string                           `json:"error,omitempty"`
+	Moves         []WorkflowSimulationMove         `json:"moves"`
+	Decisions     []WorkflowDecision               `json:"decisions,omitempty"`
+	History       []string                         `json:"history,omitempty"`
+	Notifications []WorkflowSimulationNotification `json:"notifications"`
+	Forms         []WorkflowSimulationForm         `json:"forms"`
+	Deadlines     []WorkflowSimulationDeadline     `json:"deadlines"`
+	Stages        []WorkflowSimulationStage        `json:"stages"`
+}
+
+// WorkflowSimulation is the trace of a simulation. Visited lists the nodes
+// stages rested on in the order they were first reached.
+type WorkflowSimulation struct {
+	WfID        string                    `json:"wfId"`
+	Versions    string                    `json:"versions"`
+	StartNodeId string                    `json:"startNodeId"`
+	Steps       []WorkflowSimulationStep  `json:"steps"`
+	Visited     []string                  `json:"visited"`
+	Stages      []WorkflowSimulationStage `json:"stages"`
+	Closed      bool                      `json:"closed"`
+	Warnings    []string                  `json:"warnings"`
+}
+
+// WorkflowBundle is a workflow exported together with the forms, case types,
+// case subtypes, skills and unit properties it references, so it can be
+// imported into another organization or environment. Checksum is the hex
+// SHA-256 of the JSON encoding of Content.
+type WorkflowBundle struct {
+	Format     string                `json:"format"`
+	SourceOrg  string                `json:"sourceOrg"`
+	ExportedAt time.Time             `json:"exportedAt"`
+	ExportedBy string                `json:"exportedBy"`
+	Checksum   string                `json:"checksum"`
+	Content    WorkflowBundleContent `json:"content"`
+}
+
+type WorkflowBundleContent struct {
+	Workflow     WorkflowBundleWorkflow      `json:"workflow"`
+	Forms        []WorkflowBundleForm        `json:"forms"`
+	CaseTypes    []WorkflowBundleCaseType    `json:"caseTypes"`
+	CaseSubTypes []WorkflowBundleCaseSubType `json:"caseSubTypes"`
+	Skills       []WorkflowBundleItem        `json:"skills"`
+	Properties   []WorkflowBundleItem        `json:"properties"`
+}
+
+type WorkflowBundleWorkflow struct {
+	WfID        string               `json:"wfId"`
+	Title       string               `json:"title"`
+	Desc        string               `json:"desc"`
+	Versions    string               `json:"versions"`
+	Nodes       []WorkFlowNode       `json:"nodes"`
+	Connections []WorkFlowConnection `json:"connections"`
+}
+
+type WorkflowBundleForm struct {
+	FormId        string                `json:"formId"`
+	FormName      string                `json:"formName"`
+	Versions      string                `json:"versions"`
+	Active        bool                  `json:"active"`
+	Publish       bool                  `json:"publish"`
+	Locks         bool                  `json:"locks"`
+	FormColSpan   int                   `json:"formColSpan"`
+	FormFieldJson []IndividualFormField `json:"formFieldJson"`
+}
+
+type WorkflowBundleCaseType struct {
+	TypeID string `json:"typeId"`
+	En     string `json:"en"`
+	Th     string `json:"th"`
+	Active bool   `json:"active"`
+}
+
+type WorkflowBundleCaseSubType struct {
+	STypeID       string   `json:"sTypeId"`
+	TypeID        string   `json:"typeId"`
+	STypeCode     string   `json:"sTypeCode"`
+	En            string   `json:"en"`
+	Th            string   `json:"th"`
+	WfID          string   `json:"wfId"`
+	CaseSla       string   `json:"caseSla"`
+	Priority      *int     `json:"priority"`
+	UserSkillList []string `json:"userSkillList"`
+	UnitPropLists []string `json:"unitPropLists"`
+	Active        bool     `json:"active"`
+}
+
+// WorkflowBundleItem is a skill or a unit property.
+type WorkflowBundleItem struct {
+	Id     string `json:"id"`
+	En     string `json:"en"`
+	Th     string `json:"th"`
+	Active bool   `json:"active"`
+}
+
+// WorkflowImportRequest imports a bundle into the organization of the caller.
+// Every record gets a new id. A record conflicts with an existing one that has
+// the same id or the same name (form name, English name, subtype code,
+// workflow title). Strategy settles conflicts: "skip" (default) links to the
+// existing record, "overwrite" replaces its content, "rename" imports a copy
+// named with RenameSuffix. Strategies overrides Strategy per bundle id. With
+// DryRun the import is rolled back and only the report is returned.
+type WorkflowImportRequest struct {
+	Bundle       WorkflowBundle    `json:"bundle"`
+	Strategy     string            `json:"strategy"`
+	Strategies   map[string]string `json:"strategies"`
+	RenameSuffix string            `json:"renameSuffix"`
+	DryRun       bool              `json:"dryRun"`
+}
+
+type WorkflowImportItem struct {
+	Kind     string `json:"kind"`
+	SourceId string `json:"sourceId"`
+	TargetId string `json:"targetId"`
+	Name     string `json:"name"`
+	Conflict string `json:"conflict,omitempty"`
+	Action   string `json:"action"`
+}
+
+type WorkflowImportReport struct {
+	DryRun   bool                      `json:"dryRun"`
+	WfID     string                    `json:"wfId"`
+	Versions string                    `json:"versions,omitempty"`
+	Items    []WorkflowImportItem      `json:"items"`
+	Errors   []WorkflowValidationError `json:"errors"`
+}
+
+// FormFieldError is one rule a form answer breaks. ParentId is set for fields
+// shown by a selected option of another field.
+type FormFieldError struct {
+	FieldId  string `json:"fieldId"`
+	ParentId string `json:"parentId,omitempty"`
+	Label    string `json:"label"`
+	Code     string `json:"code"`
+	En       string `json:"en"`
+	Th       string `json:"th"`
+}
+
+// FormAnswerValidateRequest is a form answer checked without saving it.
+type FormAnswerValidateRequest struct {
+	FormId        string                `json:"formId"`
+	Versions      string                `json:"versions"`
+	FormFieldJson []IndividualFormField `json:"formFieldJson"`
+}
+
+// FormAnswerValue is one typed value of an answered field. Multi-select
+// fields give one value per selected option. TextValue always holds the
+// value as text; NumValue and DateValue are set for numbers and dates.
+type FormAnswerValue struct {
+	CaseId    string     `json:"caseId"`
+	FormId    string     `json:"formId"`
+	Versions  string     `json:"versions"`
+	FieldId   string     `json:"fieldId"`
+	FieldUid  string     `json:"fieldUid,omitempty"`
+	ParentId  string     `json:"parentId,omitempty"`
+	Label     string     `json:"label"`
+	FieldType string     `json:"fieldType"`
+	ValueType string     `json:"valueType"`
+	NumValue  *float64   `json:"numValue,omitempty"`
+	DateValue *time.Time `json:"dateValue,omitempty"`
+	TextValue string     `json:"textValue"`
+}
+
+// FormAnswerPredicate matches cases with an answered field, by field id,
+// field uid or label. Op is one of eq, ne, gt, gte, lt, lte, contains, in and exists.
+type FormAnswerPredicate struct {
+	FormId string   `json:"formId,omitempty"`
+	Field  string   `json:"field" binding:"required"`
+	Op     string   `json:"op" binding:"required"`
+	Value  string   `json:"value,omitempty"`
+	Values []string `json:"values,omitempty"`
+}
+
+// FormAnswerQueryRequest selects the cases matching every predicate and
+// returns their values of Fields, or of the predicate fields when empty.
+type FormAnswerQueryRequest struct {
+	Predicates []FormAnswerPredicate `json:"predicates" binding:"required"`
+	Fields     []string              `json:"fields,omitempty"`
+	Start      int                   `json:"start"`
+	Length     int                   `json:"length"`
+}
+
+type FormAnswerQueryResult struct {
+	TotalCases int               `json:"totalCases"`
+	Values     []FormAnswerValue `json:"values"`
+}
+
+// DiffChange is one property that differs between two versions. Path names
+// the property, e.g. "label" or "formRule.maxLength".
+type DiffChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// FormFieldDiff is a field added, removed or changed between two versions of
+// a form. ParentId is set for fields shown by an option of another field.
+type FormFieldDiff struct {
+	Uid            string       `json:"uid,omitempty"`
+	FieldId        string       `json:"fieldId"`
+	OldFieldId     string       `json:"oldFieldId,omitempty"`
+	ParentId       string       `json:"parentId,omitempty"`
+	Label          string       `json:"label"`
+	Change         string       `json:"change"`
+	Changes        []DiffChange `json:"changes,omitempty"`
+	OptionsAdded   []string     `json:"optionsAdded,omitempty"`
+	OptionsRemoved []string     `json:"optionsRemoved,omitempty"`
+}
+
+type FormVersionDiff struct {
+	FormId  string          `json:"formId"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Changes []DiffChange    `json:"changes"`
+	Fields  []FormFieldDiff `json:"fields"`
+}
+
+type WorkflowNodeDiff struct {
+	NodeId  string       `json:"nodeId"`
+	Label   string       `json:"label"`
+	Type    string       `json:"type"`
+	Change  string       `json:"change"`
+	Changes []DiffChange `json:"changes,omitempty"`
+}
+
+type WorkflowVersionDiff struct {
+	WfID               string               `json:"wfId"`
+	From               string               `json:"from"`
+	To                 string               `json:"to"`
+	Nodes              []WorkflowNodeDiff   `json:"nodes"`
+	ConnectionsAdded   []WorkFlowConnection `json:"connectionsAdded"`
+	ConnectionsRemoved []WorkFlowConnection `json:"connectionsRemoved"`
+}
+
+// FormFieldLineage follows one field through the versions of a form.
+type FormFieldLineage struct {
+	Uid      string             `json:"uid,omitempty"`
+	Versions []FormFieldVersion `json:"versions"`
+}
+
+type FormFieldVersion struct {
+	Version string `json:"version"`
+	FieldId string `json:"fieldId"`
+	Label   string `json:"label"`
+	Type    string `json:"type"`
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// UserMfa is the TOTP second factor of a user, stored in um_user_mfa. Secret
+// is sealed with MFA_SECRET_KEY and RecoveryCodes holds SHA-256 hashes of the
+// unused recovery codes. LastStep is the last TOTP time step accepted, so a
+// code works only once.
+type UserMfa struct {
+	OrgID         string     `json:"orgId"`
+	Username      string     `json:"username"`
+	Secret        string     `json:"-"`
+	Enabled       bool       `json:"enabled"`
+	RecoveryCodes []string   `json:"-"`
+	LastStep      int64      `json:"-"`
+	EnrolledAt    *time.Time `json:"enrolledAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+type MfaStatus struct {
+	Enabled           bool       `json:"enabled"`
+	Required          bool       `json:"required"`
+	RecoveryCodesLeft int        `json:"recoveryCodesLeft"`
+	EnrolledAt        *time.Time `json:"enrolledAt"`
+}
+
+// MfaEnrollment is shown once when enrolling: the secret for manual entry and
+// the otpauth URI for a QR code.
+type MfaEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+type MfaCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MfaTokenInput struct {
+	MfaToken string `json:"mfaToken" binding:"required"`
+}
+
+// MfaVerifyInput is the second login step. It takes either a TOTP code or a
+// recovery code.
+type MfaVerifyInput struct {
+	MfaToken     string `json:"mfaToken" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
@@ -0,0 +1,11 @@
+package model
+
+// MonitorLeader is the current lease holder of a background job.
+type MonitorLeader struct {
+	Job       string `json:"job"`
+	Owner     string `json:"owner"`
+	Host      string `json:"host"`
+	Token     int64  `json:"token"`
+	ExpiresIn int64  `json:"expiresIn"` // milliseconds
+	Self      bool   `json:"self"`
+}
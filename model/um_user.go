@@ -21,7 +21,7 @@ type Um_User_Login struct {
 	Address               *string    `json:"address"`
 	Photo                 *string    `json:"photo"`
 	Username              string     `json:"username"`
-	Password              string     `json:"password"`
+	Password              string     `json:"-"`
 	Email                 *string    `json:"email"`
 	RoleID                string     `json:"roleId"`
 	Permission            []string   `json:"permission"`
@@ -62,7 +62,6 @@ type Um_User struct {
 	Address               *string                   `json:"address"`
 	Photo                 *string                   `json:"photo"`
 	Username              string                    `json:"username"`
-	Password              string                    `json:"password"`
 	Email                 *string                   `json:"email"`
 	RoleID                string                    `json:"roleId"`
 	RoleName              string                    `json:"roleName"`
@@ -172,13 +171,26 @@ type UserUpdate struct {
 
 //add Reset Password and Change Password form UserUpdate by Delta 26/08/2568
 
-// ResetPasswordRequest สำหรับ reset password
+// ResetPasswordRequest asks for a reset token to be sent to the user.
 type ResetPasswordRequest struct {
-	Username    string `json:"username" binding:"required"`
-	Email       string `json:"email" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+}
+
+// ResetPasswordConfirm sets a new password with a token from
+// ResetPasswordRequest.
+type ResetPasswordConfirm struct {
+	Token       string `json:"token" binding:"required"`
 	NewPassword string `json:"newPassword" binding:"required"`
 }
 
+// PasswordResetToken is what a reset token stands for.
+type PasswordResetToken struct {
+	OrgID    string `json:"orgId"`
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+}
+
 // ChangePasswordRequest สำหรับ change password
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"currentPassword" binding:"required"`
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// WorkerStatus is the state of a supervised background worker.
+type WorkerStatus struct {
+	Name        string     `json:"name"`
+	State       string     `json:"state"` // running, restarting or stopped
+	Restarts    int        `json:"restarts"`
+	StartedAt   *time.Time `json:"startedAt"`
+	LastError   *string    `json:"lastError"`
+	LastErrorAt *time.Time `json:"lastErrorAt"`
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mainPackage/model"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type CaseRepository struct {
+	db DBTX
+}
+
+func NewCaseRepository(db DBTX) *CaseRepository {
+	return &CaseRepository{db: db}
+}
+
+// GetByID returns the case, or nil when it does not exist.
+func (r *CaseRepository) GetByID(ctx context.Context, orgId string, caseId string) (*model.Case, error) {
+	query := `
+	SELECT 
+		"caseId", "integration_ref_number", "distId", "statusId", "caseTypeId", "caseSTypeId", "priority", "caseLat", "caseLon", "caseDetail", "deviceMetaData", "wfId", "versions",
+		"countryId", "provId", "distId", "createdDate", "scheduleFlag", "scheduleDate", "createdBy"
+	FROM public."tix_cases"
+	WHERE "orgId" = $1 AND "caseId" = $2
+	LIMIT 1;
+	`
+
+	var c model.Case
+	var deviceMetaJSON []byte
+
+	err := r.db.QueryRow(ctx, query, orgId, caseId).Scan(
+		&c.CaseID,
+		&c.IntegrationRefNumber,
+		&c.DistID,
+		&c.StatusID,
+		&c.CaseTypeID,
+		&c.CaseSTypeID,
+		&c.Priority,
+		&c.CaseLat,
+		&c.CaseLon,
+		&c.CaseDetail,
+		&c.DeviceMetaData,
+		&c.WfID,
+		&c.WfVersions,
+		&c.CountryID,
+		&c.ProvID,
+		&c.DistID,
+		&c.CreatedDate,
+		&c.ScheduleFlag,
+		&c.ScheduleDate,
+		&c.CreatedBy,
+	)
+
+	// ✅ case not found
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query case failed: %w", err)
+	}
+
+	// ✅ handle JSON column (TEXT / JSONB)
+	if len(deviceMetaJSON) > 0 {
+		if err := json.Unmarshal(deviceMetaJSON, &c.DeviceMetaData); err != nil {
+			log.Printf("Warning: cannot unmarshal deviceMetaData: %v", err)
+		}
+	}
+
+	return &c, nil
+}
+
+// UpdateSLAPlus flags the case as over SLA and counts one more alert.
+func (r *CaseRepository) UpdateSLAPlus(ctx context.Context, orgId, caseId string, overSlaFlag bool, overSlaDate time.Time) error {
+	query := `
+		UPDATE tix_cases
+		SET "overSlaFlag" = $1,
+		    "overSlaDate" = $2,
+		    "overSlaCount" = COALESCE("overSlaCount", 0) + 1,
+		    "updatedAt" = NOW()
+		WHERE "orgId" = $3
+		  AND "caseId" = $4;
+	`
+	//log.Print("====UpdateCaseSLAPlus===", query)
+	_, err := r.db.Exec(ctx, query, overSlaFlag, overSlaDate, orgId, caseId)
+	if err != nil {
+		return fmt.Errorf("update case SLA failed: %w", err)
+	}
+
+	return nil
+}
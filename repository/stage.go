@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"mainPackage/model"
+	"time"
+)
+
+type StageRepository struct {
+	db DBTX
+}
+
+func NewStageRepository(db DBTX) *StageRepository {
+	return &StageRepository{db: db}
+}
+
+// StageWrite is one row of tix_case_current_stage to insert or update.
+type StageWrite struct {
+	CaseID    string
+	NodeID    string
+	StageType string // case | unit
+	UnitID    string
+	Username  string
+	Data      []byte
+	By        string
+}
+
+// GetNode returns a workflow node of the given workflow version, or of the
+// current version of its definition when version is empty. wfId is optional
+// and narrows the lookup when node ids repeat across workflows.
+func (r *StageRepository) GetNode(ctx context.Context, nodeId string, wfId string, version string) (*model.WfNode, error) {
+	query := `
+		SELECT n."orgId", n."wfId", n."nodeId", n."versions", n."type", n."section", 
+       n."formId", n."pic", n."group"
+		FROM public."wf_nodes" n
+		JOIN public."wf_definitions" d 
+		ON n."wfId" = d."wfId"
+		WHERE n."nodeId" = $1
+		AND ($2 = '' OR d."wfId" = $2)
+		AND (n."versions" = $3 OR ($3 = '' AND n."versions" = d."versions"))
+	`
+	var node model.WfNode
+	err := r.db.QueryRow(ctx, query, nodeId, wfId, version).Scan(
+		&node.OrgID, &node.WfID, &node.NodeID, &node.Versions, &node.Type,
+		&node.Section, &node.FormID, &node.Pic, &node.Group,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Insert adds a current stage for the case on the given node.
+func (r *StageRepository) Insert(ctx context.Context, node model.WfNode, w StageWrite) error {
+	now := time.Now()
+	query := `
+	INSERT INTO public."tix_case_current_stage"
+	("orgId", "caseId", "wfId", "nodeId", "versions", "type", "section", "data",
+	  "stageType", "unitId",
+	 "username", "updatedAt", "createdAt", "createdBy", "updatedBy")
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14, $15)
+	`
+	_, err := r.db.Exec(ctx, query,
+		node.OrgID, w.CaseID, node.WfID, w.NodeID, node.Versions, node.Type, node.Section, w.Data,
+		w.StageType, w.UnitID,
+		w.Username, now, now, w.By, w.By,
+	)
+	return err
+}
+
+// Update moves the current stage of the case (and unit) to the given node.
+func (r *StageRepository) Update(ctx context.Context, node model.WfNode, w StageWrite) error {
+	query := `
+	UPDATE public."tix_case_current_stage"
+	SET "wfId" = $1,
+	    "type" = $2,
+	    "section" = $3,
+	    "data" = $4,
+	    "username" = $6,
+	    "updatedAt" = $7,
+	    "updatedBy" = $8,
+		"nodeId" = $12,
+		"versions" = $11 
+	WHERE "caseId" = $9
+	  AND "stageType" = $10 
+	  AND "unitId" = $5
+	`
+	_, err := r.db.Exec(ctx, query,
+		node.WfID, node.Type, node.Section, w.Data,
+		w.UnitID, w.Username, time.Now(), w.By,
+		w.CaseID, w.StageType, node.Versions, w.NodeID,
+	)
+	return err
+}
+
+// CaseWorkflow returns the workflow and the workflow version the case is
+// pinned to.
+func (r *StageRepository) CaseWorkflow(ctx context.Context, caseId string) (string, string, error) {
+	var wfId, version *string
+	err := r.db.QueryRow(ctx, `
+		SELECT "wfId", "versions" FROM public."tix_cases" WHERE "caseId" = $1
+	`, caseId).Scan(&wfId, &version)
+	if err != nil {
+		return "", "", err
+	}
+	var w, v string
+	if wfId != nil {
+		w = *wfId
+	}
+	if version != nil {
+		v = *version
+	}
+	return w, v, nil
+}
+
+// DeleteCaseStages removes the case level stages of the case kept under the
+// given unit ids; parallel branches are stored this way.
+func (r *StageRepository) DeleteCaseStages(ctx context.Context, caseId string, unitIds []string) error {
+	query := `
+	DELETE FROM public."tix_case_current_stage"
+	WHERE "caseId" = $1
+	  AND "stageType" = 'case'
+	  AND "unitId" = ANY($2)
+	`
+	_, err := r.db.Exec(ctx, query, caseId, unitIds)
+	return err
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"mainPackage/model"
+)
+
+type UnitRepository struct {
+	db DBTX
+}
+
+func NewUnitRepository(db DBTX) *UnitRepository {
+	return &UnitRepository{db: db}
+}
+
+// ListCaseStages returns the units holding a unit stage of the case,
+// optionally filtered by the stage action and unit.
+func (r *UnitRepository) ListCaseStages(ctx context.Context, orgID string, caseID string, statusId string, unitID string) ([]model.UnitDispatch, int, error) {
+	var unitLists []model.UnitDispatch
+	//st := []string{"S007", "S016", "S017", "S018"}
+
+	// If statusId is in the skip list, return empty slice
+	// if contains(st, statusId) {
+	// 	//return unitLists, nil
+	// }
+
+	query := `
+		SELECT 
+    cs."unitId",
+    cs."username",
+    u."firstName",
+    u."lastName"
+FROM public.tix_case_current_stage AS cs
+JOIN public.um_users AS u
+    ON cs."username" = u."username"
+    AND cs."orgId" = u."orgId"
+WHERE 
+    cs."orgId" = $1
+    AND cs."caseId" = $2
+    AND cs."stageType" = 'unit'
+	`
+
+	args := []interface{}{orgID, caseID}
+	argIndex := 3
+
+	// ถ้ามี statusId ให้เพิ่ม filter จาก JSONB
+	if statusId != "" {
+		query += fmt.Sprintf(` AND data->'data'->'config'->>'action' = $%d`, argIndex)
+		args = append(args, statusId)
+		argIndex++
+	}
+
+	// ถ้ามี unitID ให้เพิ่ม filter ด้วย
+	if unitID != "" {
+		query += fmt.Sprintf(` AND "unitId" = $%d`, argIndex)
+		args = append(args, unitID)
+		argIndex++
+	}
+	log.Print(query)
+	log.Print(args)
+	rows, err := r.db.Query(ctx, query, args...)
+
+	//rows, err := r.db.Query(ctx, query, orgID, caseID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u model.UnitDispatch
+		if err := rows.Scan(&u.UnitID, &u.Username, &u.FirstName, &u.LastName); err != nil {
+			return nil, 0, err
+		}
+		unitLists = append(unitLists, u)
+	}
+
+	count := len(unitLists)
+
+	return unitLists, count, nil
+}
+
+// ListResponders returns the units recorded as responders of the case,
+// optionally filtered by responder status and unit.
+func (r *UnitRepository) ListResponders(ctx context.Context, orgID string, caseID string, statusId string, unitID string) ([]model.UnitDispatch, int, error) {
+	var unitLists []model.UnitDispatch
+	//st := []string{"S007", "S016", "S017", "S018"}
+
+	// If statusId is in the skip list, return empty slice
+	// if contains(st, statusId) {
+	// 	//return unitLists, nil
+	// }
+
+	query := `
+		SELECT 
+			r."unitId",
+			u."username",
+			u."firstName",
+			u."lastName",
+			r."createdBy",
+			r."statusId"
+		FROM public.tix_case_responders AS r
+		JOIN public.um_users AS u
+			ON r."userOwner" = u."username"
+			AND r."orgId" = u."orgId"
+		WHERE 
+			r."unitId" != 'case' 
+			AND r."orgId" = $1
+			AND r."caseId" =  $2
+	`
+
+	args := []interface{}{orgID, caseID}
+	argIndex := 3
+
+	// ถ้ามี statusId ให้เพิ่ม filter จาก JSONB
+	if statusId != "" {
+		query += fmt.Sprintf(` AND r."statusId" = $%d`, argIndex)
+		args = append(args, statusId)
+		argIndex++
+	}
+
+	// ถ้ามี unitID ให้เพิ่ม filter ด้วย
+	if unitID != "" {
+		query += fmt.Sprintf(` AND r."unitId" = $%d`, argIndex)
+		args = append(args, unitID)
+		argIndex++
+	}
+	log.Print(query)
+	log.Print(args)
+	rows, err := r.db.Query(ctx, query, args...)
+
+	//rows, err := r.db.Query(ctx, query, orgID, caseID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u model.UnitDispatch
+		if err := rows.Scan(&u.UnitID, &u.Username, &u.FirstName, &u.LastName, &u.CreatedBy, &u.StatusId); err != nil {
+			return nil, 0, err
+		}
+		unitLists = append(unitLists, u)
+	}
+
+	count := len(unitLists)
+
+	return unitLists, count, nil
+}
+
+// DeleteCaseStages removes the unit stages of the case, or only the one of
+// unitID when given, and returns how many were removed.
+func (r *UnitRepository) DeleteCaseStages(ctx context.Context, orgID, caseID, unitID string) (int64, error) {
+
+	query := `
+		DELETE FROM public.tix_case_current_stage
+		WHERE "orgId" = $1 
+		  AND "caseId" = $2 
+		  AND "stageType" = 'unit'
+	`
+	args := []interface{}{orgID, caseID}
+	argIndex := 3
+
+	// ถ้ามี unitID ให้เพิ่ม filter
+	if unitID != "" {
+		query += fmt.Sprintf(` AND "unitId" = $%d`, argIndex)
+		args = append(args, unitID)
+	}
+
+	// Execute delete query
+	cmdTag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteCurrentUnit failed: %w", err)
+	}
+
+	// cmdTag.RowsAffected() คืนค่าจำนวนแถวที่ถูกลบ
+	deletedCount := cmdTag.RowsAffected()
+	log.Printf("Deleted %d unit(s) from case %s", deletedCount, caseID)
+
+	return deletedCount, nil
+}
+
+// UpdateStatus sets login state, status and location of the unit owned by
+// username and returns its unitId.
+func (r *UnitRepository) UpdateStatus(ctx context.Context, orgId, username string, isLogin bool, sttId string, lat, lon sql.NullFloat64, updatedBy string) (string, error) {
+	query := `
+		UPDATE mdm_units
+		SET 
+			"isLogin" = $1,
+			"sttId" = $2,
+			"locLat" = $3,
+			"locLon" = $4,
+			"updatedAt" = NOW(),
+			"updatedBy" = $5
+		WHERE "orgId" = $6 AND "username" = $7
+		RETURNING "unitId";
+	`
+
+	var updatedUnit string
+	err := r.db.QueryRow(ctx, query,
+		isLogin,
+		sttId,
+		lat,
+		lon,
+		updatedBy,
+		orgId,
+		username,
+	).Scan(&updatedUnit)
+	return updatedUnit, err
+}
@@ -3,11 +3,11 @@ package utils
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 
 	"mainPackage/model"
+	"mainPackage/repository"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
@@ -91,36 +91,11 @@ func GetUserByUsername(ctx context.Context, conn *pgx.Conn, orgId, username stri
 		}
 	}
 
-	query := `
-	SELECT  "username", "email", "displayName", 
-	       "roleId", "active", "photo", "empId", "firstName", "lastName", "photo", "mobileNo", "password"
-	FROM public.um_users
-	WHERE "orgId" = $1 AND "username" = $2
-	LIMIT 1;
-	`
-
-	var u model.User
-	err = conn.QueryRow(ctx, query, orgId, username).Scan(
-		&u.Username,
-		&u.Email,
-		&u.DisplayName,
-		&u.RoleID,
-		&u.Active,
-		&u.Photo,
-		&u.EmpID,
-		&u.FirstName,
-		&u.LastName,
-		&u.Photo,
-		&u.MobileNo,
-		&u.Password,
-	)
-
-	if err == pgx.ErrNoRows {
-		return nil, nil // not found
-	}
-	if err != nil {
-		return nil, fmt.Errorf("query user failed: %w", err)
+	user, err := repository.NewUserRepository(conn).GetByUsername(ctx, orgId, username)
+	if err != nil || user == nil {
+		return user, err
 	}
+	u := *user
 
 	//Set Cache
 	userJSON, err := json.Marshal(u)
@@ -373,85 +348,7 @@ func GetUnitProp(ctx context.Context, conn *pgx.Conn, orgID string) ([]model.Get
 // ---------- Query User Profile ----------
 
 func GetUserProfileFromDB(ctx context.Context, dbConn *pgx.Conn, orgId, username string) (*model.UserConnectionInfo, error) {
-	log.Printf("Database: Querying for user '%s' in organization '%s'", username, orgId)
-
-	var userProfile model.UserConnectionInfo
-	var roleID string
-	var distIdListsJSON []byte
-	var GrpID []string
-
-	// 1) ลองอ่านจาก user_connections ก่อน (เก็บ grpId เป็น array อยู่แล้ว)
-	connectionQuery := `
-        SELECT "empId", "username", "orgId", "deptId", "commId", "stnId", "roleId", "grpId", "distIdLists", COALESCE("ip", '') as ip
-        FROM user_connections
-        WHERE "orgId" = $1 AND "username" = $2
-        LIMIT 1;
-    `
-	err := dbConn.QueryRow(ctx, connectionQuery, orgId, username).Scan(
-		&userProfile.ID, &userProfile.Username, &userProfile.OrgID,
-		&userProfile.DeptID, &userProfile.CommID, &userProfile.StnID,
-		&roleID, &GrpID, &userProfile.DistIdLists, &userProfile.Ip, // scan array -> []string
-	)
-	if err == nil {
-		userProfile.RoleID = roleID
-		userProfile.GrpID = GrpID
-		log.Printf("Database: Found existing connection for '%s'", username)
-		return &userProfile, nil
-	}
-	if !errors.Is(err, pgx.ErrNoRows) {
-		log.Printf("ERROR: Failed to query user connections for '%s': %v", username, err)
-		return nil, err
-	}
-
-	// 2) ไม่เจอใน user_connections -> ไปอ่านจาก um_users + um_user_with_groups
-	//    รวมหลายแถวของ grpId ให้เป็น array ด้วย array_agg(DISTINCT ...)
-	query := `
-        SELECT 
-          COALESCE(u."empId"::text, '')  AS "empId",
-          u."username",
-          COALESCE(u."orgId"::text, '')  AS "orgId",
-          COALESCE(u."deptId"::text, '') AS "deptId",
-          COALESCE(u."commId"::text, '') AS "commId",
-          COALESCE(u."stnId"::text, '')  AS "stnId",
-          COALESCE(u."roleId"::text, '') AS "roleId",
-          COALESCE(array_agg(DISTINCT ug."grpId"::text) FILTER (WHERE ug."grpId" IS NOT NULL), '{}') AS "grpIds",
-          COALESCE(uar."distIdLists", '[]'::jsonb) AS "distIdLists"
-        FROM um_users u
-        LEFT JOIN um_user_with_groups ug 
-               ON u."username" = ug."username"
-        LEFT JOIN um_user_with_area_response uar 
-               ON u."username" = uar."username"
-        WHERE u."orgId"::text = $1 
-          AND u."username" = $2 
-          AND u."active" = true
-        GROUP BY u."empId", u."username", u."orgId", u."deptId", u."commId", u."stnId", u."roleId", uar."distIdLists"
-        LIMIT 1;
-    `
-	err = dbConn.QueryRow(ctx, query, orgId, username).Scan(
-		&userProfile.ID, &userProfile.Username, &userProfile.OrgID,
-		&userProfile.DeptID, &userProfile.CommID, &userProfile.StnID,
-		&roleID, &GrpID, &distIdListsJSON,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("user not found or is not active")
-		}
-		log.Printf("ERROR: Failed to query user profile for '%s': %v", username, err)
-		return nil, err
-	}
-
-	userProfile.RoleID = roleID
-	userProfile.GrpID = GrpID
-
-	// distIdLists จากตาราง uar เป็น jsonb -> unmarshal เป็น []string
-	if len(distIdListsJSON) > 0 {
-		if err := json.Unmarshal(distIdListsJSON, &userProfile.DistIdLists); err != nil {
-			log.Printf("WARNING: Failed to parse distIdLists for user '%s': %v", username, err)
-			userProfile.DistIdLists = []string{}
-		}
-	}
-
-	return &userProfile, nil
+	return repository.NewUserRepository(dbConn).GetConnectionProfile(ctx, orgId, username)
 }
 
 func GetAreaByUsernameOrLoad(ctx context.Context, dbConn *pgx.Conn, orgId string, username string) (*model.Um_User_Login, error) {
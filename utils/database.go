@@ -4,49 +4,137 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func ConnectDB() (*pgx.Conn, context.Context, context.CancelFunc) {
-	logger := GetLog()
+var (
+	// DB is the shared pool of the main database, set by InitDB.
+	DB       *pgxpool.Pool
+	reportDB *pgxpool.Pool
+	dbMu     sync.Mutex
+)
+
+// newPool builds a pool for the given database. Limits and health checks
+// come from DB_POOL_* (see .env).
+func newPool(database string) (*pgxpool.Pool, error) {
 	var username string = os.Getenv("DB_USER")
 	var password string = os.Getenv("DB_PASS")
 	var host string = os.Getenv("DB_HOST")
-	var database string = os.Getenv("DB_NAME")
 	connStr := fmt.Sprintf("postgres://%s:%s@%s/%s", username, password, host, database)
-	//logger.Debug("Connection String : " + connStr)
-	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxConns = int32(EnvInt("DB_POOL_MAX_CONNS", 20))
+	config.MinConns = int32(EnvInt("DB_POOL_MIN_CONNS", 2))
+	config.MaxConnLifetime = time.Duration(EnvInt("DB_POOL_MAX_CONN_LIFETIME", 1800)) * time.Second
+	config.MaxConnIdleTime = time.Duration(EnvInt("DB_POOL_MAX_CONN_IDLE", 300)) * time.Second
+	config.HealthCheckPeriod = time.Duration(EnvInt("DB_POOL_HEALTH_CHECK", 30)) * time.Second
+
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
 
-	conn, err := pgx.Connect(ctx, connStr)
+// InitDB opens the shared pool of the main database.
+func InitDB() error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if DB != nil {
+		return nil
+	}
+	pool, err := newPool(os.Getenv("DB_NAME"))
 	if err != nil {
-		cancel()
-		logger.DPanic("Unable to connect to database : " + err.Error())
+		return err
 	}
+	DB = pool
+	return nil
+}
 
-	return conn, ctx, cancel
+// CloseDB closes every pool. Call it once, on shutdown.
+func CloseDB() {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if DB != nil {
+		DB.Close()
+		DB = nil
+	}
+	if reportDB != nil {
+		reportDB.Close()
+		reportDB = nil
+	}
 }
 
-func ConnectDB_REPORT() (*pgx.Conn, context.Context, context.CancelFunc) {
+func getPool(report bool) (*pgxpool.Pool, error) {
+	if !report {
+		if DB == nil {
+			if err := InitDB(); err != nil {
+				return nil, err
+			}
+		}
+		return DB, nil
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if reportDB == nil {
+		pool, err := newPool(os.Getenv("DB_NAME_REPORT"))
+		if err != nil {
+			return nil, err
+		}
+		reportDB = pool
+	}
+	return reportDB, nil
+}
+
+// acquire borrows a connection from the pool. The returned cancel releases
+// the connection back to the pool, so callers must not Close it.
+func acquire(report bool) (*pgx.Conn, context.Context, context.CancelFunc) {
 	logger := GetLog()
-	var username string = os.Getenv("DB_USER")
-	var password string = os.Getenv("DB_PASS")
-	var host string = os.Getenv("DB_HOST")
-	var database string = os.Getenv("DB_NAME_REPORT")
-	connStr := fmt.Sprintf("postgres://%s:%s@%s/%s", username, password, host, database)
-	//logger.Debug("Connection String : " + connStr)
 	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 
-	conn, err := pgx.Connect(ctx, connStr)
+	pool, err := getPool(report)
 	if err != nil {
 		cancel()
 		logger.DPanic("Unable to connect to database : " + err.Error())
+		return nil, ctx, cancel
 	}
+	pc, err := pool.Acquire(ctx)
+	if err != nil {
+		cancel()
+		logger.DPanic("Unable to connect to database : " + err.Error())
+		return nil, ctx, cancel
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			pc.Release()
+			cancel()
+		})
+	}
+	return pc.Conn(), ctx, release
+}
 
-	return conn, ctx, cancel
+func ConnectDB() (*pgx.Conn, context.Context, context.CancelFunc) {
+	return acquire(false)
+}
+
+func ConnectDB_REPORT() (*pgx.Conn, context.Context, context.CancelFunc) {
+	return acquire(true)
 }
 
 // func ConnectDB_(c *gin.Context) (*pgx.Conn, *gin.Context, context.CancelFunc) {
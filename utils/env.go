@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvInt returns the integer value of the environment variable key, or
+// fallback when it is unset or not a number.
+func EnvInt(key string, fallback int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// EnvBool returns the boolean value of the environment variable key, or
+// fallback when it is unset or not a boolean.
+func EnvBool(key string, fallback bool) bool {
+	if v, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key))); err == nil {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,585 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed boolean condition used on workflow decision
+// branches, for example:
+//
+//	injured > 0 && priority <= 2
+//	distId in ["1001", "1002"] || form.vehicle == 'truck'
+//	!(caseSTypeId == "fire") and symptoms contains "bleeding"
+//	injured - deaths >= 3
+//
+// Identifiers (letters, digits, "_" and ".") are resolved by the lookup passed
+// to Eval; an unknown identifier is null. Ordering is numeric when both sides
+// are numbers or numeric strings and textual otherwise; ordering with a null
+// side is false. Equality is numeric only between numbers: a string equals a
+// number only when it is the number's text, so "02" != 2. "+" and "-" take
+// numbers or numeric strings and give null otherwise.
+type Expression struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(lookup func(string) (interface{}, bool)) (interface{}, error)
+}
+
+// ParseExpression parses src. It fails on syntax errors only; identifiers are
+// not checked.
+func ParseExpression(src string) (*Expression, error) {
+	tokens, err := lexExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+// Identifiers returns the identifiers used in the expression.
+func (e *Expression) Identifiers() []string {
+	seen := map[string]bool{}
+	var names []string
+	var walk func(n exprNode)
+	walk = func(n exprNode) {
+		switch v := n.(type) {
+		case exprIdent:
+			if !seen[string(v)] {
+				seen[string(v)] = true
+				names = append(names, string(v))
+			}
+		case *exprUnary:
+			walk(v.x)
+		case *exprBinary:
+			walk(v.left)
+			walk(v.right)
+		case exprList:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(e.root)
+	return names
+}
+
+// Eval evaluates the expression. A non-boolean result is true when it is not
+// null, zero or empty.
+func (e *Expression) Eval(lookup func(name string) (interface{}, bool)) (bool, error) {
+	v, err := e.root.eval(lookup)
+	if err != nil {
+		return false, err
+	}
+	return exprTruthy(v), nil
+}
+
+// ---- lexer
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+func lexExpression(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, exprToken{tokString, sb.String(), start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]) && exprExpectsOperand(tokens)):
+			// A "-" is only part of the number where an operand is
+			// expected; after one, as in "injured-1", it is subtraction.
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			word := string(runes[start:i])
+			switch strings.ToLower(word) {
+			case "and":
+				tokens = append(tokens, exprToken{tokOp, "&&", start})
+			case "or":
+				tokens = append(tokens, exprToken{tokOp, "||", start})
+			case "not":
+				tokens = append(tokens, exprToken{tokOp, "!", start})
+			case "in", "contains":
+				tokens = append(tokens, exprToken{tokOp, strings.ToLower(word), start})
+			default:
+				tokens = append(tokens, exprToken{tokIdent, word, start})
+			}
+		default:
+			start := i
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "==", "!=", ">=", "<=", "&&", "||":
+				tokens = append(tokens, exprToken{tokOp, two, start})
+				i += 2
+				continue
+			}
+			switch r {
+			case '>', '<', '!', '(', ')', '[', ']', ',', '=', '+', '-':
+				op := string(r)
+				if op == "=" {
+					op = "=="
+				}
+				tokens = append(tokens, exprToken{tokOp, op, start})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected %q at %d", r, start)
+			}
+		}
+	}
+	return append(tokens, exprToken{tokEOF, "", len(runes)}), nil
+}
+
+// exprExpectsOperand reports whether a "-" at this point starts a negative
+// number rather than being the subtraction operator, that is whether the
+// previous token is an operator other than a closing bracket.
+func exprExpectsOperand(tokens []exprToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokOp && last.text != ")" && last.text != "]"
+}
+
+// ---- parser
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return fmt.Errorf("expected %q at end of expression", op)
+		}
+		return fmt.Errorf("expected %q at %d, found %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp {
+		return left, nil
+	}
+	switch t.text {
+	case "==", "!=", ">", ">=", "<", "<=", "in", "contains":
+		p.next()
+	default:
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return &exprBinary{op: t.text, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return exprLiteral{f}, nil
+	case tokString:
+		return exprLiteral{t.text}, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return exprLiteral{true}, nil
+		case "false":
+			return exprLiteral{false}, nil
+		case "null", "nil":
+			return exprLiteral{nil}, nil
+		}
+		return exprIdent(t.text), nil
+	case tokOp:
+		switch t.text {
+		case "-":
+			x, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &exprBinary{op: "-", left: exprLiteral{0.0}, right: x}, nil
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var items exprList
+			if p.accept("]") {
+				return items, nil
+			}
+			for {
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if p.accept("]") {
+					return items, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// ---- evaluation
+
+type exprLiteral struct{ v interface{} }
+
+func (n exprLiteral) eval(func(string) (interface{}, bool)) (interface{}, error) {
+	return n.v, nil
+}
+
+type exprIdent string
+
+func (n exprIdent) eval(lookup func(string) (interface{}, bool)) (interface{}, error) {
+	if lookup == nil {
+		return nil, nil
+	}
+	v, _ := lookup(string(n))
+	return v, nil
+}
+
+type exprList []exprNode
+
+func (n exprList) eval(lookup func(string) (interface{}, bool)) (interface{}, error) {
+	values := make([]interface{}, 0, len(n))
+	for _, item := range n {
+		v, err := item.eval(lookup)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type exprUnary struct{ x exprNode }
+
+func (n *exprUnary) eval(lookup func(string) (interface{}, bool)) (interface{}, error) {
+	v, err := n.x.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	return !exprTruthy(v), nil
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *exprBinary) eval(lookup func(string) (interface{}, bool)) (interface{}, error) {
+	left, err := n.left.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&":
+		if !exprTruthy(left) {
+			return false, nil
+		}
+	case "||":
+		if exprTruthy(left) {
+			return true, nil
+		}
+	}
+	right, err := n.right.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&", "||":
+		return exprTruthy(right), nil
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	case "in":
+		return exprContains(right, left), nil
+	case "contains":
+		return exprContains(left, right), nil
+	case "+", "-":
+		lf, lok := exprNumber(left)
+		rf, rok := exprNumber(right)
+		if !lok || !rok {
+			return nil, nil
+		}
+		if n.op == "-" {
+			rf = -rf
+		}
+		return lf + rf, nil
+	}
+	if left == nil || right == nil {
+		return false, nil
+	}
+	var cmp int
+	lf, lok := exprNumber(left)
+	rf, rok := exprNumber(right)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(exprString(left), exprString(right))
+	}
+	switch n.op {
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", n.op)
+}
+
+func exprNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func exprString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func exprEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		return ok && ab == bb
+	}
+	_, astr := a.(string)
+	_, bstr := b.(string)
+	if !astr && !bstr {
+		af, aok := exprNumber(a)
+		bf, bok := exprNumber(b)
+		if aok && bok {
+			return af == bf
+		}
+	}
+	return exprString(a) == exprString(b)
+}
+
+// exprContains reports whether list holds v. A string list is searched for a
+// substring.
+func exprContains(list interface{}, v interface{}) bool {
+	switch x := list.(type) {
+	case []interface{}:
+		for _, item := range x {
+			if exprEqual(item, v) {
+				return true
+			}
+		}
+	case []string:
+		for _, item := range x {
+			if exprEqual(item, v) {
+				return true
+			}
+		}
+	case string:
+		return v != nil && strings.Contains(x, exprString(v))
+	}
+	return false
+}
+
+func exprTruthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case string:
+		return x != ""
+	case []interface{}:
+		return len(x) > 0
+	}
+	if f, ok := exprNumber(v); ok {
+		return f != 0
+	}
+	return true
+}
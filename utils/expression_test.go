@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpressionEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"injured":       3,
+		"deaths":        1.0,
+		"priority":      2,
+		"priorityText":  "2",
+		"distId":        "1001",
+		"caseSTypeId":   "fire",
+		"symptoms":      []interface{}{"bleeding", "burn"},
+		"form.vehicle":  "truck",
+		"form.count":    "12",
+		"form.note":     "smoke and fire",
+		"flag":          true,
+		"zero":          0,
+		"empty":         "",
+		"negative":      -4,
+		"form.nothing":  nil,
+		"form.distance": "1.5",
+	}
+	lookup := func(name string) (interface{}, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// comparisons
+		{`injured > 0 && priority <= 2`, true},
+		{`injured > 3`, false},
+		{`injured >= 3`, true},
+		{`priority < 2`, false},
+		{`priority != 2`, false},
+		{`priority = 2`, true},
+		{`form.count > 9`, true},
+		{`form.count > "9"`, true},
+		{`distId < "1002"`, true},
+		{`form.distance >= 1.5`, true},
+
+		// subtraction and negative numbers
+		{`injured-1 > 0`, true},
+		{`injured - 1 == 2`, true},
+		{`injured -1 == 2`, true},
+		{`injured - deaths >= 3`, false},
+		{`injured + deaths == 4`, true},
+		{`(injured - 1) - 1 == 1`, true},
+		{`injured - 1 - 1 == 1`, true},
+		{`negative < -1`, true},
+		{`negative == -4`, true},
+		{`-injured == -3`, true},
+		{`0 - -1 == 1`, true},
+		{`form.count - 2 == 10`, true},
+		{`caseSTypeId - 1 == null`, true},
+		{`missing - 1 > 0`, false},
+
+		// equality between strings and numbers
+		{`priority == "02"`, false},
+		{`priority == "2"`, true},
+		{`priorityText == 2`, true},
+		{`priorityText == "02"`, false},
+		{`priorityText == 2.0`, true},
+		{`deaths == 1`, true},
+		{`deaths == "1"`, true},
+		{`form.count == "12.0"`, false},
+
+		// lists and contains
+		{`distId in ["1001", "1002"] || form.vehicle == 'truck'`, true},
+		{`distId in ["1002"]`, false},
+		{`priority in [1, 2]`, true},
+		{`priority in ["02"]`, false},
+		{`symptoms contains "bleeding"`, true},
+		{`symptoms contains "fever"`, false},
+		{`form.note contains "fire"`, true},
+		{`distId in []`, false},
+
+		// boolean logic and truthiness
+		{`!(caseSTypeId == "fire") and symptoms contains "bleeding"`, false},
+		{`not flag or zero`, false},
+		{`flag and injured`, true},
+		{`empty || zero`, false},
+		{`flag == true`, true},
+		{`flag == "true"`, false},
+		{`form.nothing == null`, true},
+		{`missing == null`, true},
+		{`missing > 0`, false},
+		{`missing < 0`, false},
+		{`symptoms`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got, err := e.Eval(lookup)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`injured >`,
+		`injured > 0 &&`,
+		`(injured > 0`,
+		`injured > 0)`,
+		`distId in ["1001"`,
+		`distId in ["1001" "1002"]`,
+		`form.vehicle == 'truck`,
+		`injured # 1`,
+		`injured > 1.2.3`,
+		`injured -`,
+	}
+	for _, src := range tests {
+		t.Run(src, func(t *testing.T) {
+			if _, err := ParseExpression(src); err == nil {
+				t.Errorf("ParseExpression(%q) succeeded", src)
+			}
+		})
+	}
+}
+
+func TestExpressionIdentifiers(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{`injured - deaths > 0 && injured < 10`, []string{"injured", "deaths"}},
+		{`distId in [a, "b", c] or not flag`, []string{"distId", "a", "c", "flag"}},
+		{`true && null == 1`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Identifiers(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"mainPackage/model"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -344,6 +345,22 @@ func CaseSyncTempSet(key string, value string) error {
 	return Rdb.Set(context.Background(), name, value, 0).Err()
 }
 
+// CaseSyncSetFenced is CaseSyncSet for the holder of the ReSyncCase lease.
+func CaseSyncSetFenced(f LeaseFence, key string, value string) error {
+	return fencedSet(f, fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_CASE_SYNC"), key), value)
+}
+
+// CaseSyncTempSetFenced is CaseSyncTempSet for the holder of the ReSyncCase
+// lease.
+func CaseSyncTempSetFenced(f LeaseFence, key string, value string) error {
+	return fencedSet(f, fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_CASE_SYNC_TEMP"), key), value)
+}
+
+// CaseSyncDelFenced is CaseSyncDel for the holder of the ReSyncCase lease.
+func CaseSyncDelFenced(f LeaseFence, key string) error {
+	return fencedDel(f, fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_CASE_SYNC"), key))
+}
+
 // ####====Schedule Case Sync=====
 func OwnerCaseSyncSet(value string) error {
 	name := fmt.Sprintf("%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_OWNER_CASE_SYNC"))
@@ -363,6 +380,145 @@ func OwnerCaseSyncDel() error {
 	return Rdb.Del(context.Background(), name).Err()
 }
 
+// ####====Monitor Lease=====
+// A lease is held on the owner key of a job (CACHE_PREFIX:CACHE_OWNER_xxx)
+// with the value "holder|token". The token comes from a counter that only
+// grows, so a holder whose lease expired can tell it has been replaced even if
+// it never saw the expiry. Values without a token are left over from the old
+// owner flags and count as free.
+var (
+	leaseAcquireScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v and string.find(v, '|', 1, true) then
+	return 0
+end
+local t = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. '|' .. t, 'PX', ARGV[2])
+return t`)
+
+	leaseRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+
+	leaseReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+func ownerLeaseKey(owner string) string {
+	return fmt.Sprintf("%s:%s", os.Getenv("CACHE_PREFIX"), owner)
+}
+
+// OwnerLeaseValue is the value stored on the owner key while holder has the
+// lease with the given fencing token.
+func OwnerLeaseValue(holder string, token int64) string {
+	return holder + "|" + strconv.FormatInt(token, 10)
+}
+
+// OwnerLeaseAcquire takes the lease on owner for ttl when nobody holds it and
+// returns its fencing token. ok is false when another holder has the lease.
+func OwnerLeaseAcquire(owner, holder string, ttl time.Duration) (token int64, ok bool, err error) {
+	name := ownerLeaseKey(owner)
+	token, err = leaseAcquireScript.Run(context.Background(), Rdb, []string{name, name + ":fence"}, holder, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	return token, token > 0, nil
+}
+
+// OwnerLeaseRenew extends the lease for ttl. It returns false when the lease
+// is no longer held with that token.
+func OwnerLeaseRenew(owner, holder string, token int64, ttl time.Duration) (bool, error) {
+	n, err := leaseRenewScript.Run(context.Background(), Rdb, []string{ownerLeaseKey(owner)}, OwnerLeaseValue(holder, token), ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+// OwnerLeaseRelease gives the lease up if it is still held with that token.
+func OwnerLeaseRelease(owner, holder string, token int64) error {
+	return leaseReleaseScript.Run(context.Background(), Rdb, []string{ownerLeaseKey(owner)}, OwnerLeaseValue(holder, token)).Err()
+}
+
+// OwnerLeaseGet returns the current holder, token and remaining time of the
+// lease on owner. holder is empty when the lease is free.
+func OwnerLeaseGet(owner string) (holder string, token int64, ttl time.Duration, err error) {
+	name := ownerLeaseKey(owner)
+	val, err := Rdb.Get(context.Background(), name).Result()
+	if err == redis.Nil {
+		return "", 0, 0, nil
+	} else if err != nil {
+		return "", 0, 0, err
+	}
+	i := strings.LastIndex(val, "|")
+	if i < 0 {
+		return "", 0, 0, nil
+	}
+	token, err = strconv.ParseInt(val[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid lease value %q: %w", val, err)
+	}
+	ttl, err = Rdb.PTTL(context.Background(), name).Result()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return val[:i], token, ttl, nil
+}
+
+// LeaseFence identifies a held lease. Writes made under a lease pass it on so
+// the store can reject them once a newer holder has taken the lease over.
+type LeaseFence struct {
+	Owner  string
+	Holder string
+	Token  int64
+}
+
+// ErrStaleFence is returned by fenced writes made with a lease that is no
+// longer held.
+var ErrStaleFence = errors.New("lease fencing token is stale")
+
+var (
+	fencedSetScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return -1
+end
+redis.call('SET', KEYS[2], ARGV[2])
+return 1`)
+
+	fencedDelScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return -1
+end
+return redis.call('DEL', KEYS[2])`)
+)
+
+// OwnerLeaseHeld reports whether the lease of f is still held with its token.
+func OwnerLeaseHeld(f LeaseFence) (bool, error) {
+	val, err := Rdb.Get(context.Background(), ownerLeaseKey(f.Owner)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return val == OwnerLeaseValue(f.Holder, f.Token), err
+}
+
+func fencedSet(f LeaseFence, name string, value string) error {
+	n, err := fencedSetScript.Run(context.Background(), Rdb, []string{ownerLeaseKey(f.Owner), name}, OwnerLeaseValue(f.Holder, f.Token), value).Int64()
+	if err == nil && n < 0 {
+		err = ErrStaleFence
+	}
+	return err
+}
+
+func fencedDel(f LeaseFence, name string) error {
+	n, err := fencedDelScript.Run(context.Background(), Rdb, []string{ownerLeaseKey(f.Owner), name}, OwnerLeaseValue(f.Holder, f.Token)).Int64()
+	if err == nil && n < 0 {
+		err = ErrStaleFence
+	}
+	return err
+}
+
 // Get All Case Sync
 func GetAllCaseSync(ctx context.Context) ([]model.CaseSyncItem, error) {
 	var cursor uint64
@@ -408,3 +564,414 @@ func GetAllCaseSync(ctx context.Context) ([]model.CaseSyncItem, error) {
 
 	return result, nil
 }
+
+// ####====Auto Dispatch=====
+func AutoDispatchSet(key string, value string) error {
+	name := fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_AUTO_DISPATCH"), key)
+	log.Print(name)
+	return Rdb.Set(context.Background(), name, value, 0).Err()
+}
+
+func AutoDispatchGet(key string) (string, error) {
+	name := fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_AUTO_DISPATCH"), key)
+	return RedisGet(name)
+}
+
+func AutoDispatchDel(key string) error {
+	name := fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_AUTO_DISPATCH"), key)
+	log.Print("Deleting:", name)
+	return Rdb.Del(context.Background(), name).Err()
+}
+
+// AutoDispatchSetFenced is AutoDispatchSet for the holder of the
+// AutoDispatchMonitor lease.
+func AutoDispatchSetFenced(f LeaseFence, key string, value string) error {
+	return fencedSet(f, fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_AUTO_DISPATCH"), key), value)
+}
+
+// AutoDispatchDelFenced is AutoDispatchDel for the holder of the
+// AutoDispatchMonitor lease.
+func AutoDispatchDelFenced(f LeaseFence, key string) error {
+	return fencedDel(f, fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_AUTO_DISPATCH"), key))
+}
+
+// Get All Auto Dispatch
+func GetAllAutoDispatch(ctx context.Context) ([]model.CaseSyncItem, error) {
+	var cursor uint64
+	var result []model.CaseSyncItem
+
+	if Rdb == nil {
+		return nil, errors.New("redis client not initialized")
+	}
+
+	pattern := fmt.Sprintf(
+		"%s:%s:*",
+		os.Getenv("CACHE_PREFIX"),
+		os.Getenv("CACHE_AUTO_DISPATCH"),
+	)
+
+	for {
+		keys, nextCursor, err := Rdb.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			val, err := Rdb.Get(ctx, key).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, model.CaseSyncItem{
+				Key:   key,
+				Value: val,
+			})
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// ####==== ESB - PENDING WO UPDATE =====
+// Updates that arrive before the work order is created are parked in a list
+// and drained, in arrival order, once the create has been applied.
+func EsbPendingPush(key string, value string) error {
+	name := fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_PENDING_WO"), key)
+	expiration := 24 * time.Hour
+	if sec := EnvInt("ESB_PENDING_EXPIRE", 0); sec > 0 {
+		expiration = time.Duration(sec) * time.Second
+	}
+	pipe := Rdb.TxPipeline()
+	pipe.RPush(context.Background(), name, value)
+	pipe.Expire(context.Background(), name, expiration)
+	_, err := pipe.Exec(context.Background())
+	return err
+}
+
+func EsbPendingDrain(key string) ([]string, error) {
+	name := fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), os.Getenv("CACHE_PENDING_WO"), key)
+	pipe := Rdb.TxPipeline()
+	values := pipe.LRange(context.Background(), name, 0, -1)
+	pipe.Del(context.Background(), name)
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		return nil, err
+	}
+	return values.Val(), nil
+}
+
+// ####====Sessions=====
+// A session is a hash on CACHE_PREFIX:session:<jti> that lives as long as its
+// latest refresh token. The jtis of a user are also kept in the set
+// CACHE_PREFIX:sessions:<orgId>:<username> so that all of them can be listed
+// or revoked at once; members whose hash has expired are pruned on read.
+var sessionRotateScript = redis.NewScript(`
+local rid = redis.call('HGET', KEYS[1], 'refreshId')
+if not rid then
+	return -1
+end
+if rid ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'refreshId', ARGV[2], 'lastSeen', ARGV[3], 'expiresAt', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+redis.call('PEXPIRE', KEYS[2], ARGV[5])
+return 1`)
+
+const (
+	SessionRotated  = 1
+	SessionReused   = 0
+	SessionNotFound = -1
+)
+
+func sessionKey(jti string) string {
+	return fmt.Sprintf("%s:session:%s", os.Getenv("CACHE_PREFIX"), jti)
+}
+
+func userSessionsKey(orgId, username string) string {
+	return fmt.Sprintf("%s:sessions:%s:%s", os.Getenv("CACHE_PREFIX"), orgId, username)
+}
+
+func SessionSet(s model.AuthSession) error {
+	ttl := time.Until(s.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("session %s already expired", s.ID)
+	}
+	name := sessionKey(s.ID)
+	set := userSessionsKey(s.OrgID, s.Username)
+	pipe := Rdb.TxPipeline()
+	pipe.HSet(context.Background(), name, map[string]any{
+		"orgId":     s.OrgID,
+		"username":  s.Username,
+		"refreshId": s.RefreshID,
+		"ip":        s.IP,
+		"userAgent": s.UserAgent,
+		"createdAt": s.CreatedAt.Format(time.RFC3339),
+		"lastSeen":  s.LastSeen.Format(time.RFC3339),
+		"expiresAt": s.ExpiresAt.Format(time.RFC3339),
+	})
+	pipe.Expire(context.Background(), name, ttl)
+	pipe.SAdd(context.Background(), set, s.ID)
+	// Sessions all last REFRESH_TOKEN_TIMEOUT from their last write, so the
+	// set outlives them as long as every write pushes its expiry too.
+	pipe.Expire(context.Background(), set, ttl)
+	_, err := pipe.Exec(context.Background())
+	return err
+}
+
+// SessionGet returns nil when the session does not exist, either because it
+// expired or because it was revoked.
+func SessionGet(jti string) (*model.AuthSession, error) {
+	val, err := Rdb.HGetAll(context.Background(), sessionKey(jti)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(val) == 0 {
+		return nil, nil
+	}
+	s := &model.AuthSession{
+		ID:        jti,
+		OrgID:     val["orgId"],
+		Username:  val["username"],
+		RefreshID: val["refreshId"],
+		IP:        val["ip"],
+		UserAgent: val["userAgent"],
+	}
+	s.CreatedAt, _ = time.Parse(time.RFC3339, val["createdAt"])
+	s.LastSeen, _ = time.Parse(time.RFC3339, val["lastSeen"])
+	s.ExpiresAt, _ = time.Parse(time.RFC3339, val["expiresAt"])
+	return s, nil
+}
+
+func SessionActive(jti string) (bool, error) {
+	n, err := Rdb.Exists(context.Background(), sessionKey(jti)).Result()
+	return n == 1, err
+}
+
+// SessionRotate replaces the refresh id of the session when refreshId is the
+// current one and extends the session to expiresAt. It returns SessionRotated,
+// SessionReused when refreshId has already been rotated away, or
+// SessionNotFound.
+func SessionRotate(orgId, username, jti, refreshId, newRefreshId string, expiresAt time.Time) (int64, error) {
+	now := time.Now()
+	return sessionRotateScript.Run(context.Background(), Rdb, []string{sessionKey(jti), userSessionsKey(orgId, username)},
+		refreshId, newRefreshId, now.Format(time.RFC3339), expiresAt.Format(time.RFC3339),
+		expiresAt.Sub(now).Milliseconds(),
+	).Int64()
+}
+
+func SessionDel(jti string) error {
+	s, err := SessionGet(jti)
+	if err != nil || s == nil {
+		return err
+	}
+	pipe := Rdb.TxPipeline()
+	pipe.Del(context.Background(), sessionKey(jti))
+	pipe.SRem(context.Background(), userSessionsKey(s.OrgID, s.Username), jti)
+	_, err = pipe.Exec(context.Background())
+	return err
+}
+
+// SessionList returns the live sessions of a user.
+func SessionList(orgId, username string) ([]model.AuthSession, error) {
+	set := userSessionsKey(orgId, username)
+	ids, err := Rdb.SMembers(context.Background(), set).Result()
+	if err != nil {
+		return nil, err
+	}
+	var result []model.AuthSession
+	for _, id := range ids {
+		s, err := SessionGet(id)
+		if err != nil {
+			return nil, err
+		}
+		if s == nil {
+			Rdb.SRem(context.Background(), set, id)
+			continue
+		}
+		result = append(result, *s)
+	}
+	return result, nil
+}
+
+// SessionDelUser removes every session of a user except keep, which may be
+// empty, and returns how many were removed.
+func SessionDelUser(orgId, username, keep string) (int64, error) {
+	set := userSessionsKey(orgId, username)
+	ids, err := Rdb.SMembers(context.Background(), set).Result()
+	if err != nil {
+		return 0, err
+	}
+	var keys, members []string
+	for _, id := range ids {
+		if id == keep {
+			continue
+		}
+		keys = append(keys, sessionKey(id))
+		members = append(members, id)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	pipe := Rdb.TxPipeline()
+	del := pipe.Del(context.Background(), keys...)
+	pipe.SRem(context.Background(), set, members)
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		return 0, err
+	}
+	return del.Val(), nil
+}
+
+// ####====Password Reset=====
+// A reset token is stored only as its hash, on
+// CACHE_PREFIX:pwreset:<hash>. CACHE_PREFIX:pwreset:user:<orgId>:<username>
+// points at the latest token of a user so that asking again cancels the
+// previous one.
+func passwordResetKey(tokenHash string) string {
+	return fmt.Sprintf("%s:pwreset:%s", os.Getenv("CACHE_PREFIX"), tokenHash)
+}
+
+func passwordResetUserKey(orgId, username string) string {
+	return fmt.Sprintf("%s:pwreset:user:%s:%s", os.Getenv("CACHE_PREFIX"), orgId, username)
+}
+
+func PasswordResetSet(orgId, username, tokenHash, value string, ttl time.Duration) error {
+	old, err := Rdb.SetArgs(context.Background(), passwordResetUserKey(orgId, username), tokenHash,
+		redis.SetArgs{TTL: ttl, Get: true}).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	pipe := Rdb.TxPipeline()
+	if old != "" {
+		pipe.Del(context.Background(), passwordResetKey(old))
+	}
+	pipe.Set(context.Background(), passwordResetKey(tokenHash), value, ttl)
+	_, err = pipe.Exec(context.Background())
+	return err
+}
+
+func PasswordResetGet(tokenHash string) (string, error) {
+	return RedisGet(passwordResetKey(tokenHash))
+}
+
+// PasswordResetTake returns the value of a token and deletes it, so a token
+// works once. It returns "" when the token is unknown or expired.
+func PasswordResetTake(tokenHash string) (string, error) {
+	val, err := Rdb.GetDel(context.Background(), passwordResetKey(tokenHash)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+func PasswordResetUserDel(orgId, username string) error {
+	return Rdb.Del(context.Background(), passwordResetUserKey(orgId, username)).Err()
+}
+
+// PasswordResetHit counts a reset request for key within a fixed window and
+// returns the count so far.
+func PasswordResetHit(key string, window time.Duration) (int64, error) {
+	name := fmt.Sprintf("%s:pwreset:rate:%s", os.Getenv("CACHE_PREFIX"), key)
+	n, err := Rdb.Incr(context.Background(), name).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		Rdb.Expire(context.Background(), name, window)
+	}
+	return n, nil
+}
+
+// ####====Login Throttle=====
+// Failed logins are counted per subject ("user:<orgId>:<username>" or
+// "ip:<orgId>:<ip>") on CACHE_PREFIX:loginfail:<subject>, which expires once
+// the subject has been quiet for the window. A subject that must wait before its
+// next try has CACHE_PREFIX:loginwait:<subject>, and a locked one has
+// CACHE_PREFIX:loginlock:<subject> holding a model.LoginLock.
+func loginThrottleKey(kind, subject string) string {
+	return fmt.Sprintf("%s:%s:%s", os.Getenv("CACHE_PREFIX"), kind, subject)
+}
+
+func LoginFailHit(subject string, window time.Duration) (int64, error) {
+	name := loginThrottleKey("loginfail", subject)
+	pipe := Rdb.TxPipeline()
+	n := pipe.Incr(context.Background(), name)
+	pipe.Expire(context.Background(), name, window)
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		return 0, err
+	}
+	return n.Val(), nil
+}
+
+func LoginFailDel(subject string) error {
+	return Rdb.Del(context.Background(),
+		loginThrottleKey("loginfail", subject),
+		loginThrottleKey("loginwait", subject),
+	).Err()
+}
+
+func LoginWaitSet(subject string, ttl time.Duration) error {
+	return Rdb.Set(context.Background(), loginThrottleKey("loginwait", subject), "1", ttl).Err()
+}
+
+// LoginWaitGet returns how long the subject still has to wait, or 0.
+func LoginWaitGet(subject string) (time.Duration, error) {
+	ttl, err := Rdb.PTTL(context.Background(), loginThrottleKey("loginwait", subject)).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func LoginLockSet(subject string, value string, ttl time.Duration) error {
+	return Rdb.Set(context.Background(), loginThrottleKey("loginlock", subject), value, ttl).Err()
+}
+
+// LoginLockGet returns "" when the subject is not locked.
+func LoginLockGet(subject string) (string, error) {
+	return RedisGet(loginThrottleKey("loginlock", subject))
+}
+
+// LoginLockDel unlocks the subject and clears its failures. It returns false
+// when the subject was not locked.
+func LoginLockDel(subject string) (bool, error) {
+	n, err := Rdb.Del(context.Background(), loginThrottleKey("loginlock", subject)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, LoginFailDel(subject)
+}
+
+// GetAllLoginLocks returns the value of every lock.
+func GetAllLoginLocks(ctx context.Context) ([]string, error) {
+	var cursor uint64
+	var result []string
+	pattern := loginThrottleKey("loginlock", "*")
+	for {
+		keys, next, err := Rdb.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			val, err := Rdb.Get(ctx, key).Result()
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			result = append(result, val)
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; ours are their last 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := func(d time.Duration) string {
+		c, err := TOTPCode(rfc6238Secret, now.Add(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantOK   bool
+		wantStep int64
+	}{
+		{"current step", code(0), 1, true, TOTPStep(now)},
+		{"previous step within skew", code(-TOTPPeriod * time.Second), 1, true, TOTPStep(now) - 1},
+		{"next step within skew", code(TOTPPeriod * time.Second), 1, true, TOTPStep(now) + 1},
+		{"two steps back", code(-2 * TOTPPeriod * time.Second), 1, false, 0},
+		{"previous step without skew", code(-TOTPPeriod * time.Second), 0, false, 0},
+		{"spaces around", " " + code(0) + " ", 1, true, TOTPStep(now)},
+		{"wrong code", "000000", 1, false, 0},
+		{"too short", code(0)[:5], 1, false, 0},
+		{"too long", code(0) + "0", 1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := TOTPValidate(rfc6238Secret, tt.code, now, tt.skew)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("got step %d ok %v, want step %d ok %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	if _, _, err := TOTPValidate("not base32!", "123456", now, 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}